@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"codecrafters/internal/redis"
 )
@@ -16,6 +18,18 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Init returns once the server has shut down, which a failed shutdown leaves
+	// running
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for range signals {
+			if err := redis.Shutdown(); err != nil {
+				fmt.Println("Received signal but failed to shut down:", err)
+			}
+		}
+	}()
+
 	err = redis.Init()
 
 	if err != nil {
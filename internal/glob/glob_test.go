@@ -0,0 +1,35 @@
+package glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"It should match everything with a star", "*", "anything", true},
+		{"It should match an empty string with a star", "*", "", true},
+		{"It should match a prefix", "foo*", "foobar", true},
+		{"It should not match a different prefix", "foo*", "barfoo", false},
+		{"It should match a single character", "h?llo", "hello", true},
+		{"It should require a character for ?", "h?llo", "hllo", false},
+		{"It should match a character class", "h[ae]llo", "hallo", true},
+		{"It should not match outside a character class", "h[ae]llo", "hillo", false},
+		{"It should match a negated character class", "h[^e]llo", "hallo", true},
+		{"It should not match a negated character", "h[^e]llo", "hello", false},
+		{"It should match a range", "h[a-c]llo", "hbllo", true},
+		{"It should match an escaped star literally", "h\\*llo", "h*llo", true},
+		{"It should not treat an escaped star as a wildcard", "h\\*llo", "hello", false},
+		{"It should match stars in the middle", "a*b*c", "axxbyyc", true},
+		{"It should not match trailing characters", "abc", "abcd", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Match(tt.pattern, tt.s); got != tt.want {
+				t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+			}
+		})
+	}
+}
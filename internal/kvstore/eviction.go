@@ -0,0 +1,353 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/tilinna/clock"
+)
+
+// Policies for choosing which keys to evict once maxmemory is reached, as in the
+// maxmemory-policy directive
+const (
+	EVICTION_NOEVICTION      = "noeviction"
+	EVICTION_ALLKEYS_LRU     = "allkeys-lru"
+	EVICTION_ALLKEYS_LFU     = "allkeys-lfu"
+	EVICTION_ALLKEYS_RANDOM  = "allkeys-random"
+	EVICTION_VOLATILE_LRU    = "volatile-lru"
+	EVICTION_VOLATILE_LFU    = "volatile-lfu"
+	EVICTION_VOLATILE_RANDOM = "volatile-random"
+	EVICTION_VOLATILE_TTL    = "volatile-ttl"
+)
+
+var EvictionPolicies = []string{
+	EVICTION_VOLATILE_LRU, EVICTION_VOLATILE_LFU, EVICTION_VOLATILE_RANDOM, EVICTION_VOLATILE_TTL,
+	EVICTION_ALLKEYS_LRU, EVICTION_ALLKEYS_LFU, EVICTION_ALLKEYS_RANDOM, EVICTION_NOEVICTION,
+}
+
+var ErrOutOfMemory = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+// How many of the best candidates for eviction are kept between evictions, so
+// that a good candidate found by one sample isn't lost to the next
+const EVICTION_POOL_SIZE = 16
+
+// The counter new keys start with under LFU, so they aren't evicted before
+// they've had the chance to be accessed
+const LFU_INIT_VAL = 5
+
+// Roughly what a key costs on top of its name and value, for the map entry, its
+// access metadata and pointers
+const ENTRY_OVERHEAD = 64
+
+type EvictionConfig struct {
+	// The most memory the dataset may use in bytes, or 0 for no limit
+	MaxMemory int64
+	Policy    string
+	// How many keys are sampled to find each key to evict
+	Samples int
+	// How many accesses it takes to saturate the LFU counter, with higher factors
+	// needing more
+	LFULogFactor int
+	// Minutes it takes for the LFU counter to be halved, or rather decremented,
+	// with 0 meaning never
+	LFUDecayTime int
+}
+
+// IsLFU reports whether the policy evicts the least frequently used keys
+func (c EvictionConfig) IsLFU() bool {
+	return c.Policy == EVICTION_ALLKEYS_LFU || c.Policy == EVICTION_VOLATILE_LFU
+}
+
+func (c EvictionConfig) volatileOnly() bool {
+	switch c.Policy {
+	case EVICTION_VOLATILE_LRU, EVICTION_VOLATILE_LFU, EVICTION_VOLATILE_RANDOM, EVICTION_VOLATILE_TTL:
+		return true
+	}
+	return false
+}
+
+// storeEntry is a value in the store along with what eviction needs to know of it
+type storeEntry struct {
+	value StoredValue
+	// Bytes used by the key and its value
+	size int64
+	// When the key was last read or written in milliseconds, for LRU
+	lastAccess atomic.Int64
+	// The LFU counter in the low 8 bits, below the time in minutes it was last
+	// decremented
+	lfu atomic.Uint32
+}
+
+func newStoreEntry(now time.Time) *storeEntry {
+	entry := &storeEntry{}
+	entry.lastAccess.Store(now.UnixMilli())
+	entry.lfu.Store(uint32(lfuMinutes(now))<<8 | LFU_INIT_VAL)
+	return entry
+}
+
+// entrySize works out how much memory a key and its value use
+func entrySize(key string, value StoredValue) int64 {
+	return ENTRY_OVERHEAD + int64(len(key)) + value.MemoryUsage()
+}
+
+// lfuMinutes is the current time in minutes, wrapping around as the 16 bits Redis
+// keeps for it do
+func lfuMinutes(now time.Time) uint16 {
+	return uint16(now.Unix() / 60)
+}
+
+// lfuDecay decrements the counter once for every decay period since it was last
+// decremented
+func lfuDecay(counter uint8, last uint16, now uint16, decayTime int) uint8 {
+	if decayTime <= 0 {
+		return counter
+	}
+
+	// Unsigned subtraction takes care of the minutes wrapping around
+	periods := int(now-last) / decayTime
+
+	if periods > int(counter) {
+		return 0
+	}
+	return counter - uint8(periods)
+}
+
+// lfuLogIncr increments the counter with a probability that falls as it grows, so
+// that 8 bits can count millions of accesses
+func lfuLogIncr(counter uint8, logFactor int, r float64) uint8 {
+	if counter == math.MaxUint8 {
+		return counter
+	}
+
+	base := max(float64(counter)-LFU_INIT_VAL, 0)
+
+	if r < 1/(base*float64(logFactor)+1) {
+		counter++
+	}
+	return counter
+}
+
+// frequency returns the entry's LFU counter, decayed for the time since it was
+// last accessed
+func (e *storeEntry) frequency(config EvictionConfig, now time.Time) uint8 {
+	lfu := e.lfu.Load()
+	return lfuDecay(uint8(lfu), uint16(lfu>>8), lfuMinutes(now), config.LFUDecayTime)
+}
+
+// accessed updates the entry's LRU time and LFU counter
+func (e *storeEntry) accessed(config EvictionConfig, now time.Time) {
+	e.lastAccess.Store(now.UnixMilli())
+
+	counter := lfuLogIncr(e.frequency(config, now), config.LFULogFactor, rand.Float64())
+	e.lfu.Store(uint32(lfuMinutes(now))<<8 | uint32(counter))
+}
+
+// idle returns how long it's been since the entry was last accessed
+func (e *storeEntry) idle(now time.Time) time.Duration {
+	return time.Duration(max(now.UnixMilli()-e.lastAccess.Load(), 0)) * time.Millisecond
+}
+
+type evictionCandidate struct {
+	key string
+	// How good a candidate the key is, higher scores being evicted first
+	score uint64
+}
+
+// evictionState holds the eviction settings, and the pool of candidates which is
+// only touched while the store is locked for writing
+type evictionState struct {
+	config atomic.Pointer[EvictionConfig]
+	pool   []evictionCandidate
+	used   atomic.Int64
+}
+
+func newEvictionState() *evictionState {
+	e := &evictionState{}
+	e.config.Store(&EvictionConfig{Policy: EVICTION_NOEVICTION, Samples: 5, LFULogFactor: 10, LFUDecayTime: 1})
+	return e
+}
+
+func (s KVStore) SetEvictionConfig(config EvictionConfig) {
+	previous := s.eviction.config.Swap(&config)
+
+	// Scores under one policy mean nothing under another
+	if previous.Policy != config.Policy {
+		s.storeMutex.Lock()
+		s.eviction.pool = nil
+		s.storeMutex.Unlock()
+	}
+}
+
+func (s KVStore) EvictionConfig() EvictionConfig {
+	return *s.eviction.config.Load()
+}
+
+// UsedMemory returns how many bytes the dataset is using
+func (s KVStore) UsedMemory() int64 {
+	return s.eviction.used.Load()
+}
+
+// OverMemoryLimit reports whether the dataset uses more than maxmemory
+func (s KVStore) OverMemoryLimit() bool {
+	config := s.eviction.config.Load()
+	return config.MaxMemory > 0 && s.eviction.used.Load() > config.MaxMemory
+}
+
+// Evict removes keys chosen by the eviction policy until the dataset fits within
+// maxmemory, returning the keys it removed. ErrOutOfMemory is returned should it
+// not be able to get under the limit, such as under the noeviction policy.
+func (s KVStore) Evict(ctx context.Context) ([]string, error) {
+	if !s.OverMemoryLimit() {
+		return nil, nil
+	}
+
+	config := *s.eviction.config.Load()
+
+	if config.Policy == EVICTION_NOEVICTION {
+		return nil, ErrOutOfMemory
+	}
+
+	now := clock.FromContext(ctx).Now()
+	evicted := []string{}
+
+	s.storeMutex.Lock()
+
+	for s.eviction.used.Load() > config.MaxMemory {
+		key, ok := s.evictionCandidate(config, now)
+
+		if !ok {
+			break
+		}
+
+		s.deleteEntry(key)
+		evicted = append(evicted, key)
+	}
+
+	s.storeMutex.Unlock()
+
+	s.stats.evicted.Add(int64(len(evicted)))
+
+	for _, key := range evicted {
+		s.touchKey(key)
+	}
+
+	if s.OverMemoryLimit() {
+		return evicted, ErrOutOfMemory
+	}
+	return evicted, nil
+}
+
+// sampleKeys picks up to count keys at random from the keys eligible for eviction,
+// relying on map iteration starting from a random place
+func (s KVStore) sampleKeys(config EvictionConfig, count int) []string {
+	keys := make([]string, 0, count)
+
+	if config.volatileOnly() {
+		for key := range s.volatile {
+			if len(keys) == count {
+				break
+			}
+			keys = append(keys, key)
+		}
+		return keys
+	}
+
+	for key := range s.store {
+		if len(keys) == count {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// evictionScore rates a key for eviction under the policy, the key with the
+// highest score going first
+func evictionScore(config EvictionConfig, entry *storeEntry, now time.Time) uint64 {
+	switch config.Policy {
+	case EVICTION_ALLKEYS_LFU, EVICTION_VOLATILE_LFU:
+		return uint64(math.MaxUint8 - entry.frequency(config, now))
+	case EVICTION_VOLATILE_TTL:
+		if expiresAt := expiresAt(entry.value); expiresAt != nil {
+			return math.MaxUint64 - *expiresAt
+		}
+		return 0
+	default:
+		return uint64(entry.idle(now).Milliseconds())
+	}
+}
+
+// populatePool samples keys, adding any that are better candidates than those in
+// the pool. The pool is kept sorted with the best candidate last.
+func (s KVStore) populatePool(config EvictionConfig, now time.Time) {
+	for _, key := range s.sampleKeys(config, max(config.Samples, 1)) {
+		candidate := evictionCandidate{key, evictionScore(config, s.store[key], now)}
+		pool := s.eviction.pool
+
+		if slices.ContainsFunc(pool, func(c evictionCandidate) bool { return c.key == key }) {
+			continue
+		}
+
+		if len(pool) == EVICTION_POOL_SIZE && candidate.score <= pool[0].score {
+			continue
+		}
+
+		i, _ := slices.BinarySearchFunc(pool, candidate, func(a evictionCandidate, b evictionCandidate) int {
+			switch {
+			case a.score < b.score:
+				return -1
+			case a.score > b.score:
+				return 1
+			}
+			return 0
+		})
+		pool = slices.Insert(pool, i, candidate)
+
+		if len(pool) > EVICTION_POOL_SIZE {
+			pool = pool[1:]
+		}
+		s.eviction.pool = pool
+	}
+}
+
+// evictionCandidate picks the next key to evict, if any key can be
+func (s KVStore) evictionCandidate(config EvictionConfig, now time.Time) (string, bool) {
+	if config.Policy == EVICTION_ALLKEYS_RANDOM || config.Policy == EVICTION_VOLATILE_RANDOM {
+		keys := s.sampleKeys(config, 1)
+
+		if len(keys) == 0 {
+			return "", false
+		}
+		return keys[0], true
+	}
+
+	for {
+		s.populatePool(config, now)
+
+		if len(s.eviction.pool) == 0 {
+			return "", false
+		}
+
+		// Take the best candidate, skipping over any that have since been deleted or
+		// lost their expiry
+		for len(s.eviction.pool) > 0 {
+			last := len(s.eviction.pool) - 1
+			key := s.eviction.pool[last].key
+			s.eviction.pool = s.eviction.pool[:last]
+
+			if _, ok := s.store[key]; !ok {
+				continue
+			}
+
+			if _, ok := s.volatile[key]; config.volatileOnly() && !ok {
+				continue
+			}
+			return key, true
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tilinna/clock"
+)
+
+func Test_lfuLogIncr(t *testing.T) {
+	tests := []struct {
+		name      string
+		counter   uint8
+		logFactor int
+		r         float64
+		want      uint8
+	}{
+		{name: "It should always increment new counters", counter: LFU_INIT_VAL, logFactor: 10, r: 0.99, want: LFU_INIT_VAL + 1},
+		{name: "It should increment when the odds come up", counter: 15, logFactor: 10, r: 0.005, want: 16},
+		{name: "It should get harder to increment as the counter grows", counter: 15, logFactor: 10, r: 0.05, want: 15},
+		{name: "It should always increment without a log factor", counter: 100, logFactor: 0, r: 0.99, want: 101},
+		{name: "It should saturate", counter: 255, logFactor: 0, r: 0, want: 255},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lfuLogIncr(tt.counter, tt.logFactor, tt.r); got != tt.want {
+				t.Errorf("lfuLogIncr() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_lfuDecay(t *testing.T) {
+	tests := []struct {
+		name      string
+		counter   uint8
+		last      uint16
+		now       uint16
+		decayTime int
+		want      uint8
+	}{
+		{name: "It should decrement once a period", counter: 10, last: 100, now: 103, decayTime: 1, want: 7},
+		{name: "It should decrement for whole periods only", counter: 10, last: 100, now: 105, decayTime: 2, want: 8},
+		{name: "It should stop at zero", counter: 3, last: 0, now: 60, decayTime: 1, want: 0},
+		{name: "It should handle the minutes wrapping around", counter: 10, last: 65535, now: 1, decayTime: 1, want: 8},
+		{name: "It should never decay without a decay time", counter: 10, last: 0, now: 1000, decayTime: 0, want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lfuDecay(tt.counter, tt.last, tt.now, tt.decayTime); got != tt.want {
+				t.Errorf("lfuDecay() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKVStore_UsedMemory(t *testing.T) {
+	store := NewKVStore()
+	ctx := context.Background()
+
+	store.SetKeyWithExpiry(ctx, "a", "12345", nil)
+	small := store.UsedMemory()
+
+	store.SetKeyWithExpiry(ctx, "a", "1234567890", nil)
+	if got := store.UsedMemory(); got != small+5 {
+		t.Errorf("UsedMemory() after growing a value = %d, want %d", got, small+5)
+	}
+
+	store.SetStream(ctx, "s", "*", []string{"field", "value"}, StreamAddOptions{})
+	withStream := store.UsedMemory()
+	store.SetStream(ctx, "s", "*", []string{"field", "value"}, StreamAddOptions{})
+	if got := store.UsedMemory(); got <= withStream {
+		t.Errorf("UsedMemory() after adding to a stream = %d, want more than %d", got, withStream)
+	}
+
+	store.Flush()
+	if got := store.UsedMemory(); got != 0 {
+		t.Errorf("UsedMemory() after flushing = %d, want 0", got)
+	}
+}
+
+func TestKVStore_Evict(t *testing.T) {
+	start := time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)
+	ttl, shorterTtl := uint64(time.Hour.Milliseconds()), uint64(2*time.Minute.Milliseconds())
+
+	tests := []struct {
+		name        string
+		policy      string
+		wantEvicted []string
+		wantErr     error
+	}{
+		{name: "It should refuse to evict under noeviction", policy: EVICTION_NOEVICTION, wantEvicted: []string{}, wantErr: ErrOutOfMemory},
+		{name: "It should evict the least recently used key", policy: EVICTION_ALLKEYS_LRU, wantEvicted: []string{"cold"}},
+		{name: "It should evict the least frequently used key", policy: EVICTION_ALLKEYS_LFU, wantEvicted: []string{"rare"}},
+		{name: "It should only evict keys with an expiry", policy: EVICTION_VOLATILE_LRU, wantEvicted: []string{"volatile"}},
+		{name: "It should evict the key closest to expiring", policy: EVICTION_VOLATILE_TTL, wantEvicted: []string{"expiring"}},
+		{name: "It should evict any key at random", policy: EVICTION_ALLKEYS_RANDOM, wantEvicted: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewKVStore()
+			store.SetEvictionConfig(EvictionConfig{Policy: tt.policy, Samples: 10, LFULogFactor: 0, LFUDecayTime: 1})
+
+			at := func(d time.Duration) context.Context {
+				ctx, _ := clock.NewMock(start.Add(d)).DeadlineContext(context.Background(), start)
+				return ctx
+			}
+
+			// Cold was accessed longest ago and rare least often, while of the keys with
+			// an expiry volatile was accessed longer ago and expiring expires sooner
+			store.SetKeyWithExpiry(at(0), "cold", "x", nil)
+			store.SetKeyWithExpiry(at(0), "rare", "x", nil)
+			store.SetKeyWithExpiry(at(0), "volatile", "x", &ttl)
+			store.SetKeyWithExpiry(at(0), "expiring", "x", &shorterTtl)
+			for i := 0; i < 5; i++ {
+				store.GetKey(at(0), "cold")
+				store.GetKey(at(30*time.Second), "volatile")
+				store.GetKey(at(time.Minute), "expiring")
+			}
+			store.GetKey(at(time.Minute), "rare")
+
+			// Leave just enough room for all but one key
+			store.SetEvictionConfig(EvictionConfig{Policy: tt.policy, MaxMemory: store.UsedMemory() - 1, Samples: 10, LFULogFactor: 0, LFUDecayTime: 1})
+
+			evicted, err := store.Evict(at(time.Minute))
+			if err != tt.wantErr {
+				t.Fatalf("Evict() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantEvicted == nil {
+				if len(evicted) != 1 {
+					t.Errorf("Evict() = %v, want a single key", evicted)
+				}
+			} else if !slices.Equal(evicted, tt.wantEvicted) {
+				t.Errorf("Evict() = %v, want %v", evicted, tt.wantEvicted)
+			}
+
+			if got := store.Stats().EvictedKeys; got != int64(len(evicted)) {
+				t.Errorf("Stats().EvictedKeys = %d, want %d", got, len(evicted))
+			}
+		})
+	}
+}
+
+func TestKVStore_EvictRunsOutOfKeys(t *testing.T) {
+	store := NewKVStore()
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		store.SetKeyWithExpiry(ctx, fmt.Sprint(i), "value", nil)
+	}
+
+	store.SetEvictionConfig(EvictionConfig{Policy: EVICTION_VOLATILE_RANDOM, MaxMemory: 1, Samples: 5})
+
+	if evicted, err := store.Evict(ctx); err != ErrOutOfMemory || len(evicted) != 0 {
+		t.Errorf("Evict() = %v, %v, want no keys and ErrOutOfMemory", evicted, err)
+	}
+}
@@ -0,0 +1,55 @@
+package kvstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/tilinna/clock"
+)
+
+// How many keys with an expiry each round of the active expire cycle looks at
+const ACTIVE_EXPIRE_CYCLE_KEYS_PER_LOOP = 20
+
+// Another round is run while more than this percentage of the keys looked at had
+// expired, as many more are then likely waiting to be removed
+const ACTIVE_EXPIRE_CYCLE_ACCEPTABLE_STALE = 10
+
+// ActiveExpireCycle removes keys that have expired without anyone reading them,
+// sampling keys with an expiry until few of those sampled turn out to have
+// expired or the time budget runs out. It returns the keys it removed.
+func (s KVStore) ActiveExpireCycle(ctx context.Context, budget time.Duration) []string {
+	deadline := clock.FromContext(ctx).Now().Add(budget)
+	removed := []string{}
+
+	for {
+		expired := []string{}
+		sampled := 0
+
+		s.storeMutex.Lock()
+
+		for key := range s.volatile {
+			if sampled == ACTIVE_EXPIRE_CYCLE_KEYS_PER_LOOP {
+				break
+			}
+			sampled++
+
+			if s.store[key].value.IsExpired(ctx) {
+				s.deleteEntry(key)
+				expired = append(expired, key)
+			}
+		}
+
+		s.storeMutex.Unlock()
+
+		s.stats.expired.Add(int64(len(expired)))
+
+		for _, key := range expired {
+			s.touchKey(key)
+		}
+		removed = append(removed, expired...)
+
+		if len(expired)*100 <= sampled*ACTIVE_EXPIRE_CYCLE_ACCEPTABLE_STALE || !clock.FromContext(ctx).Now().Before(deadline) {
+			return removed
+		}
+	}
+}
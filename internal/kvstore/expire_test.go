@@ -0,0 +1,47 @@
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tilinna/clock"
+)
+
+func TestKVStore_ActiveExpireCycle(t *testing.T) {
+	store := NewKVStore()
+
+	start := time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)
+	ctx := clock.Context(context.Background(), clock.NewMock(start))
+
+	ttl := uint64(1000)
+	longTtl := uint64(60_000)
+
+	for i := 0; i < 100; i++ {
+		store.SetKeyWithExpiry(ctx, fmt.Sprintf("expiring:%d", i), "value", &ttl)
+	}
+
+	for i := 0; i < 5; i++ {
+		store.SetKeyWithExpiry(ctx, fmt.Sprintf("eternal:%d", i), "value", nil)
+		store.SetKeyWithExpiry(ctx, fmt.Sprintf("later:%d", i), "value", &longTtl)
+	}
+
+	if removed := store.ActiveExpireCycle(ctx, time.Second); len(removed) != 0 {
+		t.Fatalf("Expected no keys to be removed before they expire, removed %v", removed)
+	}
+
+	ctx = clock.Context(context.Background(), clock.NewMock(start.Add(2*time.Second)))
+
+	if removed := store.ActiveExpireCycle(ctx, time.Second); len(removed) != 100 {
+		t.Fatalf("Expected the 100 expired keys to be removed, removed %d", len(removed))
+	}
+
+	if count := store.KeyCount(); count != 10 {
+		t.Fatalf("Expected 10 keys to be left, got %d", count)
+	}
+
+	if expired := store.Stats().ExpiredKeys; expired != 100 {
+		t.Fatalf("Expected 100 expired keys to be counted, got %d", expired)
+	}
+}
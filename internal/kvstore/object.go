@@ -0,0 +1,71 @@
+package kvstore
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/tilinna/clock"
+)
+
+// Strings up to this long are embedded alongside their object header in Redis
+const EMBSTR_SIZE_LIMIT = 44
+
+// ObjectInfo describes how a key is stored, for OBJECT and MEMORY USAGE
+type ObjectInfo struct {
+	Encoding string
+	// How long since the key was last read or written
+	Idle time.Duration
+	// The key's LFU counter, decayed for the time since it was last accessed
+	Frequency uint8
+	// Bytes used by the key and its value
+	MemoryUsage int64
+}
+
+// stringEncoding names how Redis would store the string
+func stringEncoding(value string) string {
+	if len(value) <= 20 {
+		if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return "int"
+		}
+	}
+
+	if len(value) <= EMBSTR_SIZE_LIMIT {
+		return "embstr"
+	}
+	return "raw"
+}
+
+// Object looks up a key without it counting as an access, so that inspecting a
+// key doesn't change how it is evicted
+func (s KVStore) Object(ctx context.Context, key string) (ObjectInfo, bool) {
+	entry, found := s.findEntry(key)
+
+	if !found {
+		return ObjectInfo{}, false
+	}
+
+	if _, found := s.maybeDeleteExpiredEntry(ctx, key, entry.value); !found {
+		return ObjectInfo{}, false
+	}
+
+	now := clock.FromContext(ctx).Now()
+
+	s.storeMutex.RLock()
+	defer s.storeMutex.RUnlock()
+
+	return ObjectInfo{
+		Encoding:    entry.value.Encoding(),
+		Idle:        entry.idle(now),
+		Frequency:   entry.frequency(s.EvictionConfig(), now),
+		MemoryUsage: entry.size,
+	}, true
+}
+
+// KeyCount returns how many keys are stored, including any that have expired but
+// are yet to be removed
+func (s KVStore) KeyCount() int {
+	s.storeMutex.RLock()
+	defer s.storeMutex.RUnlock()
+	return len(s.store)
+}
@@ -0,0 +1,53 @@
+package kvstore
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tilinna/clock"
+)
+
+func Test_stringEncoding(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "12345", want: "int"},
+		{value: "-9223372036854775808", want: "int"},
+		{value: "9223372036854775808", want: "embstr"},
+		{value: "1.5", want: "embstr"},
+		{value: strings.Repeat("a", 44), want: "embstr"},
+		{value: strings.Repeat("a", 45), want: "raw"},
+	}
+	for _, tt := range tests {
+		if got := stringEncoding(tt.value); got != tt.want {
+			t.Errorf("stringEncoding(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestKVStore_Object(t *testing.T) {
+	store := NewKVStore()
+
+	start := time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)
+	ctx, _ := clock.NewMock(start).DeadlineContext(context.Background(), start)
+	later, _ := clock.NewMock(start.Add(10*time.Second)).DeadlineContext(context.Background(), start)
+
+	store.SetKeyWithExpiry(ctx, "a", "hello", nil)
+
+	info, found := store.Object(later, "a")
+	if !found || info.Encoding != "embstr" || info.Idle != 10*time.Second || info.MemoryUsage != entrySize("a", NewStoredString("hello", nil)) {
+		t.Fatalf("Object() = %+v, %v", info, found)
+	}
+
+	// Looking at a key doesn't count as accessing it
+	if info, _ := store.Object(later, "a"); info.Idle != 10*time.Second {
+		t.Errorf("Object() idle after a second look = %v, want 10s", info.Idle)
+	}
+
+	if _, found := store.Object(ctx, "missing"); found {
+		t.Errorf("Object() found a missing key")
+	}
+}
@@ -0,0 +1,85 @@
+package kvstore
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Stats counts how lookups by reading commands have gone, along with how many
+// keys have expired, since the store was created or ResetStats was last called
+type Stats struct {
+	Hits        int64
+	Misses      int64
+	ExpiredKeys int64
+	EvictedKeys int64
+}
+
+type storeStats struct {
+	hits    atomic.Int64
+	misses  atomic.Int64
+	expired atomic.Int64
+	evicted atomic.Int64
+}
+
+func (s KVStore) Stats() Stats {
+	return Stats{
+		Hits:        s.stats.hits.Load(),
+		Misses:      s.stats.misses.Load(),
+		ExpiredKeys: s.stats.expired.Load(),
+		EvictedKeys: s.stats.evicted.Load(),
+	}
+}
+
+func (s KVStore) ResetStats() {
+	s.stats.hits.Store(0)
+	s.stats.misses.Store(0)
+	s.stats.expired.Store(0)
+	s.stats.evicted.Store(0)
+}
+
+func (s KVStore) countLookup(found bool) {
+	if found {
+		s.stats.hits.Add(1)
+	} else {
+		s.stats.misses.Add(1)
+	}
+}
+
+// ReadKey behaves as GetKey, counting the lookup as a keyspace hit or miss as
+// reading commands such as GET do
+func (s KVStore) ReadKey(ctx context.Context, key string) (StoredValue, bool) {
+	stored, found := s.GetKey(ctx, key)
+	s.countLookup(found)
+	return stored, found
+}
+
+// WatchStats returns how many clients are watching keys, and how many keys they
+// are watching between them
+func (s KVStore) WatchStats() (int, int) {
+	s.watchMutex.Lock()
+	defer s.watchMutex.Unlock()
+
+	watches := map[*Watch]struct{}{}
+
+	for _, watchers := range s.watchers {
+		for w := range watchers {
+			watches[w] = struct{}{}
+		}
+	}
+
+	return len(watches), len(s.watchers)
+}
+
+// KeysByType counts the keys of each type, along with how many keys have an
+// expiry. Keys that have expired but are yet to be removed are counted.
+func (s KVStore) KeysByType() (map[string]int, int) {
+	s.storeMutex.RLock()
+	defer s.storeMutex.RUnlock()
+
+	types := map[string]int{}
+
+	for _, entry := range s.store {
+		types[entry.value.Type()]++
+	}
+	return types, len(s.volatile)
+}
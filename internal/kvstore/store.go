@@ -4,28 +4,51 @@ import (
 	"codecrafters/internal/serde"
 	"codecrafters/internal/time"
 	"context"
+	"errors"
 	"sync"
 
 	"github.com/tilinna/clock"
 )
 
+var (
+	ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrNoSuchKey = errors.New("ERR no such key")
+)
+
 type StoredValue interface {
 	Value() serde.Value
 	Type() string
 	IsExpired(context.Context) bool
+	// MemoryUsage estimates how many bytes the value uses
+	MemoryUsage() int64
+	// Encoding names the way Redis would store the value, as OBJECT ENCODING shows
+	Encoding() string
 }
 
 type storeChan struct {
-	key   string
-	id    StreamId
-	value map[string]string
+	key string
+	id  StreamId
 }
 
 type KVStore struct {
-	store             map[string]StoredValue
+	store map[string]*storeEntry
+	// Keys with an expiry set, which the volatile eviction policies choose from
+	volatile          map[string]struct{}
 	storeMutex        *sync.RWMutex
 	streamSubscribers map[string][]chan storeChan
 	subscribersMutex  *sync.RWMutex
+	watchers          map[string]map[*Watch]struct{}
+	watchMutex        *sync.Mutex
+	// Called with every key that is written, deleted or expires
+	keyModified func(key string)
+	stats       *storeStats
+	eviction    *evictionState
+}
+
+// OnKeyModified registers f to be told about every change to a key, from
+// whichever write path makes it
+func (s *KVStore) OnKeyModified(f func(key string)) {
+	s.keyModified = f
 }
 
 func (s *KVStore) Subscribe(key string, ch chan storeChan) {
@@ -53,10 +76,15 @@ func (s *KVStore) Unsubscribe(key string, ch chan storeChan) {
 
 func (s KVStore) SetKeyWithExpiresAt(key string, value string, expiresAtMs *uint64) StoredValue {
 	storedValue := NewStoredString(value, expiresAtMs)
-	s.setKey(key, storedValue)
+	s.setKey(context.Background(), key, storedValue)
 	return storedValue
 }
 
+// SetValue stores a value as it is, such as one loaded from disk
+func (s KVStore) SetValue(key string, value StoredValue) {
+	s.setKey(context.Background(), key, value)
+}
+
 func (s KVStore) SetKeyWithExpiry(ctx context.Context, key string, value string, expiresInMs *uint64) StoredString {
 	contextClock := clock.FromContext(ctx)
 	var expiresAt *uint64 = nil
@@ -68,14 +96,21 @@ func (s KVStore) SetKeyWithExpiry(ctx context.Context, key string, value string,
 
 	storedValue := NewStoredString(value, expiresAt)
 
-	s.setKey(key, storedValue)
+	s.setKey(ctx, key, storedValue)
 	return storedValue
 }
 
-func (s KVStore) SetStream(ctx context.Context, key string, id string, value map[string]string) (StreamId, StoredStream, error) {
-	existingStream, exists := s.getStream(ctx, key)
+func (s KVStore) SetStream(ctx context.Context, key string, id string, value []string, opts StreamAddOptions) (StreamId, StoredStream, error) {
+	existingStream, exists, err := s.getStream(ctx, key)
+
+	if err != nil {
+		return StreamId{}, existingStream, err
+	}
 
 	if !exists {
+		if opts.NoMkStream {
+			return StreamId{}, existingStream, ErrNoSuchKey
+		}
 		existingStream = NewStoredStream()
 	}
 
@@ -85,14 +120,18 @@ func (s KVStore) SetStream(ctx context.Context, key string, id string, value map
 		return streamId, existingStream, err
 	}
 
-	s.setKey(key, existingStream)
+	if opts.Trim != nil {
+		existingStream.Trim(*opts.Trim)
+	}
+
+	s.setKey(ctx, key, existingStream)
 
 	s.subscribersMutex.RLock()
 	defer s.subscribersMutex.RUnlock()
 
 	for _, ch := range s.streamSubscribers[key] {
 		select {
-		case ch <- storeChan{key, streamId, value}:
+		case ch <- storeChan{key, streamId}:
 		default:
 			// Non-blocking send to avoid blocking SetStream if a subscriber is slow
 			// or has already timed out.
@@ -102,131 +141,323 @@ func (s KVStore) SetStream(ctx context.Context, key string, id string, value map
 	return streamId, existingStream, nil
 }
 
-func (s KVStore) ReadStream(ctx context.Context, key string, startId string) ([]StreamQueryResult, error) {
-	return s.QueryStream(ctx, key, startId, END_OF_STREAM)
+func (s KVStore) StreamLength(ctx context.Context, key string) (uint64, error) {
+	existingStream, exists, err := s.readStream(ctx, key)
+
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	return existingStream.Length(), nil
 }
 
-func (s KVStore) ReadStreamBlocking(ctx context.Context, key string, startId string, result chan BlockingQueryResult) {
-	defer close(result)
-	ch := make(chan storeChan)
-	s.Subscribe(key, ch)
-	defer s.Unsubscribe(key, ch)
+func (s KVStore) DeleteStreamEntries(ctx context.Context, key string, ids []StreamId) (uint64, error) {
+	existingStream, exists, err := s.getStream(ctx, key)
 
-	select {
-	case <-ctx.Done():
-		return
-	case data := <-ch:
-		if data.key == key && data.id.ToString() > startId {
-			queryResult, err := s.ReadStream(ctx, key, data.id.ToString())
-			if err != nil {
-				return
-			}
-			result <- BlockingQueryResult{data.key, queryResult}
-		}
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	deleted := existingStream.Delete(ids)
+
+	if deleted > 0 {
+		s.refreshSize(key)
+		s.touchKey(key)
 	}
 
+	return deleted, nil
 }
 
-func (s KVStore) QueryStream(ctx context.Context, key string, startId string, endId string) ([]StreamQueryResult, error) {
-	result := []StreamQueryResult{}
+func (s KVStore) TrimStream(ctx context.Context, key string, opts StreamTrimOptions) (uint64, error) {
+	existingStream, exists, err := s.getStream(ctx, key)
 
-	existingStream, exists := s.getStream(ctx, key)
+	if err != nil || !exists {
+		return 0, err
+	}
 
-	if !exists {
-		return result, nil
+	deleted := existingStream.Trim(opts)
+
+	if deleted > 0 {
+		s.refreshSize(key)
+		s.touchKey(key)
 	}
 
-	startStreamId, err := GetQueryStreamId(startId)
+	return deleted, nil
+}
+
+func (s KVStore) SetStreamId(ctx context.Context, key string, id StreamId, entriesAdded *uint64, maxDeletedId *StreamId) error {
+	existingStream, exists, err := s.getStream(ctx, key)
 
 	if err != nil {
-		return result, err
+		return err
 	}
 
-	endStreamId, err := GetQueryStreamId(endId)
+	if !exists {
+		return ErrNoSuchKey
+	}
 
-	if err != nil {
-		return result, err
+	err = existingStream.SetId(id, entriesAdded, maxDeletedId)
+
+	if err == nil {
+		s.touchKey(key)
+	}
+
+	return err
+}
+
+// StreamReadStart resolves an ID given to XREAD into the ID that returned entries
+// must follow. "$" only waits for entries added from now on, while "+" also
+// includes the current last entry of the stream.
+func (s KVStore) StreamReadStart(ctx context.Context, key string, id string) (StreamId, error) {
+	if id != NEW_ENTRIES_ONLY && id != END_OF_STREAM {
+		return ParseStreamId(id)
+	}
+
+	existingStream, exists, err := s.getStream(ctx, key)
+
+	if err != nil || !exists {
+		return StreamId{}, err
 	}
 
-	existingStream.value.Walk(func(s string, v interface{}) bool {
+	existingStream.mutex.RLock()
+	defer existingStream.mutex.RUnlock()
+
+	if id == END_OF_STREAM && existingStream.metadata.length > 0 {
+		return existingStream.lastEntryId().prev(), nil
+	}
+
+	return existingStream.metadata.lastId, nil
+}
 
-		if err != nil || s < startStreamId.ToString() || s > endStreamId.ToString() {
-			return false
+// ReadStreams returns up to count entries following each of the given IDs,
+// leaving out any stream without new entries
+func (s KVStore) ReadStreams(ctx context.Context, reads []StreamRead, count int) ([]StreamReadResult, error) {
+	results := []StreamReadResult{}
+
+	for _, read := range reads {
+		if read.After == MaxStreamId {
+			continue
 		}
 
-		maybeMap, ok := v.(map[string]string)
+		entries, err := s.QueryStream(ctx, read.Key, StreamRange{Start: read.After.next(), End: MaxStreamId, Count: count})
 
-		if !ok {
-			return false
+		if err != nil {
+			return results, err
 		}
 
-		kvList := []string{}
+		if len(entries) > 0 {
+			results = append(results, StreamReadResult{read.Key, entries})
+		}
+	}
 
-		for k, v := range maybeMap {
-			kvList = append(kvList, k)
-			kvList = append(kvList, v)
+	return results, nil
+}
+
+// ReadStreamsBlocking behaves as ReadStreams, but if no stream has new entries it
+// waits until one of them does or ctx is done, whichever comes first
+func (s KVStore) ReadStreamsBlocking(ctx context.Context, reads []StreamRead, count int) ([]StreamReadResult, error) {
+	// Buffer a single notification, if more arrive while we're querying we'll see
+	// their entries anyway
+	ch := make(chan storeChan, 1)
+
+	for _, read := range reads {
+		s.Subscribe(read.Key, ch)
+		defer s.Unsubscribe(read.Key, ch)
+	}
+
+	// Only check for existing entries once subscribed so nothing slips in between
+	results, err := s.ReadStreams(ctx, reads, count)
+
+	for err == nil && len(results) == 0 {
+		select {
+		case <-ctx.Done():
+			return results, nil
+		case <-ch:
+			results, err = s.ReadStreams(ctx, reads, count)
 		}
-		result = append(result, StreamQueryResult{s, kvList})
-		return false
-	})
+	}
 
-	return result, nil
+	return results, err
 }
 
-func (s KVStore) getStream(ctx context.Context, key string) (StoredStream, bool) {
+func (s KVStore) QueryStream(ctx context.Context, key string, query StreamRange) ([]StreamQueryResult, error) {
+	existingStream, exists, err := s.readStream(ctx, key)
+
+	if err != nil || !exists {
+		return []StreamQueryResult{}, err
+	}
+
+	return existingStream.Range(query), nil
+}
+
+func (s KVStore) StreamInfo(ctx context.Context, key string, full bool, count int) (StreamInfo, error) {
+	existingStream, exists, err := s.readStream(ctx, key)
+
+	if err != nil {
+		return StreamInfo{}, err
+	}
+
+	if !exists {
+		return StreamInfo{}, ErrNoSuchKey
+	}
+
+	return existingStream.Info(full, count), nil
+}
+
+// readStream behaves as getStream, counting the lookup as ReadKey does
+func (s KVStore) readStream(ctx context.Context, key string) (StoredStream, bool, error) {
+	stream, exists, err := s.getStream(ctx, key)
+
+	if err == nil {
+		s.countLookup(exists)
+	}
+	return stream, exists, err
+}
+
+func (s KVStore) getStream(ctx context.Context, key string) (StoredStream, bool, error) {
 	existingStream, exists := s.GetKey(ctx, key)
 
 	if !exists {
-		return StoredStream{}, exists
+		return StoredStream{}, false, nil
 	}
 
 	stream, ok := existingStream.(StoredStream)
 
 	if !ok {
-		return StoredStream{}, false
+		return StoredStream{}, false, ErrWrongType
 	}
 
-	return stream, true
+	return stream, true, nil
 }
 
-func (s KVStore) setKey(key string, value StoredValue) *StoredValue {
+// expiresAt returns when the value expires, if it ever does
+func expiresAt(value StoredValue) *uint64 {
+	if stored, ok := value.(StoredString); ok {
+		return stored.ExpiresAt()
+	}
+	return nil
+}
+
+// setKey stores the value, keeping what eviction knows of how the key has been
+// accessed should it already exist
+func (s KVStore) setKey(ctx context.Context, key string, value StoredValue) *StoredValue {
+	now := clock.FromContext(ctx).Now()
+
 	s.storeMutex.Lock()
-	defer s.storeMutex.Unlock()
-	s.store[key] = value
+	entry, ok := s.store[key]
+
+	if !ok {
+		entry = newStoreEntry(now)
+		s.store[key] = entry
+	}
+
+	size := entrySize(key, value)
+	s.eviction.used.Add(size - entry.size)
+	entry.value, entry.size = value, size
+
+	if expiresAt(value) != nil {
+		s.volatile[key] = struct{}{}
+	} else {
+		delete(s.volatile, key)
+	}
+	s.storeMutex.Unlock()
+
+	entry.accessed(s.EvictionConfig(), now)
+	s.touchKey(key)
 	return &value
 }
 
-func (s KVStore) findKey(key string) (StoredValue, bool) {
+// refreshSize recounts the memory used by a value changed in place
+func (s KVStore) refreshSize(key string) {
+	s.storeMutex.Lock()
+	defer s.storeMutex.Unlock()
+
+	if entry, ok := s.store[key]; ok {
+		size := entrySize(key, entry.value)
+		s.eviction.used.Add(size - entry.size)
+		entry.size = size
+	}
+}
+
+// Delete removes the keys, returning how many of them there were. Keys that had
+// already expired are removed without being counted.
+func (s KVStore) Delete(ctx context.Context, keys []string) int {
+	s.storeMutex.Lock()
+	deleted := []string{}
+
+	for _, key := range keys {
+		entry, ok := s.store[key]
+
+		if !ok {
+			continue
+		}
+
+		if !entry.value.IsExpired(ctx) {
+			deleted = append(deleted, key)
+		}
+		s.deleteEntry(key)
+	}
+	s.storeMutex.Unlock()
+
+	for _, key := range deleted {
+		s.touchKey(key)
+	}
+	return len(deleted)
+}
+
+// deleteEntry removes the key, which the caller must hold the store lock for
+func (s KVStore) deleteEntry(key string) {
+	if entry, ok := s.store[key]; ok {
+		s.eviction.used.Add(-entry.size)
+		delete(s.store, key)
+		delete(s.volatile, key)
+	}
+}
+
+func (s KVStore) findEntry(key string) (*storeEntry, bool) {
 	s.storeMutex.RLock()
 	defer s.storeMutex.RUnlock()
-	storedValue, ok := s.store[key]
+	entry, ok := s.store[key]
+	return entry, ok
+}
+
+func (s KVStore) findKey(key string) (StoredValue, bool) {
+	entry, ok := s.findEntry(key)
 
 	if !ok {
 		return nil, false
 	}
 
-	return storedValue, true
+	return entry.value, true
 }
 
 func (s KVStore) GetKeys(ctx context.Context) []string {
 	s.storeMutex.RLock()
-	defer s.storeMutex.RUnlock()
 
 	keys := []string{}
+	expired := []string{}
 
-	for k, v := range s.store {
-		_, exists := s.maybeDeleteExpiredEntry(ctx, k, v)
-		if exists {
-			keys = append(keys, k)
+	for k, entry := range s.store {
+		if entry.value.IsExpired(ctx) {
+			expired = append(expired, k)
+			continue
 		}
+		keys = append(keys, k)
 	}
+
+	s.storeMutex.RUnlock()
+
+	for _, k := range expired {
+		s.deleteExpiredKey(ctx, k)
+	}
+
 	return keys
 }
 
 func (s KVStore) maybeDeleteExpiredEntry(ctx context.Context, key string, stored StoredValue) (StoredValue, bool) {
 
 	if stored.IsExpired(ctx) {
-		s.deleteKey(key)
+		s.deleteExpiredKey(ctx, key)
 		return nil, false
 	}
 
@@ -235,23 +466,81 @@ func (s KVStore) maybeDeleteExpiredEntry(ctx context.Context, key string, stored
 
 func (s KVStore) GetKey(ctx context.Context, key string) (StoredValue, bool) {
 
-	value, found := s.findKey(key)
+	entry, found := s.findEntry(key)
 
 	if !found {
 		return nil, false
 	}
-	return s.maybeDeleteExpiredEntry(ctx, key, value)
+
+	value, found := s.maybeDeleteExpiredEntry(ctx, key, entry.value)
+
+	if found {
+		entry.accessed(s.EvictionConfig(), clock.FromContext(ctx).Now())
+	}
+	return value, found
 }
 
-func (s KVStore) deleteKey(key string) {
-	delete(s.store, key)
+// deleteExpiredKey removes key provided it is still expired once we hold the lock,
+// so we don't throw away a value written since we last looked
+func (s KVStore) deleteExpiredKey(ctx context.Context, key string) {
+	s.storeMutex.Lock()
+	entry, exists := s.store[key]
+	exists = exists && entry.value.IsExpired(ctx)
+
+	if exists {
+		s.deleteEntry(key)
+	}
+	s.storeMutex.Unlock()
+
+	if exists {
+		s.stats.expired.Add(1)
+		s.touchKey(key)
+	}
+}
+
+// Snapshot copies every key that hasn't expired, such as for saving to disk
+func (s KVStore) Snapshot(ctx context.Context) map[string]StoredValue {
+	s.storeMutex.RLock()
+	defer s.storeMutex.RUnlock()
+
+	snapshot := make(map[string]StoredValue, len(s.store))
+
+	for k, entry := range s.store {
+		if !entry.value.IsExpired(ctx) {
+			snapshot[k] = entry.value
+		}
+	}
+
+	return snapshot
+}
+
+// Flush removes every key from the store
+func (s KVStore) Flush() {
+	s.storeMutex.Lock()
+	keys := make([]string, 0, len(s.store))
+
+	for k := range s.store {
+		keys = append(keys, k)
+		s.deleteEntry(k)
+	}
+	s.eviction.pool = nil
+	s.storeMutex.Unlock()
+
+	for _, k := range keys {
+		s.touchKey(k)
+	}
 }
 
 func NewKVStore() KVStore {
 	return KVStore{
-		store:             map[string]StoredValue{},
+		store:             map[string]*storeEntry{},
+		volatile:          map[string]struct{}{},
 		storeMutex:        &sync.RWMutex{},
 		subscribersMutex:  &sync.RWMutex{},
 		streamSubscribers: map[string][]chan storeChan{},
+		watchers:          map[string]map[*Watch]struct{}{},
+		watchMutex:        &sync.Mutex{},
+		stats:             &storeStats{},
+		eviction:          newEvictionState(),
 	}
 }
@@ -2,6 +2,8 @@ package kvstore
 
 import (
 	"context"
+	"reflect"
+	"slices"
 	"testing"
 	"time"
 
@@ -60,3 +62,104 @@ func TestKVStore_SetKeyWithExpiry(t *testing.T) {
 	}
 
 }
+
+func TestKVStore_ReadStreamsBlocking(t *testing.T) {
+	store := NewKVStore()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reads := []StreamRead{{"first", StreamId{}}, {"second", StreamId{}}}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		store.SetStream(context.Background(), "second", "1-1", []string{"foo", "bar"}, StreamAddOptions{})
+	}()
+
+	results, err := store.ReadStreamsBlocking(ctx, reads, 0)
+
+	if err != nil {
+		t.Fatalf("Expected blocking read to succeed, got %v", err)
+	}
+
+	if len(results) != 1 || results[0].Key != "second" || results[0].Values[0].Id != "1-1" {
+		t.Fatalf("Expected to be woken by a write to the second stream, got %v", results)
+	}
+
+	if len(store.streamSubscribers) != 0 {
+		t.Fatalf("Expected subscriptions to be released, got %v", store.streamSubscribers)
+	}
+}
+
+func TestKVStore_OnKeyModified(t *testing.T) {
+	store := NewKVStore()
+
+	modified := []string{}
+	store.OnKeyModified(func(key string) {
+		modified = append(modified, key)
+	})
+
+	ctx := context.Background()
+
+	store.SetKeyWithExpiry(ctx, "a", "1", nil)
+	store.SetStream(ctx, "s", "*", []string{"f", "v"}, StreamAddOptions{})
+	store.GetKey(ctx, "a")
+
+	if !reflect.DeepEqual(modified, []string{"a", "s"}) {
+		t.Fatalf("modified keys = %v, want [a s]", modified)
+	}
+
+	modified = []string{}
+	store.Flush()
+	slices.Sort(modified)
+
+	if !reflect.DeepEqual(modified, []string{"a", "s"}) {
+		t.Errorf("flushed keys = %v, want [a s]", modified)
+	}
+}
+
+func TestKVStore_Stats(t *testing.T) {
+	store := NewKVStore()
+
+	start := time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)
+	ctx, _ := clock.NewMock(start).DeadlineContext(context.Background(), start)
+
+	expiry := uint64(1000)
+	store.SetKeyWithExpiry(ctx, "a", "1", nil)
+	store.SetKeyWithExpiry(ctx, "b", "2", &expiry)
+	store.SetStream(ctx, "s", "*", []string{"f", "v"}, StreamAddOptions{})
+
+	store.ReadKey(ctx, "a")
+	store.ReadKey(ctx, "missing")
+	store.StreamLength(ctx, "s")
+	// Lookups by writes don't count
+	store.GetKey(ctx, "a")
+
+	later, _ := clock.NewMock(start.Add(2*time.Second)).DeadlineContext(context.Background(), start)
+	store.ReadKey(later, "b")
+
+	want := Stats{Hits: 2, Misses: 2, ExpiredKeys: 1}
+	if got := store.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+
+	store.ResetStats()
+	if got := store.Stats(); got != (Stats{}) {
+		t.Errorf("Stats() after reset = %+v, want zero", got)
+	}
+}
+
+func TestKVStore_KeysByType(t *testing.T) {
+	store := NewKVStore()
+	ctx := context.Background()
+
+	expiry := uint64(60_000)
+	store.SetKeyWithExpiry(ctx, "a", "1", nil)
+	store.SetKeyWithExpiry(ctx, "b", "2", &expiry)
+	store.SetStream(ctx, "s", "*", []string{"f", "v"}, StreamAddOptions{})
+
+	types, expires := store.KeysByType()
+
+	if want := map[string]int{"string": 2, "stream": 1}; !reflect.DeepEqual(types, want) || expires != 1 {
+		t.Errorf("KeysByType() = %v, %d, want %v, 1", types, expires, want)
+	}
+}
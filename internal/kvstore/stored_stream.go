@@ -5,16 +5,98 @@ import (
 	"codecrafters/internal/time"
 	"context"
 	"errors"
-	"fmt"
+	"math"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/armon/go-radix"
 	"github.com/tilinna/clock"
 )
 
+// Mirrors the stream-node-max-entries default, the number of entries we pack
+// into a single node of the radix tree before starting a new one
+const STREAM_NODE_MAX_ENTRIES = 100
+
+// Rough costs of a stream, each of its nodes and each of their entries, on top of
+// the strings they hold
+const (
+	STREAM_OVERHEAD       = 128
+	STREAM_NODE_OVERHEAD  = 96
+	STREAM_ENTRY_OVERHEAD = 64
+)
+
+const (
+	TRIM_MAXLEN = "maxlen"
+	TRIM_MINID  = "minid"
+)
+
+type StreamTrimOptions struct {
+	Strategy    string
+	MaxLen      uint64
+	MinId       StreamId
+	Approximate bool
+	// Caps the number of entries removed by an approximate trim, 0 means no limit
+	Limit uint64
+}
+
+type StreamAddOptions struct {
+	NoMkStream bool
+	Trim       *StreamTrimOptions
+}
+
+// A streamEntry keeps its fields in the order they were given. Like a listpack
+// entry flagged SAMEFIELDS, an entry whose field names match the master entry of
+// its node only stores its values, leaving fields nil.
+type streamEntry struct {
+	id      StreamId
+	fields  []string
+	values  []string
+	deleted bool
+}
+
+// A streamNode holds a run of consecutive entries, keyed in the radix tree by the
+// ID of its first entry (its master ID). The field names of that first entry are
+// kept as the master fields, shared by every entry with the same shape. Deleted
+// entries stay in place as tombstones until every entry in the node has gone, at
+// which point the node itself is removed.
+type streamNode struct {
+	masterFields []string
+	entries      []streamEntry
+	live         int
+	// Bytes used by the node, which tombstoned entries go on using until the node
+	// is removed
+	bytes int64
+}
+
+type streamMetadata struct {
+	lastId       StreamId
+	firstId      StreamId
+	maxDeletedId StreamId
+	entriesAdded uint64
+	length       uint64
+	// Master IDs of every node in the tree in ascending order, letting range
+	// queries binary search their way to a starting node
+	nodeIds []StreamId
+	// Bytes used by every node in the tree
+	bytes int64
+}
+
 type StoredStream struct {
-	value radix.Tree
+	value    *radix.Tree
+	metadata *streamMetadata
+	mutex    *sync.RWMutex
+}
+
+// StreamRange describes an inclusive interval of IDs to query. A Count of 0
+// returns every entry in the interval.
+type StreamRange struct {
+	Start   StreamId
+	End     StreamId
+	Count   int
+	Reverse bool
 }
 
 type StreamQueryResult struct {
@@ -22,47 +104,73 @@ type StreamQueryResult struct {
 	Values []string
 }
 
-type BlockingQueryResult struct {
+type StreamRead struct {
+	Key   string
+	After StreamId
+}
+
+type StreamReadResult struct {
 	Key    string
 	Values []StreamQueryResult
 }
 
+// stringsSize counts the headers and contents of the strings
+func stringsSize(strs []string) int64 {
+	size := int64(0)
+
+	for _, str := range strs {
+		size += int64(16 + len(str))
+	}
+	return size
+}
+
+func nodeKey(id StreamId) string {
+	return id.encode()
+}
+
 func (ss StoredStream) generateStreamId(ctx context.Context, input string) (StreamId, error) {
+	lastId := ss.metadata.lastId
 
-	now := time.NowMilli(clock.FromContext(ctx))
-	newId := StreamId{timestamp: now, seqNo: 0}
 	if input == WILDCARD {
-		return newId, nil
-	}
+		now := time.NowMilli(clock.FromContext(ctx))
 
-	parts := strings.Split(input, STREAM_ID_DELIMETER)
-	if len(parts) != 2 {
-		return StreamId{}, fmt.Errorf("expected two parts in stream ID got %d", len(parts))
-	}
+		if now > lastId.timestamp {
+			return StreamId{timestamp: now, seqNo: 0}, nil
+		}
 
-	if parts[1] == WILDCARD {
+		if lastId.seqNo == math.MaxUint64 {
+			return StreamId{}, errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+		}
+		return StreamId{timestamp: lastId.timestamp, seqNo: lastId.seqNo + 1}, nil
+	}
 
-		var seqNoCount uint64 = 0
+	parts := strings.Split(input, STREAM_ID_DELIMETER)
 
-		msTime, err := strconv.Atoi(parts[0])
+	if len(parts) == 2 && parts[1] == WILDCARD {
+		msTime, err := strconv.ParseUint(parts[0], 10, 64)
 
 		if err != nil {
-			return StreamId{}, err
+			return StreamId{}, ErrInvalidStreamId
 		}
 
-		ss.value.WalkPrefix(parts[0], func(s string, v interface{}) bool {
-			fmt.Printf("%v %v", input, s)
-			seqNoCount += 1
-			return false
-		})
+		if msTime < lastId.timestamp {
+			return StreamId{}, errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+		}
+
+		if msTime == lastId.timestamp {
+			if lastId.seqNo == math.MaxUint64 {
+				return StreamId{}, errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+			}
 
-		if msTime == 0 && seqNoCount == 0 {
-			// Redis only permits IDs that start counting from 0-1
-			seqNoCount += 1
+			// Redis only permits IDs that start counting from 0-1, which falls out of
+			// incrementing the 0-0 ID of an empty stream
+			return StreamId{timestamp: msTime, seqNo: lastId.seqNo + 1}, nil
 		}
-		return StreamId{timestamp: uint64(msTime), seqNo: seqNoCount}, nil
+
+		return StreamId{timestamp: msTime, seqNo: 0}, nil
 	}
-	id, err := parseStreamId(input)
+
+	id, err := ParseStreamId(input)
 
 	if err != nil {
 		return StreamId{}, err
@@ -71,51 +179,589 @@ func (ss StoredStream) generateStreamId(ctx context.Context, input string) (Stre
 	err = ss.validateInsertionKey(id)
 	return id, err
 }
+
 func (ss StoredStream) validateInsertionKey(key StreamId) error {
-	if key.timestamp == 0 && key.seqNo == 0 {
+	if key.IsZero() {
 		return errors.New("ERR The ID specified in XADD must be greater than 0-0")
 	}
 
-	lastKey, _, exists := ss.value.Maximum()
+	if key.Compare(ss.metadata.lastId) <= 0 {
+		return errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	}
+
+	return nil
+}
 
-	if !exists {
-		return nil
+func NewStoredStream() StoredStream {
+	return StoredStream{
+		value:    radix.New(),
+		metadata: &streamMetadata{},
+		mutex:    &sync.RWMutex{},
 	}
+}
 
-	lastId, err := parseStreamId(lastKey)
+func (ss StoredStream) Type() string {
+	return "stream"
+}
+
+// Insert adds an entry to the stream, with value holding its field names and
+// values interleaved in the order they should be returned
+func (ss StoredStream) Insert(ctx context.Context, id string, value []string) (StreamId, error) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	streamId, err := ss.generateStreamId(ctx, id)
 
 	if err != nil {
-		return err
+		return streamId, err
 	}
 
-	if lastId.timestamp > key.timestamp {
-		return errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	ss.append(streamId, value)
+	return streamId, nil
+}
+
+func (ss StoredStream) append(id StreamId, value []string) {
+	nodeCount := len(ss.metadata.nodeIds)
+	var node *streamNode
+
+	if nodeCount > 0 {
+		node = ss.node(nodeCount - 1)
 	}
 
-	if lastId.timestamp == key.timestamp && lastId.seqNo >= key.seqNo {
-		return errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	fields := make([]string, 0, len(value)/2)
+	values := make([]string, 0, len(value)/2)
+
+	for i := 0; i+1 < len(value); i += 2 {
+		fields = append(fields, value[i])
+		values = append(values, value[i+1])
+	}
+
+	if node == nil || len(node.entries) >= STREAM_NODE_MAX_ENTRIES {
+		node = &streamNode{masterFields: fields, bytes: STREAM_NODE_OVERHEAD + stringsSize(fields)}
+		ss.metadata.bytes += node.bytes
+		ss.value.Insert(nodeKey(id), node)
+		ss.metadata.nodeIds = append(ss.metadata.nodeIds, id)
+	}
+
+	if slices.Equal(fields, node.masterFields) {
+		fields = nil
+	}
+
+	node.entries = append(node.entries, streamEntry{id: id, fields: fields, values: values})
+	node.live += 1
+
+	entryBytes := STREAM_ENTRY_OVERHEAD + stringsSize(fields) + stringsSize(values)
+	node.bytes += entryBytes
+	ss.metadata.bytes += entryBytes
+
+	if ss.metadata.length == 0 {
+		ss.metadata.firstId = id
+	}
+
+	ss.metadata.lastId = id
+	ss.metadata.length += 1
+	ss.metadata.entriesAdded += 1
+}
+
+func (ss StoredStream) node(i int) *streamNode {
+	v, ok := ss.value.Get(nodeKey(ss.metadata.nodeIds[i]))
+
+	if !ok {
+		return nil
+	}
+
+	return v.(*streamNode)
+}
+
+func (ss StoredStream) removeNode(i int) {
+	if node := ss.node(i); node != nil {
+		ss.metadata.bytes -= node.bytes
+	}
+
+	ss.value.Delete(nodeKey(ss.metadata.nodeIds[i]))
+	ss.metadata.nodeIds = append(ss.metadata.nodeIds[:i], ss.metadata.nodeIds[i+1:]...)
+}
+
+// seek returns the index of the node that would hold id, which is the last node
+// whose master ID is not greater than it
+func (ss StoredStream) seek(id StreamId) int {
+	i := sort.Search(len(ss.metadata.nodeIds), func(i int) bool {
+		return ss.metadata.nodeIds[i].Compare(id) > 0
+	})
+
+	if i == 0 {
+		return 0
+	}
+	return i - 1
+}
+
+func newStreamQueryResult(node *streamNode, entry *streamEntry) StreamQueryResult {
+	fields := entry.fields
+
+	if fields == nil {
+		fields = node.masterFields
+	}
+
+	kvList := make([]string, 0, len(entry.values)*2)
+
+	for i, v := range entry.values {
+		kvList = append(kvList, fields[i])
+		kvList = append(kvList, v)
+	}
+
+	return StreamQueryResult{entry.id.ToString(), kvList}
+}
+
+func (ss StoredStream) Range(query StreamRange) []StreamQueryResult {
+	ss.mutex.RLock()
+	defer ss.mutex.RUnlock()
+
+	if query.Reverse {
+		return ss.reverseRange(query)
+	}
+	return ss.forwardRange(query)
+}
+
+func (ss StoredStream) forwardRange(query StreamRange) []StreamQueryResult {
+	result := []StreamQueryResult{}
+
+	if query.Start.Compare(query.End) > 0 {
+		return result
+	}
+
+	for i := ss.seek(query.Start); i < len(ss.metadata.nodeIds); i++ {
+		node := ss.node(i)
+
+		for j := range node.entries {
+			entry := &node.entries[j]
+
+			if entry.deleted || entry.id.Compare(query.Start) < 0 {
+				continue
+			}
+
+			if entry.id.Compare(query.End) > 0 {
+				return result
+			}
+
+			result = append(result, newStreamQueryResult(node, entry))
+
+			if query.Count > 0 && len(result) == query.Count {
+				return result
+			}
+		}
+	}
+
+	return result
+}
+
+func (ss StoredStream) reverseRange(query StreamRange) []StreamQueryResult {
+	result := []StreamQueryResult{}
+
+	if query.Start.Compare(query.End) > 0 || len(ss.metadata.nodeIds) == 0 {
+		return result
+	}
+
+	for i := ss.seek(query.End); i >= 0; i-- {
+		node := ss.node(i)
+
+		for j := len(node.entries) - 1; j >= 0; j-- {
+			entry := &node.entries[j]
+
+			if entry.deleted || entry.id.Compare(query.End) > 0 {
+				continue
+			}
+
+			if entry.id.Compare(query.Start) < 0 {
+				return result
+			}
+
+			result = append(result, newStreamQueryResult(node, entry))
+
+			if query.Count > 0 && len(result) == query.Count {
+				return result
+			}
+		}
+	}
+
+	return result
+}
+
+func (ss StoredStream) Length() uint64 {
+	ss.mutex.RLock()
+	defer ss.mutex.RUnlock()
+	return ss.metadata.length
+}
+
+// Delete tombstones each of the given entries, returning how many were actually
+// present in the stream
+func (ss StoredStream) Delete(ids []StreamId) uint64 {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	var deleted uint64 = 0
+
+	for _, id := range ids {
+		if len(ss.metadata.nodeIds) == 0 {
+			break
+		}
+
+		i := ss.seek(id)
+		node := ss.node(i)
+
+		for j := range node.entries {
+			entry := &node.entries[j]
+
+			if entry.deleted || entry.id.Compare(id) != 0 {
+				continue
+			}
+
+			entry.deleted = true
+			node.live -= 1
+			deleted += 1
+			ss.metadata.length -= 1
+
+			if node.live == 0 {
+				ss.removeNode(i)
+			}
+
+			if id.Compare(ss.metadata.maxDeletedId) > 0 {
+				ss.metadata.maxDeletedId = id
+			}
+			break
+		}
+	}
+
+	if deleted > 0 {
+		ss.refreshFirstId()
+	}
+
+	return deleted
+}
+
+func (ss StoredStream) Trim(opts StreamTrimOptions) uint64 {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	return ss.trim(opts)
+}
+
+func (ss StoredStream) shouldTrimEntry(opts StreamTrimOptions, id StreamId) bool {
+	if opts.Strategy == TRIM_MINID {
+		return id.Compare(opts.MinId) < 0
+	}
+	return ss.metadata.length > opts.MaxLen
+}
+
+func (ss StoredStream) shouldTrimNode(opts StreamTrimOptions, node *streamNode) bool {
+	if opts.Strategy == TRIM_MINID {
+		return node.entries[len(node.entries)-1].id.Compare(opts.MinId) < 0
+	}
+	return ss.metadata.length-uint64(node.live) >= opts.MaxLen
+}
+
+// trim removes entries from the head of the stream. Whole nodes are dropped where
+// possible, and exact trims tombstone the remaining entries of the last node.
+// Approximate trims stop at the first node that can't be dropped entirely, which
+// may leave a few more entries than requested in exchange for cheaper trimming.
+func (ss StoredStream) trim(opts StreamTrimOptions) uint64 {
+	var deleted uint64 = 0
+
+	for ss.metadata.length > 0 && len(ss.metadata.nodeIds) > 0 {
+		node := ss.node(0)
+
+		if ss.shouldTrimNode(opts, node) {
+			if opts.Approximate && opts.Limit > 0 && deleted+uint64(node.live) > opts.Limit {
+				break
+			}
+
+			ss.removeNode(0)
+			ss.metadata.length -= uint64(node.live)
+			deleted += uint64(node.live)
+			continue
+		}
+
+		if opts.Approximate {
+			break
+		}
+
+		for i := range node.entries {
+			entry := &node.entries[i]
+
+			if entry.deleted {
+				continue
+			}
+
+			if !ss.shouldTrimEntry(opts, entry.id) {
+				break
+			}
+
+			entry.deleted = true
+			node.live -= 1
+			ss.metadata.length -= 1
+			deleted += 1
+		}
+
+		// The entries left may all have been deleted already, as with MINID, where
+		// the node is only dropped whole when its last entry is below the ID
+		if node.live == 0 {
+			ss.removeNode(0)
+			continue
+		}
+		break
+	}
+
+	if deleted > 0 {
+		ss.refreshFirstId()
+	}
+
+	return deleted
+}
+
+func (ss StoredStream) refreshFirstId() {
+	ss.metadata.firstId = StreamId{}
+
+	for i := range ss.metadata.nodeIds {
+		node := ss.node(i)
+
+		for j := range node.entries {
+			if !node.entries[j].deleted {
+				ss.metadata.firstId = node.entries[j].id
+				return
+			}
+		}
+	}
+}
+
+func (ss StoredStream) lastEntryId() StreamId {
+	for i := len(ss.metadata.nodeIds) - 1; i >= 0; i-- {
+		node := ss.node(i)
+
+		for j := len(node.entries) - 1; j >= 0; j-- {
+			if !node.entries[j].deleted {
+				return node.entries[j].id
+			}
+		}
+	}
+
+	return StreamId{}
+}
+
+// SetId implements XSETID, moving the last ID of the stream forward and
+// optionally overriding the entries-added and max-deleted-id bookkeeping
+func (ss StoredStream) SetId(id StreamId, entriesAdded *uint64, maxDeletedId *StreamId) error {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	if maxDeletedId != nil && id.Compare(*maxDeletedId) < 0 {
+		return errors.New("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+	}
+
+	if ss.metadata.length > 0 {
+		if id.Compare(ss.lastEntryId()) < 0 {
+			return errors.New("ERR The ID specified in XSETID is smaller than the target stream top item")
+		}
+
+		if entriesAdded != nil && *entriesAdded < ss.metadata.length {
+			return errors.New("ERR The entries_added specified in XSETID is smaller than the target stream length")
+		}
+	}
+
+	ss.metadata.lastId = id
+
+	if entriesAdded != nil {
+		ss.metadata.entriesAdded = *entriesAdded
+	}
+
+	if maxDeletedId != nil {
+		ss.metadata.maxDeletedId = *maxDeletedId
 	}
 
 	return nil
 }
 
-func NewStoredStream() StoredStream {
-	return StoredStream{value: *radix.New()}
+type StreamInfo struct {
+	Length               uint64
+	RadixTreeKeys        int
+	RadixTreeNodes       int
+	LastGeneratedId      StreamId
+	MaxDeletedEntryId    StreamId
+	EntriesAdded         uint64
+	RecordedFirstEntryId StreamId
+	FirstEntry           *StreamQueryResult
+	LastEntry            *StreamQueryResult
+	// Only populated for a full report
+	Entries []StreamQueryResult
 }
 
-func (ss StoredStream) Type() string {
-	return "stream"
+// radixNodeCount works out how many nodes the radix tree is using. As node keys
+// are all the same width none is a prefix of another, so the tree has a leaf per
+// key, the root, and an inner node wherever neighbouring keys branch apart.
+func (ss StoredStream) radixNodeCount() int {
+	nodeIds := ss.metadata.nodeIds
+	branches := map[string]bool{}
+
+	for i := 1; i < len(nodeIds); i++ {
+		prev, next := nodeKey(nodeIds[i-1]), nodeKey(nodeIds[i])
+
+		n := 0
+		for n < len(prev) && prev[n] == next[n] {
+			n++
+		}
+
+		if n > 0 {
+			branches[prev[:n]] = true
+		}
+	}
+
+	return 1 + len(nodeIds) + len(branches)
 }
 
-func (ss StoredStream) Insert(ctx context.Context, id string, value map[string]string) (StreamId, error) {
-	streamId, err := ss.generateStreamId(ctx, id)
+// Info summarises the stream for XINFO STREAM. A full report includes up to count
+// entries from the start of the stream, or all of them if count is 0.
+func (ss StoredStream) Info(full bool, count int) StreamInfo {
+	ss.mutex.RLock()
+	defer ss.mutex.RUnlock()
 
-	if err != nil {
-		return streamId, err
+	info := StreamInfo{
+		Length:               ss.metadata.length,
+		RadixTreeKeys:        ss.value.Len(),
+		RadixTreeNodes:       ss.radixNodeCount(),
+		LastGeneratedId:      ss.metadata.lastId,
+		MaxDeletedEntryId:    ss.metadata.maxDeletedId,
+		EntriesAdded:         ss.metadata.entriesAdded,
+		RecordedFirstEntryId: ss.metadata.firstId,
 	}
 
-	ss.value.Insert(streamId.ToString(), value)
-	return streamId, nil
+	if full {
+		info.Entries = ss.forwardRange(StreamRange{End: MaxStreamId, Count: count})
+		return info
+	}
+
+	if first := ss.forwardRange(StreamRange{End: MaxStreamId, Count: 1}); len(first) > 0 {
+		info.FirstEntry = &first[0]
+	}
+
+	if last := ss.reverseRange(StreamRange{End: MaxStreamId, Count: 1}); len(last) > 0 {
+		info.LastEntry = &last[0]
+	}
+
+	return info
+}
+
+// StreamSnapshot lays a stream out node by node the way an RDB file stores it,
+// including the entries deleted from nodes that are still in use
+type StreamSnapshot struct {
+	Nodes        []StreamSnapshotNode
+	Length       uint64
+	LastId       StreamId
+	FirstId      StreamId
+	MaxDeletedId StreamId
+	EntriesAdded uint64
+}
+
+type StreamSnapshotNode struct {
+	MasterId     StreamId
+	MasterFields []string
+	Entries      []StreamSnapshotEntry
+}
+
+// StreamSnapshotEntry leaves Fields nil when they match the master fields of its
+// node
+type StreamSnapshotEntry struct {
+	Id      StreamId
+	Fields  []string
+	Values  []string
+	Deleted bool
+}
+
+// Snapshot copies the stream, such as for saving to disk
+func (ss StoredStream) Snapshot() StreamSnapshot {
+	ss.mutex.RLock()
+	defer ss.mutex.RUnlock()
+
+	snapshot := StreamSnapshot{
+		Nodes:        make([]StreamSnapshotNode, 0, len(ss.metadata.nodeIds)),
+		Length:       ss.metadata.length,
+		LastId:       ss.metadata.lastId,
+		FirstId:      ss.metadata.firstId,
+		MaxDeletedId: ss.metadata.maxDeletedId,
+		EntriesAdded: ss.metadata.entriesAdded,
+	}
+
+	for i, masterId := range ss.metadata.nodeIds {
+		node := ss.node(i)
+		entries := make([]StreamSnapshotEntry, 0, len(node.entries))
+
+		for _, entry := range node.entries {
+			entries = append(entries, StreamSnapshotEntry{
+				Id:      entry.id,
+				Fields:  slices.Clone(entry.fields),
+				Values:  slices.Clone(entry.values),
+				Deleted: entry.deleted,
+			})
+		}
+
+		snapshot.Nodes = append(snapshot.Nodes, StreamSnapshotNode{
+			MasterId:     masterId,
+			MasterFields: slices.Clone(node.masterFields),
+			Entries:      entries,
+		})
+	}
+
+	return snapshot
+}
+
+// NewStoredStreamFromSnapshot rebuilds a stream, such as one loaded from disk.
+// Nodes left without any entries are dropped, as Redis does.
+func NewStoredStreamFromSnapshot(snapshot StreamSnapshot) StoredStream {
+	ss := NewStoredStream()
+
+	for _, snapshotNode := range snapshot.Nodes {
+		node := &streamNode{
+			masterFields: snapshotNode.MasterFields,
+			bytes:        STREAM_NODE_OVERHEAD + stringsSize(snapshotNode.MasterFields),
+		}
+
+		for _, entry := range snapshotNode.Entries {
+			fields := entry.Fields
+
+			if slices.Equal(fields, node.masterFields) {
+				fields = nil
+			}
+
+			node.entries = append(node.entries, streamEntry{id: entry.Id, fields: fields, values: entry.Values, deleted: entry.Deleted})
+			node.bytes += STREAM_ENTRY_OVERHEAD + stringsSize(fields) + stringsSize(entry.Values)
+
+			if !entry.Deleted {
+				node.live++
+			}
+		}
+
+		if node.live == 0 {
+			continue
+		}
+
+		ss.value.Insert(nodeKey(snapshotNode.MasterId), node)
+		ss.metadata.nodeIds = append(ss.metadata.nodeIds, snapshotNode.MasterId)
+		ss.metadata.bytes += node.bytes
+		ss.metadata.length += uint64(node.live)
+	}
+
+	ss.metadata.lastId = snapshot.LastId
+	ss.metadata.firstId = snapshot.FirstId
+	ss.metadata.maxDeletedId = snapshot.MaxDeletedId
+	ss.metadata.entriesAdded = snapshot.EntriesAdded
+	return ss
+}
+
+// MemoryUsage counts the nodes of the stream along with its metadata
+func (ss StoredStream) MemoryUsage() int64 {
+	ss.mutex.RLock()
+	defer ss.mutex.RUnlock()
+	return STREAM_OVERHEAD + ss.metadata.bytes
+}
+
+func (ss StoredStream) Encoding() string {
+	return "stream"
 }
 
 func (ss StoredStream) Value() serde.Value {
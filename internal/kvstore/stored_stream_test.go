@@ -0,0 +1,232 @@
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func newTestStream(t *testing.T, count int) StoredStream {
+	stream := NewStoredStream()
+
+	for i := 1; i <= count; i++ {
+		_, err := stream.Insert(context.Background(), fmt.Sprintf("%d-0", i), []string{"i", fmt.Sprint(i)})
+
+		if err != nil {
+			t.Fatalf("Failed to insert entry %d: %v", i, err)
+		}
+	}
+
+	return stream
+}
+
+func TestStoredStream_Trim(t *testing.T) {
+	tests := []struct {
+		name        string
+		entries     int
+		opts        StreamTrimOptions
+		wantDeleted uint64
+		wantFirstId StreamId
+	}{
+		{
+			"Exact MAXLEN should trim to precisely the requested length",
+			250,
+			StreamTrimOptions{Strategy: TRIM_MAXLEN, MaxLen: 10},
+			240,
+			StreamId{241, 0},
+		},
+		{
+			"Approximate MAXLEN should only remove whole nodes",
+			250,
+			StreamTrimOptions{Strategy: TRIM_MAXLEN, MaxLen: 10, Approximate: true},
+			200,
+			StreamId{201, 0},
+		},
+		{
+			"Approximate MAXLEN should respect LIMIT",
+			250,
+			StreamTrimOptions{Strategy: TRIM_MAXLEN, MaxLen: 10, Approximate: true, Limit: 150},
+			100,
+			StreamId{101, 0},
+		},
+		{
+			"Exact MINID should remove every entry below the ID",
+			250,
+			StreamTrimOptions{Strategy: TRIM_MINID, MinId: StreamId{120, 0}},
+			119,
+			StreamId{120, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := newTestStream(t, tt.entries)
+
+			if got := stream.Trim(tt.opts); got != tt.wantDeleted {
+				t.Errorf("StoredStream.Trim() = %v, want %v", got, tt.wantDeleted)
+			}
+
+			if got := stream.Length(); got != uint64(tt.entries)-tt.wantDeleted {
+				t.Errorf("StoredStream.Length() = %v, want %v", got, uint64(tt.entries)-tt.wantDeleted)
+			}
+
+			if stream.metadata.firstId != tt.wantFirstId {
+				t.Errorf("first ID = %v, want %v", stream.metadata.firstId, tt.wantFirstId)
+			}
+
+			if stream.metadata.entriesAdded != uint64(tt.entries) {
+				t.Errorf("entries added = %v, want %v", stream.metadata.entriesAdded, tt.entries)
+			}
+		})
+	}
+}
+
+func TestStoredStream_TrimDropsEmptyNodes(t *testing.T) {
+	stream := newTestStream(t, 100)
+
+	// The node's last entry is already deleted, so MINID has to tombstone the rest
+	// one by one, leaving nothing live in it
+	stream.Delete([]StreamId{{100, 0}})
+
+	if got := stream.Trim(StreamTrimOptions{Strategy: TRIM_MINID, MinId: StreamId{100, 0}}); got != 99 {
+		t.Errorf("StoredStream.Trim() = %v, want 99", got)
+	}
+
+	if len(stream.metadata.nodeIds) != 0 {
+		t.Errorf("Expected the emptied node to be removed, got %d nodes", len(stream.metadata.nodeIds))
+	}
+}
+
+func TestStoredStream_Insert(t *testing.T) {
+	tests := []struct {
+		name    string
+		lastId  string
+		id      string
+		want    StreamId
+		wantErr bool
+	}{
+		{name: "It should take a missing sequence number to be 0", id: "5", want: StreamId{5, 0}},
+		{name: "It should take a timestamp past what fits an int", id: "18446744073709551615-1", want: StreamId{math.MaxUint64, 1}},
+		{name: "It should generate the next sequence number", lastId: "5-3", id: "5-*", want: StreamId{5, 4}},
+		{name: "It should start the sequence for a later timestamp", lastId: "5-3", id: "6-*", want: StreamId{6, 0}},
+		{name: "It should refuse a sequence number past the last possible one", lastId: "5-18446744073709551615", id: "5-*", wantErr: true},
+		{name: "It should refuse a negative ID", id: "-1", wantErr: true},
+		{name: "It should refuse an ID that isn't a number", id: "a-1", wantErr: true},
+		{name: "It should refuse an ID at or below the last one", lastId: "5-3", id: "5", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := NewStoredStream()
+
+			if tt.lastId != "" {
+				if _, err := stream.Insert(context.Background(), tt.lastId, []string{"f", "v"}); err != nil {
+					t.Fatalf("Insert(%s) error = %v", tt.lastId, err)
+				}
+			}
+
+			got, err := stream.Insert(context.Background(), tt.id, []string{"f", "v"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Insert(%s) error = %v, wantErr %v", tt.id, err, tt.wantErr)
+			}
+
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Insert(%s) = %v, want %v", tt.id, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoredStream_Delete(t *testing.T) {
+	stream := newTestStream(t, 3)
+
+	deleted := stream.Delete([]StreamId{{2, 0}, {2, 0}, {9, 0}})
+
+	if deleted != 1 {
+		t.Fatalf("Expected a single entry to be deleted, got %d", deleted)
+	}
+
+	if stream.metadata.maxDeletedId != (StreamId{2, 0}) {
+		t.Fatalf("Expected max deleted ID to be 2-0, got %v", stream.metadata.maxDeletedId)
+	}
+
+	_, err := stream.Insert(context.Background(), "2-0", []string{"i", "2"})
+
+	if err == nil {
+		t.Fatalf("Expected re-adding a deleted ID to fail")
+	}
+}
+
+func TestStoredStream_Range(t *testing.T) {
+	stream := newTestStream(t, 250)
+	stream.Delete([]StreamId{{150, 0}})
+
+	tests := []struct {
+		name    string
+		query   StreamRange
+		wantIds []string
+	}{
+		{
+			"Should order IDs numerically rather than lexically",
+			StreamRange{Start: StreamId{9, 0}, End: StreamId{11, 0}},
+			[]string{"9-0", "10-0", "11-0"},
+		},
+		{
+			"Should seek into the middle of a node and skip deleted entries",
+			StreamRange{Start: StreamId{149, 0}, End: MaxStreamId, Count: 3},
+			[]string{"149-0", "151-0", "152-0"},
+		},
+		{
+			"Should walk backwards across nodes in reverse",
+			StreamRange{Start: StreamId{}, End: StreamId{201, 0}, Count: 3, Reverse: true},
+			[]string{"201-0", "200-0", "199-0"},
+		},
+		{
+			"Should return nothing for an inverted interval",
+			StreamRange{Start: StreamId{20, 0}, End: StreamId{10, 0}},
+			[]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+
+			for _, res := range stream.Range(tt.query) {
+				got = append(got, res.Id)
+			}
+
+			if !reflect.DeepEqual(got, tt.wantIds) {
+				t.Errorf("StoredStream.Range() = %v, want %v", got, tt.wantIds)
+			}
+		})
+	}
+}
+
+func TestStoredStream_FieldOrder(t *testing.T) {
+	stream := NewStoredStream()
+	ctx := context.Background()
+
+	entries := [][]string{
+		{"b", "1", "a", "2", "b", "3"},
+		{"b", "4", "a", "5", "b", "6"},
+		{"z", "7"},
+	}
+
+	for i, fields := range entries {
+		if _, err := stream.Insert(ctx, fmt.Sprintf("%d-0", i+1), fields); err != nil {
+			t.Fatalf("Failed to insert entry %d: %v", i, err)
+		}
+	}
+
+	node := stream.node(0)
+
+	if node.entries[1].fields != nil || node.entries[2].fields == nil {
+		t.Errorf("Expected only entries matching the master fields to share them, got %v", node.entries)
+	}
+
+	for i, res := range stream.Range(StreamRange{End: MaxStreamId}) {
+		if !reflect.DeepEqual(res.Values, entries[i]) {
+			t.Errorf("Entry %s = %v, want %v", res.Id, res.Values, entries[i])
+		}
+	}
+}
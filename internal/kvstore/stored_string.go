@@ -27,6 +27,26 @@ func (ss StoredString) Value() serde.Value {
 	return serde.NewBulkString(ss.value)
 }
 
+// MemoryUsage counts the string's header and contents, along with its expiry
+func (ss StoredString) MemoryUsage() int64 {
+	size := int64(16 + len(ss.value))
+
+	if ss.expiresAt != nil {
+		size += 8
+	}
+	return size
+}
+
+func (ss StoredString) Encoding() string {
+	return stringEncoding(ss.value)
+}
+
 func (ss StoredString) ToString() string {
 	return ss.value
 }
+
+// ExpiresAt returns when the string expires in milliseconds since the epoch, or
+// nil if it never does
+func (ss StoredString) ExpiresAt() *uint64 {
+	return ss.expiresAt
+}
@@ -1,6 +1,7 @@
 package kvstore
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
@@ -18,66 +19,154 @@ const (
 	WILDCARD            = "*"
 	START_OF_STREAM     = "-"
 	END_OF_STREAM       = "+"
+	NEW_ENTRIES_ONLY    = "$"
 )
 
-func GetQueryStreamId(id string) (StreamId, error) {
-	if id == START_OF_STREAM {
-		return StreamId{0, 0}, nil
-	}
-	if id == END_OF_STREAM {
-		return StreamId{math.MaxUint64, math.MaxUint64}, nil
+const EXCLUSIVE_RANGE_PREFIX = "("
+
+var (
+	ErrInvalidStreamId = errors.New("ERR Invalid stream ID specified as stream command argument")
+	MaxStreamId        = StreamId{math.MaxUint64, math.MaxUint64}
+)
+
+func NewStreamId(timestamp uint64, seqNo uint64) StreamId {
+	return StreamId{timestamp: timestamp, seqNo: seqNo}
+}
+
+// ParseStreamId parses an explicit ID given to a stream command, where a missing
+// sequence number is taken to be 0
+func ParseStreamId(input string) (StreamId, error) {
+	parts := strings.Split(input, STREAM_ID_DELIMETER)
+
+	if len(parts) > 2 {
+		return StreamId{}, ErrInvalidStreamId
 	}
-	parts := strings.Split(id, STREAM_ID_DELIMETER)
-	msTime, err := strconv.Atoi(parts[0])
+
+	msTime, err := strconv.ParseUint(parts[0], 10, 64)
 
 	if err != nil {
-		return StreamId{}, err
+		return StreamId{}, ErrInvalidStreamId
 	}
 
 	if len(parts) == 1 {
-		var seqNo uint64 = 0
-		if msTime == 0 {
-			seqNo += 1
-		}
-		return StreamId{uint64(msTime), seqNo}, nil
+		return StreamId{timestamp: msTime, seqNo: 0}, nil
 	}
 
-	seqNo, err := strconv.Atoi(parts[1])
+	seqNo, err := strconv.ParseUint(parts[1], 10, 64)
 
 	if err != nil {
-		return StreamId{}, err
+		return StreamId{}, ErrInvalidStreamId
 	}
 
-	return StreamId{timestamp: uint64(msTime), seqNo: uint64(seqNo)}, nil
-
+	return StreamId{timestamp: msTime, seqNo: seqNo}, nil
 }
 
-func parseStreamId(input string) (StreamId, error) {
-	parts := strings.Split(input, STREAM_ID_DELIMETER)
-	if len(parts) != 2 {
-		return StreamId{}, fmt.Errorf("expected two parts in stream ID got %d", len(parts))
+// ParseRangeStart parses the start of an XRANGE style interval, which may be "-",
+// an ID or an ID prefixed with "(" to exclude it from the interval
+func ParseRangeStart(input string) (StreamId, error) {
+	if input == START_OF_STREAM {
+		return StreamId{}, nil
 	}
-	msTime, err := strconv.Atoi(parts[0])
+
+	if !strings.HasPrefix(input, EXCLUSIVE_RANGE_PREFIX) {
+		return ParseStreamId(input)
+	}
+
+	id, err := ParseStreamId(input[1:])
 
 	if err != nil {
-		return StreamId{}, err
+		return id, err
 	}
-	seqNo, err := strconv.Atoi(parts[1])
+
+	if id == MaxStreamId {
+		return id, errors.New("ERR invalid start ID for the interval")
+	}
+
+	return id.next(), nil
+}
+
+// ParseRangeEnd parses the end of an XRANGE style interval. Unlike the start, an ID
+// without a sequence number covers every sequence number for that timestamp.
+func ParseRangeEnd(input string) (StreamId, error) {
+	if input == END_OF_STREAM {
+		return MaxStreamId, nil
+	}
+
+	exclusive := strings.HasPrefix(input, EXCLUSIVE_RANGE_PREFIX)
+	input = strings.TrimPrefix(input, EXCLUSIVE_RANGE_PREFIX)
+
+	id, err := ParseStreamId(input)
 
 	if err != nil {
-		return StreamId{}, err
+		return id, err
 	}
 
-	if seqNo < 0 {
-		return StreamId{}, errors.New("ERR The ID specified in XADD must be greater than 0-0")
+	if !strings.Contains(input, STREAM_ID_DELIMETER) {
+		id.seqNo = math.MaxUint64
 	}
 
-	if msTime < 0 {
-		return StreamId{}, errors.New("ERR The ID specified in XADD must be greater than 0-0")
+	if !exclusive {
+		return id, nil
 	}
 
-	return StreamId{timestamp: uint64(msTime), seqNo: uint64(seqNo)}, nil
+	if id.IsZero() {
+		return id, errors.New("ERR invalid end ID for the interval")
+	}
+
+	return id.prev(), nil
+}
+
+func (id StreamId) next() StreamId {
+	if id.seqNo == math.MaxUint64 {
+		return StreamId{timestamp: id.timestamp + 1, seqNo: 0}
+	}
+	return StreamId{timestamp: id.timestamp, seqNo: id.seqNo + 1}
+}
+
+func (id StreamId) prev() StreamId {
+	if id.seqNo == 0 {
+		return StreamId{timestamp: id.timestamp - 1, seqNo: math.MaxUint64}
+	}
+	return StreamId{timestamp: id.timestamp, seqNo: id.seqNo - 1}
+}
+
+// encode produces a fixed width big endian key for the ID, so that byte-wise
+// ordering of encoded IDs matches their numeric ordering
+func (id StreamId) encode() string {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf[:8], id.timestamp)
+	binary.BigEndian.PutUint64(buf[8:], id.seqNo)
+	return string(buf)
+}
+
+func (id StreamId) Compare(other StreamId) int {
+	if id.timestamp != other.timestamp {
+		if id.timestamp < other.timestamp {
+			return -1
+		}
+		return 1
+	}
+
+	if id.seqNo != other.seqNo {
+		if id.seqNo < other.seqNo {
+			return -1
+		}
+		return 1
+	}
+
+	return 0
+}
+
+func (id StreamId) Timestamp() uint64 {
+	return id.timestamp
+}
+
+func (id StreamId) SeqNo() uint64 {
+	return id.seqNo
+}
 
+func (id StreamId) IsZero() bool {
+	return id.timestamp == 0 && id.seqNo == 0
 }
 
 func (id StreamId) ToString() string {
@@ -0,0 +1,88 @@
+package kvstore
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// A Watch holds the keys a client has WATCHed ahead of a transaction. It is
+// marked dirty as soon as any of those keys is modified.
+type Watch struct {
+	dirty atomic.Bool
+	// Whether each key existed when it was watched, so that a key which has since
+	// expired can dirty the watch even if nothing has got round to deleting it
+	keys map[string]bool
+}
+
+func NewWatch() *Watch {
+	return &Watch{keys: map[string]bool{}}
+}
+
+func (s KVStore) WatchKeys(ctx context.Context, w *Watch, keys []string) {
+	for _, key := range keys {
+		if _, watched := w.keys[key]; watched {
+			continue
+		}
+
+		_, exists := s.GetKey(ctx, key)
+
+		s.watchMutex.Lock()
+		if s.watchers[key] == nil {
+			s.watchers[key] = map[*Watch]struct{}{}
+		}
+		s.watchers[key][w] = struct{}{}
+		s.watchMutex.Unlock()
+
+		w.keys[key] = exists
+	}
+}
+
+// Unwatch forgets every key being watched and resets the watch for reuse
+func (s KVStore) Unwatch(w *Watch) {
+	s.watchMutex.Lock()
+	defer s.watchMutex.Unlock()
+
+	for key := range w.keys {
+		delete(s.watchers[key], w)
+
+		if len(s.watchers[key]) == 0 {
+			delete(s.watchers, key)
+		}
+	}
+
+	w.keys = map[string]bool{}
+	w.dirty.Store(false)
+}
+
+// IsWatchDirty reports whether a transaction guarded by w must be aborted
+func (s KVStore) IsWatchDirty(ctx context.Context, w *Watch) bool {
+	if w.dirty.Load() {
+		return true
+	}
+
+	for key, existed := range w.keys {
+		if !existed {
+			continue
+		}
+
+		stored, found := s.findKey(key)
+
+		if found && stored.IsExpired(ctx) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s KVStore) touchKey(key string) {
+	s.watchMutex.Lock()
+	for w := range s.watchers[key] {
+		w.dirty.Store(true)
+	}
+	s.watchMutex.Unlock()
+
+	if s.keyModified != nil {
+		s.keyModified(key)
+	}
+}
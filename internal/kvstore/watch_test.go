@@ -0,0 +1,40 @@
+package kvstore
+
+import (
+	"context"
+	"testing"
+)
+
+func TestKVStore_Watch(t *testing.T) {
+	ctx := context.Background()
+	store := NewKVStore()
+	store.SetKeyWithExpiry(ctx, "watched", "1", nil)
+
+	watch := NewWatch()
+	store.WatchKeys(ctx, watch, []string{"watched", "missing"})
+
+	store.SetKeyWithExpiry(ctx, "other", "1", nil)
+
+	if store.IsWatchDirty(ctx, watch) {
+		t.Fatalf("Expected writes to unwatched keys to leave the watch clean")
+	}
+
+	store.SetKeyWithExpiry(ctx, "missing", "1", nil)
+
+	if !store.IsWatchDirty(ctx, watch) {
+		t.Fatalf("Expected creating a watched key to dirty the watch")
+	}
+
+	store.Unwatch(watch)
+
+	if store.IsWatchDirty(ctx, watch) || len(store.watchers) != 0 {
+		t.Fatalf("Expected unwatch to reset the watch, got watchers %v", store.watchers)
+	}
+
+	store.WatchKeys(ctx, watch, []string{"watched"})
+	store.Flush()
+
+	if !store.IsWatchDirty(ctx, watch) {
+		t.Fatalf("Expected flushing to dirty the watch")
+	}
+}
@@ -0,0 +1,195 @@
+package redis
+
+import (
+	"codecrafters/internal/array"
+	"codecrafters/internal/serde"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var aclHelp = []string{
+	"ACL <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"CAT [<category>]",
+	"    List all commands that belong to <category>, or all command categories",
+	"    when no category is specified.",
+	"DELUSER <username> [<username> ...]",
+	"    Delete a list of users.",
+	"GETUSER <username>",
+	"    Get the user's details.",
+	"LIST",
+	"    Show users details in config file format.",
+	"LOAD",
+	"    Reload users from the ACL file.",
+	"LOG [<count> | RESET]",
+	"    Show the ACL log entries.",
+	"SAVE",
+	"    Save the current config to the ACL file.",
+	"SETUSER <username> <attribute> [<attribute> ...]",
+	"    Create or modify a user with the specified attributes.",
+	"USERS",
+	"    List all the registered usernames.",
+	"WHOAMI",
+	"    Return the current connection username.",
+	"HELP",
+	"    Print this help.",
+}
+
+func (r *Redis) aclGetUser(name string) []serde.Value {
+	user, ok := r.acl.getUser(name)
+
+	if !ok {
+		return []serde.Value{serde.NewNull()}
+	}
+
+	return []serde.Value{serde.NewArray([]serde.Value{
+		serde.NewBulkString("flags"), bulkStrings(user.flags()),
+		serde.NewBulkString("passwords"), bulkStrings(user.passwords),
+		serde.NewBulkString("commands"), serde.NewBulkString(user.commandsDescription()),
+		serde.NewBulkString("keys"), serde.NewBulkString(user.keysDescription()),
+		serde.NewBulkString("channels"), serde.NewBulkString(user.channelsDescription()),
+		serde.NewBulkString("selectors"), serde.NewArray([]serde.Value{}),
+	})}
+}
+
+func (r *Redis) aclList() []serde.Value {
+	lines := []string{}
+
+	for _, name := range r.acl.userNames() {
+		if user, ok := r.acl.getUser(name); ok {
+			lines = append(lines, user.describe())
+		}
+	}
+
+	return []serde.Value{bulkStrings(lines)}
+}
+
+// aclCat lists the categories, or the commands in one of them
+func aclCat(args []string) []serde.Value {
+	if len(args) == 0 {
+		return []serde.Value{bulkStrings(aclCategoryNames)}
+	}
+
+	category := aclCategoryBit(args[0])
+
+	if category == 0 {
+		return []serde.Value{serde.NewError(fmt.Sprintf("ERR Unknown category '%s'", args[0]))}
+	}
+
+	commands := []string{}
+
+	for cmd, spec := range commandTable {
+		subcommands, hasSubcommands := subcommandTable[cmd]
+
+		if !hasSubcommands {
+			if spec.aclCategories()&category != 0 {
+				commands = append(commands, spec.name)
+			}
+			continue
+		}
+
+		for _, subcommand := range subcommands {
+			if subcommand.aclCategories()&category != 0 {
+				commands = append(commands, subcommand.name)
+			}
+		}
+	}
+
+	slices.Sort(commands)
+	return []serde.Value{bulkStrings(commands)}
+}
+
+func (r *Redis) aclLog(args []string) []serde.Value {
+	count := ACL_LOG_MAX_LEN
+
+	if len(args) > 1 {
+		return []serde.Value{serde.NewError("ERR syntax error")}
+	}
+
+	if len(args) == 1 {
+		if strings.ToLower(args[0]) == "reset" {
+			r.acl.resetLog()
+			return []serde.Value{serde.Ok()}
+		}
+
+		var err error
+		count, err = strconv.Atoi(args[0])
+
+		if err != nil || count < 0 {
+			return []serde.Value{serde.NewError("ERR value is out of range, must be positive")}
+		}
+	}
+
+	now := time.Now()
+
+	entries := array.Map(r.acl.logEntries(count), func(entry aclLogEntry) serde.Value {
+		return serde.NewArray([]serde.Value{
+			serde.NewBulkString("count"), serde.NewInteger(int64(entry.count)),
+			serde.NewBulkString("reason"), serde.NewBulkString(entry.reason),
+			serde.NewBulkString("context"), serde.NewBulkString(entry.context),
+			serde.NewBulkString("object"), serde.NewBulkString(entry.object),
+			serde.NewBulkString("username"), serde.NewBulkString(entry.username),
+			serde.NewBulkString("age-seconds"), serde.NewBulkString(strconv.FormatFloat(now.Sub(entry.created).Seconds(), 'f', 3, 64)),
+			serde.NewBulkString("client-info"), serde.NewBulkString(entry.clientInfo),
+			serde.NewBulkString("entry-id"), serde.NewInteger(entry.id),
+			serde.NewBulkString("timestamp-created"), serde.NewInteger(entry.created.UnixMilli()),
+			serde.NewBulkString("timestamp-last-updated"), serde.NewInteger(entry.updated.UnixMilli()),
+		})
+	})
+
+	return []serde.Value{serde.NewArray(entries)}
+}
+
+func (r *Redis) aclCommand(args []string, connection RedisConnection) []serde.Value {
+	switch strings.ToLower(args[0]) {
+	case "setuser":
+		if err := r.acl.setUser(args[1], args[2:]); err != nil {
+			return []serde.Value{serde.NewError(err.Error())}
+		}
+		return []serde.Value{serde.Ok()}
+	case "deluser":
+		deleted, err := r.acl.deleteUsers(args[1:])
+
+		if err != nil {
+			return []serde.Value{serde.NewError(err.Error())}
+		}
+		return []serde.Value{serde.NewInteger(int64(deleted))}
+	case "getuser":
+		return r.aclGetUser(args[1])
+	case "list":
+		return r.aclList()
+	case "users":
+		return []serde.Value{bulkStrings(r.acl.userNames())}
+	case "whoami":
+		return []serde.Value{serde.NewBulkString(connection.auth.user)}
+	case "cat":
+		return aclCat(args[1:])
+	case "log":
+		return r.aclLog(args[1:])
+	case "load", "save":
+		if r.configuration.aclFile == "" {
+			return []serde.Value{serde.NewError("ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")}
+		}
+
+		var err error
+
+		if strings.ToLower(args[0]) == "load" {
+			err = r.acl.loadFile(r.configuration.aclFile)
+		} else {
+			err = r.acl.saveFile(r.configuration.aclFile)
+		}
+
+		if err != nil {
+			return []serde.Value{serde.NewError(fmt.Sprintf("ERR %s", err.Error()))}
+		}
+		return []serde.Value{serde.Ok()}
+	case "help":
+		return []serde.Value{serde.NewArray(array.Map(aclHelp, func(line string) serde.Value {
+			return serde.NewSimpleString(line)
+		}))}
+	default:
+		return []serde.Value{serde.NewError(fmt.Sprintf("ERR unknown subcommand '%s'. Try ACL HELP.", args[0]))}
+	}
+}
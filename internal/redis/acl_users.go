@@ -0,0 +1,647 @@
+package redis
+
+import (
+	"bufio"
+	"codecrafters/internal/glob"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_USER = "default"
+	// How many entries ACL LOG keeps before dropping the oldest
+	ACL_LOG_MAX_LEN = 128
+	// Similar log entries within this window are counted as one
+	ACL_LOG_GROUPING_WINDOW = 60 * time.Second
+)
+
+const (
+	ACL_REASON_COMMAND = "command"
+	ACL_REASON_KEY     = "key"
+	ACL_REASON_AUTH    = "auth"
+
+	ACL_CONTEXT_TOPLEVEL = "toplevel"
+	ACL_CONTEXT_MULTI    = "multi"
+	ACL_CONTEXT_LUA      = "lua"
+)
+
+var (
+	ErrNoAuth    = errors.New("NOAUTH Authentication required.")
+	ErrWrongPass = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	ErrNoKeyPerm = errors.New("NOPERM No permissions to access a key")
+)
+
+// connectionAuth tracks who a client has authenticated as, shared between the
+// copies of its connection
+type connectionAuth struct {
+	user          string
+	authenticated bool
+}
+
+type aclKeyPattern struct {
+	pattern string
+	read    bool
+	write   bool
+}
+
+func (p aclKeyPattern) String() string {
+	switch {
+	case p.read && p.write:
+		return "~" + p.pattern
+	case p.read:
+		return "%R~" + p.pattern
+	default:
+		return "%W~" + p.pattern
+	}
+}
+
+// aclCommandRule allows or denies a command, a subcommand given as
+// "command|subcommand", or a category given as "@category"
+type aclCommandRule struct {
+	allow  bool
+	target string
+}
+
+func (c aclCommandRule) String() string {
+	if c.allow {
+		return "+" + c.target
+	}
+	return "-" + c.target
+}
+
+func (c aclCommandRule) matches(cmd string, spec commandSpec) bool {
+	if c.target == "@all" {
+		return true
+	}
+
+	if category, ok := strings.CutPrefix(c.target, "@"); ok {
+		return spec.aclCategories()&aclCategoryBit(category) != 0
+	}
+
+	return c.target == cmd || c.target == spec.name
+}
+
+type aclUser struct {
+	name    string
+	enabled bool
+	nopass  bool
+	// SHA-256 hashes of the user's passwords, as hex
+	passwords []string
+	// Applied in order, so later rules override earlier ones
+	commandRules    []aclCommandRule
+	keyPatterns     []aclKeyPattern
+	channelPatterns []string
+}
+
+func newACLUser(name string) *aclUser {
+	return &aclUser{
+		name:            name,
+		passwords:       []string{},
+		commandRules:    []aclCommandRule{},
+		keyPatterns:     []aclKeyPattern{},
+		channelPatterns: []string{},
+	}
+}
+
+func newDefaultUser() *aclUser {
+	user := newACLUser(DEFAULT_USER)
+
+	for _, rule := range []string{"on", "nopass", "allkeys", "allchannels", "allcommands"} {
+		user.applyRule(rule)
+	}
+
+	return user
+}
+
+func (u *aclUser) clone() *aclUser {
+	clone := *u
+	clone.passwords = slices.Clone(u.passwords)
+	clone.commandRules = slices.Clone(u.commandRules)
+	clone.keyPatterns = slices.Clone(u.keyPatterns)
+	clone.channelPatterns = slices.Clone(u.channelPatterns)
+	return &clone
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func isValidPasswordHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+
+	for _, c := range hash {
+		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f')) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func aclCategoryBit(name string) int {
+	index := slices.Index(aclCategoryNames, strings.ToLower(name))
+
+	if index < 0 {
+		return 0
+	}
+	return 1 << index
+}
+
+func isKnownCommandTarget(target string) bool {
+	if category, ok := strings.CutPrefix(target, "@"); ok {
+		return category == "all" || aclCategoryBit(category) != 0
+	}
+
+	cmd, subcommand, hasSubcommand := strings.Cut(target, "|")
+
+	if _, ok := commandTable[cmd]; !ok {
+		return false
+	}
+
+	if !hasSubcommand {
+		return true
+	}
+
+	_, ok := subcommandTable[cmd][subcommand]
+	return ok
+}
+
+func (u *aclUser) addCommandRule(allow bool, target string) error {
+	target = strings.ToLower(target)
+
+	if !isKnownCommandTarget(target) {
+		return errors.New("Unknown command or category name in ACL")
+	}
+
+	// Nothing before a rule covering every command can make a difference
+	if target == "@all" {
+		u.commandRules = []aclCommandRule{}
+	}
+
+	u.commandRules = append(u.commandRules, aclCommandRule{allow, target})
+	return nil
+}
+
+// applyRule applies a single ACL SETUSER rule to the user
+func (u *aclUser) applyRule(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+	case "off":
+		u.enabled = false
+	case "nopass":
+		u.nopass = true
+		u.passwords = []string{}
+	case "resetpass":
+		u.nopass = false
+		u.passwords = []string{}
+	case "allkeys":
+		u.keyPatterns = []aclKeyPattern{{"*", true, true}}
+	case "resetkeys":
+		u.keyPatterns = []aclKeyPattern{}
+	case "allchannels":
+		u.channelPatterns = []string{"*"}
+	case "resetchannels":
+		u.channelPatterns = []string{}
+	case "allcommands":
+		return u.addCommandRule(true, "@all")
+	case "nocommands":
+		return u.addCommandRule(false, "@all")
+	case "reset":
+		for _, reset := range []string{"resetpass", "resetkeys", "resetchannels", "off", "nocommands"} {
+			u.applyRule(reset)
+		}
+	default:
+		return u.applyPatternRule(rule)
+	}
+
+	return nil
+}
+
+func (u *aclUser) applyPatternRule(rule string) error {
+	if len(rule) == 0 {
+		return errors.New("Syntax error")
+	}
+
+	switch rule[0] {
+	case '>':
+		hash := hashPassword(rule[1:])
+		u.nopass = false
+
+		if !slices.Contains(u.passwords, hash) {
+			u.passwords = append(u.passwords, hash)
+		}
+	case '#':
+		if !isValidPasswordHash(rule[1:]) {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+
+		u.nopass = false
+
+		if !slices.Contains(u.passwords, rule[1:]) {
+			u.passwords = append(u.passwords, rule[1:])
+		}
+	case '<', '!':
+		hash := rule[1:]
+
+		if rule[0] == '<' {
+			hash = hashPassword(rule[1:])
+		}
+
+		index := slices.Index(u.passwords, hash)
+
+		if index < 0 {
+			return errors.New("The password you are trying to remove from the user does not exist")
+		}
+		u.passwords = slices.Delete(u.passwords, index, index+1)
+	case '~':
+		u.keyPatterns = append(u.keyPatterns, aclKeyPattern{rule[1:], true, true})
+	case '%':
+		permissions, pattern, ok := strings.Cut(rule[1:], "~")
+		permissions = strings.ToUpper(permissions)
+
+		if !ok || permissions == "" || strings.Trim(permissions, "RW") != "" {
+			return errors.New("Syntax error")
+		}
+
+		u.keyPatterns = append(u.keyPatterns, aclKeyPattern{pattern, strings.Contains(permissions, "R"), strings.Contains(permissions, "W")})
+	case '&':
+		u.channelPatterns = append(u.channelPatterns, rule[1:])
+	case '+', '-':
+		return u.addCommandRule(rule[0] == '+', rule[1:])
+	default:
+		return errors.New("Syntax error")
+	}
+
+	return nil
+}
+
+func (u *aclUser) canRunCommand(cmd string, spec commandSpec) bool {
+	allowed := false
+
+	for _, rule := range u.commandRules {
+		if rule.matches(cmd, spec) {
+			allowed = rule.allow
+		}
+	}
+
+	return allowed
+}
+
+func (u *aclUser) canAccessKey(key string, read bool, write bool) bool {
+	for _, p := range u.keyPatterns {
+		if (!read || p.read) && (!write || p.write) && glob.Match(p.pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *aclUser) flags() []string {
+	flags := []string{"off"}
+
+	if u.enabled {
+		flags[0] = "on"
+	}
+
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+
+	return flags
+}
+
+func (u *aclUser) commandsDescription() string {
+	if len(u.commandRules) == 0 {
+		return "-@all"
+	}
+
+	rules := []string{}
+	for _, rule := range u.commandRules {
+		rules = append(rules, rule.String())
+	}
+	return strings.Join(rules, " ")
+}
+
+func (u *aclUser) keysDescription() string {
+	patterns := []string{}
+	for _, p := range u.keyPatterns {
+		patterns = append(patterns, p.String())
+	}
+	return strings.Join(patterns, " ")
+}
+
+func (u *aclUser) channelsDescription() string {
+	patterns := []string{}
+	for _, p := range u.channelPatterns {
+		patterns = append(patterns, "&"+p)
+	}
+	return strings.Join(patterns, " ")
+}
+
+// describe returns the rules that would recreate the user, as used by ACL LIST
+// and when saving the ACL file
+func (u *aclUser) describe() string {
+	rules := []string{"user", u.name}
+	rules = append(rules, u.flags()...)
+
+	for _, hash := range u.passwords {
+		rules = append(rules, "#"+hash)
+	}
+
+	if len(u.keyPatterns) > 0 {
+		rules = append(rules, u.keysDescription())
+	}
+
+	if len(u.channelPatterns) > 0 {
+		rules = append(rules, u.channelsDescription())
+	} else {
+		rules = append(rules, "resetchannels")
+	}
+
+	rules = append(rules, u.commandsDescription())
+	return strings.Join(rules, " ")
+}
+
+type aclLogEntry struct {
+	id         int64
+	count      int
+	reason     string
+	context    string
+	object     string
+	username   string
+	clientInfo string
+	created    time.Time
+	updated    time.Time
+}
+
+// aclState holds every ACL user, along with the log of commands and logins they
+// were refused
+type aclState struct {
+	mutex     *sync.Mutex
+	users     map[string]*aclUser
+	log       []aclLogEntry
+	nextLogId int64
+}
+
+func newACLState() *aclState {
+	return &aclState{
+		mutex: &sync.Mutex{},
+		users: map[string]*aclUser{DEFAULT_USER: newDefaultUser()},
+		log:   []aclLogEntry{},
+	}
+}
+
+func aclRuleError(rule string, err error) error {
+	return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': %s", rule, err.Error())
+}
+
+// setUser creates or updates a user, applying none of the rules unless all of
+// them are valid
+func (a *aclState) setUser(name string, rules []string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	user := newACLUser(name)
+
+	if existing, ok := a.users[name]; ok {
+		user = existing.clone()
+	}
+
+	for _, rule := range rules {
+		if err := user.applyRule(rule); err != nil {
+			return aclRuleError(rule, err)
+		}
+	}
+
+	a.users[name] = user
+	return nil
+}
+
+func (a *aclState) deleteUsers(names []string) (int, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if slices.Contains(names, DEFAULT_USER) {
+		return 0, errors.New("ERR The 'default' user cannot be removed")
+	}
+
+	deleted := 0
+
+	for _, name := range names {
+		if _, ok := a.users[name]; ok {
+			delete(a.users, name)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// getUser returns a copy of the named user, so it can be read without the lock
+func (a *aclState) getUser(name string) (*aclUser, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	user, ok := a.users[name]
+
+	if !ok {
+		return nil, false
+	}
+	return user.clone(), true
+}
+
+func (a *aclState) userNames() []string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	names := make([]string, 0, len(a.users))
+
+	for name := range a.users {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+// defaultUserOpen reports whether clients may use the default user without
+// authenticating first
+func (a *aclState) defaultUserOpen() bool {
+	user, ok := a.getUser(DEFAULT_USER)
+	return ok && user.enabled && user.nopass
+}
+
+func (a *aclState) authenticate(name string, password string) bool {
+	user, ok := a.getUser(name)
+
+	if !ok || !user.enabled {
+		return false
+	}
+
+	return user.nopass || slices.Contains(user.passwords, hashPassword(password))
+}
+
+// addLogEntry records a refusal, counting it against a recent entry for the same
+// thing rather than adding another
+func (a *aclState) addLogEntry(reason string, context string, object string, username string, clientInfo string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := time.Now()
+
+	for i, entry := range a.log {
+		if entry.reason == reason && entry.context == context && entry.object == object &&
+			entry.username == username && now.Sub(entry.updated) < ACL_LOG_GROUPING_WINDOW {
+			entry.count++
+			entry.updated = now
+			entry.clientInfo = clientInfo
+
+			// Move it back to the front, as the most recent entry
+			a.log = append([]aclLogEntry{entry}, slices.Delete(a.log, i, i+1)...)
+			return
+		}
+	}
+
+	entry := aclLogEntry{
+		id:         a.nextLogId,
+		count:      1,
+		reason:     reason,
+		context:    context,
+		object:     object,
+		username:   username,
+		clientInfo: clientInfo,
+		created:    now,
+		updated:    now,
+	}
+	a.nextLogId++
+
+	a.log = append([]aclLogEntry{entry}, a.log...)
+
+	if len(a.log) > ACL_LOG_MAX_LEN {
+		a.log = a.log[:ACL_LOG_MAX_LEN]
+	}
+}
+
+func (a *aclState) logEntries(count int) []aclLogEntry {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return slices.Clone(a.log[:min(count, len(a.log))])
+}
+
+func (a *aclState) resetLog() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.log = []aclLogEntry{}
+}
+
+// parseACLFile reads users from an ACL file, made up of lines in the same form as
+// ACL LIST returns. A default user is created if the file doesn't define one.
+func parseACLFile(path string) (map[string]*aclUser, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	users := map[string]*aclUser{}
+	scanner := bufio.NewScanner(file)
+
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] != "user" || len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: line should start with user keyword", path, lineNo)
+		}
+
+		name := fields[1]
+
+		if _, exists := users[name]; exists {
+			return nil, fmt.Errorf("%s:%d: duplicate user '%s' found", path, lineNo, name)
+		}
+
+		user := newACLUser(name)
+
+		for _, rule := range fields[2:] {
+			if err := user.applyRule(rule); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", path, lineNo, err.Error())
+			}
+		}
+
+		users[name] = user
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := users[DEFAULT_USER]; !ok {
+		users[DEFAULT_USER] = newDefaultUser()
+	}
+
+	return users, nil
+}
+
+// loadFile replaces every user with those in the ACL file, leaving them as they
+// were should the file have any errors
+func (a *aclState) loadFile(path string) error {
+	users, err := parseACLFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.users = users
+	return nil
+}
+
+// saveFile writes every user to the ACL file, replacing it all at once so that
+// it's never left half written
+func (a *aclState) saveFile(path string) error {
+	lines := []string{}
+
+	for _, name := range a.userNames() {
+		if user, ok := a.getUser(name); ok {
+			lines = append(lines, user.describe())
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "acl-*.tmp")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,76 @@
+package redis
+
+import "testing"
+
+func Test_aclUserPermissions(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []string
+		cmd     string
+		args    []string
+		wantRun bool
+		wantKey bool
+	}{
+		{"It should deny everything to a new user", []string{}, GET, []string{"foo"}, false, false},
+		{"It should allow a command by name", []string{"+get", "~*"}, GET, []string{"foo"}, true, true},
+		{"It should allow a command by category", []string{"+@string", "~*"}, SET, []string{"foo", "bar"}, true, true},
+		{"It should apply later rules over earlier ones", []string{"+@all", "-set", "~*"}, SET, []string{"foo", "bar"}, false, true},
+		{"It should allow a single subcommand", []string{"+function|list"}, FUNCTION, []string{"list"}, true, true},
+		{"It should not allow other subcommands", []string{"+function|list"}, FUNCTION, []string{"load", "code"}, false, true},
+		{"It should deny keys outside the patterns", []string{"+get", "~app:*"}, GET, []string{"other"}, true, false},
+		{"It should allow reading from read only patterns", []string{"+get", "%R~app:*"}, GET, []string{"app:1"}, true, true},
+		{"It should deny writing to read only patterns", []string{"+set", "%R~app:*"}, SET, []string{"app:1", "x"}, true, false},
+		{"It should check the keys of XREAD", []string{"+xread", "~s1"}, XREAD, []string{"STREAMS", "s1", "s2", "0", "0"}, true, false},
+		{"It should check the keys given to scripts", []string{"+eval", "~k1"}, EVAL, []string{"return 1", "1", "k1", "arg"}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := newACLUser("test")
+			for _, rule := range tt.rules {
+				if err := user.applyRule(rule); err != nil {
+					t.Fatalf("applyRule(%q) error = %v", rule, err)
+				}
+			}
+
+			spec := lookupCommand(tt.cmd, tt.args)
+			if got := user.canRunCommand(tt.cmd, spec); got != tt.wantRun {
+				t.Errorf("canRunCommand() = %v, want %v", got, tt.wantRun)
+			}
+
+			gotKey := true
+			for _, key := range commandKeys(tt.cmd, spec, tt.args) {
+				gotKey = gotKey && user.canAccessKey(key, !spec.hasFlag(CMD_WRITE), !spec.hasFlag(CMD_READONLY))
+			}
+			if gotKey != tt.wantKey {
+				t.Errorf("canAccessKey() = %v, want %v", gotKey, tt.wantKey)
+			}
+		})
+	}
+}
+
+func Test_aclUserApplyRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		wantErr bool
+	}{
+		{"It should accept a password", ">secret", false},
+		{"It should accept a password hash", "#2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", false},
+		{"It should reject a short password hash", "#abc", true},
+		{"It should reject removing an unknown password", "<nope", true},
+		{"It should accept read write key permissions", "%RW~app:*", false},
+		{"It should reject unknown key permissions", "%X~app:*", true},
+		{"It should reject unknown commands", "+nosuchcommand", true},
+		{"It should reject unknown categories", "+@nosuchcategory", true},
+		{"It should reject unknown subcommands", "+function|nosuchsubcommand", true},
+		{"It should reject unknown rules", "bogus", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newACLUser("test").applyRule(tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("applyRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
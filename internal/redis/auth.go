@@ -0,0 +1,68 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"fmt"
+)
+
+func (r *Redis) auth(args []string, connection RedisConnection) []serde.Value {
+	if len(args) > 2 {
+		return []serde.Value{serde.NewError("ERR syntax error")}
+	}
+
+	username, password := DEFAULT_USER, args[0]
+
+	if len(args) == 2 {
+		username, password = args[0], args[1]
+	} else if r.acl.defaultUserOpen() {
+		return []serde.Value{serde.NewError("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")}
+	}
+
+	if !r.acl.authenticate(username, password) {
+		r.acl.addLogEntry(ACL_REASON_AUTH, ACL_CONTEXT_TOPLEVEL, AUTH, username, connection.Info())
+		return []serde.Value{serde.NewError(ErrWrongPass.Error())}
+	}
+
+	connection.auth.user = username
+	connection.auth.authenticated = true
+	return []serde.Value{serde.Ok()}
+}
+
+// authorize checks that the client may run a command, and may access the keys it
+// names, logging why not if it can't
+func (r *Redis) authorize(cmd string, args []string, spec commandSpec, connection RedisConnection, context string) error {
+	if spec.hasFlag(CMD_NO_AUTH) {
+		return nil
+	}
+
+	if !connection.auth.authenticated && !r.acl.defaultUserOpen() {
+		return ErrNoAuth
+	}
+
+	user, ok := r.acl.getUser(connection.auth.user)
+
+	// The user was deleted since the client authenticated
+	if !ok {
+		connection.auth.user = DEFAULT_USER
+		connection.auth.authenticated = false
+		return ErrNoAuth
+	}
+
+	if !user.canRunCommand(cmd, spec) {
+		r.acl.addLogEntry(ACL_REASON_COMMAND, context, spec.name, user.name, connection.Info())
+		return fmt.Errorf("NOPERM User %s has no permissions to run the '%s' command", user.name, spec.name)
+	}
+
+	// Commands that neither only read nor write, like scripts, need both
+	read := !spec.hasFlag(CMD_WRITE)
+	write := !spec.hasFlag(CMD_READONLY)
+
+	for _, key := range commandKeys(cmd, spec, args) {
+		if !user.canAccessKey(key, read, write) {
+			r.acl.addLogEntry(ACL_REASON_KEY, context, key, user.name, connection.Info())
+			return ErrNoKeyPerm
+		}
+	}
+
+	return nil
+}
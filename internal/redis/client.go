@@ -0,0 +1,455 @@
+package redis
+
+import (
+	"codecrafters/internal/array"
+	"codecrafters/internal/serde"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var clientHelp = []string{
+	"CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GETNAME",
+	"    Return the name of the current connection.",
+	"ID",
+	"    Return the ID of the current connection.",
+	"INFO",
+	"    Return information about the current client connection.",
+	"KILL <ip:port>",
+	"    Kill connection made from <ip:port>.",
+	"KILL <option> <value> [<option> <value> [...]]",
+	"    Kill connections. Options are:",
+	"    * ADDR (<ip:port>|<unixsocket>:0)",
+	"      Kill connections made from the specified address",
+	"    * LADDR (<ip:port>|<unixsocket>:0)",
+	"      Kill connections made to specified local address",
+	"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+	"      Kill connections by type.",
+	"    * USER <username>",
+	"      Kill connections authenticated by <username>.",
+	"    * SKIPME (YES|NO)",
+	"      Skip killing current connection (default: yes).",
+	"    * ID <client-id>",
+	"      Kill connections by client id.",
+	"    * MAXAGE <maxage>",
+	"      Kill connections older than the specified age.",
+	"LIST [options ...]",
+	"    Return information about client connections. Options:",
+	"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+	"      Return clients of specified type.",
+	"    * ID <client-id> [<client-id> ...]",
+	"      Return clients of specified IDs only.",
+	"PAUSE <timeout> [WRITE|ALL]",
+	"    Suspend all, or just write, clients for <timeout> milliseconds.",
+	"UNPAUSE",
+	"    Stop the current client pause, resuming traffic.",
+	"SETNAME <name>",
+	"    Assign the name <name> to the current connection.",
+	"NO-EVICT (ON|OFF)",
+	"    Protect current client connection from eviction.",
+	"TRACKING (ON|OFF) [REDIRECT <id>] [BCAST] [PREFIX <prefix> [...]]",
+	"         [OPTIN] [OPTOUT] [NOLOOP]",
+	"    Control server assisted client side caching.",
+	"CACHING (YES|NO)",
+	"    Enable/disable tracking of the keys for next command in OPTIN/OPTOUT modes.",
+	"GETREDIR",
+	"    Return the client ID we are redirecting to when tracking is enabled.",
+	"TRACKINGINFO",
+	"    Report tracking status for the current connection.",
+	"HELP",
+	"    Print this help.",
+}
+
+func parseClientType(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case CLIENT_TYPE_NORMAL:
+		return CLIENT_TYPE_NORMAL, nil
+	case CLIENT_TYPE_MASTER:
+		return CLIENT_TYPE_MASTER, nil
+	case CLIENT_TYPE_REPLICA, "slave":
+		return CLIENT_TYPE_REPLICA, nil
+	case CLIENT_TYPE_PUBSUB:
+		return CLIENT_TYPE_PUBSUB, nil
+	default:
+		return "", fmt.Errorf("ERR Unknown client type '%s'", value)
+	}
+}
+
+func parseClientId(value string) (int64, error) {
+	id, err := strconv.ParseInt(value, 10, 64)
+
+	if err != nil || id <= 0 {
+		return 0, errors.New("ERR Invalid client ID")
+	}
+
+	return id, nil
+}
+
+// clientLines ends each client's description with a newline, as CLIENT LIST and
+// CLIENT INFO do
+func clientLines(lines []string) string {
+	output := ""
+	for _, line := range lines {
+		output += line + "\n"
+	}
+	return output
+}
+
+func parseClientListFilter(args []string) (clientFilter, error) {
+	filter := clientFilter{}
+
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "type":
+			if i+1 == len(args) {
+				return filter, errors.New("ERR syntax error")
+			}
+
+			kind, err := parseClientType(args[i+1])
+
+			if err != nil {
+				return filter, err
+			}
+
+			filter.kind = kind
+			i++
+		case "id":
+			if i+1 == len(args) {
+				return filter, errors.New("ERR syntax error")
+			}
+
+			// IDs run to the end of the arguments
+			for _, arg := range args[i+1:] {
+				id, err := parseClientId(arg)
+
+				if err != nil {
+					return filter, err
+				}
+
+				filter.ids = append(filter.ids, id)
+			}
+			i = len(args)
+		default:
+			return filter, errors.New("ERR syntax error")
+		}
+	}
+
+	return filter, nil
+}
+
+// parseClientKillFilter parses the option/value pairs of CLIENT KILL, which skips
+// the calling client unless told otherwise
+func (r *Redis) parseClientKillFilter(args []string, connection RedisConnection) (clientFilter, error) {
+	filter := clientFilter{skipId: connection.id}
+
+	if len(args)%2 != 0 {
+		return filter, errors.New("ERR syntax error")
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1]
+
+		switch strings.ToLower(args[i]) {
+		case "id":
+			id, err := strconv.ParseInt(value, 10, 64)
+
+			if err != nil || id <= 0 {
+				return filter, errors.New("ERR client-id should be greater than 0")
+			}
+
+			filter.ids = append(filter.ids, id)
+		case "type":
+			kind, err := parseClientType(value)
+
+			if err != nil {
+				return filter, err
+			}
+
+			filter.kind = kind
+		case "user":
+			if _, ok := r.acl.getUser(value); !ok {
+				return filter, fmt.Errorf("ERR No such user '%s'", value)
+			}
+
+			filter.user = value
+		case "addr":
+			filter.addr = value
+		case "laddr":
+			filter.laddr = value
+		case "skipme":
+			switch strings.ToLower(value) {
+			case "yes":
+				filter.skipId = connection.id
+			case "no":
+				filter.skipId = 0
+			default:
+				return filter, errors.New("ERR syntax error")
+			}
+		case "maxage":
+			seconds, err := strconv.ParseInt(value, 10, 64)
+
+			if err != nil || seconds <= 0 {
+				return filter, errors.New("ERR syntax error")
+			}
+
+			filter.maxAge = time.Duration(seconds) * time.Second
+		default:
+			return filter, errors.New("ERR syntax error")
+		}
+	}
+
+	return filter, nil
+}
+
+func (r *Redis) clientKill(args []string, connection RedisConnection) []serde.Value {
+	// The old form takes just an address, and complains if nobody is there
+	if len(args) == 1 {
+		if r.clients.kill(clientFilter{addr: args[0]}, connection.id) == 0 {
+			return []serde.Value{serde.NewError("ERR No such client")}
+		}
+		return []serde.Value{serde.Ok()}
+	}
+
+	filter, err := r.parseClientKillFilter(args, connection)
+
+	if err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	return []serde.Value{serde.NewInteger(int64(r.clients.kill(filter, connection.id)))}
+}
+
+// validClientName checks the name only has printable characters other than space
+func validClientName(name string) bool {
+	for _, c := range name {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Redis) clientPause(args []string) []serde.Value {
+	timeoutMs, err := strconv.ParseInt(args[0], 10, 64)
+
+	if err != nil {
+		return []serde.Value{serde.NewError("ERR timeout is not an integer or out of range")}
+	}
+
+	if timeoutMs < 0 {
+		return []serde.Value{serde.NewError("ERR timeout is negative")}
+	}
+
+	mode := CLIENT_PAUSE_ALL
+
+	if len(args) == 2 {
+		mode = strings.ToLower(args[1])
+	}
+
+	if len(args) > 2 || (mode != CLIENT_PAUSE_ALL && mode != CLIENT_PAUSE_WRITE) {
+		return []serde.Value{serde.NewError("ERR syntax error")}
+	}
+
+	r.clients.pauseClients(mode, time.Now().Add(time.Duration(timeoutMs)*time.Millisecond))
+	return []serde.Value{serde.Ok()}
+}
+
+func parseTrackingOptions(args []string) (trackingOptions, error) {
+	options := trackingOptions{}
+
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "redirect":
+			if i+1 == len(args) {
+				return options, errors.New("ERR syntax error")
+			}
+
+			if options.redirect != 0 {
+				return options, errors.New("ERR A client can only redirect to a single other client")
+			}
+
+			id, err := strconv.ParseInt(args[i+1], 10, 64)
+
+			if err != nil {
+				return options, errors.New("ERR value is not an integer or out of range")
+			}
+
+			options.redirect = id
+			i++
+		case "prefix":
+			if i+1 == len(args) {
+				return options, errors.New("ERR syntax error")
+			}
+
+			options.prefixes = append(options.prefixes, args[i+1])
+			i++
+		case "bcast":
+			options.bcast = true
+		case "optin":
+			options.optIn = true
+		case "optout":
+			options.optOut = true
+		case "noloop":
+			options.noLoop = true
+		default:
+			return options, errors.New("ERR syntax error")
+		}
+	}
+
+	return options, nil
+}
+
+func (r *Redis) clientTracking(args []string, connection RedisConnection) []serde.Value {
+	switch strings.ToLower(args[0]) {
+	case "on":
+		options, err := parseTrackingOptions(args[1:])
+
+		if err != nil {
+			return []serde.Value{serde.NewError(err.Error())}
+		}
+
+		if options.redirect != 0 && options.redirect != connection.id {
+			if _, _, ok := r.clients.lookup(options.redirect); !ok {
+				return []serde.Value{serde.NewError("ERR The client ID you want redirect to does not exist")}
+			}
+		}
+
+		if err := r.tracking.enable(connection.id, options); err != nil {
+			return []serde.Value{serde.NewError(err.Error())}
+		}
+
+		r.clients.setRedirect(connection.id, options.redirect)
+	case "off":
+		if len(args) > 1 {
+			return []serde.Value{serde.NewError("ERR syntax error")}
+		}
+
+		r.tracking.disable(connection.id)
+		r.clients.setRedirect(connection.id, -1)
+	default:
+		return []serde.Value{serde.NewError("ERR syntax error")}
+	}
+
+	return []serde.Value{serde.Ok()}
+}
+
+func (r *Redis) clientTrackingInfo(connection RedisConnection) []serde.Value {
+	options, caching, ok := r.tracking.options(connection.id)
+
+	flags := []string{}
+	redirect := int64(-1)
+
+	if !ok {
+		flags = append(flags, "off")
+	} else {
+		flags = append(flags, "on")
+		redirect = options.redirect
+
+		for _, flag := range []struct {
+			set  bool
+			name string
+		}{
+			{options.bcast, "bcast"},
+			{options.optIn, "optin"},
+			{options.optOut, "optout"},
+			{caching == TRACKING_CACHING_YES, "caching-yes"},
+			{caching == TRACKING_CACHING_NO, "caching-no"},
+			{options.noLoop, "noloop"},
+		} {
+			if flag.set {
+				flags = append(flags, flag.name)
+			}
+		}
+
+		if redirect != 0 {
+			if _, _, ok := r.clients.lookup(redirect); !ok {
+				flags = append(flags, "broken_redirect")
+			}
+		}
+	}
+
+	return []serde.Value{serde.NewArray([]serde.Value{
+		serde.NewBulkString("flags"), bulkStrings(flags),
+		serde.NewBulkString("redirect"), serde.NewInteger(redirect),
+		serde.NewBulkString("prefixes"), bulkStrings(options.prefixes),
+	})}
+}
+
+func (r *Redis) client(args []string, connection RedisConnection) []serde.Value {
+	switch strings.ToLower(args[0]) {
+	case "id":
+		return []serde.Value{serde.NewInteger(connection.id)}
+	case "info":
+		return []serde.Value{serde.NewBulkString(clientLines(r.clients.list(clientFilter{ids: []int64{connection.id}})))}
+	case "list":
+		filter, err := parseClientListFilter(args[1:])
+
+		if err != nil {
+			return []serde.Value{serde.NewError(err.Error())}
+		}
+
+		return []serde.Value{serde.NewBulkString(clientLines(r.clients.list(filter)))}
+	case "kill":
+		return r.clientKill(args[1:], connection)
+	case "setname":
+		if !validClientName(args[1]) {
+			return []serde.Value{serde.NewError("ERR Client names cannot contain spaces, newlines or special characters.")}
+		}
+
+		r.clients.setName(connection.id, args[1])
+		return []serde.Value{serde.Ok()}
+	case "getname":
+		name := r.clients.name(connection.id)
+
+		if name == "" {
+			return []serde.Value{serde.NewNull()}
+		}
+		return []serde.Value{serde.NewBulkString(name)}
+	case "pause":
+		return r.clientPause(args[1:])
+	case "unpause":
+		r.clients.unpause()
+		return []serde.Value{serde.Ok()}
+	case "no-evict":
+		switch strings.ToLower(args[1]) {
+		case "on":
+			r.clients.setNoEvict(connection.id, true)
+		case "off":
+			r.clients.setNoEvict(connection.id, false)
+		default:
+			return []serde.Value{serde.NewError("ERR syntax error")}
+		}
+		return []serde.Value{serde.Ok()}
+	case "tracking":
+		return r.clientTracking(args[1:], connection)
+	case "caching":
+		caching := strings.ToLower(args[1])
+
+		if caching != TRACKING_CACHING_YES && caching != TRACKING_CACHING_NO {
+			return []serde.Value{serde.NewError("ERR syntax error")}
+		}
+
+		if err := r.tracking.setCaching(connection.id, caching); err != nil {
+			return []serde.Value{serde.NewError(err.Error())}
+		}
+		return []serde.Value{serde.Ok()}
+	case "getredir":
+		options, _, ok := r.tracking.options(connection.id)
+
+		if !ok {
+			return []serde.Value{serde.NewInteger(-1)}
+		}
+		return []serde.Value{serde.NewInteger(options.redirect)}
+	case "trackinginfo":
+		return r.clientTrackingInfo(connection)
+	case "help":
+		return []serde.Value{serde.NewArray(array.Map(clientHelp, func(line string) serde.Value {
+			return serde.NewSimpleString(line)
+		}))}
+	default:
+		return []serde.Value{serde.NewError(fmt.Sprintf("ERR unknown subcommand '%s'. Try CLIENT HELP.", args[0]))}
+	}
+}
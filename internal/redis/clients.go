@@ -0,0 +1,670 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CLIENT_TYPE_NORMAL  = "normal"
+	CLIENT_TYPE_MASTER  = "master"
+	CLIENT_TYPE_REPLICA = "replica"
+	CLIENT_TYPE_PUBSUB  = "pubsub"
+)
+
+const (
+	CLIENT_PAUSE_WRITE = "write"
+	CLIENT_PAUSE_ALL   = "all"
+)
+
+type clientInfo struct {
+	id         int64
+	connection RedisConnection
+	user       string
+	kind       string
+	name       string
+	// The RESP version chosen with HELLO
+	protocol int
+	created  time.Time
+	// When the client last sent us a command
+	lastInteraction time.Time
+	lastCommand     string
+	// The number of queued commands while in MULTI, otherwise -1
+	multi   int
+	noEvict bool
+	// Bytes read from the connection but not yet parsed, and the size of the buffer
+	// holding them
+	queryBuffer     int
+	queryBufferSize int
+	// Where invalidation messages go for a client tracking keys, -1 when it isn't
+	redirect int64
+	// Lines waiting to be sent to a client in MONITOR mode, which is sent every
+	// command run. Nil for other clients.
+	monitor chan serde.Value
+	// The Pub/Sub channels the client has subscribed to
+	channels []string
+	// Set when a client kills itself, so that it gets its reply before we hang up
+	closeAfterReply bool
+	// For replicas, the port they listen on and how far they've acknowledged
+	// replicating
+	replicaPort   int
+	replicaOffset int
+	replicaAck    time.Time
+}
+
+// replicaInfo describes a replica for INFO replication
+type replicaInfo struct {
+	ip      string
+	port    int
+	offset  int
+	lastAck time.Time
+}
+
+// clientAddrs gives the remote and local addresses of a client. Unix socket
+// clients have no address of their own, so as in Redis both are the socket path.
+func clientAddrs(conn net.Conn) (string, string) {
+	if unixAddr, ok := conn.LocalAddr().(*net.UnixAddr); ok {
+		addr := unixAddr.Name + ":0"
+		return addr, addr
+	}
+	return conn.RemoteAddr().String(), conn.LocalAddr().String()
+}
+
+// flags describes the client as the flags field of CLIENT LIST does
+func (c *clientInfo) flags() string {
+	flags := ""
+
+	switch c.kind {
+	case CLIENT_TYPE_MASTER:
+		flags += "M"
+	case CLIENT_TYPE_REPLICA:
+		flags += "S"
+	}
+
+	if c.multi >= 0 {
+		flags += "x"
+	}
+
+	if c.monitor != nil {
+		flags += "O"
+	}
+
+	if c.noEvict {
+		flags += "e"
+	}
+
+	if len(c.channels) > 0 {
+		flags += "P"
+	}
+
+	if c.redirect >= 0 {
+		flags += "t"
+	}
+
+	if flags == "" {
+		return "N"
+	}
+	return flags
+}
+
+// clientType is the type CLIENT LIST and CLIENT KILL filter on, where normal
+// clients that have subscribed to a channel count as Pub/Sub clients
+func (c *clientInfo) clientType() string {
+	if c.kind == CLIENT_TYPE_NORMAL && len(c.channels) > 0 {
+		return CLIENT_TYPE_PUBSUB
+	}
+	return c.kind
+}
+
+func (c *clientInfo) describe(now time.Time) string {
+	addr, laddr := clientAddrs(c.connection.conn)
+
+	fields := []string{
+		fmt.Sprintf("id=%d", c.id),
+		fmt.Sprintf("addr=%s", addr),
+		fmt.Sprintf("laddr=%s", laddr),
+		fmt.Sprintf("name=%s", c.name),
+		fmt.Sprintf("age=%d", int64(now.Sub(c.created).Seconds())),
+		fmt.Sprintf("idle=%d", int64(now.Sub(c.lastInteraction).Seconds())),
+		fmt.Sprintf("flags=%s", c.flags()),
+		"db=0",
+		fmt.Sprintf("sub=%d", len(c.channels)),
+		"psub=0",
+		fmt.Sprintf("multi=%d", c.multi),
+		fmt.Sprintf("qbuf=%d", c.queryBuffer),
+		fmt.Sprintf("qbuf-free=%d", c.queryBufferSize-c.queryBuffer),
+		"obl=0",
+		"oll=0",
+		"omem=0",
+		"events=r",
+		fmt.Sprintf("cmd=%s", c.lastCommand),
+		fmt.Sprintf("user=%s", c.user),
+		fmt.Sprintf("redir=%d", c.redirect),
+		fmt.Sprintf("resp=%d", c.protocol),
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// clientFilter picks out clients for CLIENT LIST and CLIENT KILL, with zero values
+// matching every client
+type clientFilter struct {
+	ids    []int64
+	kind   string
+	user   string
+	addr   string
+	laddr  string
+	skipId int64
+	maxAge time.Duration
+}
+
+func (f clientFilter) matches(c *clientInfo, now time.Time) bool {
+	if len(f.ids) > 0 && !slices.Contains(f.ids, c.id) {
+		return false
+	}
+
+	if f.kind != "" && f.kind != c.clientType() {
+		return false
+	}
+
+	if f.user != "" && f.user != c.user {
+		return false
+	}
+
+	addr, laddr := clientAddrs(c.connection.conn)
+
+	if f.addr != "" && f.addr != addr {
+		return false
+	}
+
+	if f.laddr != "" && f.laddr != laddr {
+		return false
+	}
+
+	if f.skipId != 0 && f.skipId == c.id {
+		return false
+	}
+
+	if f.maxAge != 0 && now.Sub(c.created) < f.maxAge {
+		return false
+	}
+
+	return true
+}
+
+// clientPause holds back commands from normal clients until it ends, either all
+// of them or only those that may write
+type clientPause struct {
+	mode string
+	end  time.Time
+	// Closed whenever the pause changes, waking up anyone waiting on it
+	changed chan struct{}
+}
+
+func (p clientPause) holds(now time.Time, mayWrite bool) bool {
+	if p.mode == "" || !now.Before(p.end) {
+		return false
+	}
+	return p.mode == CLIENT_PAUSE_ALL || mayWrite
+}
+
+// extended combines a pause still under way with a new one, keeping whichever
+// holds back more and lasts longer, as a later CLIENT PAUSE WRITE mustn't let
+// reads through part way through a pause of all clients
+func (p clientPause) extended(now time.Time, mode string, end time.Time) clientPause {
+	if p.mode != "" && now.Before(p.end) {
+		if p.mode == CLIENT_PAUSE_ALL {
+			mode = CLIENT_PAUSE_ALL
+		}
+
+		if p.end.After(end) {
+			end = p.end
+		}
+	}
+
+	return clientPause{mode: mode, end: end, changed: make(chan struct{})}
+}
+
+type clientRegistry struct {
+	mutex   *sync.Mutex
+	nextId  int64
+	clients map[int64]*clientInfo
+	pause   clientPause
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{
+		mutex:   &sync.Mutex{},
+		clients: map[int64]*clientInfo{},
+		pause:   clientPause{changed: make(chan struct{})},
+	}
+}
+
+// register gives the connection its ID and tracks it until unregistered
+func (c *clientRegistry) register(connection *RedisConnection, kind string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.nextId++
+	now := time.Now()
+
+	connection.id = c.nextId
+	c.clients[connection.id] = &clientInfo{
+		id:              connection.id,
+		connection:      *connection,
+		protocol:        2,
+		user:            connection.auth.user,
+		kind:            kind,
+		created:         now,
+		lastInteraction: now,
+		multi:           -1,
+		redirect:        -1,
+		queryBufferSize: connection.reader.Size(),
+	}
+}
+
+func (c *clientRegistry) unregister(id int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if client, ok := c.clients[id]; ok && client.monitor != nil {
+		close(client.monitor)
+	}
+	delete(c.clients, id)
+}
+
+// update runs f against the client while holding the lock, returning false if
+// the client isn't registered
+func (c *clientRegistry) update(id int64, f func(client *clientInfo)) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	client, ok := c.clients[id]
+
+	if ok {
+		f(client)
+	}
+	return ok
+}
+
+// touch records a command read from the client
+func (c *clientRegistry) touch(connection RedisConnection, cmd string) {
+	c.update(connection.id, func(client *clientInfo) {
+		client.lastInteraction = time.Now()
+		client.lastCommand = cmd
+		client.queryBuffer = connection.reader.Buffered()
+	})
+}
+
+// refresh records the state a command may have changed once it has run
+func (c *clientRegistry) refresh(connection RedisConnection) {
+	c.update(connection.id, func(client *clientInfo) {
+		client.user = connection.auth.user
+		client.multi = -1
+		if connection.transaction {
+			client.multi = len(connection.bufferedCommands)
+		}
+	})
+}
+
+func (c *clientRegistry) setType(id int64, kind string) {
+	c.update(id, func(client *clientInfo) {
+		client.kind = kind
+	})
+}
+
+// lookup finds a client's connection, along with the RESP version it speaks
+func (c *clientRegistry) lookup(id int64) (RedisConnection, int, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	client, ok := c.clients[id]
+
+	if !ok {
+		return RedisConnection{}, 0, false
+	}
+	return client.connection, client.protocol, true
+}
+
+func (c *clientRegistry) protocol(id int64) int {
+	protocol := 2
+	c.update(id, func(client *clientInfo) {
+		protocol = client.protocol
+	})
+	return protocol
+}
+
+func (c *clientRegistry) setProtocol(id int64, protocol int) {
+	c.update(id, func(client *clientInfo) {
+		client.protocol = protocol
+	})
+}
+
+func (c *clientRegistry) setRedirect(id int64, redirect int64) {
+	c.update(id, func(client *clientInfo) {
+		client.redirect = redirect
+	})
+}
+
+func (c *clientRegistry) name(id int64) string {
+	name := ""
+	c.update(id, func(client *clientInfo) {
+		name = client.name
+	})
+	return name
+}
+
+func (c *clientRegistry) setName(id int64, name string) {
+	c.update(id, func(client *clientInfo) {
+		client.name = name
+	})
+}
+
+func (c *clientRegistry) setNoEvict(id int64, noEvict bool) {
+	c.update(id, func(client *clientInfo) {
+		client.noEvict = noEvict
+	})
+}
+
+// setMonitor puts the client in MONITOR mode, which replicas can't be put in. It
+// returns the queue of lines for the client when it has only now been put in
+// MONITOR mode, and false for replicas. The queue starts with the reply to
+// MONITOR, so that it comes before any line.
+func (c *clientRegistry) setMonitor(id int64) (chan serde.Value, bool) {
+	var queue chan serde.Value
+	set := false
+	c.update(id, func(client *clientInfo) {
+		set = client.kind != CLIENT_TYPE_REPLICA
+
+		if set && client.monitor == nil {
+			client.monitor = make(chan serde.Value, MONITOR_QUEUE_SIZE)
+			client.monitor <- serde.Ok()
+			queue = client.monitor
+		}
+	})
+	return queue, set
+}
+
+// hasMonitors reports whether any client is in MONITOR mode
+func (c *clientRegistry) hasMonitors() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, client := range c.clients {
+		if client.monitor != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// queueMonitorLine queues a line for every client in MONITOR mode. Those too far
+// behind to take it are disconnected, as Redis does once a client's output
+// buffer grows past its limit.
+func (c *clientRegistry) queueMonitorLine(line serde.Value) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, client := range c.clients {
+		if client.monitor == nil {
+			continue
+		}
+
+		select {
+		case client.monitor <- line:
+		default:
+			client.connection.Close()
+		}
+	}
+}
+
+// subscribe adds channels to those the client has subscribed to, returning how
+// many it's subscribed to after each one
+func (c *clientRegistry) subscribe(id int64, channels []string) []int {
+	counts := []int{}
+	c.update(id, func(client *clientInfo) {
+		for _, channel := range channels {
+			if !slices.Contains(client.channels, channel) {
+				client.channels = append(client.channels, channel)
+			}
+			counts = append(counts, len(client.channels))
+		}
+	})
+	return counts
+}
+
+// unsubscribe removes channels from those the client has subscribed to, or all
+// of them when none are given. It returns the channels along with how many the
+// client is still subscribed to after each one.
+func (c *clientRegistry) unsubscribe(id int64, channels []string) ([]string, []int) {
+	counts := []int{}
+	c.update(id, func(client *clientInfo) {
+		if len(channels) == 0 {
+			channels = slices.Clone(client.channels)
+		}
+
+		for _, channel := range channels {
+			client.channels = slices.DeleteFunc(client.channels, func(subscribed string) bool { return subscribed == channel })
+			counts = append(counts, len(client.channels))
+		}
+	})
+	return channels, counts
+}
+
+// isSubscribed reports whether the client has subscribed to the channel, or to
+// any channel when it's empty
+func (c *clientRegistry) isSubscribed(id int64, channel string) bool {
+	subscribed := false
+	c.update(id, func(client *clientInfo) {
+		subscribed = slices.Contains(client.channels, channel) || (channel == "" && len(client.channels) > 0)
+	})
+	return subscribed
+}
+
+// list describes the matching clients in order of ID, one per line
+func (c *clientRegistry) list(filter clientFilter) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	lines := []string{}
+
+	for _, id := range c.sortedIds() {
+		if client := c.clients[id]; filter.matches(client, now) {
+			lines = append(lines, client.describe(now))
+		}
+	}
+
+	return lines
+}
+
+// replicas lists the connected replicas in the order they connected
+func (c *clientRegistry) replicas() []replicaInfo {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	replicas := []replicaInfo{}
+
+	for _, id := range c.sortedIds() {
+		client := c.clients[id]
+
+		if client.kind != CLIENT_TYPE_REPLICA {
+			continue
+		}
+
+		addr, _ := clientAddrs(client.connection.conn)
+		ip, _, _ := net.SplitHostPort(addr)
+		replicas = append(replicas, replicaInfo{ip, client.replicaPort, client.replicaOffset, client.replicaAck})
+	}
+
+	return replicas
+}
+
+// stats returns how many clients are connected, leaving out replicas, along with
+// the largest query buffer any of them has
+func (c *clientRegistry) stats() (int, int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	connected, maxQueryBuffer := 0, 0
+
+	for _, client := range c.clients {
+		if client.kind != CLIENT_TYPE_REPLICA {
+			connected++
+		}
+		maxQueryBuffer = max(maxQueryBuffer, client.queryBuffer)
+	}
+
+	return connected, maxQueryBuffer
+}
+
+func (c *clientRegistry) setReplicaPort(id int64, port int) {
+	c.update(id, func(client *clientInfo) {
+		client.replicaPort = port
+	})
+}
+
+func (c *clientRegistry) replicaAcked(id int64, offset int) {
+	c.update(id, func(client *clientInfo) {
+		client.replicaOffset = offset
+		client.replicaAck = time.Now()
+	})
+}
+
+// unresponsiveReplicas returns the replicas that have acknowledged before, but
+// not since the given time. Those that never acknowledge can't be told apart
+// from ones that are merely quiet, so are left alone.
+func (c *clientRegistry) unresponsiveReplicas(since time.Time) []int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ids := []int64{}
+
+	for _, id := range c.sortedIds() {
+		client := c.clients[id]
+
+		if client.kind == CLIENT_TYPE_REPLICA && !client.replicaAck.IsZero() && client.replicaAck.Before(since) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+func (c *clientRegistry) sortedIds() []int64 {
+	ids := make([]int64, 0, len(c.clients))
+	for id := range c.clients {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// kill disconnects the matching clients, returning how many there were. The
+// calling client is only marked, as it should still get its reply.
+func (c *clientRegistry) kill(filter clientFilter, self int64) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	killed := 0
+
+	for _, client := range c.clients {
+		if !filter.matches(client, now) {
+			continue
+		}
+
+		if client.id == self {
+			client.closeAfterReply = true
+		} else {
+			client.connection.Close()
+		}
+		killed++
+	}
+
+	return killed
+}
+
+// closeAll disconnects every client, replicas and masters included
+func (c *clientRegistry) closeAll() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, client := range c.clients {
+		client.connection.Close()
+	}
+}
+
+func (c *clientRegistry) shouldClose(id int64) bool {
+	closeAfterReply := false
+	c.update(id, func(client *clientInfo) {
+		closeAfterReply = client.closeAfterReply
+	})
+	return closeAfterReply
+}
+
+// pauseClients starts a pause, extending any pause already under way
+func (c *clientRegistry) pauseClients(mode string, end time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	close(c.pause.changed)
+	c.pause = c.pause.extended(time.Now(), mode, end)
+}
+
+func (c *clientRegistry) unpause() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	close(c.pause.changed)
+	c.pause = clientPause{changed: make(chan struct{})}
+}
+
+// isPaused reports whether the client's command would be held back by a pause
+func (c *clientRegistry) isPaused(id int64, mayWrite bool) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.pausesClient(id, mayWrite)
+}
+
+func (c *clientRegistry) pausesClient(id int64, mayWrite bool) bool {
+	client, ok := c.clients[id]
+
+	if !ok || client.kind != CLIENT_TYPE_NORMAL {
+		return false
+	}
+	return c.pause.holds(time.Now(), mayWrite)
+}
+
+// waitWhilePaused blocks for as long as a pause holds back the client's command,
+// or until the context is done
+func (c *clientRegistry) waitWhilePaused(ctx context.Context, id int64, mayWrite bool) {
+	for {
+		c.mutex.Lock()
+		pause := c.pause
+		paused := c.pausesClient(id, mayWrite)
+		c.mutex.Unlock()
+
+		if !paused {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(pause.end))
+
+		select {
+		case <-pause.changed:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		timer.Stop()
+	}
+}
@@ -0,0 +1,105 @@
+package redis
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseClientListFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    clientFilter
+		wantErr bool
+	}{
+		{"It should match every client without options", []string{}, clientFilter{}, false},
+		{"It should filter by type", []string{"TYPE", "slave"}, clientFilter{kind: CLIENT_TYPE_REPLICA}, false},
+		{"It should filter by several IDs", []string{"ID", "1", "5"}, clientFilter{ids: []int64{1, 5}}, false},
+		{"It should reject an unknown type", []string{"TYPE", "bogus"}, clientFilter{}, true},
+		{"It should reject an invalid ID", []string{"ID", "0"}, clientFilter{}, true},
+		{"It should reject a missing value", []string{"TYPE"}, clientFilter{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseClientListFilter(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseClientListFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseClientListFilter() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clientPauseHolds(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		pause    clientPause
+		mayWrite bool
+		want     bool
+	}{
+		{"It should hold nothing without a pause", clientPause{}, true, false},
+		{"It should hold every command when pausing all", clientPause{mode: CLIENT_PAUSE_ALL, end: now.Add(time.Second)}, false, true},
+		{"It should hold writes when pausing writes", clientPause{mode: CLIENT_PAUSE_WRITE, end: now.Add(time.Second)}, true, true},
+		{"It should let reads through when pausing writes", clientPause{mode: CLIENT_PAUSE_WRITE, end: now.Add(time.Second)}, false, false},
+		{"It should hold nothing once the pause has ended", clientPause{mode: CLIENT_PAUSE_ALL, end: now}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.pause.holds(now, tt.mayWrite); got != tt.want {
+				t.Errorf("holds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clientPauseExtended(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		pause    clientPause
+		mode     string
+		end      time.Time
+		wantMode string
+		wantEnd  time.Time
+	}{
+		{"It should start a pause", clientPause{}, CLIENT_PAUSE_WRITE, now.Add(time.Second), CLIENT_PAUSE_WRITE, now.Add(time.Second)},
+		{"It should keep pausing all clients", clientPause{mode: CLIENT_PAUSE_ALL, end: now.Add(10 * time.Second)}, CLIENT_PAUSE_WRITE, now.Add(time.Second), CLIENT_PAUSE_ALL, now.Add(10 * time.Second)},
+		{"It should widen a pause of writes", clientPause{mode: CLIENT_PAUSE_WRITE, end: now.Add(10 * time.Second)}, CLIENT_PAUSE_ALL, now.Add(time.Second), CLIENT_PAUSE_ALL, now.Add(10 * time.Second)},
+		{"It should extend a pause", clientPause{mode: CLIENT_PAUSE_WRITE, end: now.Add(time.Second)}, CLIENT_PAUSE_WRITE, now.Add(10 * time.Second), CLIENT_PAUSE_WRITE, now.Add(10 * time.Second)},
+		{"It should replace a pause that has ended", clientPause{mode: CLIENT_PAUSE_ALL, end: now}, CLIENT_PAUSE_WRITE, now.Add(time.Second), CLIENT_PAUSE_WRITE, now.Add(time.Second)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.pause.extended(now, tt.mode, tt.end)
+			if got.mode != tt.wantMode || !got.end.Equal(tt.wantEnd) {
+				t.Errorf("extended() = %s until %v, want %s until %v", got.mode, got.end, tt.wantMode, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func Test_clientInfoFlags(t *testing.T) {
+	tests := []struct {
+		name   string
+		client clientInfo
+		want   string
+	}{
+		{"It should flag a normal client", clientInfo{kind: CLIENT_TYPE_NORMAL, multi: -1, redirect: -1}, "N"},
+		{"It should flag a replica", clientInfo{kind: CLIENT_TYPE_REPLICA, multi: -1, redirect: -1}, "S"},
+		{"It should flag a client in MULTI that can't be evicted", clientInfo{kind: CLIENT_TYPE_NORMAL, multi: 2, noEvict: true, redirect: -1}, "xe"},
+		{"It should flag a client tracking keys", clientInfo{kind: CLIENT_TYPE_NORMAL, multi: -1, redirect: 0}, "t"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.client.flags(); got != tt.want {
+				t.Errorf("flags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
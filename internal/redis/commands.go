@@ -0,0 +1,356 @@
+package redis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Command flags, named after their counterparts in the Redis command table
+const (
+	CMD_WRITE = 1 << iota
+	CMD_READONLY
+	CMD_ADMIN
+	// Rejected when sent inside MULTI, flagging the transaction as failed
+	CMD_NO_MULTI
+	// May block the client, though never when run as part of EXEC
+	CMD_BLOCKING
+	// May not be called from a script through redis.call
+	CMD_NO_SCRIPT
+	CMD_FAST
+	// May be run before the client has authenticated
+	CMD_NO_AUTH
+	// May use more memory, so is refused once maxmemory is reached and nothing can
+	// be evicted
+	CMD_DENYOOM
+)
+
+// ACL categories, each command belonging to those listed in the table along with
+// any implied by its flags
+const (
+	ACL_CATEGORY_KEYSPACE = 1 << iota
+	ACL_CATEGORY_READ
+	ACL_CATEGORY_WRITE
+	ACL_CATEGORY_SET
+	ACL_CATEGORY_SORTEDSET
+	ACL_CATEGORY_LIST
+	ACL_CATEGORY_HASH
+	ACL_CATEGORY_STRING
+	ACL_CATEGORY_BITMAP
+	ACL_CATEGORY_HYPERLOGLOG
+	ACL_CATEGORY_GEO
+	ACL_CATEGORY_STREAM
+	ACL_CATEGORY_PUBSUB
+	ACL_CATEGORY_ADMIN
+	ACL_CATEGORY_FAST
+	ACL_CATEGORY_SLOW
+	ACL_CATEGORY_BLOCKING
+	ACL_CATEGORY_DANGEROUS
+	ACL_CATEGORY_CONNECTION
+	ACL_CATEGORY_TRANSACTION
+	ACL_CATEGORY_SCRIPTING
+)
+
+// Category names in the same order as their bits above
+var aclCategoryNames = []string{
+	"keyspace", "read", "write", "set", "sortedset", "list", "hash", "string", "bitmap", "hyperloglog",
+	"geo", "stream", "pubsub", "admin", "fast", "slow", "blocking", "dangerous", "connection",
+	"transaction", "scripting",
+}
+
+// keySpec gives the positions of a command's keys, counting the command name as
+// zero. A negative last position counts back from the final argument.
+type keySpec struct {
+	first int
+	last  int
+	step  int
+}
+
+var noKeys = keySpec{}
+var firstKey = keySpec{1, 1, 1}
+var allKeys = keySpec{1, -1, 1}
+
+type commandSpec struct {
+	name string
+	// As in Redis, a positive arity is the exact number of arguments including the
+	// command name, whereas a negative arity is the minimum number
+	arity      int
+	flags      int
+	keys       keySpec
+	categories int
+}
+
+var commandTable = map[string]commandSpec{
+	PING:      {PING, -1, CMD_FAST, noKeys, ACL_CATEGORY_CONNECTION},
+	ECHO:      {ECHO, 2, CMD_FAST, noKeys, ACL_CATEGORY_CONNECTION},
+	SET:       {SET, -3, CMD_WRITE | CMD_DENYOOM, firstKey, ACL_CATEGORY_STRING},
+	GET:       {GET, 2, CMD_READONLY | CMD_FAST, firstKey, ACL_CATEGORY_STRING},
+	CONFIG:    {CONFIG, -2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+	KEYS:      {KEYS, 2, CMD_READONLY, noKeys, ACL_CATEGORY_KEYSPACE | ACL_CATEGORY_DANGEROUS},
+	INFO:      {INFO, -1, 0, noKeys, ACL_CATEGORY_DANGEROUS},
+	REPLCONF:  {REPLCONF, -1, CMD_ADMIN | CMD_NO_MULTI | CMD_NO_SCRIPT, noKeys, 0},
+	PSYNC:     {PSYNC, -3, CMD_ADMIN | CMD_NO_MULTI | CMD_NO_SCRIPT, noKeys, 0},
+	REPLICAOF: {REPLICAOF, 3, CMD_ADMIN | CMD_NO_MULTI | CMD_NO_SCRIPT, noKeys, 0},
+	SLAVEOF:   {SLAVEOF, 3, CMD_ADMIN | CMD_NO_MULTI | CMD_NO_SCRIPT, noKeys, 0},
+	WAIT:      {WAIT, 3, CMD_BLOCKING | CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_CONNECTION},
+	TYPE:      {TYPE, 2, CMD_READONLY | CMD_FAST, firstKey, ACL_CATEGORY_KEYSPACE},
+	DEL:       {DEL, -2, CMD_WRITE, allKeys, ACL_CATEGORY_KEYSPACE},
+	XADD:      {XADD, -5, CMD_WRITE | CMD_DENYOOM | CMD_FAST, firstKey, ACL_CATEGORY_STREAM},
+	XRANGE:    {XRANGE, -4, CMD_READONLY, firstKey, ACL_CATEGORY_STREAM},
+	XREVRANGE: {XREVRANGE, -4, CMD_READONLY, firstKey, ACL_CATEGORY_STREAM},
+	XREAD:     {XREAD, -4, CMD_READONLY | CMD_BLOCKING, noKeys, ACL_CATEGORY_STREAM},
+	XLEN:      {XLEN, 2, CMD_READONLY | CMD_FAST, firstKey, ACL_CATEGORY_STREAM},
+	XDEL:      {XDEL, -3, CMD_WRITE | CMD_FAST, firstKey, ACL_CATEGORY_STREAM},
+	XTRIM:     {XTRIM, -4, CMD_WRITE, firstKey, ACL_CATEGORY_STREAM},
+	XSETID:    {XSETID, -3, CMD_WRITE | CMD_DENYOOM | CMD_FAST, firstKey, ACL_CATEGORY_STREAM},
+	XINFO:     {XINFO, -2, CMD_READONLY, keySpec{2, 2, 1}, ACL_CATEGORY_STREAM},
+	INCR:      {INCR, 2, CMD_WRITE | CMD_DENYOOM | CMD_FAST, firstKey, ACL_CATEGORY_STRING},
+	MULTI:     {MULTI, 1, CMD_NO_MULTI | CMD_NO_SCRIPT | CMD_FAST, noKeys, ACL_CATEGORY_TRANSACTION},
+	EXEC:      {EXEC, 1, CMD_NO_MULTI | CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_TRANSACTION},
+	DISCARD:   {DISCARD, 1, CMD_NO_MULTI | CMD_NO_SCRIPT | CMD_FAST, noKeys, ACL_CATEGORY_TRANSACTION},
+	WATCH:     {WATCH, -2, CMD_NO_MULTI | CMD_NO_SCRIPT | CMD_FAST, keySpec{1, -1, 1}, ACL_CATEGORY_TRANSACTION},
+	UNWATCH:   {UNWATCH, 1, CMD_NO_SCRIPT | CMD_FAST, noKeys, ACL_CATEGORY_TRANSACTION},
+	FLUSHALL:  {FLUSHALL, -1, CMD_WRITE, noKeys, ACL_CATEGORY_KEYSPACE | ACL_CATEGORY_DANGEROUS},
+	FLUSHDB:   {FLUSHDB, -1, CMD_WRITE, noKeys, ACL_CATEGORY_KEYSPACE | ACL_CATEGORY_DANGEROUS},
+	// Scripts aren't flagged as writes, instead the writes they make are propagated
+	// one by one as they happen
+	EVAL:        {EVAL, -3, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+	EVALSHA:     {EVALSHA, -3, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+	EVAL_RO:     {EVAL_RO, -3, CMD_READONLY | CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+	EVALSHA_RO:  {EVALSHA_RO, -3, CMD_READONLY | CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+	SCRIPT:      {SCRIPT, -2, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+	FCALL:       {FCALL, -3, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+	FCALL_RO:    {FCALL_RO, -3, CMD_READONLY | CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+	FUNCTION:    {FUNCTION, -2, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+	AUTH:        {AUTH, -2, CMD_NO_AUTH | CMD_NO_SCRIPT | CMD_FAST, noKeys, ACL_CATEGORY_CONNECTION},
+	ACL:         {ACL, -2, CMD_NO_SCRIPT, noKeys, 0},
+	CLIENT:      {CLIENT, -2, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_CONNECTION},
+	SHUTDOWN:    {SHUTDOWN, -1, CMD_ADMIN | CMD_NO_MULTI | CMD_NO_SCRIPT, noKeys, 0},
+	HELLO:       {HELLO, -1, CMD_NO_AUTH | CMD_NO_SCRIPT | CMD_FAST, noKeys, ACL_CATEGORY_CONNECTION},
+	OBJECT:      {OBJECT, -2, CMD_READONLY, noKeys, ACL_CATEGORY_KEYSPACE},
+	MEMORY:      {MEMORY, -2, CMD_READONLY, noKeys, 0},
+	SLOWLOG:     {SLOWLOG, -2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+	LATENCY:     {LATENCY, -2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+	MONITOR:     {MONITOR, 1, CMD_ADMIN | CMD_NO_MULTI | CMD_NO_SCRIPT, noKeys, 0},
+	SUBSCRIBE:   {SUBSCRIBE, -2, CMD_NO_MULTI | CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_PUBSUB},
+	UNSUBSCRIBE: {UNSUBSCRIBE, -1, CMD_NO_MULTI | CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_PUBSUB},
+}
+
+// Commands made up of subcommands with their own arity and flags, such as
+// FUNCTION LOAD, where the subcommand's spec applies in place of the command's
+var subcommandTable = map[string]map[string]commandSpec{
+	SCRIPT: {
+		"load":   {"script|load", 3, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+		"exists": {"script|exists", -3, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+		"flush":  {"script|flush", -2, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+		"kill":   {"script|kill", 2, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+		"help":   {"script|help", 2, 0, noKeys, ACL_CATEGORY_SCRIPTING},
+	},
+	FUNCTION: {
+		"load":    {"function|load", -3, CMD_WRITE | CMD_DENYOOM | CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+		"delete":  {"function|delete", 3, CMD_WRITE | CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+		"flush":   {"function|flush", -2, CMD_WRITE | CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+		"restore": {"function|restore", -3, CMD_WRITE | CMD_DENYOOM | CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+		"list":    {"function|list", -2, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+		"stats":   {"function|stats", 2, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+		"dump":    {"function|dump", 2, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+		"kill":    {"function|kill", 2, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_SCRIPTING},
+		"help":    {"function|help", 2, 0, noKeys, ACL_CATEGORY_SCRIPTING},
+	},
+	ACL: {
+		"cat":     {"acl|cat", -2, CMD_NO_SCRIPT, noKeys, 0},
+		"deluser": {"acl|deluser", -3, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"getuser": {"acl|getuser", 3, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"list":    {"acl|list", 2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"load":    {"acl|load", 2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"log":     {"acl|log", -2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"save":    {"acl|save", 2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"setuser": {"acl|setuser", -3, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"users":   {"acl|users", 2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"whoami":  {"acl|whoami", 2, CMD_NO_SCRIPT, noKeys, 0},
+		"help":    {"acl|help", 2, 0, noKeys, 0},
+	},
+	CONFIG: {
+		"get":       {"config|get", -3, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"set":       {"config|set", -4, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"resetstat": {"config|resetstat", 2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"rewrite":   {"config|rewrite", 2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"help":      {"config|help", 2, 0, noKeys, 0},
+	},
+	OBJECT: {
+		"encoding": {"object|encoding", 3, CMD_READONLY, keySpec{2, 2, 1}, ACL_CATEGORY_KEYSPACE},
+		"refcount": {"object|refcount", 3, CMD_READONLY | CMD_FAST, keySpec{2, 2, 1}, ACL_CATEGORY_KEYSPACE},
+		"idletime": {"object|idletime", 3, CMD_READONLY, keySpec{2, 2, 1}, ACL_CATEGORY_KEYSPACE},
+		"freq":     {"object|freq", 3, CMD_READONLY, keySpec{2, 2, 1}, ACL_CATEGORY_KEYSPACE},
+		"help":     {"object|help", 2, CMD_READONLY, noKeys, ACL_CATEGORY_KEYSPACE},
+	},
+	MEMORY: {
+		"usage":        {"memory|usage", -3, CMD_READONLY, keySpec{2, 2, 1}, 0},
+		"stats":        {"memory|stats", 2, CMD_READONLY, noKeys, 0},
+		"doctor":       {"memory|doctor", 2, CMD_READONLY, noKeys, 0},
+		"malloc-stats": {"memory|malloc-stats", 2, CMD_READONLY, noKeys, 0},
+		"help":         {"memory|help", 2, CMD_READONLY, noKeys, 0},
+	},
+	SLOWLOG: {
+		"get":   {"slowlog|get", -2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"len":   {"slowlog|len", 2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"reset": {"slowlog|reset", 2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"help":  {"slowlog|help", 2, 0, noKeys, 0},
+	},
+	LATENCY: {
+		"latest":    {"latency|latest", 2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"history":   {"latency|history", 3, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"graph":     {"latency|graph", 3, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"doctor":    {"latency|doctor", 2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"reset":     {"latency|reset", -2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"histogram": {"latency|histogram", -2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, 0},
+		"help":      {"latency|help", 2, 0, noKeys, 0},
+	},
+	CLIENT: {
+		"id":           {"client|id", 2, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_CONNECTION},
+		"info":         {"client|info", 2, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_CONNECTION},
+		"list":         {"client|list", -2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_CONNECTION},
+		"kill":         {"client|kill", -3, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_CONNECTION},
+		"setname":      {"client|setname", 3, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_CONNECTION},
+		"getname":      {"client|getname", 2, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_CONNECTION},
+		"pause":        {"client|pause", -3, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_CONNECTION},
+		"unpause":      {"client|unpause", 2, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_CONNECTION},
+		"no-evict":     {"client|no-evict", 3, CMD_ADMIN | CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_CONNECTION},
+		"tracking":     {"client|tracking", -3, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_CONNECTION},
+		"caching":      {"client|caching", 3, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_CONNECTION},
+		"getredir":     {"client|getredir", 2, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_CONNECTION},
+		"trackinginfo": {"client|trackinginfo", 2, CMD_NO_SCRIPT, noKeys, ACL_CATEGORY_CONNECTION},
+		"help":         {"client|help", 2, 0, noKeys, ACL_CATEGORY_CONNECTION},
+	},
+}
+
+func (c commandSpec) hasFlag(flag int) bool {
+	return c.flags&flag != 0
+}
+
+func (c commandSpec) validArity(argCount int) bool {
+	// argCount excludes the command name itself
+	if c.arity < 0 {
+		return argCount+1 >= -c.arity
+	}
+	return argCount+1 == c.arity
+}
+
+// aclCategories returns the categories the command belongs to, adding those
+// implied by its flags as Redis does
+func (c commandSpec) aclCategories() int {
+	categories := c.categories
+
+	if c.hasFlag(CMD_WRITE) {
+		categories |= ACL_CATEGORY_WRITE
+	}
+
+	if c.hasFlag(CMD_READONLY) && categories&ACL_CATEGORY_SCRIPTING == 0 {
+		categories |= ACL_CATEGORY_READ
+	}
+
+	if c.hasFlag(CMD_ADMIN) {
+		categories |= ACL_CATEGORY_ADMIN | ACL_CATEGORY_DANGEROUS
+	}
+
+	if c.hasFlag(CMD_BLOCKING) {
+		categories |= ACL_CATEGORY_BLOCKING
+	}
+
+	if c.hasFlag(CMD_FAST) {
+		categories |= ACL_CATEGORY_FAST
+	} else {
+		categories |= ACL_CATEGORY_SLOW
+	}
+
+	return categories
+}
+
+// commandKeys returns the keys a command will access, for those whose keys can't
+// be found from their key spec alone
+func commandKeys(cmd string, spec commandSpec, args []string) []string {
+	switch cmd {
+	case XREAD:
+		for i, arg := range args {
+			if strings.ToLower(arg) == "streams" {
+				streams := args[i+1:]
+				return streams[:len(streams)/2]
+			}
+		}
+		return []string{}
+	case EVAL, EVALSHA, EVAL_RO, EVALSHA_RO, FCALL, FCALL_RO:
+		numKeys, err := strconv.Atoi(args[1])
+
+		if err != nil || numKeys < 0 || numKeys > len(args)-2 {
+			return []string{}
+		}
+		return args[2 : 2+numKeys]
+	}
+
+	keys := []string{}
+
+	if spec.keys.first == 0 {
+		return keys
+	}
+
+	last := spec.keys.last
+	if last < 0 {
+		last = len(args) + 1 + last
+	}
+
+	for i := spec.keys.first; i <= last && i <= len(args); i += spec.keys.step {
+		keys = append(keys, args[i-1])
+	}
+
+	return keys
+}
+
+// validateCommand performs the checks Redis makes before running or queueing a
+// command, returning the error to reply with if it can't be run at all
+func validateCommand(cmd string, args []string) (commandSpec, error) {
+	spec, ok := commandTable[cmd]
+
+	if !ok {
+		quoted := make([]string, 0, len(args))
+		for _, arg := range args {
+			quoted = append(quoted, fmt.Sprintf("'%s'", arg))
+		}
+		return spec, fmt.Errorf("ERR unknown command '%s', with args beginning with: %s", cmd, strings.Join(quoted, " "))
+	}
+
+	if !spec.validArity(len(args)) {
+		return spec, fmt.Errorf("ERR wrong number of arguments for '%s' command", cmd)
+	}
+
+	subcommands, ok := subcommandTable[cmd]
+
+	if !ok {
+		return spec, nil
+	}
+
+	subcommand, ok := subcommands[strings.ToLower(args[0])]
+
+	if !ok {
+		return spec, fmt.Errorf("ERR unknown subcommand '%s'. Try %s HELP.", args[0], strings.ToUpper(cmd))
+	}
+
+	if !subcommand.validArity(len(args)) {
+		return subcommand, fmt.Errorf("ERR wrong number of arguments for '%s' command", subcommand.name)
+	}
+
+	return subcommand, nil
+}
+
+// lookupCommand finds the spec for a command, or its subcommand where it has them
+func lookupCommand(cmd string, args []string) commandSpec {
+	if subcommands, ok := subcommandTable[cmd]; ok && len(args) > 0 {
+		if subcommand, ok := subcommands[strings.ToLower(args[0])]; ok {
+			return subcommand
+		}
+	}
+	return commandTable[cmd]
+}
+
+func isWriteCommand(cmd string, args []string) bool {
+	return lookupCommand(cmd, args).hasFlag(CMD_WRITE)
+}
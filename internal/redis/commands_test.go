@@ -0,0 +1,26 @@
+package redis
+
+import "testing"
+
+func Test_validateCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     string
+		args    []string
+		wantErr bool
+	}{
+		{"It should accept a command with fixed arity", GET, []string{"foo"}, false},
+		{"It should reject a command with too many args", GET, []string{"foo", "bar"}, true},
+		{"It should accept a command over its minimum arity", SET, []string{"foo", "bar", "PX", "100"}, false},
+		{"It should reject a command under its minimum arity", SET, []string{"foo"}, true},
+		{"It should reject an unknown command", "foo", []string{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validateCommand(tt.cmd, tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,34 +1,136 @@
 package redis
 
 import (
+	"codecrafters/internal/array"
 	"codecrafters/internal/serde"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 )
 
-func getConfigProperty(arg string, r Redis) serde.Value {
-	response := [2]serde.Value{}
+var configHelp = []string{
+	"CONFIG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GET <pattern>",
+	"    Return parameters matching the glob-like <pattern> and their values.",
+	"SET <directive> <value>",
+	"    Set the configuration <directive> to <value>.",
+	"RESETSTAT",
+	"    Reset statistics reported by the INFO command.",
+	"REWRITE",
+	"    Rewrite the configuration file.",
+	"HELP",
+	"    Print this help.",
+}
+
+func (r *Redis) configGet(patterns []string, connection RedisConnection) []serde.Value {
+	fields := []serde.Value{}
+	seen := map[string]bool{}
+
+	for _, pattern := range patterns {
+		for _, param := range matchConfigParams(pattern) {
+			if seen[param.name] {
+				continue
+			}
+			seen[param.name] = true
+
+			fields = append(fields, serde.NewBulkString(param.name), serde.NewBulkString(param.get(&r.configuration)))
+		}
+	}
+
+	if r.clients.protocol(connection.id) >= 3 {
+		return []serde.Value{serde.NewMap(fields)}
+	}
+	return []serde.Value{serde.NewArray(fields)}
+}
+
+// configSet changes every parameter given, or none of them should any be invalid
+// or fail to take effect
+func (r *Redis) configSet(args []string) []serde.Value {
+	if len(args)%2 != 0 {
+		return []serde.Value{serde.NewError("ERR wrong number of arguments for 'config|set' command")}
+	}
+
+	updated := r.configuration
+	changed := []configParam{}
+
+	for pairs := args; len(pairs) > 0; pairs = pairs[2:] {
+		name, value := pairs[0], pairs[1]
+		param, ok := lookupConfigParam(name)
 
-	switch v := strings.ToLower(arg); v {
-	case "dir":
-		response[0] = serde.NewBulkString(v)
-		response[1] = serde.NewBulkString(r.configuration.persistenceDir)
-	case "dbfilename":
-		response[0] = serde.NewBulkString(v)
-		response[1] = serde.NewBulkString(r.configuration.persistenceFileName)
+		if !ok {
+			return []serde.Value{serde.NewError(fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", name))}
+		}
+
+		if slices.ContainsFunc(changed, func(other configParam) bool { return other.name == param.name }) {
+			return []serde.Value{serde.NewError(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - duplicate parameter", name))}
+		}
+
+		if !param.mutable {
+			return []serde.Value{serde.NewError(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", name))}
+		}
+
+		if err := param.set(&updated, value); err != nil {
+			return []serde.Value{serde.NewError(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %s", name, err))}
+		}
+
+		changed = append(changed, param)
+	}
+
+	previous := r.configuration
+	r.configuration = updated
+
+	for _, param := range changed {
+		if param.apply == nil {
+			continue
+		}
+
+		if err := param.apply(r); err != nil {
+			// Put back what was there before, along with its effects
+			r.configuration = previous
+			for _, param := range changed {
+				if param.apply != nil {
+					param.apply(r)
+				}
+			}
+			return []serde.Value{serde.NewError(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %s", param.name, err))}
+		}
 	}
-	return serde.NewArray(response[:])
+
+	return []serde.Value{serde.Ok()}
 }
 
-func (r Redis) config(args []string) []serde.Value {
-	if len(args) < 2 {
-		return []serde.Value{serde.NewError("CONFIG requires at least two arguments")}
+func (r *Redis) configRewrite() error {
+	if r.configuration.configFile == "" {
+		return errors.New("ERR The server is running without a config file")
 	}
 
-	switch v := strings.ToLower(args[0]); v {
+	if err := rewriteConfigFile(r.configuration.configFile, &r.configuration); err != nil {
+		return fmt.Errorf("ERR Rewriting config file: %s", err)
+	}
+	return nil
+}
+
+func (r *Redis) config(args []string, connection RedisConnection) []serde.Value {
+	switch strings.ToLower(args[0]) {
 	case "get":
-		return []serde.Value{getConfigProperty(args[1], r)}
+		return r.configGet(args[1:], connection)
+	case "set":
+		return r.configSet(args[1:])
+	case "resetstat":
+		r.stats.reset()
+		r.store.ResetStats()
+		return []serde.Value{serde.Ok()}
+	case "rewrite":
+		if err := r.configRewrite(); err != nil {
+			return []serde.Value{serde.NewError(err.Error())}
+		}
+		return []serde.Value{serde.Ok()}
+	case "help":
+		return []serde.Value{serde.NewArray(array.Map(configHelp, func(line string) serde.Value {
+			return serde.NewSimpleString(line)
+		}))}
 	default:
-		return []serde.Value{serde.NewError(fmt.Sprintf("Do not recognise config command %s", args[1]))}
+		return []serde.Value{serde.NewError(fmt.Sprintf("ERR unknown subcommand '%s'. Try CONFIG HELP.", args[0]))}
 	}
 }
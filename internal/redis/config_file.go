@@ -0,0 +1,330 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// How deeply config files may include one another, which stops an include loop
+// from going on forever
+const MAX_CONFIG_INCLUDE_DEPTH = 16
+
+// configDirective is a line of configuration, as read from a file or the
+// command line
+type configDirective struct {
+	// Where the directive came from, for reporting errors
+	file string
+	line int
+	args []string
+}
+
+func (d configDirective) errorf(format string, a ...any) error {
+	return fmt.Errorf("%s:%d: %s", d.file, d.line, fmt.Sprintf(format, a...))
+}
+
+func hexDigit(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// splitConfigArgs splits a line into arguments the way Redis does. Arguments are
+// separated by spaces, and may be quoted to include them. Double quoted arguments
+// take escapes such as \n and \x41, while single quoted ones only take \'.
+func splitConfigArgs(line string) ([]string, error) {
+	args := []string{}
+	i := 0
+
+	for {
+		for i < len(line) && isConfigSpace(line[i]) {
+			i++
+		}
+
+		if i == len(line) {
+			return args, nil
+		}
+
+		arg := strings.Builder{}
+
+		switch line[i] {
+		case '"':
+			i++
+			for ; ; i++ {
+				if i == len(line) {
+					return nil, errors.New("unbalanced quotes")
+				}
+
+				c := line[i]
+
+				if c == '"' {
+					break
+				}
+
+				if c != '\\' || i+1 == len(line) {
+					arg.WriteByte(c)
+					continue
+				}
+
+				i++
+				switch line[i] {
+				case 'n':
+					arg.WriteByte('\n')
+				case 'r':
+					arg.WriteByte('\r')
+				case 't':
+					arg.WriteByte('\t')
+				case 'b':
+					arg.WriteByte('\b')
+				case 'a':
+					arg.WriteByte('\a')
+				case 'x':
+					if i+2 < len(line) {
+						high, okHigh := hexDigit(line[i+1])
+						low, okLow := hexDigit(line[i+2])
+
+						if okHigh && okLow {
+							arg.WriteByte(high<<4 | low)
+							i += 2
+							continue
+						}
+					}
+					arg.WriteByte('x')
+				default:
+					arg.WriteByte(line[i])
+				}
+			}
+			i++
+		case '\'':
+			i++
+			for ; ; i++ {
+				if i == len(line) {
+					return nil, errors.New("unbalanced quotes")
+				}
+
+				if line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					arg.WriteByte('\'')
+					i++
+					continue
+				}
+
+				if line[i] == '\'' {
+					break
+				}
+				arg.WriteByte(line[i])
+			}
+			i++
+		default:
+			for i < len(line) && !isConfigSpace(line[i]) {
+				arg.WriteByte(line[i])
+				i++
+			}
+			args = append(args, arg.String())
+			continue
+		}
+
+		// A closing quote must end the argument
+		if i < len(line) && !isConfigSpace(line[i]) {
+			return nil, errors.New("unbalanced quotes")
+		}
+
+		args = append(args, arg.String())
+	}
+}
+
+func isConfigSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// readConfigFile reads the directives in a config file, along with those of any
+// files it includes
+func readConfigFile(path string, depth int) ([]configDirective, error) {
+	if depth > MAX_CONFIG_INCLUDE_DEPTH {
+		return nil, fmt.Errorf("%s: too many nested includes", path)
+	}
+
+	contents, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	directives := []configDirective{}
+
+	for i, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		directive := configDirective{file: path, line: i + 1}
+		directive.args, err = splitConfigArgs(line)
+
+		if err != nil {
+			return nil, directive.errorf("%s", err)
+		}
+
+		if strings.ToLower(directive.args[0]) != "include" {
+			directives = append(directives, directive)
+			continue
+		}
+
+		if len(directive.args) != 2 {
+			return nil, directive.errorf("wrong number of arguments for include")
+		}
+
+		included, err := readConfigFile(directive.args[1], depth+1)
+
+		if err != nil {
+			return nil, err
+		}
+
+		directives = append(directives, included...)
+	}
+
+	return directives, nil
+}
+
+// parseCommandLine reads the optional config file given as the first argument,
+// followed by --option value overrides. Options take every argument up to the
+// next option, as in --save 900 1.
+func parseCommandLine(args []string) (string, []configDirective, error) {
+	configFile := ""
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		configFile, args = args[0], args[1:]
+	}
+
+	directives := []configDirective{}
+
+	for i, arg := range args {
+		if name, ok := strings.CutPrefix(arg, "--"); ok && name != "" {
+			directives = append(directives, configDirective{file: "command line", line: i + 1, args: []string{name}})
+			continue
+		}
+
+		if len(directives) == 0 {
+			return "", nil, fmt.Errorf("invalid argument %s, expected an option such as --port", arg)
+		}
+
+		last := &directives[len(directives)-1]
+		last.args = append(last.args, arg)
+	}
+
+	return configFile, directives, nil
+}
+
+// applyConfigDirectives sets each parameter in turn. Every argument after the
+// name makes up the value, which is how multi-argument directives like save 900 1
+// are given.
+func applyConfigDirectives(c *configurationOptions, directives []configDirective) error {
+	savesSeen := false
+
+	for _, directive := range directives {
+		name := strings.ToLower(directive.args[0])
+		args := directive.args[1:]
+
+		if name == "user" {
+			if len(args) < 1 {
+				return directive.errorf("wrong number of arguments for user")
+			}
+
+			c.users = append(c.users, args)
+			continue
+		}
+
+		param, ok := lookupConfigParam(name)
+
+		if !ok || (!param.multiArg && len(args) != 1) {
+			return directive.errorf("Bad directive or wrong number of arguments: '%s'", strings.Join(directive.args, " "))
+		}
+
+		value := strings.Join(args, " ")
+
+		// The first save directive replaces the default save points, with any more
+		// adding to it
+		if name == "save" && savesSeen && value != "" {
+			value = param.get(c) + " " + value
+		}
+		savesSeen = savesSeen || name == "save"
+
+		if err := param.set(c, value); err != nil {
+			return directive.errorf("%s: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: ./redis-server [/path/to/redis.conf] [options]")
+	fmt.Fprintln(os.Stderr, "Examples:")
+	fmt.Fprintln(os.Stderr, "       ./redis-server /etc/redis/6379.conf")
+	fmt.Fprintln(os.Stderr, "       ./redis-server --port 7777 --replicaof 127.0.0.1 8888")
+	fmt.Fprintln(os.Stderr, "       ./redis-server /etc/myredis.conf --save 60 1000")
+	fmt.Fprintln(os.Stderr, "Options:")
+
+	for _, param := range configParams {
+		fmt.Fprintf(os.Stderr, "  --%s\n    \t%s (default %s)\n", param.name, param.usage, strconv.Quote(param.defaultValue))
+	}
+}
+
+// ParseConfiguration reads the configuration from the command line arguments,
+// along with the config file they name
+func ParseConfiguration(args []string) (configurationOptions, error) {
+	opts := defaultConfiguration()
+
+	if len(args) == 1 {
+		switch args[0] {
+		case "-h", "--help":
+			printUsage()
+			os.Exit(0)
+		case "-v", "--version":
+			fmt.Printf("Redis server v=%s\n", REDIS_VERSION)
+			os.Exit(0)
+		}
+	}
+
+	configFile, overrides, err := parseCommandLine(args)
+
+	if err != nil {
+		return opts, err
+	}
+
+	directives := []configDirective{}
+
+	if configFile != "" {
+		// CONFIG REWRITE needs to find the file again should the directory change
+		if opts.configFile, err = filepath.Abs(configFile); err != nil {
+			return opts, err
+		}
+
+		if directives, err = readConfigFile(configFile, 0); err != nil {
+			return opts, err
+		}
+	}
+
+	if err := applyConfigDirectives(&opts, append(directives, overrides...)); err != nil {
+		return opts, err
+	}
+
+	if err := opts.tls.validate(); err != nil {
+		return opts, err
+	}
+
+	if len(opts.users) > 0 && opts.aclFile != "" {
+		return opts, errors.New("configuring users in the config file is not allowed when an ACL file is used")
+	}
+
+	return opts, nil
+}
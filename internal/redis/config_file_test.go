@@ -0,0 +1,207 @@
+package redis
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_splitConfigArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "It should split on spaces", line: "save  900\t1", want: []string{"save", "900", "1"}},
+		{name: "It should read double quotes", line: `requirepass "a b"`, want: []string{"requirepass", "a b"}},
+		{name: "It should read escapes", line: `x "\"\n\x41\\"`, want: []string{"x", "\"\nA\\"}},
+		{name: "It should read single quotes", line: `x 'it\'s "raw"\n'`, want: []string{"x", `it's "raw"\n`}},
+		{name: "It should read empty quotes", line: `save ""`, want: []string{"save", ""}},
+		{name: "It should reject unbalanced quotes", line: `x "open`, wantErr: true},
+		{name: "It should reject text straight after a quote", line: `x "a"b`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitConfigArgs(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitConfigArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitConfigArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_splitConfigArgsReadsQuotedValues(t *testing.T) {
+	for _, value := range []string{"", "plain", "with space", "say \"hi\"\n", "\x01#'\\"} {
+		got, err := splitConfigArgs("x " + quoteConfigValue(value))
+		if err != nil || len(got) != 2 || got[1] != value {
+			t.Errorf("splitConfigArgs(quoteConfigValue(%q)) = %q, %v", value, got, err)
+		}
+	}
+}
+
+func Test_parseMemory(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "100", want: 100},
+		{value: "100b", want: 100},
+		{value: "1k", want: 1000},
+		{value: "1kb", want: 1024},
+		{value: "2MB", want: 2 << 20},
+		{value: "1g", want: 1000 * 1000 * 1000},
+		{value: "1gb", want: 1 << 30},
+		{value: "1tb", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "gb", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseMemory(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseMemory(%s) = %d, %v, want %d, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func Test_parseCommandLine(t *testing.T) {
+	file, directives, err := parseCommandLine([]string{"redis.conf", "--port", "7000", "--save", "900", "1", "--save"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := [][]string{}
+	for _, directive := range directives {
+		args = append(args, directive.args)
+	}
+
+	if want := [][]string{{"port", "7000"}, {"save", "900", "1"}, {"save"}}; file != "redis.conf" || !reflect.DeepEqual(args, want) {
+		t.Errorf("parseCommandLine() = %s, %q, want redis.conf, %q", file, args, want)
+	}
+
+	if _, _, err := parseCommandLine([]string{"--port", "7000", "stray.conf"}); err != nil {
+		t.Errorf("parseCommandLine() should treat trailing arguments as values, got %v", err)
+	}
+
+	if _, _, err := parseCommandLine([]string{"-x"}); err == nil {
+		t.Error("parseCommandLine() should reject arguments before any option")
+	}
+}
+
+func writeConfigFile(t *testing.T, dir string, name string, lines ...string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_readConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	included := writeConfigFile(t, dir, "included.conf", "port 7001")
+	path := writeConfigFile(t, dir, "redis.conf",
+		"# A comment",
+		"",
+		"  save 900 1  ",
+		"include "+included,
+		`requirepass "a b"`,
+	)
+
+	directives, err := readConfigFile(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []configDirective{
+		{file: path, line: 3, args: []string{"save", "900", "1"}},
+		{file: included, line: 1, args: []string{"port", "7001"}},
+		{file: path, line: 5, args: []string{"requirepass", "a b"}},
+	}
+	if !reflect.DeepEqual(directives, want) {
+		t.Errorf("readConfigFile() = %+v, want %+v", directives, want)
+	}
+
+	loop := filepath.Join(dir, "loop.conf")
+	writeConfigFile(t, dir, "loop.conf", "include "+loop)
+	if _, err := readConfigFile(loop, 0); err == nil {
+		t.Error("readConfigFile() should stop an include loop")
+	}
+
+	broken := writeConfigFile(t, dir, "broken.conf", "port 1", `requirepass "open`)
+	if _, err := readConfigFile(broken, 0); err == nil || !strings.HasPrefix(err.Error(), broken+":2:") {
+		t.Errorf("readConfigFile() error = %v, want it to give the file and line", err)
+	}
+}
+
+func Test_applyConfigDirectives(t *testing.T) {
+	tests := []struct {
+		name       string
+		directives [][]string
+		wantSave   string
+		wantErr    string
+	}{
+		{name: "It should replace the default save points", directives: [][]string{{"save", "900", "1"}}, wantSave: "900 1"},
+		{name: "It should add further save points", directives: [][]string{{"save", "900", "1"}, {"save", "300", "10"}}, wantSave: "900 1 300 10"},
+		{name: "It should clear save points", directives: [][]string{{"save", "900", "1"}, {"save", ""}}, wantSave: ""},
+		{name: "It should keep the defaults without save", directives: [][]string{{"port", "7000"}}, wantSave: DEFAULT_SAVE_POINTS},
+		{name: "It should reject unknown directives", directives: [][]string{{"port", "7000"}, {"foo", "bar"}}, wantErr: "test.conf:2: Bad directive or wrong number of arguments: 'foo bar'"},
+		{name: "It should reject extra arguments", directives: [][]string{{"port", "7000", "7001"}}, wantErr: "test.conf:1: Bad directive or wrong number of arguments: 'port 7000 7001'"},
+		{name: "It should reject invalid values", directives: [][]string{{"port", "port"}}, wantErr: "test.conf:1: port: argument couldn't be parsed into an integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			directives := []configDirective{}
+			for i, args := range tt.directives {
+				directives = append(directives, configDirective{file: "test.conf", line: i + 1, args: args})
+			}
+
+			c := defaultConfiguration()
+			err := applyConfigDirectives(&c, directives)
+
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("applyConfigDirectives() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := formatSavePoints(c.savePoints); got != tt.wantSave {
+				t.Errorf("applyConfigDirectives() save = %q, want %q", got, tt.wantSave)
+			}
+		})
+	}
+}
+
+func Test_ParseConfiguration(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfigFile(t, dir, "redis.conf",
+		"port 7000",
+		"dbfilename from-file.rdb",
+		"user alice on >secret ~* +@all",
+	)
+
+	c, err := ParseConfiguration([]string{path, "--port", "7001", "--replicaof", "localhost", "6379"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.port != 7001 || c.persistenceFileName != "from-file.rdb" || c.configFile != path {
+		t.Errorf("ParseConfiguration() = %+v", c)
+	}
+
+	if master, ok := c.replicationConfig.replicaConfig.(slaveConfig); !ok || master.host != "localhost" || master.port != 6379 {
+		t.Errorf("ParseConfiguration() replicaof = %+v", c.replicationConfig.replicaConfig)
+	}
+
+	if want := [][]string{{"alice", "on", ">secret", "~*", "+@all"}}; !reflect.DeepEqual(c.users, want) {
+		t.Errorf("ParseConfiguration() users = %q, want %q", c.users, want)
+	}
+}
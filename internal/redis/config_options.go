@@ -2,11 +2,8 @@ package redis
 
 import (
 	"errors"
-	"flag"
 	"strconv"
 	"strings"
-
-	"github.com/dchest/uniuri"
 )
 
 const DEFAULT_PERSISTENCE_FILE_NAME string = "dump.rdb"
@@ -38,9 +35,7 @@ func (m masterConfig) Role() string {
 }
 
 type replicationConfig struct {
-	replicaConfig    replicaConfig
-	masterReplId     string
-	masterReplOffset int
+	replicaConfig replicaConfig
 }
 
 func parseReplicaString(replicaString string) (replicaConfig, error) {
@@ -67,48 +62,49 @@ func parseReplicaString(replicaString string) (replicaConfig, error) {
 	}, nil
 }
 
-func newReplicationConfig(replicaOf string) (replicationConfig, error) {
-
-	config := replicationConfig{
-		masterReplId:     uniuri.NewLen(40),
-		masterReplOffset: 0,
-	}
-
-	replicaConfig, err := parseReplicaString(replicaOf)
-
-	if err != nil {
-		return config, err
-	}
-
-	config.replicaConfig = replicaConfig
-
-	return config, nil
-}
-
 type configurationOptions struct {
 	persistenceFileName string
 	persistenceDir      string
 	port                int
 	replicationConfig   replicationConfig
-}
-
-func ParseConfigurationFromFlags() (configurationOptions, error) {
-	opts := configurationOptions{}
-
-	replicaOf := ""
-
-	flag.StringVar(&opts.persistenceFileName, "dbfilename", DEFAULT_PERSISTENCE_FILE_NAME, "File name to store persisted data in")
-	flag.StringVar(&opts.persistenceDir, "dir", DEFAULT_PERSISTENCE_DIR, "Directory to store the persisted data in")
-	flag.IntVar(&opts.port, "port", DEFAULT_PORT, "Port to listen on for connections")
-	flag.StringVar(&replicaOf, "replicaof", "", "Host and port to replicate from")
-	flag.Parse()
-
-	replicationConfig, err := newReplicationConfig(replicaOf)
-
-	if err != nil {
-		return opts, err
-	}
-
-	opts.replicationConfig = replicationConfig
-	return opts, nil
+	// How long a script may run before other clients are answered with BUSY
+	busyReplyThresholdMs int
+	// Sets a password for the default user
+	requirePass string
+	aclFile     string
+	// Credentials a replica authenticates to its master with
+	masterAuth string
+	masterUser string
+	tls        tlsOptions
+	unixSocket unixSocketOptions
+	pidFile    string
+	// How long SHUTDOWN waits for replicas to catch up
+	shutdownTimeoutSeconds int
+	// When to save the dataset, unless empty
+	savePoints []savePoint
+	// The file the configuration was read from, which CONFIG REWRITE updates
+	configFile string
+	// ACL rules for the users given by user directives
+	users [][]string
+	// Limits the memory used by the dataset, evicting keys by the policy once
+	// reached
+	maxMemory        int64
+	maxMemoryPolicy  string
+	maxMemorySamples int
+	lfuLogFactor     int
+	lfuDecayTime     int
+	// Commands taking at least this many microseconds are kept in the slow log
+	slowlogLogSlowerThan int
+	slowlogMaxLen        int
+	// Events taking at least this long are sampled by the latency monitor
+	latencyMonitorThresholdMs int
+	// Where Prometheus metrics are served over HTTP, with port 0 leaving them off
+	metricsPort int
+	metricsBind string
+	// Bytes of the replication stream kept for replicas that reconnect
+	replBacklogSize int64
+	// Seconds either end of a replication link may go quiet before the other gives
+	// up on it, and how often a master pings its replicas so that they don't
+	replTimeoutSeconds           int
+	replPingReplicaPeriodSeconds int
 }
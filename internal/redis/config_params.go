@@ -0,0 +1,351 @@
+package redis
+
+import (
+	"codecrafters/internal/glob"
+	"codecrafters/internal/kvstore"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configParam is a server option that can be given on the command line, read
+// with CONFIG GET and, when mutable, changed with CONFIG SET
+type configParam struct {
+	name string
+	// An older name the parameter also goes by, such as slaveof for replicaof
+	alias        string
+	usage        string
+	defaultValue string
+	// get formats the value the way CONFIG GET shows it and CONFIG REWRITE writes
+	// it
+	get func(c *configurationOptions) string
+	// set validates the value before storing it
+	set func(c *configurationOptions, value string) error
+	// Whether CONFIG SET can change the value while running
+	mutable bool
+	// apply puts a value changed by CONFIG SET into effect
+	apply func(r *Redis) error
+	// Whether the value is written out as separate arguments, as in save 900 1,
+	// rather than a single quoted string
+	multiArg bool
+}
+
+func (p configParam) withAlias(alias string) configParam {
+	p.alias = alias
+	return p
+}
+
+// settable lets CONFIG SET change the parameter, calling apply if given once it
+// has
+func (p configParam) settable(apply func(r *Redis) error) configParam {
+	p.mutable = true
+	p.apply = apply
+	return p
+}
+
+// validated checks values with check before they're stored
+func (p configParam) validated(check func(value string) error) configParam {
+	set := p.set
+	p.set = func(c *configurationOptions, value string) error {
+		if err := check(value); err != nil {
+			return err
+		}
+		return set(c, value)
+	}
+	return p
+}
+
+func stringConfig(name string, usage string, defaultValue string, field func(c *configurationOptions) *string) configParam {
+	return configParam{
+		name:         name,
+		usage:        usage,
+		defaultValue: defaultValue,
+		get:          func(c *configurationOptions) string { return *field(c) },
+		set: func(c *configurationOptions, value string) error {
+			*field(c) = value
+			return nil
+		},
+	}
+}
+
+func intConfig(name string, usage string, defaultValue int, min int, max int, field func(c *configurationOptions) *int) configParam {
+	return configParam{
+		name:         name,
+		usage:        usage,
+		defaultValue: strconv.Itoa(defaultValue),
+		get:          func(c *configurationOptions) string { return strconv.Itoa(*field(c)) },
+		set: func(c *configurationOptions, value string) error {
+			n, err := strconv.Atoi(value)
+
+			if err != nil {
+				return errors.New("argument couldn't be parsed into an integer")
+			}
+
+			if n < min || n > max {
+				return fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+			}
+
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+// parseMemory reads a number of bytes, which may be given in units such as 1gb.
+// As in Redis k, m and g are powers of 1000, while kb, mb and gb are powers of
+// 1024.
+func parseMemory(value string) (int64, error) {
+	lower := strings.ToLower(value)
+	digits := strings.TrimRight(lower, "bkmg")
+	multiplier := int64(1)
+
+	switch lower[len(digits):] {
+	case "", "b":
+	case "k":
+		multiplier = 1000
+	case "kb":
+		multiplier = 1 << 10
+	case "m":
+		multiplier = 1000 * 1000
+	case "mb":
+		multiplier = 1 << 20
+	case "g":
+		multiplier = 1000 * 1000 * 1000
+	case "gb":
+		multiplier = 1 << 30
+	default:
+		return 0, errors.New("argument must be a memory value")
+	}
+
+	n, err := strconv.ParseInt(digits, 10, 64)
+
+	if err != nil || n < 0 || n > math.MaxInt64/multiplier {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return n * multiplier, nil
+}
+
+func memoryConfig(name string, usage string, defaultValue int64, field func(c *configurationOptions) *int64) configParam {
+	return configParam{
+		name:         name,
+		usage:        usage,
+		defaultValue: strconv.FormatInt(defaultValue, 10),
+		get:          func(c *configurationOptions) string { return strconv.FormatInt(*field(c), 10) },
+		set: func(c *configurationOptions, value string) error {
+			n, err := parseMemory(value)
+
+			if err != nil {
+				return err
+			}
+
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+func yesNoConfig(name string, usage string, defaultValue bool, field func(c *configurationOptions) *bool) configParam {
+	return configParam{
+		name:         name,
+		usage:        usage,
+		defaultValue: formatYesNo(defaultValue),
+		get:          func(c *configurationOptions) string { return formatYesNo(*field(c)) },
+		set: func(c *configurationOptions, value string) error {
+			yes, err := parseYesNo(name, value)
+
+			if err != nil {
+				return errors.New("argument must be 'yes' or 'no'")
+			}
+
+			*field(c) = yes
+			return nil
+		},
+	}
+}
+
+func enumConfig(name string, usage string, defaultValue string, values []string, field func(c *configurationOptions) *string) configParam {
+	return configParam{
+		name:         name,
+		usage:        usage,
+		defaultValue: defaultValue,
+		get:          func(c *configurationOptions) string { return *field(c) },
+		set: func(c *configurationOptions, value string) error {
+			value = strings.ToLower(value)
+
+			if !slices.Contains(values, value) {
+				return fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(values, ", "))
+			}
+
+			*field(c) = value
+			return nil
+		},
+	}
+}
+
+func formatYesNo(yes bool) string {
+	if yes {
+		return "yes"
+	}
+	return "no"
+}
+
+var configParams = []configParam{
+	stringConfig("dbfilename", "File name to store persisted data in", DEFAULT_PERSISTENCE_FILE_NAME, func(c *configurationOptions) *string { return &c.persistenceFileName }).validated(func(value string) error {
+		if strings.ContainsRune(value, os.PathSeparator) {
+			return errors.New("dbfilename can't be a path, just a filename")
+		}
+		return nil
+	}).settable(nil),
+	stringConfig("dir", "Directory to store the persisted data in", DEFAULT_PERSISTENCE_DIR, func(c *configurationOptions) *string { return &c.persistenceDir }).validated(func(value string) error {
+		if info, err := os.Stat(value); err != nil || !info.IsDir() {
+			return errors.New("No such file or directory")
+		}
+		return nil
+	}).settable(nil),
+	intConfig("port", "Port to listen on for connections, or 0 to only accept TLS or Unix socket clients", DEFAULT_PORT, 0, 65535, func(c *configurationOptions) *int { return &c.port }),
+	intConfig("tls-port", "Port to listen on for TLS connections", 0, 0, 65535, func(c *configurationOptions) *int { return &c.tls.port }),
+	stringConfig("tls-cert-file", "Certificate presented to clients and masters", "", func(c *configurationOptions) *string { return &c.tls.certFile }),
+	stringConfig("tls-key-file", "Private key for the certificate", "", func(c *configurationOptions) *string { return &c.tls.keyFile }),
+	stringConfig("tls-ca-cert-file", "CA certificates used to verify clients and masters", "", func(c *configurationOptions) *string { return &c.tls.caCertFile }),
+	enumConfig("tls-auth-clients", "Whether TLS clients must present a certificate: yes, no or optional", TLS_AUTH_CLIENTS_YES, []string{TLS_AUTH_CLIENTS_YES, TLS_AUTH_CLIENTS_NO, TLS_AUTH_CLIENTS_OPTIONAL}, func(c *configurationOptions) *string { return &c.tls.authClients }),
+	yesNoConfig("tls-replication", "Whether to connect to the master over TLS", false, func(c *configurationOptions) *bool { return &c.tls.replication }),
+	stringConfig("unixsocket", "Path of a Unix socket to listen on for connections", "", func(c *configurationOptions) *string { return &c.unixSocket.path }),
+	{
+		name:         "unixsocketperm",
+		usage:        "Octal permissions for the Unix socket file",
+		defaultValue: "0",
+		get:          func(c *configurationOptions) string { return strconv.FormatUint(uint64(c.unixSocket.perm), 8) },
+		set: func(c *configurationOptions, value string) (err error) {
+			c.unixSocket.perm, err = parseUnixSocketPerm(value)
+			return err
+		},
+	},
+	stringConfig("pidfile", "File to write the process ID to, removed on shutdown", "", func(c *configurationOptions) *string { return &c.pidFile }),
+	intConfig("shutdown-timeout", "Seconds to wait for replicas to catch up when shutting down", DEFAULT_SHUTDOWN_TIMEOUT_SECONDS, 0, 1<<31-1, func(c *configurationOptions) *int { return &c.shutdownTimeoutSeconds }).settable(nil),
+	{
+		name:         "replicaof",
+		alias:        "slaveof",
+		usage:        "Host and port to replicate from",
+		defaultValue: "",
+		get: func(c *configurationOptions) string {
+			if master, ok := c.replicationConfig.replicaConfig.(slaveConfig); ok {
+				return fmt.Sprintf("%s %d", master.host, master.port)
+			}
+			return ""
+		},
+		set: func(c *configurationOptions, value string) (err error) {
+			c.replicationConfig.replicaConfig, err = parseReplicaString(value)
+			return err
+		},
+		multiArg: true,
+	},
+	memoryConfig("repl-backlog-size", "Bytes of the replication stream kept so that replicas can reconnect without a full sync", DEFAULT_REPL_BACKLOG_SIZE, func(c *configurationOptions) *int64 { return &c.replBacklogSize }).settable(func(r *Redis) error {
+		r.replication.backlog = r.replication.backlog.resized(int(max(r.configuration.replBacklogSize, REPL_BACKLOG_MIN_SIZE)))
+		return nil
+	}),
+	intConfig("repl-timeout", "Seconds a master or replica may go without hearing from the other before dropping the link", DEFAULT_REPL_TIMEOUT_SECONDS, 1, 1<<31-1, func(c *configurationOptions) *int { return &c.replTimeoutSeconds }).settable(nil),
+	intConfig("repl-ping-replica-period", "Seconds between the pings a master sends its replicas", DEFAULT_REPL_PING_REPLICA_PERIOD_SECONDS, 1, 1<<31-1, func(c *configurationOptions) *int { return &c.replPingReplicaPeriodSeconds }).withAlias("repl-ping-slave-period").settable(nil),
+	stringConfig("requirepass", "Password clients must AUTH with as the default user", "", func(c *configurationOptions) *string { return &c.requirePass }).settable(func(r *Redis) error {
+		if r.configuration.requirePass == "" {
+			return r.acl.setUser(DEFAULT_USER, []string{"resetpass", "nopass"})
+		}
+		return r.acl.setUser(DEFAULT_USER, []string{"resetpass", ">" + r.configuration.requirePass})
+	}),
+	stringConfig("aclfile", "File to load ACL users from", "", func(c *configurationOptions) *string { return &c.aclFile }),
+	stringConfig("masterauth", "Password to authenticate to the master with", "", func(c *configurationOptions) *string { return &c.masterAuth }).settable(nil),
+	stringConfig("masteruser", "User to authenticate to the master as", "", func(c *configurationOptions) *string { return &c.masterUser }).settable(nil),
+	intConfig("busy-reply-threshold", "Milliseconds a script may run before other clients get a BUSY error", DEFAULT_BUSY_REPLY_THRESHOLD_MS, 0, 1<<31-1, func(c *configurationOptions) *int { return &c.busyReplyThresholdMs }).withAlias("lua-time-limit").settable(func(r *Redis) error {
+		r.scripting.setBusyThreshold(time.Duration(r.configuration.busyReplyThresholdMs) * time.Millisecond)
+		return nil
+	}),
+	memoryConfig("maxmemory", "Most memory the dataset may use, such as 100mb, or 0 for no limit", 0, func(c *configurationOptions) *int64 { return &c.maxMemory }).settable(applyEvictionConfig),
+	enumConfig("maxmemory-policy", "How keys are chosen for eviction once maxmemory is reached", kvstore.EVICTION_NOEVICTION, kvstore.EvictionPolicies, func(c *configurationOptions) *string { return &c.maxMemoryPolicy }).settable(applyEvictionConfig),
+	intConfig("maxmemory-samples", "Keys sampled to find each key to evict, more being more accurate but slower", 5, 1, 64, func(c *configurationOptions) *int { return &c.maxMemorySamples }).settable(applyEvictionConfig),
+	intConfig("lfu-log-factor", "How many accesses it takes to saturate the LFU counter", 10, 0, 1<<31-1, func(c *configurationOptions) *int { return &c.lfuLogFactor }).settable(applyEvictionConfig),
+	intConfig("lfu-decay-time", "Minutes after which the LFU counter of an unused key is decremented", 1, 0, 1<<31-1, func(c *configurationOptions) *int { return &c.lfuDecayTime }).settable(applyEvictionConfig),
+	intConfig("slowlog-log-slower-than", "Microseconds a command must take to be logged in the slow log, 0 logging every command and -1 none", DEFAULT_SLOWLOG_LOG_SLOWER_THAN, -1, 1<<31-1, func(c *configurationOptions) *int { return &c.slowlogLogSlowerThan }).settable(applySlowlogConfig),
+	intConfig("slowlog-max-len", "Most commands kept in the slow log", DEFAULT_SLOWLOG_MAX_LEN, 0, 1<<31-1, func(c *configurationOptions) *int { return &c.slowlogMaxLen }).settable(applySlowlogConfig),
+	intConfig("latency-monitor-threshold", "Milliseconds an event must take to be sampled by the latency monitor, or 0 to disable it", 0, 0, 1<<31-1, func(c *configurationOptions) *int { return &c.latencyMonitorThresholdMs }).settable(func(r *Redis) error {
+		r.latency.setThreshold(time.Duration(r.configuration.latencyMonitorThresholdMs) * time.Millisecond)
+		return nil
+	}),
+	intConfig("metrics-port", "Port to serve Prometheus metrics on over HTTP at /metrics, or 0 to not serve them", 0, 0, 65535, func(c *configurationOptions) *int { return &c.metricsPort }),
+	stringConfig("metrics-bind", "Address to serve Prometheus metrics on, or empty for every address", "", func(c *configurationOptions) *string { return &c.metricsBind }),
+	{
+		name:         "save",
+		usage:        "Save the dataset after the given seconds once there have been as many changes, as in \"3600 1 300 100\"",
+		defaultValue: DEFAULT_SAVE_POINTS,
+		get:          func(c *configurationOptions) string { return formatSavePoints(c.savePoints) },
+		set: func(c *configurationOptions, value string) (err error) {
+			c.savePoints, err = parseSavePoints(value)
+			return err
+		},
+		mutable:  true,
+		multiArg: true,
+	},
+}
+
+// applyEvictionConfig hands the maxmemory settings to the store
+func applyEvictionConfig(r *Redis) error {
+	r.store.SetEvictionConfig(kvstore.EvictionConfig{
+		MaxMemory:    r.configuration.maxMemory,
+		Policy:       r.configuration.maxMemoryPolicy,
+		Samples:      r.configuration.maxMemorySamples,
+		LFULogFactor: r.configuration.lfuLogFactor,
+		LFUDecayTime: r.configuration.lfuDecayTime,
+	})
+	return nil
+}
+
+func applySlowlogConfig(r *Redis) error {
+	r.slowlog.configure(r.configuration.slowlogLogSlowerThan, r.configuration.slowlogMaxLen)
+	return nil
+}
+
+// lookupConfigParam finds a parameter by its name or alias, ignoring case
+func lookupConfigParam(name string) (configParam, bool) {
+	name = strings.ToLower(name)
+
+	for _, param := range configParams {
+		if param.name == name || (param.alias != "" && param.alias == name) {
+			return param, true
+		}
+	}
+	return configParam{}, false
+}
+
+// matchConfigParams returns the names matching the glob pattern. Aliases are only
+// matched by name, so that CONFIG GET * lists each parameter once.
+func matchConfigParams(pattern string) []configParam {
+	pattern = strings.ToLower(pattern)
+	matched := []configParam{}
+
+	for _, param := range configParams {
+		switch {
+		case glob.Match(pattern, param.name):
+			matched = append(matched, param)
+		case param.alias == pattern:
+			alias := param
+			alias.name = param.alias
+			matched = append(matched, alias)
+		}
+	}
+	return matched
+}
+
+// defaultConfiguration holds each parameter's default value
+func defaultConfiguration() configurationOptions {
+	opts := configurationOptions{}
+
+	for _, param := range configParams {
+		if err := param.set(&opts, param.defaultValue); err != nil {
+			panic(fmt.Sprintf("invalid default for %s: %v", param.name, err))
+		}
+	}
+	return opts
+}
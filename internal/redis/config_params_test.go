@@ -0,0 +1,127 @@
+package redis
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_configParamSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		param   string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "It should set integers", param: "shutdown-timeout", value: "30", want: "30"},
+		{name: "It should reject integers that don't parse", param: "shutdown-timeout", value: "soon", wantErr: true},
+		{name: "It should reject integers out of range", param: "port", value: "65536", wantErr: true},
+		{name: "It should set yes or no", param: "tls-replication", value: "YES", want: "yes"},
+		{name: "It should reject anything but yes or no", param: "tls-replication", value: "maybe", wantErr: true},
+		{name: "It should set enums", param: "tls-auth-clients", value: "Optional", want: "optional"},
+		{name: "It should reject values outside an enum", param: "tls-auth-clients", value: "sometimes", wantErr: true},
+		{name: "It should set through an alias", param: "lua-time-limit", value: "100", want: "100"},
+		{name: "It should set save points", param: "save", value: "900 1 300 10", want: "900 1 300 10"},
+		{name: "It should turn save points off", param: "save", value: "", want: ""},
+		{name: "It should reject unpaired save points", param: "save", value: "900", wantErr: true},
+		{name: "It should set the master", param: "replicaof", value: "localhost 6380", want: "localhost 6380"},
+		{name: "It should format the socket permissions in octal", param: "unixsocketperm", value: "700", want: "700"},
+		{name: "It should reject a dbfilename with a path", param: "dbfilename", value: "dir/dump.rdb", wantErr: true},
+		{name: "It should reject a dir that doesn't exist", param: "dir", value: "/does/not/exist", wantErr: true},
+		{name: "It should set memory in units", param: "maxmemory", value: "1mb", want: "1048576"},
+		{name: "It should set the eviction policy", param: "maxmemory-policy", value: "ALLKEYS-LFU", want: "allkeys-lfu"},
+		{name: "It should reject unknown eviction policies", param: "maxmemory-policy", value: "allkeys-fifo", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			param, ok := lookupConfigParam(tt.param)
+			if !ok {
+				t.Fatalf("lookupConfigParam(%s) not found", tt.param)
+			}
+
+			c := defaultConfiguration()
+			err := param.set(&c, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := param.get(&c); got != tt.want {
+				t.Errorf("get() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_defaultConfiguration(t *testing.T) {
+	c := defaultConfiguration()
+
+	if c.port != DEFAULT_PORT || c.persistenceFileName != DEFAULT_PERSISTENCE_FILE_NAME || c.replicationConfig.replicaConfig.Role() != MASTER {
+		t.Errorf("defaultConfiguration() = %+v", c)
+	}
+
+	if len(c.savePoints) != 0 {
+		t.Errorf("defaultConfiguration() save points = %v, want none", c.savePoints)
+	}
+}
+
+func Test_matchConfigParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		want    []string
+	}{
+		{name: "It should match exactly", pattern: "port", want: []string{"port"}},
+		{name: "It should ignore case", pattern: "PORT", want: []string{"port"}},
+		{name: "It should match globs", pattern: "tls-*-file", want: []string{"tls-cert-file", "tls-key-file", "tls-ca-cert-file"}},
+		{name: "It should match aliases by name", pattern: "slaveof", want: []string{"slaveof"}},
+		{name: "It should leave aliases out of globs", pattern: "*of", want: []string{"replicaof"}},
+		{name: "It should match nothing", pattern: "nothing", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, param := range matchConfigParams(tt.pattern) {
+				got = append(got, param.name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchConfigParams() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_persistenceStateDue(t *testing.T) {
+	start := time.Now()
+	points := []savePoint{{60, 1}, {10, 100}}
+
+	tests := []struct {
+		name    string
+		dirty   int64
+		elapsed time.Duration
+		failed  bool
+		want    bool
+	}{
+		{name: "It should wait for changes", dirty: 0, elapsed: time.Hour, want: false},
+		{name: "It should wait for time to pass", dirty: 1, elapsed: 30 * time.Second, want: false},
+		{name: "It should save once a point is reached", dirty: 1, elapsed: time.Minute, want: true},
+		{name: "It should save sooner after many changes", dirty: 100, elapsed: 10 * time.Second, want: true},
+		{name: "It should hold off after a failed save", dirty: 100, elapsed: time.Minute, failed: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPersistenceState()
+			p.lastSave = start
+			p.dirty = tt.dirty
+			if tt.failed {
+				p.lastFailed = true
+				p.lastAttempt = start.Add(tt.elapsed)
+			}
+			if got := p.due(points, start.Add(tt.elapsed)); got != tt.want {
+				t.Errorf("due() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
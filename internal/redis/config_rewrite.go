@@ -0,0 +1,174 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const CONFIG_REWRITE_SIGNATURE = "# Generated by CONFIG REWRITE"
+
+// quoteConfigValue quotes a value for the config file should it hold anything
+// that wouldn't otherwise be read back as a single argument, escaping it the way
+// Redis does
+func quoteConfigValue(value string) string {
+	plain := value != "" && !strings.ContainsFunc(value, func(c rune) bool {
+		return c <= ' ' || c == 0x7f || strings.ContainsRune("\"'\\#", c)
+	})
+
+	if plain {
+		return value
+	}
+	return reprString(value)
+}
+
+// reprString quotes a value, escaping anything that isn't printable the way
+// Redis does
+func reprString(value string) string {
+	quoted := strings.Builder{}
+	quoted.WriteByte('"')
+
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\', '"':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(c)
+		case '\n':
+			quoted.WriteString("\\n")
+		case '\r':
+			quoted.WriteString("\\r")
+		case '\t':
+			quoted.WriteString("\\t")
+		case '\a':
+			quoted.WriteString("\\a")
+		case '\b':
+			quoted.WriteString("\\b")
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&quoted, "\\x%02x", c)
+			} else {
+				quoted.WriteByte(c)
+			}
+		}
+	}
+
+	quoted.WriteByte('"')
+	return quoted.String()
+}
+
+func formatConfigLine(param configParam, c *configurationOptions) string {
+	value := param.get(c)
+
+	if param.multiArg && value != "" {
+		return param.name + " " + value
+	}
+	return param.name + " " + quoteConfigValue(value)
+}
+
+// rewriteConfigLines updates each directive in the file to its current value,
+// keeping comments and anything it doesn't know about where they were. Values
+// that differ from the default but aren't in the file yet are added at the end,
+// unless they're set by an included file, which we don't rewrite.
+func rewriteConfigLines(lines []string, c *configurationOptions, included map[string]bool) []string {
+	rewritten := []string{}
+	written := map[string]bool{}
+	signed := false
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		if trimmed == CONFIG_REWRITE_SIGNATURE {
+			signed = true
+		}
+
+		fields := strings.Fields(trimmed)
+
+		if len(fields) == 0 || strings.HasPrefix(trimmed, "#") {
+			rewritten = append(rewritten, line)
+			continue
+		}
+
+		param, ok := lookupConfigParam(fields[0])
+
+		if !ok {
+			rewritten = append(rewritten, line)
+			continue
+		}
+
+		// Directives such as save may be given many times, but once is enough now
+		if written[param.name] {
+			continue
+		}
+
+		written[param.name] = true
+		rewritten = append(rewritten, formatConfigLine(param, c))
+	}
+
+	for _, param := range configParams {
+		if written[param.name] || included[param.name] || param.get(c) == param.defaultValue {
+			continue
+		}
+
+		if !signed {
+			rewritten = append(rewritten, CONFIG_REWRITE_SIGNATURE)
+			signed = true
+		}
+
+		rewritten = append(rewritten, formatConfigLine(param, c))
+	}
+
+	return rewritten
+}
+
+// includedConfigParams returns the names of the parameters set by the files the
+// config file includes
+func includedConfigParams(lines []string) (map[string]bool, error) {
+	included := map[string]bool{}
+
+	for _, line := range lines {
+		args, err := splitConfigArgs(strings.TrimSpace(line))
+
+		if err != nil || len(args) != 2 || strings.ToLower(args[0]) != "include" {
+			continue
+		}
+
+		directives, err := readConfigFile(args[1], 1)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, directive := range directives {
+			if param, ok := lookupConfigParam(directive.args[0]); ok {
+				included[param.name] = true
+			}
+		}
+	}
+
+	return included, nil
+}
+
+func rewriteConfigFile(path string, c *configurationOptions) error {
+	contents, err := os.ReadFile(path)
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+
+	if len(contents) == 0 {
+		lines = []string{}
+	}
+
+	included, err := includedConfigParams(lines)
+
+	if err != nil {
+		return err
+	}
+
+	rewritten := strings.Join(rewriteConfigLines(lines, c, included), "\n") + "\n"
+	return writeFileAtomically(filepath.Dir(path), path, []byte(rewritten))
+}
@@ -0,0 +1,73 @@
+package redis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_quoteConfigValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "plain", want: "plain"},
+		{value: "", want: `""`},
+		{value: "with space", want: `"with space"`},
+		{value: "say \"hi\"\n", want: `"say \"hi\"\n"`},
+		{value: "\x01", want: `"\x01"`},
+	}
+	for _, tt := range tests {
+		if got := quoteConfigValue(tt.value); got != tt.want {
+			t.Errorf("quoteConfigValue(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func Test_rewriteConfigLines(t *testing.T) {
+	c := defaultConfiguration()
+	c.port = 7000
+	c.requirePass = "secret word"
+	c.savePoints = []savePoint{{900, 1}}
+	c.maxMemory = 1 << 20
+
+	lines := []string{
+		"# Where to listen",
+		"port 6379",
+		"",
+		"save 3600 1",
+		"save 300 100",
+		"user alice on >password ~* +@all",
+		"include memory.conf",
+	}
+
+	want := []string{
+		"# Where to listen",
+		"port 7000",
+		"",
+		"save 900 1",
+		"user alice on >password ~* +@all",
+		"include memory.conf",
+		CONFIG_REWRITE_SIGNATURE,
+		`requirepass "secret word"`,
+	}
+
+	// maxmemory comes from the included file, so isn't added to this one
+	if got := rewriteConfigLines(lines, &c, map[string]bool{"maxmemory": true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("rewriteConfigLines() = %q, want %q", got, want)
+	}
+}
+
+func Test_includedConfigParams(t *testing.T) {
+	dir := t.TempDir()
+	nested := writeConfigFile(t, dir, "nested.conf", "maxmemory 1mb")
+	included := writeConfigFile(t, dir, "included.conf", "port 7001\nuser alice on\ninclude "+nested)
+
+	got, err := includedConfigParams([]string{"port 7000", "include " + included})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[string]bool{"port": true, "maxmemory": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("includedConfigParams() = %v, want %v", got, want)
+	}
+}
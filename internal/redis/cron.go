@@ -0,0 +1,60 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"context"
+	"time"
+)
+
+// How many times a second the server does its background tasks
+const SERVER_HZ = 10
+
+// How much of each tick the active expire cycle may take, as a percentage
+const ACTIVE_EXPIRE_CYCLE_TIME_PERC = 25
+
+// serverCron runs background tasks, such as sampling stats and saving the
+// dataset, until the server shuts down
+func (r *Redis) serverCron() {
+	ticker := time.NewTicker(time.Second / SERVER_HZ)
+	defer ticker.Stop()
+
+	for ticks := 0; ; ticks++ {
+		select {
+		case <-r.shutdown.done:
+			return
+		case now := <-ticker.C:
+			r.stats.sample(now)
+			r.activeExpireCycle()
+
+			// The rest only needs doing once a second
+			if ticks%SERVER_HZ != 0 {
+				continue
+			}
+
+			r.stats.memoryUsed()
+			r.saveIfDue(now)
+			r.replicationCron(ticks / SERVER_HZ)
+		}
+	}
+}
+
+// activeExpireCycle removes expired keys nobody has read, deleting them on
+// replicas and invalidating them for tracking clients. Replicas leave their
+// dataset for the master to change, as with eviction.
+func (r *Redis) activeExpireCycle() {
+	r.executionMutex.Lock()
+	defer r.executionMutex.Unlock()
+
+	if r.configuration.replicationConfig.replicaConfig.Role() == SLAVE {
+		return
+	}
+
+	start := time.Now()
+	expired := r.store.ActiveExpireCycle(context.Background(), time.Second/SERVER_HZ*ACTIVE_EXPIRE_CYCLE_TIME_PERC/100)
+	r.latency.addSampleIfNeeded(LATENCY_EVENT_EXPIRE_CYCLE, time.Since(start))
+
+	for _, key := range expired {
+		r.propagate(serde.NewArray([]serde.Value{serde.NewBulkString("DEL"), serde.NewBulkString(key)}))
+	}
+	r.invalidateTrackedKeys(0)
+}
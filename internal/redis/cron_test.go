@@ -0,0 +1,52 @@
+package redis
+
+import (
+	"bytes"
+	"codecrafters/internal/kvstore"
+	"codecrafters/internal/serde"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestRedis_activeExpireCycle(t *testing.T) {
+	r := &Redis{
+		store:          kvstore.NewKVStore(),
+		configuration:  configurationOptions{replicationConfig: replicationConfig{replicaConfig: masterConfig{}}},
+		executionMutex: &sync.Mutex{},
+		clients:        newClientRegistry(),
+		tracking:       newTrackingState(),
+		latency:        newLatencyMonitor(),
+		replication:    newReplicationState(REPL_BACKLOG_MIN_SIZE, true),
+	}
+
+	expired := uint64(1)
+
+	for i := range 5 {
+		r.store.SetKeyWithExpiresAt(fmt.Sprintf("key:%d", i), "value", &expired)
+	}
+	r.store.SetKeyWithExpiresAt("eternal", "value", nil)
+
+	r.activeExpireCycle()
+
+	stream, _ := r.replication.backlog.since(r.replication.backlog.start())
+
+	for i := range 5 {
+		key := fmt.Sprintf("key:%d", i)
+
+		if _, ok := r.store.ReadKey(context.Background(), key); ok {
+			t.Errorf("Expected %s to have been removed", key)
+		}
+
+		del := serde.NewArray([]serde.Value{serde.NewBulkString("DEL"), serde.NewBulkString(key)}).Marshal()
+
+		if !bytes.Contains(stream, del) {
+			t.Errorf("Expected %s to be deleted on replicas, got %q", key, stream)
+		}
+	}
+
+	if bytes.Contains(stream, []byte("eternal")) {
+		t.Errorf("Expected only expired keys to be deleted on replicas, got %q", stream)
+	}
+}
@@ -0,0 +1,14 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"context"
+)
+
+func (r *Redis) del(ctx context.Context, args []string) []serde.Value {
+	if len(args) < 1 {
+		return []serde.Value{serde.NewError("ERR wrong number of arguments for 'del' command")}
+	}
+
+	return []serde.Value{serde.NewInteger(int64(r.store.Delete(ctx, args)))}
+}
@@ -0,0 +1,14 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+)
+
+func (r *Redis) discard(connection *RedisConnection) []serde.Value {
+	if !connection.transaction {
+		return []serde.Value{serde.NewError("ERR DISCARD without MULTI")}
+	}
+
+	r.endTransaction(connection)
+	return []serde.Value{serde.Ok()}
+}
@@ -0,0 +1,66 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// splitKeysAndArgs separates the numkeys, keys and args that follow the script
+// in EVAL and friends
+func splitKeysAndArgs(args []string) ([]string, []string, error) {
+	numKeys, err := strconv.Atoi(args[0])
+
+	if err != nil {
+		return nil, nil, errors.New("ERR value is not an integer or out of range")
+	}
+
+	if numKeys < 0 {
+		return nil, nil, errors.New("ERR Number of keys can't be negative")
+	}
+
+	if numKeys > len(args)-1 {
+		return nil, nil, errors.New("ERR Number of keys can't be greater than number of args")
+	}
+
+	return args[1 : numKeys+1], args[numKeys+1:], nil
+}
+
+func (r *Redis) eval(ctx context.Context, cmd string, args []string, readOnly bool, connection RedisConnection) []serde.Value {
+	if len(args) < 2 {
+		return []serde.Value{serde.NewError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd))}
+	}
+
+	keys, argv, err := splitKeysAndArgs(args[1:])
+
+	if err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	body := args[0]
+	r.scripting.load(body)
+
+	return r.runScript(ctx, body, keys, argv, readOnly, connection)
+}
+
+func (r *Redis) evalSha(ctx context.Context, cmd string, args []string, readOnly bool, connection RedisConnection) []serde.Value {
+	if len(args) < 2 {
+		return []serde.Value{serde.NewError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd))}
+	}
+
+	keys, argv, err := splitKeysAndArgs(args[1:])
+
+	if err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	body, ok := r.scripting.lookup(args[0])
+
+	if !ok {
+		return []serde.Value{serde.NewError(ErrNoScript.Error())}
+	}
+
+	return r.runScript(ctx, body, keys, argv, readOnly, connection)
+}
@@ -5,24 +5,64 @@ import (
 	"context"
 )
 
-func (r Redis) exec(ctx context.Context, _ []string, connection RedisConnection) []serde.Value {
+func (r *Redis) exec(ctx context.Context, connection *RedisConnection) []serde.Value {
 	if !connection.transaction {
-		connection.WithWriteMutex(func() error {
-			return connection.Send([]serde.Value{serde.NewError("ERR EXEC without MULTI")})
-		})
+		return []serde.Value{serde.NewError("ERR EXEC without MULTI")}
 	}
+
+	defer r.endTransaction(connection)
+
+	if connection.transactionAborted {
+		return []serde.Value{serde.NewError("EXECABORT Transaction discarded because of previous errors.")}
+	}
+
+	// Nothing else runs until the whole transaction is done
+	r.executionMutex.Lock()
+	defer r.executionMutex.Unlock()
+
+	// Someone got to a key we were watching first, so abort the transaction
+	if r.store.IsWatchDirty(ctx, connection.watch) {
+		return []serde.Value{serde.NewNullArray()}
+	}
+
+	ctx = withBlockingDenied(ctx)
+
+	// Replicas are sent the transaction's writes between MULTI and EXEC, so that
+	// they apply them all at once too. A transaction that doesn't write sends
+	// nothing.
+	propagatedMulti := false
+	defer func() {
+		if propagatedMulti {
+			r.propagate(serde.NewArray([]serde.Value{serde.NewBulkString("EXEC")}))
+		}
+	}()
+
 	result := []serde.Value{}
 	for _, command := range connection.bufferedCommands {
 		cmd, args, err := r.parseCommand(command)
 
+		// Commands are validated as they're queued, so this shouldn't happen, but
+		// an error in one command shouldn't stop the rest from running
 		if err != nil {
-			panic(err)
+			result = append(result, serde.NewError(err.Error()))
+			continue
 		}
 
-		response, _ := r.executeAndMaybePropagate(ctx, cmd, args, command, connection)
-		for _, v := range response {
-			result = append(result, v)
+		// Permissions may have changed since the command was queued
+		spec := lookupCommand(cmd, args)
+
+		if err := r.authorize(cmd, args, spec, *connection, ACL_CONTEXT_MULTI); err != nil {
+			result = append(result, serde.NewError(err.Error()))
+			continue
 		}
+
+		if !propagatedMulti && r.mayWrite(cmd, args, *connection) {
+			r.propagate(serde.NewArray([]serde.Value{serde.NewBulkString("MULTI")}))
+			propagatedMulti = true
+		}
+
+		response, _ := r.executeAndMaybePropagate(ctx, cmd, args, command, *connection)
+		result = append(result, response...)
 	}
 
 	return []serde.Value{serde.NewArray(result)}
@@ -0,0 +1,26 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"context"
+)
+
+func (r *Redis) fcall(ctx context.Context, args []string, readOnly bool, connection RedisConnection) []serde.Value {
+	keys, argv, err := splitKeysAndArgs(args[1:])
+
+	if err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	library, function, ok := r.functions.lookup(args[0])
+
+	if !ok {
+		return []serde.Value{serde.NewError("ERR Function not found")}
+	}
+
+	if readOnly && !function.readOnly() {
+		return []serde.Value{serde.NewError("ERR Can not execute a script with write flag using *_ro command.")}
+	}
+
+	return r.runFunction(ctx, library, function, keys, argv, readOnly || function.readOnly(), connection)
+}
@@ -0,0 +1,25 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"strings"
+)
+
+// We only have the one database, so FLUSHALL and FLUSHDB are the same thing. The
+// ASYNC and SYNC modifiers are accepted but we always flush synchronously.
+func (r Redis) flush(args []string) []serde.Value {
+	if len(args) > 1 {
+		return []serde.Value{serde.NewError("ERR syntax error")}
+	}
+
+	if len(args) == 1 {
+		mode := strings.ToLower(args[0])
+
+		if mode != "async" && mode != "sync" {
+			return []serde.Value{serde.NewError("ERR syntax error")}
+		}
+	}
+
+	r.store.Flush()
+	return []serde.Value{serde.Ok()}
+}
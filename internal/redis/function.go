@@ -0,0 +1,194 @@
+package redis
+
+import (
+	"codecrafters/internal/array"
+	"codecrafters/internal/glob"
+	"codecrafters/internal/serde"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var functionHelp = []string{
+	"FUNCTION <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"LOAD [REPLACE] <FUNCTION CODE>",
+	"    Create a new library with the given library name and code.",
+	"DELETE <LIBRARY NAME>",
+	"    Delete the given library.",
+	"LIST [LIBRARYNAME PATTERN] [WITHCODE]",
+	"    Return general information on all the libraries.",
+	"FCALL <FUNCTION NAME> <NUMKEYS> <KEY1> ... <KEYN> <ARG1> ... <ARGN>",
+	"    Call the given function.",
+	"FCALL_RO <FUNCTION NAME> <NUMKEYS> <KEY1> ... <KEYN> <ARG1> ... <ARGN>",
+	"    Call the given function, failing if it may write.",
+	"STATS",
+	"    Return information about the current function running.",
+	"KILL",
+	"    Kill the current running function.",
+	"FLUSH [ASYNC|SYNC]",
+	"    Delete all the libraries.",
+	"DUMP",
+	"    Return a serialized payload representing the current libraries.",
+	"RESTORE <PAYLOAD> [FLUSH|APPEND|REPLACE]",
+	"    Restore the libraries represented by the given payload.",
+	"HELP",
+	"    Print this help.",
+}
+
+func bulkStrings(values []string) serde.Value {
+	return serde.NewArray(array.Map(values, func(s string) serde.Value {
+		return serde.NewBulkString(s)
+	}))
+}
+
+func functionListEntry(library functionLibrary, withCode bool) serde.Value {
+	functions := array.Map(library.functions, func(function libraryFunction) serde.Value {
+		var description serde.Value = serde.NewNull()
+
+		if function.description != nil {
+			description = serde.NewBulkString(*function.description)
+		}
+
+		return serde.NewArray([]serde.Value{
+			serde.NewBulkString("name"), serde.NewBulkString(function.name),
+			serde.NewBulkString("description"), description,
+			serde.NewBulkString("flags"), bulkStrings(function.flags),
+		})
+	})
+
+	entry := []serde.Value{
+		serde.NewBulkString("library_name"), serde.NewBulkString(library.name),
+		serde.NewBulkString("engine"), serde.NewBulkString(FUNCTION_ENGINE_LUA),
+		serde.NewBulkString("functions"), serde.NewArray(functions),
+	}
+
+	if withCode {
+		entry = append(entry, serde.NewBulkString("library_code"), serde.NewBulkString(library.code))
+	}
+
+	return serde.NewArray(entry)
+}
+
+func (r *Redis) functionList(args []string) []serde.Value {
+	withCode := false
+	pattern := "*"
+
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "withcode":
+			withCode = true
+		case "libraryname":
+			if i+1 == len(args) {
+				return []serde.Value{serde.NewError("ERR library name argument was not given")}
+			}
+			pattern = args[i+1]
+			i++
+		default:
+			return []serde.Value{serde.NewError(fmt.Sprintf("ERR Unknown argument %s", args[i]))}
+		}
+	}
+
+	entries := []serde.Value{}
+
+	for _, library := range r.functions.list() {
+		if glob.Match(pattern, library.name) {
+			entries = append(entries, functionListEntry(library, withCode))
+		}
+	}
+
+	return []serde.Value{serde.NewArray(entries)}
+}
+
+func (r *Redis) functionStats() []serde.Value {
+	var runningScript serde.Value = serde.NewNull()
+
+	if running := r.scripting.runningScript(); running != nil && running.function != "" {
+		runningScript = serde.NewArray([]serde.Value{
+			serde.NewBulkString("name"), serde.NewBulkString(running.function),
+			serde.NewBulkString("command"), bulkStrings(running.command),
+			serde.NewBulkString("duration_ms"), serde.NewInteger(time.Since(running.started).Milliseconds()),
+		})
+	}
+
+	libraries, functions := r.functions.counts()
+
+	return []serde.Value{serde.NewArray([]serde.Value{
+		serde.NewBulkString("running_script"), runningScript,
+		serde.NewBulkString("engines"), serde.NewArray([]serde.Value{
+			serde.NewBulkString(FUNCTION_ENGINE_LUA), serde.NewArray([]serde.Value{
+				serde.NewBulkString("libraries_count"), serde.NewInteger(int64(libraries)),
+				serde.NewBulkString("functions_count"), serde.NewInteger(int64(functions)),
+			}),
+		}),
+	})}
+}
+
+func (r *Redis) function(args []string) []serde.Value {
+	switch strings.ToLower(args[0]) {
+	case "load":
+		replace := false
+		code := args[len(args)-1]
+
+		for _, option := range args[1 : len(args)-1] {
+			if strings.ToLower(option) != "replace" {
+				return []serde.Value{serde.NewError(fmt.Sprintf("ERR Unknown option given: %s", option))}
+			}
+			replace = true
+		}
+
+		name, err := r.functions.load(code, replace)
+
+		if err != nil {
+			return []serde.Value{serde.NewError(err.Error())}
+		}
+		return []serde.Value{serde.NewBulkString(name)}
+	case "delete":
+		if err := r.functions.delete(args[1]); err != nil {
+			return []serde.Value{serde.NewError(err.Error())}
+		}
+		return []serde.Value{serde.Ok()}
+	case "flush":
+		if len(args) > 2 {
+			return []serde.Value{serde.NewError("ERR syntax error")}
+		}
+
+		r.functions.flush()
+		return []serde.Value{serde.Ok()}
+	case "restore":
+		policy := FUNCTION_RESTORE_APPEND
+
+		if len(args) > 3 {
+			return []serde.Value{serde.NewError("ERR syntax error")}
+		}
+
+		if len(args) == 3 {
+			policy = strings.ToLower(args[2])
+
+			if policy != FUNCTION_RESTORE_APPEND && policy != FUNCTION_RESTORE_REPLACE && policy != FUNCTION_RESTORE_FLUSH {
+				return []serde.Value{serde.NewError("ERR Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE.")}
+			}
+		}
+
+		if err := r.functions.restore([]byte(args[1]), policy); err != nil {
+			return []serde.Value{serde.NewError(err.Error())}
+		}
+		return []serde.Value{serde.Ok()}
+	case "list":
+		return r.functionList(args[1:])
+	case "stats":
+		return r.functionStats()
+	case "dump":
+		return []serde.Value{serde.NewBulkString(string(r.functions.dump()))}
+	case "kill":
+		if err := r.scripting.kill(true); err != nil {
+			return []serde.Value{serde.NewError(err.Error())}
+		}
+		return []serde.Value{serde.Ok()}
+	case "help":
+		return []serde.Value{serde.NewArray(array.Map(functionHelp, func(line string) serde.Value {
+			return serde.NewSimpleString(line)
+		}))}
+	default:
+		return []serde.Value{serde.NewError(fmt.Sprintf("ERR unknown subcommand '%s'. Try FUNCTION HELP.", args[0]))}
+	}
+}
@@ -0,0 +1,557 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"codecrafters/internal/serde"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	FUNCTION_ENGINE_LUA         = "LUA"
+	FUNCTION_CHUNK_NAME         = "user_function"
+	FUNCTION_LOAD_TIMEOUT_MS    = 500
+	FUNCTION_FLAG_NO_WRITES     = "no-writes"
+	FUNCTION_RESTORE_APPEND     = "append"
+	FUNCTION_RESTORE_REPLACE    = "replace"
+	FUNCTION_RESTORE_FLUSH      = "flush"
+	RDB_FOOTER_SIZE             = 10
+	FUNCTION_NAME_RULES_MESSAGE = "can only contain letters, numbers, or underscores(_) and must be at least one character long"
+)
+
+var functionFlags = map[string]bool{
+	FUNCTION_FLAG_NO_WRITES: true,
+	"allow-oom":             true,
+	"allow-stale":           true,
+	"no-cluster":            true,
+	"allow-cross-slot-keys": true,
+}
+
+type libraryFunction struct {
+	name        string
+	description *string
+	flags       []string
+}
+
+func (f libraryFunction) readOnly() bool {
+	return slices.Contains(f.flags, FUNCTION_FLAG_NO_WRITES)
+}
+
+type functionLibrary struct {
+	name      string
+	code      string
+	functions []libraryFunction
+	// The interpreter the library was loaded into, holding the callbacks it
+	// registered so that FCALL doesn't have to run the library again
+	state     *lua.LState
+	callbacks map[string]*lua.LFunction
+}
+
+// functionsState holds the libraries loaded with FUNCTION LOAD, along with an
+// index from each function to the library that registered it
+type functionsState struct {
+	mutex     *sync.Mutex
+	libraries map[string]functionLibrary
+	functions map[string]string
+}
+
+func newFunctionsState() *functionsState {
+	return &functionsState{
+		mutex:     &sync.Mutex{},
+		libraries: map[string]functionLibrary{},
+		functions: map[string]string{},
+	}
+}
+
+func isValidFunctionName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+
+	for _, c := range name {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseLibraryMetadata reads the shebang line a library starts with, such as
+// "#!lua name=mylib", returning the library name and the code that follows
+func parseLibraryMetadata(code string) (string, string, error) {
+	firstLine, _, _ := strings.Cut(code, "\n")
+
+	if !strings.HasPrefix(firstLine, "#!") {
+		return "", "", errors.New("ERR Missing library metadata")
+	}
+
+	parts := strings.Fields(firstLine[2:])
+
+	if len(parts) == 0 {
+		return "", "", errors.New("ERR Missing library metadata")
+	}
+
+	if !strings.EqualFold(parts[0], FUNCTION_ENGINE_LUA) {
+		return "", "", fmt.Errorf("ERR Engine '%s' not found", parts[0])
+	}
+
+	name := ""
+
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(part, "=")
+
+		if !ok || key != "name" {
+			return "", "", fmt.Errorf("ERR Invalid metadata value given: %s", part)
+		}
+		name = value
+	}
+
+	if name == "" {
+		return "", "", errors.New("ERR Library name was not given")
+	}
+
+	if !isValidFunctionName(name) {
+		return "", "", fmt.Errorf("ERR Library names %s", FUNCTION_NAME_RULES_MESSAGE)
+	}
+
+	// Keep the newline so line numbers in errors still match the library
+	return name, code[len(firstLine):], nil
+}
+
+func parseFunctionFlags(value lua.LValue) ([]string, error) {
+	table, ok := value.(*lua.LTable)
+
+	if !ok {
+		return nil, errors.New("flags argument to redis.register_function must be a table representing function flags")
+	}
+
+	flags := []string{}
+
+	for i := 1; i <= table.Len(); i++ {
+		flag, ok := table.RawGetInt(i).(lua.LString)
+
+		if !ok || !functionFlags[string(flag)] {
+			return nil, errors.New("unknown flag given")
+		}
+		flags = append(flags, string(flag))
+	}
+
+	return flags, nil
+}
+
+// registerFunctionArgs reads the arguments to redis.register_function, given
+// either as a name and callback or as a single table of named arguments
+func registerFunctionArgs(L *lua.LState) (libraryFunction, *lua.LFunction, error) {
+	function := libraryFunction{flags: []string{}}
+	var name, callback lua.LValue
+
+	switch L.GetTop() {
+	case 1:
+		table, ok := L.Get(1).(*lua.LTable)
+
+		if !ok {
+			return function, nil, errors.New("calling redis.register_function with a single argument is only applicable to Lua table (representing named arguments).")
+		}
+
+		var err error
+
+		table.ForEach(func(key lua.LValue, value lua.LValue) {
+			if err != nil {
+				return
+			}
+
+			switch key.String() {
+			case "function_name":
+				name = value
+			case "callback":
+				callback = value
+			case "description":
+				description, ok := value.(lua.LString)
+
+				if !ok {
+					err = errors.New("description argument given to redis.register_function must be a string")
+					return
+				}
+				function.description = new(string)
+				*function.description = string(description)
+			case "flags":
+				function.flags, err = parseFunctionFlags(value)
+			default:
+				err = errors.New("unknown argument given to redis.register_function")
+			}
+		})
+
+		if err != nil {
+			return function, nil, err
+		}
+	case 2:
+		name, callback = L.Get(1), L.Get(2)
+	default:
+		return function, nil, errors.New("wrong number of arguments to redis.register_function")
+	}
+
+	nameString, ok := name.(lua.LString)
+
+	if !ok {
+		return function, nil, errors.New("function_name argument given to redis.register_function must be a string")
+	}
+
+	callbackFn, ok := callback.(*lua.LFunction)
+
+	if !ok {
+		return function, nil, errors.New("callback argument given to redis.register_function must be a function")
+	}
+
+	function.name = string(nameString)
+
+	if !isValidFunctionName(function.name) {
+		return function, nil, fmt.Errorf("Function names %s", FUNCTION_NAME_RULES_MESSAGE)
+	}
+
+	return function, callbackFn, nil
+}
+
+// loadLibrary runs a library's code with redis.register_function available,
+// returning the functions it registered along with their callbacks
+func loadLibrary(L *lua.LState, body string) ([]libraryFunction, map[string]*lua.LFunction, error) {
+	functions := []libraryFunction{}
+	callbacks := map[string]*lua.LFunction{}
+
+	redisLib, ok := L.GetGlobal("redis").(*lua.LTable)
+
+	if !ok {
+		redisLib = L.NewTable()
+		L.SetGlobal("redis", redisLib)
+	}
+
+	redisLib.RawSetString("register_function", L.NewFunction(func(L *lua.LState) int {
+		function, callback, err := registerFunctionArgs(L)
+
+		if err == nil && callbacks[function.name] != nil {
+			err = errors.New("Function already exists in the library")
+		}
+
+		if err != nil {
+			L.RaiseError("%s", err.Error())
+			return 0
+		}
+
+		functions = append(functions, function)
+		callbacks[function.name] = callback
+		return 0
+	}))
+
+	// Functions may only be registered while the library loads
+	defer redisLib.RawSetString("register_function", lua.LNil)
+
+	fn, err := L.Load(strings.NewReader(body), FUNCTION_CHUNK_NAME)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error compiling function: %s", err.Error())
+	}
+
+	L.Push(fn)
+
+	if err := L.PCall(0, 0, nil); err != nil {
+		return nil, nil, err
+	}
+
+	return functions, callbacks, nil
+}
+
+// compileLibrary runs a library's code once, without redis.call available, and
+// keeps the interpreter along with the functions it registered
+func compileLibrary(code string) (library functionLibrary, err error) {
+	name, body, err := parseLibraryMetadata(code)
+
+	if err != nil {
+		return functionLibrary{}, err
+	}
+
+	L := newScriptState()
+	defer func() {
+		if err != nil {
+			L.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), FUNCTION_LOAD_TIMEOUT_MS*time.Millisecond)
+	defer cancel()
+	L.SetContext(ctx)
+
+	functions, callbacks, err := loadLibrary(L, body)
+
+	if ctx.Err() != nil {
+		return functionLibrary{}, errors.New("ERR FUNCTION LOAD timeout")
+	}
+
+	if err != nil {
+		var apiErr *lua.ApiError
+
+		if errors.As(err, &apiErr) {
+			err = errors.New(apiErr.Object.String())
+		}
+		return functionLibrary{}, scriptErrorValue("ERR Error registering functions: %s", err.Error())
+	}
+
+	if len(functions) == 0 {
+		return functionLibrary{}, errors.New("ERR No functions registered")
+	}
+
+	return functionLibrary{name: name, code: code, functions: functions, state: L, callbacks: callbacks}, nil
+}
+
+func scriptErrorValue(format string, a ...any) error {
+	return errors.New(scriptError(format, a...).Value())
+}
+
+func sortedLibraryNames(libraries map[string]functionLibrary) []string {
+	names := make([]string, 0, len(libraries))
+
+	for name := range libraries {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+// closeLibraries closes the interpreters of the libraries that aren't also in
+// kept, as nothing will call them again
+func closeLibraries(libraries map[string]functionLibrary, kept map[string]functionLibrary) {
+	for name, library := range libraries {
+		if keptLibrary, ok := kept[name]; !ok || keptLibrary.state != library.state {
+			library.state.Close()
+		}
+	}
+}
+
+// install replaces the loaded libraries, provided no two of them register a
+// function with the same name. Whichever libraries don't end up loaded have
+// their interpreters closed. It must be called with the mutex held.
+func (s *functionsState) install(libraries map[string]functionLibrary) error {
+	functions := map[string]string{}
+
+	for _, name := range sortedLibraryNames(libraries) {
+		for _, function := range libraries[name].functions {
+			if _, exists := functions[function.name]; exists {
+				closeLibraries(libraries, s.libraries)
+				return fmt.Errorf("ERR Function %s already exists", function.name)
+			}
+			functions[function.name] = name
+		}
+	}
+
+	closeLibraries(s.libraries, libraries)
+	s.libraries = libraries
+	s.functions = functions
+	return nil
+}
+
+func (s *functionsState) load(code string, replace bool) (string, error) {
+	library, err := compileLibrary(code)
+
+	if err != nil {
+		return "", err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.libraries[library.name]; exists && !replace {
+		library.state.Close()
+		return "", fmt.Errorf("ERR Library '%s' already exists", library.name)
+	}
+
+	libraries := maps.Clone(s.libraries)
+	libraries[library.name] = library
+
+	return library.name, s.install(libraries)
+}
+
+func (s *functionsState) delete(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.libraries[name]; !exists {
+		return errors.New("ERR Library not found")
+	}
+
+	libraries := maps.Clone(s.libraries)
+	delete(libraries, name)
+
+	return s.install(libraries)
+}
+
+func (s *functionsState) flush() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	closeLibraries(s.libraries, nil)
+	s.libraries = map[string]functionLibrary{}
+	s.functions = map[string]string{}
+}
+
+func (s *functionsState) lookup(name string) (functionLibrary, libraryFunction, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	library, ok := s.libraries[s.functions[name]]
+
+	if !ok {
+		return library, libraryFunction{}, false
+	}
+
+	for _, function := range library.functions {
+		if function.name == name {
+			return library, function, true
+		}
+	}
+
+	return library, libraryFunction{}, false
+}
+
+// list returns the loaded libraries ordered by name
+func (s *functionsState) list() []functionLibrary {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	libraries := []functionLibrary{}
+
+	for _, name := range sortedLibraryNames(s.libraries) {
+		libraries = append(libraries, s.libraries[name])
+	}
+
+	return libraries
+}
+
+// rdbSection encodes each library as it is saved in an RDB file
+func (s *functionsState) rdbSection() []byte {
+	section := []byte{}
+
+	for _, library := range s.list() {
+		section = append(section, FUNCTION2)
+		section = append(section, encodeRDBString(library.code)...)
+	}
+
+	return section
+}
+
+// dump serializes the libraries the way Redis does for FUNCTION DUMP, as their
+// RDB encoding followed by the RDB version and a checksum
+func (s *functionsState) dump() []byte {
+	payload := binary.LittleEndian.AppendUint16(s.rdbSection(), RDB_VERSION)
+	return binary.LittleEndian.AppendUint64(payload, rdbChecksum(payload))
+}
+
+func parseFunctionsDump(payload []byte) ([]string, error) {
+	if len(payload) < RDB_FOOTER_SIZE {
+		return nil, errors.New("ERR payload version or checksum are wrong")
+	}
+
+	footer := payload[len(payload)-RDB_FOOTER_SIZE:]
+	version := binary.LittleEndian.Uint16(footer)
+	checksum := binary.LittleEndian.Uint64(footer[2:])
+
+	if version > RDB_VERSION || checksum != rdbChecksum(payload[:len(payload)-8]) {
+		return nil, errors.New("ERR payload version or checksum are wrong")
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(payload[:len(payload)-RDB_FOOTER_SIZE]))
+	codes := []string{}
+
+	for {
+		opcode, err := reader.ReadByte()
+
+		if err != nil {
+			return codes, nil
+		}
+
+		if opcode != FUNCTION2 {
+			return nil, errors.New("ERR given type is not a function")
+		}
+
+		code, err := readRDBString(reader)
+
+		if err != nil {
+			return nil, errors.New("ERR payload version or checksum are wrong")
+		}
+		codes = append(codes, code)
+	}
+}
+
+// restore loads the libraries from a FUNCTION DUMP payload, either alongside,
+// replacing or instead of those already loaded. Nothing changes if any fail.
+func (s *functionsState) restore(payload []byte, policy string) error {
+	codes, err := parseFunctionsDump(payload)
+
+	if err != nil {
+		return err
+	}
+
+	restored := []functionLibrary{}
+	closeRestored := func() {
+		for _, library := range restored {
+			library.state.Close()
+		}
+	}
+
+	for _, code := range codes {
+		library, err := compileLibrary(code)
+
+		if err != nil {
+			closeRestored()
+			return err
+		}
+		restored = append(restored, library)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	libraries := map[string]functionLibrary{}
+
+	if policy != FUNCTION_RESTORE_FLUSH {
+		libraries = maps.Clone(s.libraries)
+	}
+
+	for _, library := range restored {
+		if _, exists := libraries[library.name]; exists && policy == FUNCTION_RESTORE_APPEND {
+			closeRestored()
+			return fmt.Errorf("ERR Library %s already exists", library.name)
+		}
+		libraries[library.name] = library
+	}
+
+	return s.install(libraries)
+}
+
+func (s *functionsState) counts() (int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.libraries), len(s.functions)
+}
+
+// runFunction calls a function with the keys and args given to FCALL, in the
+// interpreter its library was loaded into
+func (r *Redis) runFunction(ctx context.Context, library functionLibrary, function libraryFunction, keys []string, argv []string, readOnly bool, connection RedisConnection) []serde.Value {
+	running := &runningScript{function: function.name, command: append([]string{FCALL, function.name}, keys...)}
+
+	return r.runLua(ctx, library.state, running, readOnly, connection, func(L *lua.LState) error {
+		L.Push(library.callbacks[function.name])
+		L.Push(stringsToLuaTable(L, keys))
+		L.Push(stringsToLuaTable(L, argv))
+		return L.PCall(2, 1, nil)
+	})
+}
@@ -0,0 +1,158 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"context"
+	"reflect"
+	"testing"
+)
+
+const testLibrary = "#!lua name=mylib\nredis.register_function('hello', function() return 'hi' end)"
+
+func Test_parseLibraryMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		wantName string
+		wantErr  bool
+	}{
+		{"It should read the library name", "#!lua name=mylib\nreturn", "mylib", false},
+		{"It should accept the engine in any case", "#!LUA name=mylib\nreturn", "mylib", false},
+		{"It should require a shebang", "return", "", true},
+		{"It should require a name", "#!lua\nreturn", "", true},
+		{"It should reject unknown engines", "#!js name=mylib\nreturn", "", true},
+		{"It should reject unknown metadata", "#!lua name=mylib foo=bar\nreturn", "", true},
+		{"It should reject invalid library names", "#!lua name=my-lib\nreturn", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, _, err := parseLibraryMetadata(tt.code)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLibraryMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if name != tt.wantName {
+				t.Errorf("parseLibraryMetadata() = %v, want %v", name, tt.wantName)
+			}
+		})
+	}
+}
+
+func Test_functionsStateClosesUnusedLibraries(t *testing.T) {
+	s := newFunctionsState()
+
+	if _, err := s.load(testLibrary, false); err != nil {
+		t.Fatal(err)
+	}
+	original := s.libraries["mylib"].state
+
+	if _, err := s.load(testLibrary, false); err == nil {
+		t.Fatal("Expected loading the library again without REPLACE to fail")
+	}
+
+	if original.IsClosed() {
+		t.Errorf("Expected a failed load to leave the loaded library alone")
+	}
+
+	if _, err := s.load(testLibrary, true); err != nil {
+		t.Fatal(err)
+	}
+	replacement := s.libraries["mylib"].state
+
+	if !original.IsClosed() || replacement.IsClosed() {
+		t.Errorf("Expected only the replaced library to be closed")
+	}
+
+	if err := s.delete("mylib"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !replacement.IsClosed() {
+		t.Errorf("Expected a deleted library to be closed")
+	}
+
+	if _, err := s.load(testLibrary, false); err != nil {
+		t.Fatal(err)
+	}
+	flushed := s.libraries["mylib"].state
+	s.flush()
+
+	if !flushed.IsClosed() {
+		t.Errorf("Expected a flushed library to be closed")
+	}
+}
+
+func Test_functionsDumpAndRestore(t *testing.T) {
+	tests := []struct {
+		name       string
+		existing   []string
+		policy     string
+		corrupt    bool
+		wantErr    bool
+		wantLoaded []string
+	}{
+		{"It should restore into an empty server", nil, FUNCTION_RESTORE_APPEND, false, false, []string{"mylib"}},
+		{"It should refuse to append an existing library", []string{testLibrary}, FUNCTION_RESTORE_APPEND, false, true, []string{"mylib"}},
+		{"It should replace an existing library", []string{testLibrary}, FUNCTION_RESTORE_REPLACE, false, false, []string{"mylib"}},
+		{
+			"It should flush libraries before restoring",
+			[]string{"#!lua name=other\nredis.register_function('other', function() end)"},
+			FUNCTION_RESTORE_FLUSH, false, false, []string{"mylib"},
+		},
+		{"It should reject a corrupt payload", nil, FUNCTION_RESTORE_APPEND, true, true, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newFunctionsState()
+			if _, err := source.load(testLibrary, false); err != nil {
+				t.Fatalf("load() error = %v", err)
+			}
+
+			payload := source.dump()
+			if tt.corrupt {
+				payload[0] ^= 0xFF
+			}
+
+			target := newFunctionsState()
+			for _, code := range tt.existing {
+				if _, err := target.load(code, false); err != nil {
+					t.Fatalf("load() error = %v", err)
+				}
+			}
+
+			err := target.restore(payload, tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("restore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			loaded := []string{}
+			for _, library := range target.list() {
+				loaded = append(loaded, library.name)
+			}
+			if !reflect.DeepEqual(loaded, tt.wantLoaded) {
+				t.Errorf("restore() loaded %v, want %v", loaded, tt.wantLoaded)
+			}
+		})
+	}
+}
+
+func TestRedis_runFunction(t *testing.T) {
+	code := `#!lua name=counter
+loads = (loads or 0) + 1
+local reached = pcall(function() return redis.call('PING') end)
+redis.register_function('loads', function() return {loads, tostring(reached)} end)`
+
+	library, err := compileLibrary(code)
+	if err != nil {
+		t.Fatalf("compileLibrary() error = %v", err)
+	}
+
+	r := Redis{scripting: newScriptingState()}
+	want := serde.NewArray([]serde.Value{serde.NewInteger(1), serde.NewBulkString("false")})
+
+	for range 2 {
+		got := r.runFunction(context.Background(), library, library.functions[0], []string{}, []string{}, false, RedisConnection{})
+		if len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+			t.Errorf("runFunction() = %v, want the library loaded once without redis.call, %v", got, want)
+		}
+	}
+}
@@ -14,7 +14,7 @@ func (r Redis) get(ctx context.Context, args []string) []serde.Value {
 
 	key := args[0]
 
-	storedValue, found := r.store.GetKey(ctx, key)
+	storedValue, found := r.store.ReadKey(ctx, key)
 
 	if !found {
 		return []serde.Value{serde.NewNull()}
@@ -0,0 +1,91 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The Redis version we report to clients
+const REDIS_VERSION = "7.4.0"
+
+// hello switches the client's protocol version, optionally authenticating and
+// naming it first, and then describes the server
+func (r *Redis) hello(args []string, connection RedisConnection) []serde.Value {
+	protocol := r.clients.protocol(connection.id)
+
+	if len(args) > 0 {
+		version, err := strconv.Atoi(args[0])
+
+		if err != nil {
+			return []serde.Value{serde.NewError("ERR Protocol version is not an integer or out of range")}
+		}
+
+		if version < 2 || version > 3 {
+			return []serde.Value{serde.NewError("NOPROTO unsupported protocol version")}
+		}
+
+		protocol = version
+	}
+
+	name := ""
+	setName := false
+
+	for i := 1; i < len(args); i++ {
+		remaining := len(args) - i - 1
+
+		switch {
+		case strings.ToLower(args[i]) == "auth" && remaining >= 2:
+			username, password := args[i+1], args[i+2]
+
+			if !r.acl.authenticate(username, password) {
+				r.acl.addLogEntry(ACL_REASON_AUTH, ACL_CONTEXT_TOPLEVEL, AUTH, username, connection.Info())
+				return []serde.Value{serde.NewError(ErrWrongPass.Error())}
+			}
+
+			connection.auth.user = username
+			connection.auth.authenticated = true
+			i += 2
+		case strings.ToLower(args[i]) == "setname" && remaining >= 1:
+			if !validClientName(args[i+1]) {
+				return []serde.Value{serde.NewError("ERR Client names cannot contain spaces, newlines or special characters.")}
+			}
+
+			name, setName = args[i+1], true
+			i++
+		default:
+			return []serde.Value{serde.NewError(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", args[i]))}
+		}
+	}
+
+	if !connection.auth.authenticated && !r.acl.defaultUserOpen() {
+		return []serde.Value{serde.NewError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")}
+	}
+
+	if setName {
+		r.clients.setName(connection.id, name)
+	}
+
+	r.clients.setProtocol(connection.id, protocol)
+
+	role := MASTER
+	if r.configuration.replicationConfig.replicaConfig.Role() == SLAVE {
+		role = "replica"
+	}
+
+	fields := []serde.Value{
+		serde.NewBulkString("server"), serde.NewBulkString("redis"),
+		serde.NewBulkString("version"), serde.NewBulkString(REDIS_VERSION),
+		serde.NewBulkString("proto"), serde.NewInteger(int64(protocol)),
+		serde.NewBulkString("id"), serde.NewInteger(connection.id),
+		serde.NewBulkString("mode"), serde.NewBulkString("standalone"),
+		serde.NewBulkString("role"), serde.NewBulkString(role),
+		serde.NewBulkString("modules"), serde.NewArray([]serde.Value{}),
+	}
+
+	if protocol >= 3 {
+		return []serde.Value{serde.NewMap(fields)}
+	}
+	return []serde.Value{serde.NewArray(fields)}
+}
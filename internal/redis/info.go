@@ -1,27 +1,376 @@
 package redis
 
 import (
+	"codecrafters/internal/kvstore"
 	"codecrafters/internal/serde"
+	"context"
 	"fmt"
-	"strconv"
+	"os"
+	"runtime"
+	"slices"
 	"strings"
+	"syscall"
+	"time"
 )
 
-func getInfoLine(key string, value string) string {
-	return fmt.Sprintf("%s:%s", key, value)
+// INFO sections in the order they're shown
+var infoSections = []string{
+	"server", "clients", "memory", "persistence", "stats", "replication", "cpu",
+	"modules", "commandstats", "errorstats", "latencystats", "keyspace",
 }
 
-func getReplicationInfo(r Redis) []string {
-	header := "# Replication"
-	role := getInfoLine("role", r.configuration.replicationConfig.replicaConfig.Role())
-	replicaId := getInfoLine("master_replid", r.configuration.replicationConfig.masterReplId)
-	replicaOffset := getInfoLine("master_repl_offset", strconv.Itoa(r.configuration.replicationConfig.masterReplOffset))
-	replicationInfo := []string{header, role, replicaId, replicaOffset}
+// Sections shown when INFO is given no arguments, or default
+var defaultInfoSections = []string{
+	"server", "clients", "memory", "persistence", "stats", "replication", "cpu",
+	"modules", "errorstats", "keyspace",
+}
+
+// Percentiles shown for each command in the latencystats section
+var infoLatencyPercentiles = []float64{50, 99, 99.9}
+
+// selectInfoSections works out which sections were asked for. Sections are shown
+// in their usual order however they were given, and unknown ones are ignored.
+func selectInfoSections(args []string) map[string]bool {
+	if len(args) == 0 {
+		args = []string{"default"}
+	}
+
+	selected := map[string]bool{}
+
+	for _, arg := range args {
+		switch arg = strings.ToLower(arg); arg {
+		case "default":
+			for _, section := range defaultInfoSections {
+				selected[section] = true
+			}
+		case "all":
+			for _, section := range infoSections {
+				selected[section] = section != "modules" || selected[section]
+			}
+		case "everything":
+			for _, section := range infoSections {
+				selected[section] = true
+			}
+		default:
+			selected[arg] = true
+		}
+	}
+	return selected
+}
+
+// infoBuilder writes out the fields of each section
+type infoBuilder struct {
+	strings.Builder
+}
+
+func (b *infoBuilder) section(name string) {
+	if b.Len() > 0 {
+		b.WriteString("\r\n")
+	}
+	fmt.Fprintf(b, "# %s\r\n", strings.ToUpper(name[:1])+name[1:])
+}
+
+func (b *infoBuilder) field(key string, value any) {
+	fmt.Fprintf(b, "%s:%v\r\n", key, value)
+}
+
+// bytesToHuman formats a number of bytes the way INFO shows them, as in 1.50M
+func bytesToHuman(n uint64) string {
+	units := []string{"K", "M", "G", "T", "P"}
+
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	value := float64(n) / 1024
+	unit := 0
+
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.2f%s", value, units[unit])
+}
+
+func (r *Redis) infoServer(b *infoBuilder) {
+	executable, _ := os.Executable()
+	uptime := time.Since(r.startTime)
+
+	b.field("redis_version", REDIS_VERSION)
+	b.field("redis_git_sha1", "00000000")
+	b.field("redis_git_dirty", 0)
+	b.field("redis_mode", "standalone")
+	b.field("os", fmt.Sprintf("%s %s", runtime.GOOS, runtime.GOARCH))
+	b.field("arch_bits", 32<<(^uint(0)>>63))
+	b.field("go_version", runtime.Version())
+	b.field("process_id", os.Getpid())
+	b.field("run_id", r.runId)
+	b.field("tcp_port", r.configuration.port)
+	b.field("server_time_usec", time.Now().UnixMicro())
+	b.field("uptime_in_seconds", int64(uptime.Seconds()))
+	b.field("uptime_in_days", int64(uptime.Hours()/24))
+	b.field("hz", SERVER_HZ)
+	b.field("executable", executable)
+	b.field("config_file", r.configuration.configFile)
+}
+
+func (r *Redis) infoClients(b *infoBuilder) {
+	connected, maxQueryBuffer := r.clients.stats()
+	trackingClients, _, _ := r.tracking.stats()
+	watchingClients, watchedKeys := r.store.WatchStats()
+
+	b.field("connected_clients", connected)
+	b.field("client_recent_max_input_buffer", maxQueryBuffer)
+	b.field("blocked_clients", r.stats.blockedClients.Load())
+	b.field("tracking_clients", trackingClients)
+	b.field("watching_clients", watchingClients)
+	b.field("total_watched_keys", watchedKeys)
+}
+
+func (r *Redis) infoMemory(b *infoBuilder) {
+	mem := r.stats.memoryUsed()
+	peak := r.stats.peakMemory.Load()
 
-	return replicationInfo
+	b.field("used_memory", mem.HeapAlloc)
+	b.field("used_memory_human", bytesToHuman(mem.HeapAlloc))
+	b.field("used_memory_rss", mem.Sys)
+	b.field("used_memory_rss_human", bytesToHuman(mem.Sys))
+	b.field("used_memory_peak", peak)
+	b.field("used_memory_peak_human", bytesToHuman(peak))
+	b.field("used_memory_peak_perc", fmt.Sprintf("%.2f%%", float64(mem.HeapAlloc)*100/float64(max(peak, 1))))
+	b.field("used_memory_dataset", r.store.UsedMemory())
+	b.field("maxmemory", r.configuration.maxMemory)
+	b.field("maxmemory_human", bytesToHuman(uint64(r.configuration.maxMemory)))
+	b.field("maxmemory_policy", r.configuration.maxMemoryPolicy)
+	b.field("mem_fragmentation_ratio", fmt.Sprintf("%.2f", float64(mem.Sys)/float64(max(mem.HeapAlloc, 1))))
+	b.field("mem_allocator", "go")
 }
 
-func (r Redis) info(_ []string) []serde.Value {
-	replicationInfo := getReplicationInfo(r)
-	return []serde.Value{serde.NewBulkString(fmt.Sprintf("%s\r\n", strings.Join(replicationInfo, "\r\n")))}
+func (r *Redis) infoPersistence(b *infoBuilder) {
+	status := r.persistence.status()
+	lastStatus := "ok"
+
+	if status.lastFailed {
+		lastStatus = "err"
+	}
+
+	b.field("loading", 0)
+	b.field("rdb_changes_since_last_save", status.dirty)
+	b.field("rdb_bgsave_in_progress", 0)
+	b.field("rdb_last_save_time", status.lastSave.Unix())
+	b.field("rdb_last_bgsave_status", lastStatus)
+	b.field("rdb_last_bgsave_time_sec", int64(status.lastDuration.Seconds()))
+	b.field("rdb_saves", status.saves)
+	b.field("aof_enabled", 0)
+}
+
+func (r *Redis) infoStats(b *infoBuilder) {
+	keyspace := r.store.Stats()
+	_, trackedKeys, trackedPrefixes := r.tracking.stats()
+
+	r.stats.mutex.Lock()
+	defer r.stats.mutex.Unlock()
+
+	b.field("total_connections_received", r.stats.connectionsReceived)
+	b.field("total_commands_processed", r.stats.commandsProcessed)
+	b.field("instantaneous_ops_per_sec", int64(r.stats.opsPerSecond.perSecond()))
+	b.field("total_net_input_bytes", r.stats.netInput.Load())
+	b.field("total_net_output_bytes", r.stats.netOutput.Load())
+	b.field("instantaneous_input_kbps", fmt.Sprintf("%.2f", r.stats.inputPerSecond.perSecond()/1024))
+	b.field("instantaneous_output_kbps", fmt.Sprintf("%.2f", r.stats.outputPerSecond.perSecond()/1024))
+	b.field("rejected_connections", 0)
+	b.field("sync_full", r.stats.fullSyncs)
+	b.field("sync_partial_ok", r.stats.partialSyncs)
+	b.field("sync_partial_err", r.stats.failedPartialSyncs)
+	b.field("expired_keys", keyspace.ExpiredKeys)
+	b.field("evicted_keys", keyspace.EvictedKeys)
+	b.field("keyspace_hits", keyspace.Hits)
+	b.field("keyspace_misses", keyspace.Misses)
+	b.field("tracking_total_keys", trackedKeys)
+	b.field("tracking_total_prefixes", trackedPrefixes)
+	b.field("total_error_replies", r.stats.errorReplies)
+}
+
+func (r *Redis) infoReplication(b *infoBuilder) {
+	b.field("role", r.configuration.replicationConfig.replicaConfig.Role())
+
+	if master, ok := r.configuration.replicationConfig.replicaConfig.(slaveConfig); ok {
+		b.field("master_host", master.host)
+		b.field("master_port", master.port)
+
+		linkStatus, lastIO, syncInProgress := "down", int64(-1), 0
+
+		switch r.replication.linkState {
+		case REPL_STATE_CONNECTED:
+			linkStatus, lastIO = "up", int64(time.Since(r.replication.lastIO).Seconds())
+		case REPL_STATE_TRANSFER:
+			syncInProgress = 1
+		}
+
+		b.field("master_link_status", linkStatus)
+		b.field("master_last_io_seconds_ago", lastIO)
+		b.field("master_sync_in_progress", syncInProgress)
+		b.field("slave_repl_offset", r.processedByteCount)
+	}
+
+	replicas := r.clients.replicas()
+	b.field("connected_slaves", len(replicas))
+
+	for i, replica := range replicas {
+		lag := int64(0)
+
+		if !replica.lastAck.IsZero() {
+			lag = int64(time.Since(replica.lastAck).Seconds())
+		}
+		b.field(fmt.Sprintf("slave%d", i), fmt.Sprintf("ip=%s,port=%d,state=online,offset=%d,lag=%d", replica.ip, replica.port, replica.offset, lag))
+	}
+
+	replication := r.replication
+	secondOffset := replication.secondReplOffset
+
+	// Redis counts the second offset from 1
+	if secondOffset >= 0 {
+		secondOffset++
+	}
+
+	b.field("master_replid", replication.replId)
+	b.field("master_replid2", replication.replId2)
+	b.field("master_repl_offset", r.processedByteCount)
+	b.field("second_repl_offset", secondOffset)
+	b.field("repl_backlog_active", 1)
+	b.field("repl_backlog_size", len(replication.backlog.buffer))
+	b.field("repl_backlog_first_byte_offset", replication.backlog.start()+1)
+	b.field("repl_backlog_histlen", replication.backlog.length)
+}
+
+func (r *Redis) infoCpu(b *infoBuilder) {
+	self, children := syscall.Rusage{}, syscall.Rusage{}
+	syscall.Getrusage(syscall.RUSAGE_SELF, &self)
+	syscall.Getrusage(syscall.RUSAGE_CHILDREN, &children)
+
+	seconds := func(t syscall.Timeval) string {
+		return fmt.Sprintf("%.6f", time.Duration(t.Nano()).Seconds())
+	}
+
+	b.field("used_cpu_sys", seconds(self.Stime))
+	b.field("used_cpu_user", seconds(self.Utime))
+	b.field("used_cpu_sys_children", seconds(children.Stime))
+	b.field("used_cpu_user_children", seconds(children.Utime))
+}
+
+func (r *Redis) infoCommandStats(b *infoBuilder) {
+	r.stats.mutex.Lock()
+	defer r.stats.mutex.Unlock()
+
+	for _, name := range r.stats.sortedCommandNames() {
+		stats := r.stats.commands[name]
+		perCall := 0.0
+
+		if stats.calls > 0 {
+			perCall = float64(stats.usec) / float64(stats.calls)
+		}
+
+		b.field("cmdstat_"+name, fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d", stats.calls, stats.usec, perCall, stats.rejectedCalls, stats.failedCalls))
+	}
+}
+
+func (r *Redis) infoErrorStats(b *infoBuilder) {
+	r.stats.mutex.Lock()
+	defer r.stats.mutex.Unlock()
+
+	prefixes := make([]string, 0, len(r.stats.errors))
+
+	for prefix := range r.stats.errors {
+		prefixes = append(prefixes, prefix)
+	}
+	slices.Sort(prefixes)
+
+	for _, prefix := range prefixes {
+		b.field("errorstat_"+prefix, fmt.Sprintf("count=%d", r.stats.errors[prefix]))
+	}
+}
+
+func (r *Redis) infoLatencyStats(b *infoBuilder) {
+	r.stats.mutex.Lock()
+	defer r.stats.mutex.Unlock()
+
+	for _, name := range r.stats.sortedCommandNames() {
+		stats := r.stats.commands[name]
+
+		if stats.calls == 0 {
+			continue
+		}
+
+		percentiles := make([]string, len(infoLatencyPercentiles))
+
+		for i, percent := range infoLatencyPercentiles {
+			percentiles[i] = fmt.Sprintf("p%g=%.3f", percent, float64(stats.latency.percentile(percent)))
+		}
+		b.field("latency_percentiles_usec_"+name, strings.Join(percentiles, ","))
+	}
+}
+
+func (r *Redis) infoKeyspace(ctx context.Context, b *infoBuilder) {
+	values := r.store.Snapshot(ctx)
+
+	if len(values) == 0 {
+		return
+	}
+
+	now := uint64(time.Now().UnixMilli())
+	expires, ttlTotal := uint64(0), uint64(0)
+
+	for _, value := range values {
+		if stored, ok := value.(kvstore.StoredString); ok && stored.ExpiresAt() != nil {
+			expires++
+			ttlTotal += max(*stored.ExpiresAt(), now) - now
+		}
+	}
+
+	avgTtl := uint64(0)
+
+	if expires > 0 {
+		avgTtl = ttlTotal / expires
+	}
+	b.field("db0", fmt.Sprintf("keys=%d,expires=%d,avg_ttl=%d", len(values), expires, avgTtl))
+}
+
+func (r *Redis) info(ctx context.Context, args []string) []serde.Value {
+	selected := selectInfoSections(args)
+	b := &infoBuilder{}
+
+	for _, section := range infoSections {
+		if !selected[section] {
+			continue
+		}
+
+		b.section(section)
+
+		switch section {
+		case "server":
+			r.infoServer(b)
+		case "clients":
+			r.infoClients(b)
+		case "memory":
+			r.infoMemory(b)
+		case "persistence":
+			r.infoPersistence(b)
+		case "stats":
+			r.infoStats(b)
+		case "replication":
+			r.infoReplication(b)
+		case "cpu":
+			r.infoCpu(b)
+		case "commandstats":
+			r.infoCommandStats(b)
+		case "errorstats":
+			r.infoErrorStats(b)
+		case "latencystats":
+			r.infoLatencyStats(b)
+		case "keyspace":
+			r.infoKeyspace(ctx, b)
+		}
+	}
+
+	return []serde.Value{serde.NewBulkString(b.String())}
 }
@@ -0,0 +1,90 @@
+package redis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_selectInfoSections(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "It should show the default sections without arguments", args: []string{}, want: defaultInfoSections},
+		{name: "It should show a single section", args: []string{"Replication"}, want: []string{"replication"}},
+		{name: "It should show sections in their usual order", args: []string{"keyspace", "server"}, want: []string{"server", "keyspace"}},
+		{name: "It should leave modules out of all", args: []string{"all"}, want: []string{"server", "clients", "memory", "persistence", "stats", "replication", "cpu", "commandstats", "errorstats", "latencystats", "keyspace"}},
+		{name: "It should show modules when asked for alongside all", args: []string{"modules", "all"}, want: infoSections},
+		{name: "It should show every section for everything", args: []string{"everything"}, want: infoSections},
+		{name: "It should ignore unknown sections", args: []string{"bogus"}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected := selectInfoSections(tt.args)
+			got := []string{}
+			for _, section := range infoSections {
+				if selected[section] {
+					got = append(got, section)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectInfoSections() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_bytesToHuman(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want string
+	}{
+		{n: 0, want: "0B"},
+		{n: 1023, want: "1023B"},
+		{n: 1536, want: "1.50K"},
+		{n: 1 << 20, want: "1.00M"},
+		{n: 5 << 30, want: "5.00G"},
+	}
+	for _, tt := range tests {
+		if got := bytesToHuman(tt.n); got != tt.want {
+			t.Errorf("bytesToHuman(%d) = %s, want %s", tt.n, got, tt.want)
+		}
+	}
+}
+
+func Test_latencyHistogramPercentile(t *testing.T) {
+	h := &latencyHistogram{}
+	for usec := int64(1); usec <= 1000; usec++ {
+		h.record(usec)
+	}
+
+	tests := []struct {
+		percent float64
+		want    int64
+	}{
+		{percent: 0, want: 1},
+		{percent: 50, want: 511},
+		{percent: 99, want: 991},
+		{percent: 100, want: 1023},
+	}
+	for _, tt := range tests {
+		got := h.percentile(tt.percent)
+		if got != tt.want {
+			t.Errorf("percentile(%v) = %d, want %d", tt.percent, got, tt.want)
+		}
+	}
+
+	if got := (&latencyHistogram{}).percentile(50); got != 0 {
+		t.Errorf("percentile() of an empty histogram = %d, want 0", got)
+	}
+}
+
+func Test_latencyBucket(t *testing.T) {
+	for _, usec := range []int64{1, 15, 16, 17, 100, 1000, 123456, 1 << 40} {
+		bucket := latencyBucket(usec)
+		if value := latencyBucketValue(bucket); value < usec || float64(value-usec) > float64(usec)/LATENCY_SUB_BUCKETS {
+			t.Errorf("latencyBucketValue(latencyBucket(%d)) = %d", usec, value)
+		}
+	}
+}
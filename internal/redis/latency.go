@@ -0,0 +1,488 @@
+package redis
+
+import (
+	"codecrafters/internal/array"
+	"codecrafters/internal/serde"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many samples are kept for each event, at most one a second
+const LATENCY_TS_LEN = 160
+
+// Width of LATENCY GRAPH in characters
+const LATENCY_GRAPH_COLS = 80
+
+// Events the latency monitor times
+const (
+	LATENCY_EVENT_COMMAND      = "command"
+	LATENCY_EVENT_FAST_COMMAND = "fast-command"
+	LATENCY_EVENT_SAVE         = "save"
+	LATENCY_EVENT_EXPIRE_CYCLE = "expire-cycle"
+)
+
+var latencyHelp = []string{
+	"LATENCY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return a human readable latency analysis report.",
+	"GRAPH <event>",
+	"    Return an ASCII latency graph for the <event> class.",
+	"HISTORY <event>",
+	"    Return time-latency samples for the <event> class.",
+	"LATEST",
+	"    Return the latest latency samples for all events.",
+	"RESET [<event> ...]",
+	"    Reset latency data of one or more <event> classes.",
+	"    (default: reset all data for all event classes)",
+	"HISTOGRAM [COMMAND ...]",
+	"    Return a cumulative distribution of latencies in the format of a histogram for the specified command names.",
+	"    If no commands are specified then all histograms are replied.",
+	"HELP",
+	"    Print this help.",
+}
+
+type latencySample struct {
+	// Unix time in seconds
+	time    int64
+	latency int64
+}
+
+// latencyTimeSeries is a ring of the latest samples for an event
+type latencyTimeSeries struct {
+	samples [LATENCY_TS_LEN]latencySample
+	// Where the next sample goes
+	index int
+	max   int64
+}
+
+// ordered returns the samples oldest first
+func (ts *latencyTimeSeries) ordered() []latencySample {
+	samples := []latencySample{}
+
+	for i := 0; i < LATENCY_TS_LEN; i++ {
+		if sample := ts.samples[(ts.index+i)%LATENCY_TS_LEN]; sample.time != 0 {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+// add records a sample, merging it with the previous one should they fall in the
+// same second
+func (ts *latencyTimeSeries) add(now int64, latency int64) {
+	ts.max = max(ts.max, latency)
+
+	previous := &ts.samples[(ts.index+LATENCY_TS_LEN-1)%LATENCY_TS_LEN]
+
+	if previous.time == now {
+		previous.latency = max(previous.latency, latency)
+		return
+	}
+
+	ts.samples[ts.index] = latencySample{now, latency}
+	ts.index = (ts.index + 1) % LATENCY_TS_LEN
+}
+
+// latencyMonitor keeps the history of events that took longer than the
+// latency-monitor-threshold
+type latencyMonitor struct {
+	mutex *sync.Mutex
+	// Events taking at least this long are sampled, with 0 disabling the monitor
+	threshold time.Duration
+	events    map[string]*latencyTimeSeries
+}
+
+func newLatencyMonitor() *latencyMonitor {
+	return &latencyMonitor{mutex: &sync.Mutex{}, events: map[string]*latencyTimeSeries{}}
+}
+
+func (m *latencyMonitor) setThreshold(threshold time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.threshold = threshold
+}
+
+func (m *latencyMonitor) enabled() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.threshold > 0
+}
+
+// addSampleIfNeeded records the event should it have reached the threshold
+func (m *latencyMonitor) addSampleIfNeeded(event string, duration time.Duration) {
+	m.addSample(event, duration, time.Now())
+}
+
+func (m *latencyMonitor) addSample(event string, duration time.Duration, now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.threshold <= 0 || duration < m.threshold {
+		return
+	}
+
+	ts, ok := m.events[event]
+
+	if !ok {
+		ts = &latencyTimeSeries{}
+		m.events[event] = ts
+	}
+	ts.add(now.Unix(), duration.Milliseconds())
+}
+
+// sortedEvents returns the names of the events with samples, in order
+func (m *latencyMonitor) sortedEvents() []string {
+	events := make([]string, 0, len(m.events))
+
+	for event := range m.events {
+		events = append(events, event)
+	}
+	slices.Sort(events)
+	return events
+}
+
+type latestLatency struct {
+	event  string
+	sample latencySample
+	max    int64
+}
+
+func (m *latencyMonitor) latest() []latestLatency {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	latest := []latestLatency{}
+
+	for _, event := range m.sortedEvents() {
+		ts := m.events[event]
+		latest = append(latest, latestLatency{event, ts.samples[(ts.index+LATENCY_TS_LEN-1)%LATENCY_TS_LEN], ts.max})
+	}
+	return latest
+}
+
+// history returns a copy of the event's samples, if it has any
+func (m *latencyMonitor) history(event string) (latencyTimeSeries, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ts, ok := m.events[event]
+
+	if !ok {
+		return latencyTimeSeries{}, false
+	}
+	return *ts, true
+}
+
+// reset forgets the given events, or every event if none are given, returning
+// how many it forgot
+func (m *latencyMonitor) reset(events ...string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(events) == 0 {
+		count := len(m.events)
+		m.events = map[string]*latencyTimeSeries{}
+		return count
+	}
+
+	count := 0
+
+	for _, event := range events {
+		if _, ok := m.events[event]; ok {
+			delete(m.events, event)
+			count++
+		}
+	}
+	return count
+}
+
+// Characters a sparkline is drawn with, from the lowest to the highest part of a
+// row, and what fills the rows below
+const (
+	SPARKLINE_CHARSET = "_o#"
+	SPARKLINE_FULL    = '|'
+)
+
+type sparklineSample struct {
+	value int64
+	label string
+}
+
+// renderSparkline draws samples as columns rows high, each labelled underneath
+// with its label written downwards. Samples that don't fit across are drawn as
+// another sparkline below.
+func renderSparkline(samples []sparklineSample, columns int, rows int) string {
+	b := strings.Builder{}
+
+	if len(samples) == 0 {
+		return ""
+	}
+
+	low, high := samples[0].value, samples[0].value
+
+	for _, sample := range samples {
+		low, high = min(low, sample.value), max(high, sample.value)
+	}
+
+	span := float64(high - low)
+
+	if span == 0 {
+		span = 1
+	}
+
+	steps := len(SPARKLINE_CHARSET) * rows
+
+	for offset := 0; offset < len(samples); offset += columns {
+		if offset > 0 {
+			b.WriteString("\n")
+		}
+
+		chunk := samples[offset:min(offset+columns, len(samples))]
+		line := make([]byte, len(chunk))
+
+		for row := 0; row < rows; row++ {
+			for i, sample := range chunk {
+				step := min(max(int(float64(sample.value-low)*float64(steps)/span), 0), steps-1)
+				char := step - (rows-row-1)*len(SPARKLINE_CHARSET)
+
+				switch {
+				case char >= len(SPARKLINE_CHARSET):
+					line[i] = SPARKLINE_FULL
+				case char >= 0:
+					line[i] = SPARKLINE_CHARSET[char]
+				default:
+					line[i] = ' '
+				}
+			}
+			b.Write(line)
+			b.WriteString("\n")
+		}
+
+		// A blank line separates the sparkline from its labels
+		b.WriteString(strings.Repeat(" ", len(chunk)) + "\n")
+
+		for row := 0; ; row++ {
+			more := false
+
+			for i, sample := range chunk {
+				line[i] = ' '
+
+				if row < len(sample.label) {
+					line[i] = sample.label[row]
+					more = true
+				}
+			}
+
+			if !more {
+				break
+			}
+			b.Write(line)
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// latencyAge labels a sample with how long ago it was taken, as in 5m
+func latencyAge(elapsed int64) string {
+	switch {
+	case elapsed < 60:
+		return fmt.Sprintf("%ds", elapsed)
+	case elapsed < 3600:
+		return fmt.Sprintf("%dm", elapsed/60)
+	case elapsed < 3600*24:
+		return fmt.Sprintf("%dh", elapsed/3600)
+	default:
+		return fmt.Sprintf("%dd", elapsed/(3600*24))
+	}
+}
+
+// latencyGraph draws the event's samples for LATENCY GRAPH
+func latencyGraph(event string, ts latencyTimeSeries, now time.Time) string {
+	samples := ts.ordered()
+	sparkline := make([]sparklineSample, len(samples))
+	low, high := int64(0), int64(0)
+
+	for i, sample := range samples {
+		if i == 0 {
+			low, high = sample.latency, sample.latency
+		}
+		low, high = min(low, sample.latency), max(high, sample.latency)
+		sparkline[i] = sparklineSample{sample.latency, latencyAge(now.Unix() - sample.time)}
+	}
+
+	return fmt.Sprintf("%s - high %d ms, low %d ms (all time high %d ms)\n", event, high, low, ts.max) +
+		strings.Repeat("-", LATENCY_GRAPH_COLS) + "\n" +
+		renderSparkline(sparkline, LATENCY_GRAPH_COLS, 4)
+}
+
+// latencyStats sums up an event's samples for LATENCY DOCTOR
+type latencyStats struct {
+	samples int
+	average int64
+	// Mean absolute deviation from the average
+	deviation int64
+	// Average seconds between samples
+	period float64
+}
+
+func analyzeLatency(ts latencyTimeSeries, now time.Time) latencyStats {
+	samples := ts.ordered()
+	stats := latencyStats{samples: len(samples)}
+
+	if len(samples) == 0 {
+		return stats
+	}
+
+	sum := int64(0)
+
+	for _, sample := range samples {
+		sum += sample.latency
+	}
+	stats.average = sum / int64(len(samples))
+
+	deviations := int64(0)
+
+	for _, sample := range samples {
+		deviations += int64(math.Abs(float64(sample.latency - stats.average)))
+	}
+	stats.deviation = deviations / int64(len(samples))
+	stats.period = float64(max(now.Unix()-samples[0].time, 1)) / float64(len(samples))
+	return stats
+}
+
+// latencyAdvice suggests what might be done about spikes of each event
+var latencyAdvice = map[string]string{
+	LATENCY_EVENT_COMMAND:      "Check your Slow Log to understand what are the commands you are running which are too slow to execute. Please check https://redis.io/commands/slowlog for more information.",
+	LATENCY_EVENT_FAST_COMMAND: "Commands that should run in constant time are slow, which usually means the system itself is slow to schedule the server. Check the intrinsic latency of your environment, and whether the server is swapping or sharing its CPU.",
+	LATENCY_EVENT_SAVE:         "Saving the dataset holds up every other command while it runs. Consider saving less often with the save directive, or having a replica do the saving instead.",
+	LATENCY_EVENT_EXPIRE_CYCLE: "Many keys are expiring at the same time, so removing them takes a while. Consider spreading the expiry of your keys over a wider window of time.",
+}
+
+// latencyDoctor writes the report for LATENCY DOCTOR
+func (m *latencyMonitor) latencyDoctor(now time.Time) string {
+	if !m.enabled() {
+		return "I'm sorry, Dave, I can't do that. Latency monitoring is disabled in this Redis instance. You may use \"CONFIG SET latency-monitor-threshold <milliseconds>.\" in order to enable it. If we weren't in a deep space mission I'd suggest to take a look at https://redis.io/topics/latency-monitor.\n"
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	events := m.sortedEvents()
+
+	if len(events) == 0 {
+		return "Dave, no latency spike was observed during the lifetime of this Redis instance, not in the slightest bit. I honestly think you ought to sleep better at night.\n"
+	}
+
+	b := strings.Builder{}
+	b.WriteString("Dave, I have observed latency spikes in this Redis instance. You don't mind talking about it, do you Dave?\n\n")
+
+	for i, event := range events {
+		ts := m.events[event]
+		stats := analyzeLatency(*ts, now)
+
+		fmt.Fprintf(&b, "%d. %s: %d latency spikes (average %dms, mean deviation %dms, period %.2f sec). Worst all time event %dms.\n", i+1, event, stats.samples, stats.average, stats.deviation, stats.period, ts.max)
+	}
+
+	b.WriteString("\nI have a few advices for you:\n\n")
+
+	for _, event := range events {
+		if advice, ok := latencyAdvice[event]; ok {
+			fmt.Fprintf(&b, "- %s\n", advice)
+		}
+	}
+	return b.String()
+}
+
+// mapReply replies with a map to RESP3 clients, and a flat array of the keys
+// and values otherwise
+func (r *Redis) mapReply(connection RedisConnection, fields []serde.Value) serde.Value {
+	if r.clients.protocol(connection.id) >= 3 {
+		return serde.NewMap(fields)
+	}
+	return serde.NewArray(fields)
+}
+
+// latencyHistogramReply replies with how long each command took, counted in powers of
+// two. Commands with subcommands may be given by name to include all of them.
+func (r *Redis) latencyHistogramReply(names []string, connection RedisConnection) serde.Value {
+	r.stats.mutex.Lock()
+	defer r.stats.mutex.Unlock()
+
+	wanted := func(name string) bool {
+		if len(names) == 0 {
+			return true
+		}
+
+		container, _, _ := strings.Cut(name, "|")
+		return slices.ContainsFunc(names, func(n string) bool {
+			n = strings.ToLower(n)
+			return n == name || n == container
+		})
+	}
+
+	fields := []serde.Value{}
+
+	for _, name := range r.stats.sortedCommandNames() {
+		stats := r.stats.commands[name]
+
+		if stats.calls == 0 || !wanted(name) {
+			continue
+		}
+
+		buckets := []serde.Value{}
+
+		for _, bucket := range stats.latency.cumulativePowersOfTwo() {
+			buckets = append(buckets, serde.NewInteger(bucket[0]), serde.NewInteger(bucket[1]))
+		}
+
+		fields = append(fields, serde.NewBulkString(name), r.mapReply(connection, []serde.Value{
+			serde.NewBulkString("calls"), serde.NewInteger(stats.calls),
+			serde.NewBulkString("histogram_usec"), r.mapReply(connection, buckets),
+		}))
+	}
+	return r.mapReply(connection, fields)
+}
+
+func (r *Redis) latencyCommand(args []string, connection RedisConnection) []serde.Value {
+	switch strings.ToLower(args[0]) {
+	case "latest":
+		return []serde.Value{serde.NewArray(array.Map(r.latency.latest(), func(latest latestLatency) serde.Value {
+			return serde.NewArray([]serde.Value{
+				serde.NewBulkString(latest.event),
+				serde.NewInteger(latest.sample.time),
+				serde.NewInteger(latest.sample.latency),
+				serde.NewInteger(latest.max),
+			})
+		}))}
+	case "history":
+		ts, _ := r.latency.history(args[1])
+
+		return []serde.Value{serde.NewArray(array.Map(ts.ordered(), func(sample latencySample) serde.Value {
+			return serde.NewArray([]serde.Value{serde.NewInteger(sample.time), serde.NewInteger(sample.latency)})
+		}))}
+	case "graph":
+		ts, ok := r.latency.history(args[1])
+
+		if !ok {
+			return []serde.Value{serde.NewError(fmt.Sprintf("ERR No samples available for event '%s'", args[1]))}
+		}
+		return []serde.Value{serde.NewBulkString(latencyGraph(args[1], ts, time.Now()))}
+	case "doctor":
+		return []serde.Value{serde.NewBulkString(r.latency.latencyDoctor(time.Now()))}
+	case "reset":
+		return []serde.Value{serde.NewInteger(int64(r.latency.reset(args[1:]...)))}
+	case "histogram":
+		return []serde.Value{r.latencyHistogramReply(args[1:], connection)}
+	case "help":
+		return []serde.Value{serde.NewArray(array.Map(latencyHelp, func(line string) serde.Value {
+			return serde.NewSimpleString(line)
+		}))}
+	default:
+		return []serde.Value{serde.NewError(fmt.Sprintf("ERR unknown subcommand '%s'. Try LATENCY HELP.", args[0]))}
+	}
+}
@@ -0,0 +1,119 @@
+package redis
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_latencyTimeSeries(t *testing.T) {
+	ts := latencyTimeSeries{}
+	ts.add(100, 5)
+	ts.add(100, 20)
+	ts.add(100, 10)
+	ts.add(101, 7)
+
+	if want := []latencySample{{100, 20}, {101, 7}}; !reflect.DeepEqual(ts.ordered(), want) {
+		t.Errorf("Expected samples in the same second to be merged into %v, got %v", want, ts.ordered())
+	}
+
+	for now := int64(200); now < 200+LATENCY_TS_LEN+10; now++ {
+		ts.add(now, 1)
+	}
+
+	samples := ts.ordered()
+
+	if len(samples) != LATENCY_TS_LEN || samples[0].time != 210 || ts.max != 20 {
+		t.Errorf("Expected the oldest samples to be dropped while keeping the max, got %d samples from %d, max %d", len(samples), samples[0].time, ts.max)
+	}
+}
+
+func Test_latencyMonitor(t *testing.T) {
+	monitor := newLatencyMonitor()
+	now := time.Unix(1000, 0)
+
+	monitor.addSample(LATENCY_EVENT_COMMAND, time.Second, now)
+
+	if got := monitor.latest(); len(got) != 0 {
+		t.Fatalf("Expected nothing to be sampled while disabled, got %v", got)
+	}
+
+	monitor.setThreshold(100 * time.Millisecond)
+	monitor.addSample(LATENCY_EVENT_COMMAND, 99*time.Millisecond, now)
+	monitor.addSample(LATENCY_EVENT_COMMAND, 300*time.Millisecond, now)
+	monitor.addSample(LATENCY_EVENT_SAVE, 200*time.Millisecond, now.Add(time.Second))
+	monitor.addSample(LATENCY_EVENT_SAVE, 150*time.Millisecond, now.Add(2*time.Second))
+
+	want := []latestLatency{
+		{LATENCY_EVENT_COMMAND, latencySample{1000, 300}, 300},
+		{LATENCY_EVENT_SAVE, latencySample{1002, 150}, 200},
+	}
+
+	if got := monitor.latest(); !reflect.DeepEqual(got, want) {
+		t.Errorf("latest() = %v, want %v", got, want)
+	}
+
+	if got := monitor.reset(LATENCY_EVENT_SAVE, "unknown"); got != 1 {
+		t.Errorf("Expected one event to be reset, got %d", got)
+	}
+
+	if got := monitor.reset(); got != 1 {
+		t.Errorf("Expected the remaining event to be reset, got %d", got)
+	}
+}
+
+func Test_latencyGraph(t *testing.T) {
+	ts := latencyTimeSeries{}
+	ts.add(1000, 10)
+	ts.add(1010, 40)
+	ts.add(1100, 25)
+
+	want := strings.Join([]string{
+		"command - high 40 ms, low 10 ms (all time high 40 ms)",
+		strings.Repeat("-", LATENCY_GRAPH_COLS),
+		" # ",
+		" |_",
+		" ||",
+		"_||",
+		"   ",
+		"119",
+		"mms",
+		"",
+	}, "\n")
+
+	if got := latencyGraph("command", ts, time.Unix(1109, 0)); got != want {
+		t.Errorf("latencyGraph() = \n%s\nwant\n%s", got, want)
+	}
+}
+
+func Test_latencyAge(t *testing.T) {
+	tests := []struct {
+		elapsed int64
+		want    string
+	}{
+		{elapsed: 5, want: "5s"},
+		{elapsed: 120, want: "2m"},
+		{elapsed: 7200, want: "2h"},
+		{elapsed: 3 * 86400, want: "3d"},
+	}
+	for _, tt := range tests {
+		if got := latencyAge(tt.elapsed); got != tt.want {
+			t.Errorf("latencyAge(%d) = %s, want %s", tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func Test_cumulativePowersOfTwo(t *testing.T) {
+	h := &latencyHistogram{}
+
+	for _, usec := range []int64{1, 1, 3, 4, 100, 1000} {
+		h.record(usec)
+	}
+
+	want := [][2]int64{{1, 2}, {4, 4}, {128, 5}, {1024, 6}}
+
+	if got := h.cumulativePowersOfTwo(); !reflect.DeepEqual(got, want) {
+		t.Errorf("cumulativePowersOfTwo() = %v, want %v", got, want)
+	}
+}
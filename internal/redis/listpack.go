@@ -0,0 +1,224 @@
+package redis
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// Listpack entry encodings, which is how Redis packs the nodes of a stream:
+// https://github.com/antirez/listpack/blob/master/listpack.md
+const (
+	LP_ENCODING_7BIT_UINT  = 0x00
+	LP_ENCODING_6BIT_STR   = 0x80
+	LP_ENCODING_13BIT_INT  = 0xC0
+	LP_ENCODING_12BIT_STR  = 0xE0
+	LP_ENCODING_32BIT_STR  = 0xF0
+	LP_ENCODING_16BIT_INT  = 0xF1
+	LP_ENCODING_24BIT_INT  = 0xF2
+	LP_ENCODING_32BIT_INT  = 0xF3
+	LP_ENCODING_64BIT_INT  = 0xF4
+	LP_EOF                 = 0xFF
+	LP_HEADER_SIZE         = 6
+	LP_NUMELE_UNKNOWN      = 65535
+	LP_BACKLEN_CONTINUE    = 0x80
+	LP_BACKLEN_VALUE_BITS  = 7
+	LP_BACKLEN_VALUE_MASK  = 0x7F
+	LP_6BIT_STR_MAX_LEN    = 63
+	LP_12BIT_STR_MAX_LEN   = 4095
+	LP_7BIT_UINT_MAX_VALUE = 127
+	LP_13BIT_INT_MIN_VALUE = -4096
+	LP_13BIT_INT_MAX_VALUE = 4095
+)
+
+var errInvalidListpack = errors.New("invalid listpack")
+
+// listpackWriter builds a listpack one entry at a time
+type listpackWriter struct {
+	entries []byte
+	count   int
+}
+
+// appendEntry adds an encoded entry, followed by its length written backwards so
+// that the listpack can be walked from either end
+func (w *listpackWriter) appendEntry(entry []byte) {
+	w.entries = append(w.entries, entry...)
+	w.entries = appendListpackBacklen(w.entries, len(entry))
+	w.count++
+}
+
+func (w *listpackWriter) appendString(value string) {
+	var entry []byte
+
+	switch n := len(value); {
+	case n <= LP_6BIT_STR_MAX_LEN:
+		entry = []byte{LP_ENCODING_6BIT_STR | byte(n)}
+	case n <= LP_12BIT_STR_MAX_LEN:
+		entry = []byte{LP_ENCODING_12BIT_STR | byte(n>>8), byte(n)}
+	default:
+		entry = binary.LittleEndian.AppendUint32([]byte{LP_ENCODING_32BIT_STR}, uint32(n))
+	}
+
+	w.appendEntry(append(entry, value...))
+}
+
+func (w *listpackWriter) appendInt(value int64) {
+	var entry []byte
+
+	switch {
+	case value >= 0 && value <= LP_7BIT_UINT_MAX_VALUE:
+		entry = []byte{byte(value)}
+	case value >= LP_13BIT_INT_MIN_VALUE && value <= LP_13BIT_INT_MAX_VALUE:
+		bits := uint16(value) & 0x1FFF
+		entry = []byte{LP_ENCODING_13BIT_INT | byte(bits>>8), byte(bits)}
+	case value >= -1<<15 && value < 1<<15:
+		entry = binary.LittleEndian.AppendUint16([]byte{LP_ENCODING_16BIT_INT}, uint16(value))
+	case value >= -1<<23 && value < 1<<23:
+		entry = []byte{LP_ENCODING_24BIT_INT, byte(value), byte(value >> 8), byte(value >> 16)}
+	case value >= -1<<31 && value < 1<<31:
+		entry = binary.LittleEndian.AppendUint32([]byte{LP_ENCODING_32BIT_INT}, uint32(value))
+	default:
+		entry = binary.LittleEndian.AppendUint64([]byte{LP_ENCODING_64BIT_INT}, uint64(value))
+	}
+
+	w.appendEntry(entry)
+}
+
+// bytes returns the finished listpack, headed by its total size and number of
+// entries
+func (w *listpackWriter) bytes() []byte {
+	count := min(w.count, LP_NUMELE_UNKNOWN)
+
+	listpack := binary.LittleEndian.AppendUint32(nil, uint32(LP_HEADER_SIZE+len(w.entries)+1))
+	listpack = binary.LittleEndian.AppendUint16(listpack, uint16(count))
+	listpack = append(listpack, w.entries...)
+	return append(listpack, LP_EOF)
+}
+
+// appendListpackBacklen encodes the length of an entry with its most significant
+// bits first, each byte but the first flagged to say that more came before it
+func appendListpackBacklen(buf []byte, length int) []byte {
+	size := listpackBacklenSize(length)
+
+	for i := size - 1; i >= 0; i-- {
+		b := byte(length>>(i*LP_BACKLEN_VALUE_BITS)) & LP_BACKLEN_VALUE_MASK
+
+		if i < size-1 {
+			b |= LP_BACKLEN_CONTINUE
+		}
+		buf = append(buf, b)
+	}
+
+	return buf
+}
+
+func listpackBacklenSize(length int) int {
+	switch {
+	case length < 1<<7:
+		return 1
+	case length < 1<<14-1:
+		return 2
+	case length < 1<<21-1:
+		return 3
+	case length < 1<<28-1:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// parseListpack returns the entries of a listpack, with integers given as their
+// decimal strings
+func parseListpack(listpack []byte) ([]string, error) {
+	if len(listpack) < LP_HEADER_SIZE+1 || int(binary.LittleEndian.Uint32(listpack)) != len(listpack) {
+		return nil, errInvalidListpack
+	}
+
+	entries := []string{}
+	data := listpack[LP_HEADER_SIZE:]
+
+	for len(data) > 0 && data[0] != LP_EOF {
+		value, size, err := parseListpackEntry(data)
+
+		if err != nil {
+			return nil, err
+		}
+
+		size += listpackBacklenSize(size)
+
+		if size > len(data) {
+			return nil, errInvalidListpack
+		}
+
+		entries = append(entries, value)
+		data = data[size:]
+	}
+
+	if len(data) != 1 {
+		return nil, errInvalidListpack
+	}
+
+	return entries, nil
+}
+
+// parseListpackEntry decodes the entry at the start of data, returning its value
+// along with how many bytes it takes up before its backlen
+func parseListpackEntry(data []byte) (string, int, error) {
+	encoding := data[0]
+
+	// Takes the entry's header and the size it says follows, provided it's all there
+	take := func(header int, size int) (string, int, error) {
+		if header+size > len(data) {
+			return "", 0, errInvalidListpack
+		}
+		return string(data[header : header+size]), header + size, nil
+	}
+
+	signed := func(header int, bits int) (string, int, error) {
+		if header+bits/8 > len(data) {
+			return "", 0, errInvalidListpack
+		}
+
+		raw := make([]byte, 8)
+		copy(raw, data[header:header+bits/8])
+		// Shifting the value to the top and back again extends its sign
+		value := int64(binary.LittleEndian.Uint64(raw)<<(64-bits)) >> (64 - bits)
+		return strconv.FormatInt(value, 10), header + bits/8, nil
+	}
+
+	switch {
+	case encoding&0x80 == LP_ENCODING_7BIT_UINT:
+		return strconv.Itoa(int(encoding)), 1, nil
+	case encoding&0xC0 == LP_ENCODING_6BIT_STR:
+		return take(1, int(encoding&0x3F))
+	case encoding&0xE0 == LP_ENCODING_13BIT_INT:
+		if len(data) < 2 {
+			return "", 0, errInvalidListpack
+		}
+		value := int64(uint64(encoding&0x1F)<<8|uint64(data[1])) << 51 >> 51
+		return strconv.FormatInt(value, 10), 2, nil
+	case encoding&0xF0 == LP_ENCODING_12BIT_STR:
+		if len(data) < 2 {
+			return "", 0, errInvalidListpack
+		}
+		return take(2, int(encoding&0x0F)<<8|int(data[1]))
+	}
+
+	switch encoding {
+	case LP_ENCODING_32BIT_STR:
+		if len(data) < 5 {
+			return "", 0, errInvalidListpack
+		}
+		return take(5, int(binary.LittleEndian.Uint32(data[1:])))
+	case LP_ENCODING_16BIT_INT:
+		return signed(1, 16)
+	case LP_ENCODING_24BIT_INT:
+		return signed(1, 24)
+	case LP_ENCODING_32BIT_INT:
+		return signed(1, 32)
+	case LP_ENCODING_64BIT_INT:
+		return signed(1, 64)
+	default:
+		return "", 0, errInvalidListpack
+	}
+}
@@ -0,0 +1,61 @@
+package redis
+
+import (
+	"codecrafters/internal/kvstore"
+	"codecrafters/internal/serde"
+	"context"
+)
+
+// deniedOnOOM reports whether a command is refused once the dataset is over
+// maxmemory. EXEC is refused should any of the commands it would run be.
+func (r *Redis) deniedOnOOM(cmd string, args []string, connection RedisConnection) bool {
+	if cmd != EXEC {
+		return lookupCommand(cmd, args).hasFlag(CMD_DENYOOM)
+	}
+
+	for _, command := range connection.bufferedCommands {
+		queuedCmd, queuedArgs, err := r.parseCommand(command)
+
+		if err == nil && r.deniedOnOOM(queuedCmd, queuedArgs, connection) {
+			return true
+		}
+	}
+	return false
+}
+
+// makeRoom evicts keys until the dataset is back within maxmemory, returning
+// kvstore.ErrOutOfMemory should it not manage to when the command would need more
+// memory
+func (r *Redis) makeRoom(ctx context.Context, cmd string, args []string, connection RedisConnection) error {
+	if !r.store.OverMemoryLimit() {
+		return nil
+	}
+
+	if err := r.evict(ctx); err != nil && r.deniedOnOOM(cmd, args, connection) {
+		return kvstore.ErrOutOfMemory
+	}
+	return nil
+}
+
+// evict removes keys chosen by the eviction policy, deleting them on replicas and
+// invalidating them for tracking clients. Replicas leave eviction to their master,
+// whose deletes they follow.
+func (r *Redis) evict(ctx context.Context) error {
+	r.executionMutex.Lock()
+	defer r.executionMutex.Unlock()
+
+	if r.configuration.replicationConfig.replicaConfig.Role() == SLAVE {
+		return nil
+	}
+
+	evicted, err := r.store.Evict(ctx)
+
+	for _, key := range evicted {
+		r.propagate(serde.NewArray([]serde.Value{serde.NewBulkString("DEL"), serde.NewBulkString(key)}))
+	}
+
+	// No client wrote the evicted keys, so even those tracking with NOLOOP hear
+	// about them
+	r.invalidateTrackedKeys(0)
+	return err
+}
@@ -0,0 +1,82 @@
+package redis
+
+import (
+	"bytes"
+	"codecrafters/internal/kvstore"
+	"codecrafters/internal/serde"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRedis_makeRoom(t *testing.T) {
+	r := &Redis{
+		store:          kvstore.NewKVStore(),
+		configuration:  configurationOptions{replicationConfig: replicationConfig{replicaConfig: masterConfig{}}},
+		executionMutex: &sync.Mutex{},
+		clients:        newClientRegistry(),
+		tracking:       newTrackingState(),
+		replication:    newReplicationState(REPL_BACKLOG_MIN_SIZE, true),
+	}
+	tracking := r.tracking
+	r.store.OnKeyModified(tracking.keyModified)
+
+	for i := range 10 {
+		r.store.SetKeyWithExpiresAt(fmt.Sprintf("key:%d", i), "value", nil)
+	}
+
+	// A client tracking every key, which it isn't the one to change
+	server, client := net.Pipe()
+	defer client.Close()
+	connection := NewRedisConnection(server)
+	r.clients.register(&connection, CLIENT_TYPE_NORMAL)
+	r.clients.setProtocol(connection.id, 3)
+
+	if err := r.tracking.enable(connection.id, trackingOptions{bcast: true, noLoop: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	r.store.SetEvictionConfig(kvstore.EvictionConfig{MaxMemory: r.store.UsedMemory() / 2, Policy: kvstore.EVICTION_ALLKEYS_RANDOM, Samples: 5})
+	invalidations := make(chan []byte)
+
+	go func() {
+		buffer := make([]byte, 4096)
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		n, _ := client.Read(buffer)
+		invalidations <- buffer[:n]
+	}()
+
+	if err := r.makeRoom(context.Background(), SET, []string{"foo", "bar"}, connection); err != nil {
+		t.Fatalf("makeRoom() error = %v", err)
+	}
+
+	stream, _ := r.replication.backlog.since(r.replication.backlog.start())
+	invalidated := <-invalidations
+	evicted := 0
+
+	for i := range 10 {
+		key := fmt.Sprintf("key:%d", i)
+
+		if _, ok := r.store.ReadKey(context.Background(), key); ok {
+			continue
+		}
+		evicted++
+
+		del := serde.NewArray([]serde.Value{serde.NewBulkString("DEL"), serde.NewBulkString(key)}).Marshal()
+
+		if !bytes.Contains(stream, del) {
+			t.Errorf("Expected %s to be deleted on replicas, got %q", key, stream)
+		}
+
+		if !bytes.Contains(invalidated, []byte(key)) {
+			t.Errorf("Expected %s to be invalidated, got %q", key, invalidated)
+		}
+	}
+
+	if evicted == 0 {
+		t.Errorf("Expected keys to be evicted")
+	}
+}
@@ -0,0 +1,182 @@
+package redis
+
+import (
+	"codecrafters/internal/array"
+	"codecrafters/internal/serde"
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var memoryHelp = []string{
+	"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return memory problems reports.",
+	"MALLOC-STATS",
+	"    Return internal statistics report from the memory allocator.",
+	"STATS",
+	"    Return information about the memory usage of the server.",
+	"USAGE <key> [SAMPLES <count>]",
+	"    Return memory in bytes used by <key> and its value. Nested values are",
+	"    sampled up to <count> times (default: 5, 0 means sample all).",
+	"HELP",
+	"    Print this help.",
+}
+
+// Below this much memory in use the doctor has too little to go on
+const MEMORY_DOCTOR_MIN_ALLOCATED = 5 * 1024 * 1024
+
+// memoryOverview breaks down where the server's memory is going
+type memoryOverview struct {
+	peak uint64
+	// Allocated on the heap, now and when the server started
+	total   uint64
+	startup uint64
+	// Heap spans in use, and everything obtained from the OS
+	active   uint64
+	resident uint64
+	dataset  int64
+	keys     int
+}
+
+func (r *Redis) memoryOverview() memoryOverview {
+	mem := r.stats.memoryUsed()
+
+	return memoryOverview{
+		peak:     r.stats.peakMemory.Load(),
+		total:    mem.HeapAlloc,
+		startup:  r.stats.startupMemory,
+		active:   mem.HeapInuse,
+		resident: mem.Sys,
+		dataset:  r.store.UsedMemory(),
+		keys:     r.store.KeyCount(),
+	}
+}
+
+func (m memoryOverview) fragmentation() float64 {
+	return float64(m.resident) / float64(max(m.total, 1))
+}
+
+func formatMemoryFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func (r *Redis) memoryStats(connection RedisConnection) []serde.Value {
+	m := r.memoryOverview()
+	overhead := max(int64(m.total)-m.dataset, 0)
+	bytesPerKey := int64(0)
+
+	if m.keys > 0 {
+		bytesPerKey = int64(m.total-min(m.startup, m.total)) / int64(m.keys)
+	}
+
+	stats := []struct {
+		name  string
+		value serde.Value
+	}{
+		{"peak.allocated", serde.NewInteger(int64(m.peak))},
+		{"total.allocated", serde.NewInteger(int64(m.total))},
+		{"startup.allocated", serde.NewInteger(int64(m.startup))},
+		{"overhead.total", serde.NewInteger(overhead)},
+		{"keys.count", serde.NewInteger(int64(m.keys))},
+		{"keys.bytes-per-key", serde.NewInteger(bytesPerKey)},
+		{"dataset.bytes", serde.NewInteger(m.dataset)},
+		{"dataset.percentage", serde.NewBulkString(formatMemoryFloat(float64(m.dataset) * 100 / float64(max(m.total-min(m.startup, m.total), 1))))},
+		{"peak.percentage", serde.NewBulkString(formatMemoryFloat(float64(m.total) * 100 / float64(max(m.peak, 1))))},
+		{"allocator.allocated", serde.NewInteger(int64(m.total))},
+		{"allocator.active", serde.NewInteger(int64(m.active))},
+		{"allocator.resident", serde.NewInteger(int64(m.resident))},
+		{"fragmentation", serde.NewBulkString(formatMemoryFloat(m.fragmentation()))},
+		{"fragmentation.bytes", serde.NewInteger(int64(m.resident) - int64(m.total))},
+	}
+
+	fields := []serde.Value{}
+
+	for _, stat := range stats {
+		fields = append(fields, serde.NewBulkString(stat.name), stat.value)
+	}
+
+	if r.clients.protocol(connection.id) >= 3 {
+		return []serde.Value{serde.NewMap(fields)}
+	}
+	return []serde.Value{serde.NewArray(fields)}
+}
+
+// memoryDoctorReport looks for signs of trouble in how memory is being used
+func memoryDoctorReport(m memoryOverview) string {
+	if m.total < MEMORY_DOCTOR_MIN_ALLOCATED {
+		return "Hi Sam, this instance is empty or is using very little memory, my issues detector can't be used in these conditions. Please, leave for your mission on Earth and fill it with some data. The new Sam and I will be back to our programming as soon as I finished rebooting."
+	}
+
+	issues := []string{}
+
+	if float64(m.peak) > float64(m.total)*1.5 {
+		issues = append(issues, " * Peak memory: In the past this instance used more than 150% the memory that is currently using. The Go runtime returns memory to the OS gradually after a peak, so you can expect to see a big fragmentation ratio for a while, however this is actually harmless and is only due to the memory peak.")
+	}
+
+	if m.fragmentation() > 1.4 {
+		issues = append(issues, fmt.Sprintf(" * High total RSS: This instance has a memory fragmentation and RSS overhead of %.2f, greater than 1.4 (this means that the memory obtained from the OS is much larger than the sum of the logical allocations Redis performed). This is usually due to a recent peak, or to garbage yet to be collected.", m.fragmentation()))
+	}
+
+	if len(issues) == 0 {
+		return "Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base."
+	}
+
+	return "Sam, I detected a few issues in this Redis instance memory implants:\n\n" + strings.Join(issues, "\n\n") + "\n\nI'm here to keep you safe, Sam. I want to help you.\n"
+}
+
+// mallocStats reports on the Go runtime's allocator, which stands in for the
+// allocator Redis is built with
+func mallocStats() string {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	lines := []string{
+		"___ Begin Go runtime memory statistics ___",
+		fmt.Sprintf("Allocated: %d, active: %d, idle: %d, released: %d, resident: %d", mem.HeapAlloc, mem.HeapInuse, mem.HeapIdle, mem.HeapReleased, mem.Sys),
+		fmt.Sprintf("Objects: %d, mallocs: %d, frees: %d", mem.HeapObjects, mem.Mallocs, mem.Frees),
+		fmt.Sprintf("Stack in use: %d, stack from OS: %d", mem.StackInuse, mem.StackSys),
+		fmt.Sprintf("GC cycles: %d, next GC target: %d, GC metadata: %d", mem.NumGC, mem.NextGC, mem.GCSys),
+		"--- End Go runtime memory statistics ---",
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func (r *Redis) memoryUsage(ctx context.Context, args []string) []serde.Value {
+	// Values are measured in full, so the sample count only needs to be valid
+	if len(args) == 3 && strings.ToLower(args[1]) == "samples" {
+		if samples, err := strconv.Atoi(args[2]); err != nil || samples < 0 {
+			return []serde.Value{serde.NewError("ERR value is not an integer or out of range")}
+		}
+	} else if len(args) != 1 {
+		return []serde.Value{serde.NewError("ERR syntax error")}
+	}
+
+	info, found := r.store.Object(ctx, args[0])
+
+	if !found {
+		return []serde.Value{serde.NewNull()}
+	}
+	return []serde.Value{serde.NewInteger(info.MemoryUsage)}
+}
+
+func (r *Redis) memory(ctx context.Context, args []string, connection RedisConnection) []serde.Value {
+	switch strings.ToLower(args[0]) {
+	case "usage":
+		return r.memoryUsage(ctx, args[1:])
+	case "stats":
+		return r.memoryStats(connection)
+	case "doctor":
+		return []serde.Value{serde.NewBulkString(memoryDoctorReport(r.memoryOverview()))}
+	case "malloc-stats":
+		return []serde.Value{serde.NewBulkString(mallocStats())}
+	case "help":
+		return []serde.Value{serde.NewArray(array.Map(memoryHelp, func(line string) serde.Value {
+			return serde.NewSimpleString(line)
+		}))}
+	default:
+		return []serde.Value{serde.NewError(fmt.Sprintf("ERR unknown subcommand '%s'. Try MEMORY HELP.", args[0]))}
+	}
+}
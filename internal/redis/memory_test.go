@@ -0,0 +1,29 @@
+package redis
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_memoryDoctorReport(t *testing.T) {
+	tests := []struct {
+		name     string
+		overview memoryOverview
+		want     []string
+	}{
+		{name: "It should need some memory in use to go on", overview: memoryOverview{total: 1024, peak: 1024, resident: 1024}, want: []string{"is empty or is using very little memory"}},
+		{name: "It should find nothing wrong", overview: memoryOverview{total: 10 << 20, peak: 10 << 20, resident: 12 << 20}, want: []string{"can't find any memory issue"}},
+		{name: "It should notice a peak", overview: memoryOverview{total: 10 << 20, peak: 20 << 20, resident: 12 << 20}, want: []string{"Peak memory"}},
+		{name: "It should notice fragmentation", overview: memoryOverview{total: 10 << 20, peak: 10 << 20, resident: 20 << 20}, want: []string{"High total RSS", "2.00"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := memoryDoctorReport(tt.overview)
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("memoryDoctorReport() = %q, want it to contain %q", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Upper bounds of the command latency histogram buckets in microseconds, powers
+// of four from 1µs to about 16s
+var metricsLatencyBuckets = func() []int64 {
+	buckets := []int64{}
+
+	for usec := int64(1); usec <= 1<<24; usec <<= 2 {
+		buckets = append(buckets, usec)
+	}
+	return buckets
+}()
+
+type metricLabel struct {
+	name  string
+	value string
+}
+
+// metricsWriter writes metrics in the Prometheus text exposition format
+type metricsWriter struct {
+	strings.Builder
+}
+
+// family starts a metric, which every sample of it must follow
+func (w *metricsWriter) family(name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (w *metricsWriter) sample(name string, value float64, labels ...metricLabel) {
+	w.WriteString(name)
+
+	if len(labels) > 0 {
+		w.WriteString("{")
+
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label.name, escapeLabelValue(label.value))
+		}
+		w.WriteString("}")
+	}
+
+	fmt.Fprintf(w, " %s\n", formatMetricValue(value))
+}
+
+// single writes a metric that has just the one sample
+func (w *metricsWriter) single(name string, kind string, help string, value float64) {
+	w.family(name, kind, help)
+	w.sample(name, value)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case value == math.Trunc(value) && math.Abs(value) < 1<<53:
+		return strconv.FormatInt(int64(value), 10)
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (r *Redis) writeServerMetrics(w *metricsWriter) {
+	// REPLICAOF changes the role while holding the execution lock
+	r.executionMutex.Lock()
+	role := r.configuration.replicationConfig.replicaConfig.Role()
+	r.executionMutex.Unlock()
+
+	w.family("redis_instance_info", "gauge", "Information about the server")
+	w.sample("redis_instance_info", 1,
+		metricLabel{"redis_version", REDIS_VERSION},
+		metricLabel{"role", role},
+		metricLabel{"run_id", r.runId},
+	)
+	w.single("redis_start_time_seconds", "gauge", "When the server started as a Unix timestamp", float64(r.startTime.Unix()))
+	w.single("redis_uptime_in_seconds", "gauge", "Seconds since the server started", time.Since(r.startTime).Seconds())
+}
+
+func (r *Redis) writeClientMetrics(w *metricsWriter) {
+	connected, _ := r.clients.stats()
+
+	w.single("redis_connected_clients", "gauge", "Clients connected, leaving out replicas", float64(connected))
+	w.single("redis_blocked_clients", "gauge", "Clients waiting on a blocking command", float64(r.stats.blockedClients.Load()))
+}
+
+func (r *Redis) writeMemoryMetrics(w *metricsWriter) {
+	mem := r.stats.memoryUsed()
+
+	// CONFIG SET changes maxmemory while holding the execution lock
+	r.executionMutex.Lock()
+	maxMemory := r.configuration.maxMemory
+	r.executionMutex.Unlock()
+
+	w.single("redis_memory_used_bytes", "gauge", "Memory allocated by the server", float64(mem.HeapAlloc))
+	w.single("redis_memory_used_rss_bytes", "gauge", "Memory obtained from the operating system", float64(mem.Sys))
+	w.single("redis_memory_used_peak_bytes", "gauge", "The most memory the server has allocated", float64(r.stats.peakMemory.Load()))
+	w.single("redis_memory_used_dataset_bytes", "gauge", "Memory used by the keys and their values", float64(r.store.UsedMemory()))
+	w.single("redis_memory_max_bytes", "gauge", "The maxmemory limit, or 0 for none", float64(maxMemory))
+}
+
+func (r *Redis) writeKeyspaceMetrics(w *metricsWriter) {
+	types, expires := r.store.KeysByType()
+	keyspace := r.store.Stats()
+
+	total := 0
+	names := make([]string, 0, len(types))
+
+	for name, count := range types {
+		total += count
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	w.family("redis_db_keys", "gauge", "Keys in each database")
+	w.sample("redis_db_keys", float64(total), metricLabel{"db", "db0"})
+	w.family("redis_db_keys_expiring", "gauge", "Keys with an expiry in each database")
+	w.sample("redis_db_keys_expiring", float64(expires), metricLabel{"db", "db0"})
+	w.family("redis_db_keys_by_type", "gauge", "Keys of each type in each database")
+
+	for _, name := range names {
+		w.sample("redis_db_keys_by_type", float64(types[name]), metricLabel{"db", "db0"}, metricLabel{"type", name})
+	}
+
+	w.single("redis_expired_keys_total", "counter", "Keys removed once they expired", float64(keyspace.ExpiredKeys))
+	w.single("redis_evicted_keys_total", "counter", "Keys evicted to keep within maxmemory", float64(keyspace.EvictedKeys))
+	w.single("redis_keyspace_hits_total", "counter", "Lookups of keys that were found", float64(keyspace.Hits))
+	w.single("redis_keyspace_misses_total", "counter", "Lookups of keys that weren't found", float64(keyspace.Misses))
+}
+
+func (r *Redis) writeStatsMetrics(w *metricsWriter) {
+	r.stats.mutex.Lock()
+	defer r.stats.mutex.Unlock()
+
+	w.single("redis_connections_received_total", "counter", "Connections accepted", float64(r.stats.connectionsReceived))
+	w.single("redis_commands_processed_total", "counter", "Commands processed", float64(r.stats.commandsProcessed))
+	w.single("redis_net_input_bytes_total", "counter", "Bytes read from clients", float64(r.stats.netInput.Load()))
+	w.single("redis_net_output_bytes_total", "counter", "Bytes written to clients", float64(r.stats.netOutput.Load()))
+
+	w.family("redis_errors_total", "counter", "Error replies by their prefix")
+	prefixes := make([]string, 0, len(r.stats.errors))
+
+	for prefix := range r.stats.errors {
+		prefixes = append(prefixes, prefix)
+	}
+	slices.Sort(prefixes)
+
+	for _, prefix := range prefixes {
+		w.sample("redis_errors_total", float64(r.stats.errors[prefix]), metricLabel{"err", prefix})
+	}
+
+	names := r.stats.sortedCommandNames()
+
+	w.family("redis_commands_total", "counter", "Calls of each command")
+	for _, name := range names {
+		w.sample("redis_commands_total", float64(r.stats.commands[name].calls), metricLabel{"cmd", name})
+	}
+
+	w.family("redis_commands_rejected_calls_total", "counter", "Calls of each command refused before running")
+	for _, name := range names {
+		w.sample("redis_commands_rejected_calls_total", float64(r.stats.commands[name].rejectedCalls), metricLabel{"cmd", name})
+	}
+
+	w.family("redis_commands_failed_calls_total", "counter", "Calls of each command that replied with an error")
+	for _, name := range names {
+		w.sample("redis_commands_failed_calls_total", float64(r.stats.commands[name].failedCalls), metricLabel{"cmd", name})
+	}
+
+	w.family("redis_command_duration_seconds", "histogram", "How long each command took to run")
+
+	for _, name := range names {
+		stats := r.stats.commands[name]
+		label := metricLabel{"cmd", name}
+
+		for _, usec := range metricsLatencyBuckets {
+			w.sample("redis_command_duration_seconds_bucket", float64(stats.latency.countUpTo(usec)), label, metricLabel{"le", formatMetricValue(float64(usec) / 1e6)})
+		}
+
+		w.sample("redis_command_duration_seconds_bucket", float64(stats.calls), label, metricLabel{"le", "+Inf"})
+		w.sample("redis_command_duration_seconds_sum", float64(stats.usec)/1e6, label)
+		w.sample("redis_command_duration_seconds_count", float64(stats.calls), label)
+	}
+}
+
+func (r *Redis) writeReplicationMetrics(w *metricsWriter) {
+	// These only change while holding the execution lock
+	r.executionMutex.Lock()
+	offset := r.processedByteCount
+	linkUp := r.replication.linkState == REPL_STATE_CONNECTED
+	_, isReplica := r.configuration.replicationConfig.replicaConfig.(slaveConfig)
+	r.executionMutex.Unlock()
+
+	w.single("redis_master_repl_offset", "gauge", "Bytes of the replication stream processed", float64(offset))
+
+	if isReplica {
+		w.single("redis_slave_repl_offset", "gauge", "Bytes of the master's replication stream processed", float64(offset))
+		w.single("redis_master_link_up", "gauge", "Whether the link to the master is up", boolMetric(linkUp))
+	}
+
+	replicas := r.clients.replicas()
+	w.single("redis_connected_slaves", "gauge", "Replicas connected", float64(len(replicas)))
+
+	w.family("redis_connected_slave_offset_bytes", "gauge", "How far each replica has acknowledged replicating")
+	for _, replica := range replicas {
+		w.sample("redis_connected_slave_offset_bytes", float64(replica.offset), metricLabel{"slave_ip", replica.ip}, metricLabel{"slave_port", strconv.Itoa(replica.port)})
+	}
+
+	w.family("redis_connected_slave_lag_seconds", "gauge", "Seconds since each replica last acknowledged")
+	for _, replica := range replicas {
+		lag := 0.0
+
+		if !replica.lastAck.IsZero() {
+			lag = time.Since(replica.lastAck).Seconds()
+		}
+		w.sample("redis_connected_slave_lag_seconds", lag, metricLabel{"slave_ip", replica.ip}, metricLabel{"slave_port", strconv.Itoa(replica.port)})
+	}
+}
+
+func (r *Redis) writePersistenceMetrics(w *metricsWriter) {
+	status := r.persistence.status()
+
+	w.single("redis_rdb_changes_since_last_save", "gauge", "Changes to the dataset since it was last saved", float64(status.dirty))
+	w.single("redis_rdb_last_save_timestamp_seconds", "gauge", "When the dataset was last saved as a Unix timestamp", float64(status.lastSave.Unix()))
+	w.single("redis_rdb_last_bgsave_status", "gauge", "Whether the last save succeeded", boolMetric(!status.lastFailed))
+	w.single("redis_rdb_last_bgsave_duration_sec", "gauge", "Seconds the last save took", status.lastDuration.Seconds())
+	w.single("redis_rdb_saves_total", "counter", "Saves of the dataset", float64(status.saves))
+}
+
+// metrics writes out every metric
+func (r *Redis) metrics() string {
+	w := &metricsWriter{}
+
+	r.writeServerMetrics(w)
+	r.writeClientMetrics(w)
+	r.writeMemoryMetrics(w)
+	r.writeKeyspaceMetrics(w)
+	r.writeStatsMetrics(w)
+	r.writeReplicationMetrics(w)
+	r.writePersistenceMetrics(w)
+	return w.String()
+}
+
+func (r *Redis) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, r.metrics())
+}
+
+// listenMetrics opens the listener for the metrics endpoint should it be enabled
+func (r *Redis) listenMetrics() error {
+	if r.configuration.metricsPort == 0 {
+		return nil
+	}
+
+	address := net.JoinHostPort(r.configuration.metricsBind, strconv.Itoa(r.configuration.metricsPort))
+	listener, err := net.Listen("tcp", address)
+
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Serving metrics", "address", address)
+	r.metricsListener = listener
+	return nil
+}
+
+// serveMetricsEndpoint answers Prometheus scrapes until the listener is closed
+func (r *Redis) serveMetricsEndpoint() {
+	if r.metricsListener == nil {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", r.serveMetrics)
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	if err := server.Serve(r.metricsListener); err != nil && !errors.Is(err, net.ErrClosed) {
+		slog.Error("Error serving metrics", "err", err)
+	}
+}
@@ -0,0 +1,69 @@
+package redis
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_formatMetricValue(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{value: 0, want: "0"},
+		{value: 1234567890, want: "1234567890"},
+		{value: 0.25, want: "0.25"},
+		{value: 0.000001, want: "1e-06"},
+		{value: math.Inf(1), want: "+Inf"},
+	}
+	for _, tt := range tests {
+		if got := formatMetricValue(tt.value); got != tt.want {
+			t.Errorf("formatMetricValue(%v) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func Test_metricsWriter(t *testing.T) {
+	w := &metricsWriter{}
+	w.family("redis_errors_total", "counter", "Error replies by their prefix")
+	w.sample("redis_errors_total", 3, metricLabel{"err", "ERR"}, metricLabel{"note", "a \"quoted\\\" \nline"})
+	w.single("redis_connected_clients", "gauge", "Clients connected", 2)
+
+	want := `# HELP redis_errors_total Error replies by their prefix
+# TYPE redis_errors_total counter
+redis_errors_total{err="ERR",note="a \"quoted\\\" \nline"} 3
+# HELP redis_connected_clients Clients connected
+# TYPE redis_connected_clients gauge
+redis_connected_clients 2
+`
+
+	if got := w.String(); got != want {
+		t.Errorf("metricsWriter wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func Test_countUpTo(t *testing.T) {
+	h := &latencyHistogram{}
+
+	for _, usec := range []int64{1, 3, 4, 15, 16, 100, 1023, 1024} {
+		h.record(usec)
+	}
+
+	tests := []struct {
+		usec int64
+		want int64
+	}{
+		{usec: 1, want: 1},
+		{usec: 4, want: 3},
+		{usec: 16, want: 5},
+		// 1024µs shares its bucket with up to 1087µs
+		{usec: 1024, want: 7},
+		{usec: 1087, want: 8},
+		{usec: 1 << 20, want: 8},
+	}
+	for _, tt := range tests {
+		if got := h.countUpTo(tt.usec); got != tt.want {
+			t.Errorf("countUpTo(%d) = %d, want %d", tt.usec, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// How many lines may wait to be sent to a client in MONITOR mode before it's
+// disconnected for falling behind
+const MONITOR_QUEUE_SIZE = 4096
+
+// monitor turns the connection into one that's sent every command the server
+// runs, as it runs it. The lines are queued and written by a goroutine of the
+// client's own, so a slow monitor doesn't hold up commands run under the
+// execution lock.
+func (r *Redis) monitor(connection RedisConnection) []serde.Value {
+	queue, ok := r.clients.setMonitor(connection.id)
+
+	// Replicas are sent the commands that change the dataset already, so as in
+	// Redis they're quietly ignored
+	if !ok {
+		return []serde.Value{}
+	}
+
+	if queue == nil {
+		return []serde.Value{serde.Ok()}
+	}
+
+	go writeMonitorLines(connection, queue)
+	return []serde.Value{}
+}
+
+// writeMonitorLines sends the queued lines to the client until it's unregistered
+func writeMonitorLines(connection RedisConnection, queue chan serde.Value) {
+	for line := range queue {
+		connection.WithWriteMutex(func() error {
+			return connection.Send([]serde.Value{line})
+		})
+	}
+}
+
+// monitorAddr describes where a command came from, as a client address or lua
+// for commands run by a script
+func monitorAddr(ctx context.Context, conn net.Conn) string {
+	if isWithinScript(ctx) {
+		return "lua"
+	}
+
+	if conn == nil {
+		return ""
+	}
+
+	if unixAddr, ok := conn.LocalAddr().(*net.UnixAddr); ok {
+		return "unix:" + unixAddr.Name
+	}
+	return conn.RemoteAddr().String()
+}
+
+// monitorLine formats a command as MONITOR shows it, args including the
+// command name
+func monitorLine(now time.Time, addr string, args []string) string {
+	quoted := make([]string, len(args))
+
+	for i, arg := range args {
+		quoted[i] = reprString(arg)
+	}
+	return fmt.Sprintf("%d.%06d [0 %s] %s", now.Unix(), now.Nanosecond()/1000, addr, strings.Join(quoted, " "))
+}
+
+// feedMonitors queues a command that has run for the clients in MONITOR mode.
+// Administrative commands are left out, and secrets such as passwords redacted.
+func (r *Redis) feedMonitors(ctx context.Context, cmd string, args []string, connection RedisConnection) {
+	if !r.clients.hasMonitors() || lookupCommand(cmd, args).hasFlag(CMD_ADMIN) {
+		return
+	}
+
+	line := serde.NewSimpleString(monitorLine(time.Now(), monitorAddr(ctx, connection.conn), redactedArgs(append([]string{cmd}, args...))))
+	r.clients.queueMonitorLine(line)
+}
@@ -0,0 +1,64 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_monitorLine(t *testing.T) {
+	now := time.Unix(1339518083, 107412000)
+
+	tests := []struct {
+		name string
+		addr string
+		args []string
+		want string
+	}{
+		{name: "It should quote each argument", addr: "127.0.0.1:60866", args: []string{"keys", "*"}, want: `1339518083.107412 [0 127.0.0.1:60866] "keys" "*"`},
+		{name: "It should escape what isn't printable", addr: "lua", args: []string{"set", "a\"b", "line\r\n\x01"}, want: `1339518083.107412 [0 lua] "set" "a\"b" "line\r\n\x01"`},
+		{name: "It should show empty arguments", addr: "unix:/tmp/redis.sock", args: []string{"set", "key", ""}, want: `1339518083.107412 [0 unix:/tmp/redis.sock] "set" "key" ""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := monitorLine(now, tt.addr, tt.args); got != tt.want {
+				t.Errorf("monitorLine() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedis_feedMonitors(t *testing.T) {
+	r := &Redis{clients: newClientRegistry()}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	monitor := NewRedisConnection(server)
+	r.clients.register(&monitor, CLIENT_TYPE_NORMAL)
+
+	if response := r.monitor(monitor); len(response) != 0 {
+		t.Fatalf("Expected the reply to MONITOR to be queued, got %v", response)
+	}
+
+	// Nothing reads from the monitor yet, so writing to it would block
+	r.feedMonitors(context.Background(), SET, []string{"foo", "bar"}, RedisConnection{})
+
+	reader := bufio.NewReader(client)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+
+	for _, want := range []string{"+OK\r\n", `"set" "foo" "bar"`} {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(line, want) {
+			t.Errorf("Expected %q to be sent, got %q", want, line)
+		}
+	}
+
+	r.clients.unregister(monitor.id)
+}
@@ -2,9 +2,21 @@ package redis
 
 import (
 	"codecrafters/internal/serde"
-	"context"
 )
 
-func (r Redis) multi(ctx context.Context, args []string) []serde.Value {
-	return []serde.Value{serde.NewSimpleString("OK")}
+func (r *Redis) multi(connection *RedisConnection) []serde.Value {
+	if connection.transaction {
+		return []serde.Value{serde.NewError("ERR MULTI calls can not be nested")}
+	}
+
+	connection.transaction = true
+	return []serde.Value{serde.Ok()}
+}
+
+// endTransaction clears out any transaction state, including watched keys
+func (r *Redis) endTransaction(connection *RedisConnection) {
+	connection.transaction = false
+	connection.transactionAborted = false
+	connection.bufferedCommands = []serde.Value{}
+	r.store.Unwatch(connection.watch)
 }
@@ -0,0 +1,43 @@
+package redis
+
+import (
+	"codecrafters/internal/kvstore"
+	"codecrafters/internal/serde"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRedis_watchInsideMulti(t *testing.T) {
+	r := &Redis{
+		store:          kvstore.NewKVStore(),
+		configuration:  configurationOptions{replicationConfig: replicationConfig{replicaConfig: masterConfig{}}},
+		executionMutex: &sync.Mutex{},
+		acl:            newACLState(),
+		scripting:      newScriptingState(),
+		clients:        newClientRegistry(),
+		tracking:       newTrackingState(),
+		stats:          newServerStats(),
+		slowlog:        newSlowlogState(),
+		latency:        newLatencyMonitor(),
+		replication:    newReplicationState(REPL_BACKLOG_MIN_SIZE, true),
+	}
+
+	connection := NewRedisConnection(nil)
+	connection.auth.authenticated = true
+	r.clients.register(&connection, CLIENT_TYPE_NORMAL)
+
+	var response []serde.Value
+	for _, command := range [][]string{{MULTI}, {SET, "foo", "bar"}, {WATCH, "foo"}, {EXEC}} {
+		response = r.processCommand(context.Background(), command[0], command[1:], bulkStrings(command), &connection)
+	}
+
+	if got := string(response[0].Marshal()); !strings.HasPrefix(got, "-EXECABORT") {
+		t.Errorf("Expected WATCH inside MULTI to abort the transaction, got %q", got)
+	}
+
+	if _, ok := r.store.ReadKey(context.Background(), "foo"); ok {
+		t.Errorf("Expected the transaction not to run")
+	}
+}
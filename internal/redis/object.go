@@ -0,0 +1,65 @@
+package redis
+
+import (
+	"codecrafters/internal/array"
+	"codecrafters/internal/serde"
+	"context"
+	"fmt"
+	"strings"
+)
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+func (r *Redis) object(ctx context.Context, args []string) []serde.Value {
+	subcommand := strings.ToLower(args[0])
+
+	switch subcommand {
+	case "help":
+		return []serde.Value{serde.NewArray(array.Map(objectHelp, func(line string) serde.Value {
+			return serde.NewSimpleString(line)
+		}))}
+	case "encoding", "refcount", "idletime", "freq":
+	default:
+		return []serde.Value{serde.NewError(fmt.Sprintf("ERR unknown subcommand '%s'. Try OBJECT HELP.", args[0]))}
+	}
+
+	info, found := r.store.Object(ctx, args[1])
+
+	if !found {
+		return []serde.Value{serde.NewNull()}
+	}
+
+	switch subcommand {
+	case "encoding":
+		return []serde.Value{serde.NewBulkString(info.Encoding)}
+	case "refcount":
+		// Values are never shared between keys
+		return []serde.Value{serde.NewInteger(1)}
+	case "idletime":
+		if r.store.EvictionConfig().IsLFU() {
+			return []serde.Value{serde.NewError("ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")}
+		}
+		return []serde.Value{serde.NewInteger(int64(info.Idle.Seconds()))}
+	default:
+		if !r.store.EvictionConfig().IsLFU() {
+			return []serde.Value{serde.NewError("ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")}
+		}
+		return []serde.Value{serde.NewInteger(int64(info.Frequency))}
+	}
+}
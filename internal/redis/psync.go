@@ -2,29 +2,59 @@ package redis
 
 import (
 	"codecrafters/internal/serde"
+	"context"
 	"fmt"
+	"strconv"
+	"time"
 )
 
-var EMPTY_RDB = []byte{
-	0x52, 0x45, 0x44, 0x49, 0x53, 0x30, 0x30, 0x31, 0x31, 0xfa, 0x09, 0x72, 0x65,
-	0x64, 0x69, 0x73, 0x2d, 0x76, 0x65, 0x72, 0x05, 0x37, 0x2e, 0x32, 0x2e, 0x30,
-	0xfa, 0x0a, 0x72, 0x65, 0x64, 0x69, 0x73, 0x2d, 0x62, 0x69, 0x74, 0x73, 0xc0,
-	0x40, 0xfa, 0x05, 0x63, 0x74, 0x69, 0x6d, 0x65, 0xc2, 0x6d, 0x08, 0xbc, 0x65,
-	0xfa, 0x08, 0x75, 0x73, 0x65, 0x64, 0x2d, 0x6d, 0x65, 0x6d, 0xc2, 0xb0, 0xc4,
-	0x10, 0x00, 0xfa, 0x08, 0x61, 0x6f, 0x66, 0x2d, 0x62, 0x61, 0x73, 0x65, 0xc0,
-	0x00, 0xff, 0xf0, 0x6e, 0x3b, 0xfe, 0xc0, 0xff, 0x5a, 0xa2,
+// psync starts replicating to the connection. A replica that gives the history
+// it follows and the offset it needs next is sent just what it missed, should
+// the backlog still hold it, and otherwise everything.
+//
+// The reply is written here rather than by the connection's loop, while we still
+// hold the execution lock, so nothing can be propagated between the reply and
+// the replica joining r.replicas.
+func (r *Redis) psync(ctx context.Context, args []string, connection RedisConnection) []serde.Value {
+	reply := r.psyncReply(ctx, args)
+
+	if err := connection.WithWriteMutex(func() error { return connection.Send(reply) }); err != nil {
+		return []serde.Value{}
+	}
+
+	r.replicas[connection.id] = connection
+	r.clients.setType(connection.id, CLIENT_TYPE_REPLICA)
+
+	return []serde.Value{}
 }
 
-func (r *Redis) psync(connection RedisConnection) []serde.Value {
-	length := fmt.Sprintf("$%d%s", len(EMPTY_RDB), serde.CRLF)
+func (r *Redis) psyncReply(ctx context.Context, args []string) []serde.Value {
+	if offset, err := strconv.ParseInt(args[1], 10, 64); err == nil {
+		if missed, ok := r.replication.partialSync(args[0], offset); ok {
+			r.stats.partialSync(true)
 
-	response := []serde.Value{
-		serde.NewSimpleString(fmt.Sprintf("FULLRESYNC %s 0", r.configuration.replicationConfig.masterReplId)),
-		serde.NewRawBytes([]byte(length)),
-		serde.NewRawBytes(EMPTY_RDB),
+			return []serde.Value{
+				serde.NewSimpleString(fmt.Sprintf("CONTINUE %s", r.replication.replId)),
+				serde.NewRawBytes(missed),
+			}
+		}
 	}
 
-	r.replicas[connection.id] = connection
+	// Asking for ? means the replica knew it needed everything
+	if args[0] != "?" {
+		r.stats.partialSync(false)
+	}
+	r.stats.fullSync()
+
+	// Nothing else runs while we hold the execution lock, so the snapshot is of the
+	// dataset as of the offset we give, and the replica is sent what follows it
+	// once it's been sent this
+	rdb := encodeRDB(r.store.Snapshot(ctx), r.functions.rdbSection(), time.Now())
+	length := fmt.Sprintf("$%d%s", len(rdb), serde.CRLF)
 
-	return response
+	return []serde.Value{
+		serde.NewSimpleString(fmt.Sprintf("FULLRESYNC %s %d", r.replication.replId, r.processedByteCount)),
+		serde.NewRawBytes([]byte(length)),
+		serde.NewRawBytes(rdb),
+	}
 }
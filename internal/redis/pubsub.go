@@ -0,0 +1,58 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"fmt"
+	"slices"
+)
+
+// Commands a RESP2 client may still send once it has subscribed, as any other
+// reply could be mistaken for a message
+var allowedWhileSubscribed = []string{SUBSCRIBE, UNSUBSCRIBE, PING}
+
+// pubSubMessage is sent to a client for something to do with a channel. RESP3
+// clients get it as a push, so they can tell it apart from replies.
+func (r *Redis) pubSubMessage(id int64, kind string, channel serde.Value, payload serde.Value) serde.Value {
+	items := []serde.Value{serde.NewBulkString(kind), channel, payload}
+
+	if r.clients.protocol(id) >= 3 {
+		return serde.NewPush(items)
+	}
+	return serde.NewArray(items)
+}
+
+// subscribe subscribes the client to channels. There's no PUBLISH, so the only
+// messages sent are invalidations redirected to __redis__:invalidate by clients
+// tracking keys.
+func (r *Redis) subscribe(args []string, connection RedisConnection) []serde.Value {
+	response := []serde.Value{}
+
+	for i, count := range r.clients.subscribe(connection.id, args) {
+		response = append(response, r.pubSubMessage(connection.id, "subscribe", serde.NewBulkString(args[i]), serde.NewInteger(int64(count))))
+	}
+	return response
+}
+
+func (r *Redis) unsubscribe(args []string, connection RedisConnection) []serde.Value {
+	channels, counts := r.clients.unsubscribe(connection.id, args)
+
+	if len(channels) == 0 {
+		return []serde.Value{r.pubSubMessage(connection.id, "unsubscribe", serde.NewNull(), serde.NewInteger(0))}
+	}
+
+	response := []serde.Value{}
+
+	for i, channel := range channels {
+		response = append(response, r.pubSubMessage(connection.id, "unsubscribe", serde.NewBulkString(channel), serde.NewInteger(int64(counts[i]))))
+	}
+	return response
+}
+
+// checkSubscribedContext refuses commands from a RESP2 client that has
+// subscribed, other than those that manage its subscriptions
+func (r *Redis) checkSubscribedContext(cmd string, connection RedisConnection) error {
+	if slices.Contains(allowedWhileSubscribed, cmd) || r.clients.protocol(connection.id) >= 3 || !r.clients.isSubscribed(connection.id, "") {
+		return nil
+	}
+	return fmt.Errorf("ERR Can't execute '%s': only SUBSCRIBE / UNSUBSCRIBE / PING are allowed in this context", cmd)
+}
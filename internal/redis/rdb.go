@@ -3,15 +3,15 @@ package redis
 import (
 	"bufio"
 	"bytes"
+	"codecrafters/internal/kvstore"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc64"
 	"io"
 	"os"
 	"path"
 	"strconv"
-
-	mapset "github.com/deckarep/golang-set/v2"
 )
 
 const (
@@ -26,10 +26,23 @@ const (
 	HASH_TABLE_SIZE        = 0xFB
 	EXPIRY_SECONDS         = 0xFD
 	EXPIRY_MS              = 0xFC
+	AUX                    = 0xFA
+	FUNCTION2              = 0xF5
+	RDB_VERSION            = 11
 
 	// Value types for Redis encoding: https://rdb.fnordig.de/file_format.html#string-encoding
-	// We only support string because I'm lazy
-	STRING_VALUE = 0x00
+	// We only support strings and streams, as they're all we store
+	STRING_VALUE       = 0x00
+	STREAM_LISTPACKS   = 0x0F
+	STREAM_LISTPACKS_2 = 0x13
+	STREAM_LISTPACKS_3 = 0x15
+)
+
+// Lengths too large for 14 bits are given in the following 4 or 8 bytes, big
+// endian
+const (
+	RDB_32BIT_LEN = 0x80
+	RDB_64BIT_LEN = 0x81
 )
 
 // Variable integer encoding consts
@@ -45,8 +58,18 @@ const (
 	ONE_BYTE_STRING_SIZE  = 0
 	TWO_BYTE_STRING_SIZE  = 1
 	FOUR_BYTE_STRING_SIZE = 2
+	LZF_COMPRESSED_STRING = 3
 )
 
+// Redis checksums RDB payloads with the reflected Jones polynomial
+var crc64Table = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+// rdbChecksum computes the CRC-64 Redis uses, which unlike the variants in the
+// standard library neither inverts the initial nor the final value
+func rdbChecksum(data []byte) uint64 {
+	return ^crc64.Update(^uint64(0), crc64Table, data)
+}
+
 const (
 	EmptyDBError EmptyDB = "DB section is empty"
 )
@@ -77,8 +100,10 @@ type persistedDB struct {
 }
 
 type keyValuePair struct {
-	key        string
-	value      string
+	key   string
+	value string
+	// Set instead of value for streams
+	stream     *kvstore.StoredStream
 	expiryInMs *uint64
 }
 
@@ -145,6 +170,16 @@ func parseSizeEncodedInteger(reader *bufio.Reader) (sizeEncoded, error) {
 		value := binary.BigEndian.Uint16(byteArray)
 		return integerSizeEncoded{int(value)}, nil
 	case FOUR_BYTE_INT:
+		if firstByte == RDB_64BIT_LEN {
+			nextEightBytes, err := readNBytes(reader, 8)
+
+			if err != nil {
+				return defaultErr, err
+			}
+
+			return integerSizeEncoded{int(binary.BigEndian.Uint64(nextEightBytes))}, nil
+		}
+
 		nextFourBytes, err := readNBytes(reader, 4)
 
 		if err != nil {
@@ -194,44 +229,221 @@ func parseHeader(reader *bufio.Reader) error {
 	return nil
 }
 
-func readUntilOneOf(reader *bufio.Reader, delimiters mapset.Set[byte]) ([]byte, byte, error) {
-	full := make([]byte, 1024)
+func readNBytes(reader *bufio.Reader, n int) ([]byte, error) {
+	buf := make([]byte, n)
 
-	for {
+	_, err := io.ReadFull(reader, buf)
+
+	return buf, err
+}
+
+// readRDBString reads a length prefixed string, which may instead hold an
+// integer or be LZF compressed
+func readRDBString(reader *bufio.Reader) (string, error) {
+	firstByte, err := reader.ReadByte()
+
+	if err != nil {
+		return "", err
+	}
+
+	if firstByte>>6 != STRING_ENCODED {
+		reader.UnreadByte()
+		size, err := parseSizeEncodedInteger(reader)
+
+		if err != nil {
+			return "", err
+		}
+
+		value, err := readNBytes(reader, size.Size())
+		return string(value), err
+	}
+
+	switch int(firstByte & 0b00111111) {
+	case ONE_BYTE_STRING_SIZE:
+		value, err := reader.ReadByte()
+		return strconv.Itoa(int(int8(value))), err
+	case TWO_BYTE_STRING_SIZE:
+		value, err := readNBytes(reader, 2)
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(value)))), err
+	case FOUR_BYTE_STRING_SIZE:
+		value, err := readNBytes(reader, 4)
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(value)))), err
+	case LZF_COMPRESSED_STRING:
+		compressedLen, err := parseSizeEncodedInteger(reader)
+
+		if err != nil {
+			return "", err
+		}
 
-		b, err := reader.ReadByte()
+		uncompressedLen, err := parseSizeEncodedInteger(reader)
 
 		if err != nil {
-			return full, NULL_BYTE, err
+			return "", err
+		}
+
+		compressed, err := readNBytes(reader, compressedLen.Size())
+
+		if err != nil {
+			return "", err
+		}
+
+		value, err := lzfDecompress(compressed, uncompressedLen.Size())
+		return string(value), err
+	default:
+		return "", errors.New("failed to parse string encoded bytes")
+	}
+}
+
+// lzfDecompress expands a string Redis compressed with LZF, which is made up of
+// runs of literal bytes and references back to bytes already expanded
+func lzfDecompress(compressed []byte, size int) ([]byte, error) {
+	value := make([]byte, 0, size)
+	errCorrupt := errors.New("invalid LZF compressed string")
+
+	for i := 0; i < len(compressed); {
+		ctrl := int(compressed[i])
+		i++
+
+		if ctrl < 1<<5 {
+			end := i + ctrl + 1
+
+			if end > len(compressed) {
+				return nil, errCorrupt
+			}
+
+			value = append(value, compressed[i:end]...)
+			i = end
+			continue
+		}
+
+		length := ctrl >> 5
+
+		if length == 7 {
+			if i >= len(compressed) {
+				return nil, errCorrupt
+			}
+			length += int(compressed[i])
+			i++
 		}
 
-		full = append(full, b)
+		if i >= len(compressed) {
+			return nil, errCorrupt
+		}
+
+		ref := len(value) - (ctrl&0x1F)<<8 - int(compressed[i]) - 1
+		i++
+
+		if ref < 0 {
+			return nil, errCorrupt
+		}
 
-		if delimiters.Contains(b) {
-			return full, b, nil
+		// The reference may overlap what it's copying, so go a byte at a time
+		for j := range length + 2 {
+			value = append(value, value[ref+j])
 		}
 	}
 
+	if len(value) != size {
+		return nil, errCorrupt
+	}
+
+	return value, nil
 }
 
-func readNBytes(reader *bufio.Reader, n int) ([]byte, error) {
-	buf := make([]byte, n)
+// encodeRDBLength encodes a size as read back by parseSizeEncodedInteger
+func encodeRDBLength(size int) []byte {
+	return encodeRDBUint64(uint64(size))
+}
 
-	_, err := io.ReadFull(reader, buf)
+// encodeRDBUint64 encodes a number the way lengths are, which is also how the
+// parts of stream IDs are saved, as read back by readRDBUint64
+func encodeRDBUint64(n uint64) []byte {
+	switch {
+	case n < 1<<6:
+		return []byte{byte(n)}
+	case n < 1<<14:
+		return []byte{0b01000000 | byte(n>>8), byte(n)}
+	case n < 1<<32:
+		return binary.BigEndian.AppendUint32([]byte{RDB_32BIT_LEN}, uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{RDB_64BIT_LEN}, n)
+	}
+}
 
-	return buf, err
+func readRDBUint64(reader *bufio.Reader) (uint64, error) {
+	firstByte, err := reader.Peek(1)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if firstByte[0]>>6 == STRING_ENCODED {
+		return 0, errors.New("expected a length, got an encoded string")
+	}
+
+	size, err := parseSizeEncodedInteger(reader)
+	return uint64(size.Size()), err
+}
+
+// encodeRDBString encodes a string with its length prefix, as read back by
+// readRDBString
+func encodeRDBString(value string) []byte {
+	return append(encodeRDBLength(len(value)), value...)
 }
 
-// We should probably read / care about this section. But the metadata is not going to be used for our later
-// parsing of this file. So instead we just read until we hit the DB section of the file
-func parseMetadataSection(reader *bufio.Reader) (byte, error) {
-	_, nextSection, err := readUntilOneOf(reader, mapset.NewSet[byte](SELECT_DB, EOF))
-	return nextSection, err
+// parseMetadataSection reads the auxiliary fields, which we don't care about,
+// and the function libraries that come before the first database
+func parseMetadataSection(reader *bufio.Reader) (byte, []string, error) {
+	libraries := []string{}
+
+	for {
+		opcode, err := reader.ReadByte()
+
+		if err != nil {
+			return NULL_BYTE, libraries, err
+		}
+
+		switch opcode {
+		case AUX:
+			for range 2 {
+				if _, err := readRDBString(reader); err != nil {
+					return NULL_BYTE, libraries, err
+				}
+			}
+		case FUNCTION2:
+			code, err := readRDBString(reader)
+
+			if err != nil {
+				return NULL_BYTE, libraries, err
+			}
+			libraries = append(libraries, code)
+		case SELECT_DB, EOF:
+			return opcode, libraries, nil
+		default:
+			return NULL_BYTE, libraries, fmt.Errorf("encountered unexpected RDB opcode %#x", opcode)
+		}
+	}
 }
 
 func parseDBKey(reader *bufio.Reader, valueType byte, expiresAt *uint64) (*keyValuePair, error) {
-	if valueType != STRING_VALUE {
-		return nil, errors.New("does not support values not encoded as strings")
+	switch valueType {
+	case STRING_VALUE:
+	case STREAM_LISTPACKS, STREAM_LISTPACKS_2, STREAM_LISTPACKS_3:
+		key, err := readRDBString(reader)
+
+		if err != nil {
+			return nil, err
+		}
+
+		stream, err := parseRDBStream(reader, valueType)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to load stream %s: %w", key, err)
+		}
+
+		return &keyValuePair{key: key, stream: &stream, expiryInMs: expiresAt}, nil
+	default:
+		return nil, errors.New("does not support values not encoded as strings or streams")
 	}
 
 	keyLen, err := parseSizeEncodedInteger(reader)
@@ -315,7 +527,7 @@ func parseDBKeys(reader *bufio.Reader, count int) ([]keyValuePair, error) {
 
 			values = append(values, *entry)
 
-		case STRING_VALUE:
+		case STRING_VALUE, STREAM_LISTPACKS, STREAM_LISTPACKS_2, STREAM_LISTPACKS_3:
 			entry, err := parseDBKey(reader, firstByte, nil)
 
 			if err != nil {
@@ -443,20 +655,28 @@ func (r Redis) processRDBFile() error {
 	}
 
 	defer file.Close()
+	return r.loadRDB(bufio.NewReader(file))
+}
 
-	reader := bufio.NewReader(file)
-
-	err = parseHeader(reader)
+// loadRDB adds the keys and function libraries from an RDB file to those we have
+func (r Redis) loadRDB(reader *bufio.Reader) error {
+	err := parseHeader(reader)
 
 	if err != nil {
 		return err
 	}
-	nextSection, err := parseMetadataSection(reader)
+	nextSection, libraries, err := parseMetadataSection(reader)
 
 	if err != nil {
 		return err
 	}
 
+	for _, code := range libraries {
+		if _, err := r.functions.load(code, false); err != nil {
+			return err
+		}
+	}
+
 	if nextSection == SELECT_DB {
 		nextSection, persistedDBs, err := parseDBSection(reader)
 
@@ -465,6 +685,10 @@ func (r Redis) processRDBFile() error {
 		}
 		for _, db := range persistedDBs {
 			for _, kvPair := range db.values {
+				if kvPair.stream != nil {
+					r.store.SetValue(kvPair.key, *kvPair.stream)
+					continue
+				}
 				r.store.SetKeyWithExpiresAt(kvPair.key, kvPair.value, kvPair.expiryInMs)
 			}
 		}
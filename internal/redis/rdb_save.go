@@ -0,0 +1,261 @@
+package redis
+
+import (
+	"codecrafters/internal/kvstore"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// There are no save points unless configured, so the dataset is only saved by
+// SHUTDOWN SAVE, as it was before save points. Redis' own default would be
+// "3600 1 300 100 60 10000".
+const DEFAULT_SAVE_POINTS = ""
+
+// How long to wait before trying again after a save point fails to save
+const SAVE_RETRY_DELAY = 5 * time.Second
+
+// savePoint saves the dataset once at least changes keys have been modified and
+// seconds have passed since it was last saved
+type savePoint struct {
+	seconds int
+	changes int
+}
+
+func parseSavePoints(value string) ([]savePoint, error) {
+	fields := strings.Fields(value)
+
+	if len(fields)%2 != 0 {
+		return nil, errors.New("Invalid save parameters")
+	}
+
+	points := []savePoint{}
+
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.Atoi(fields[i])
+
+		if err != nil || seconds < 1 {
+			return nil, errors.New("Invalid save parameters")
+		}
+
+		changes, err := strconv.Atoi(fields[i+1])
+
+		if err != nil || changes < 0 {
+			return nil, errors.New("Invalid save parameters")
+		}
+
+		points = append(points, savePoint{seconds, changes})
+	}
+	return points, nil
+}
+
+func formatSavePoints(points []savePoint) string {
+	fields := []string{}
+
+	for _, point := range points {
+		fields = append(fields, strconv.Itoa(point.seconds), strconv.Itoa(point.changes))
+	}
+	return strings.Join(fields, " ")
+}
+
+// persistenceState counts the changes since the dataset was last saved, which
+// decide when a save point is reached
+type persistenceState struct {
+	mutex    *sync.Mutex
+	dirty    int64
+	lastSave time.Time
+	// When saving was last attempted, how long it took and whether it worked
+	lastAttempt  time.Time
+	lastDuration time.Duration
+	lastFailed   bool
+	saves        int64
+}
+
+func newPersistenceState() *persistenceState {
+	now := time.Now()
+	return &persistenceState{mutex: &sync.Mutex{}, lastSave: now, lastAttempt: now}
+}
+
+func (p *persistenceState) keyModified(_ string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.dirty++
+}
+
+func (p *persistenceState) saved(at time.Time, duration time.Duration, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.lastAttempt = at
+	p.lastDuration = duration
+	p.lastFailed = err != nil
+
+	if err == nil {
+		p.dirty = 0
+		p.lastSave = at
+		p.saves++
+	}
+}
+
+// status returns a copy of the state for INFO persistence
+func (p *persistenceState) status() persistenceState {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	status := *p
+	status.mutex = nil
+	return status
+}
+
+// due reports whether any of the save points has been reached
+func (p *persistenceState) due(points []savePoint, now time.Time) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.lastFailed && now.Sub(p.lastAttempt) < SAVE_RETRY_DELAY {
+		return false
+	}
+
+	for _, point := range points {
+		if p.dirty >= int64(point.changes) && p.dirty > 0 && now.Sub(p.lastSave) >= time.Duration(point.seconds)*time.Second {
+			return true
+		}
+	}
+	return false
+}
+
+// saveIfDue saves the dataset should a save point have been reached
+func (r *Redis) saveIfDue(now time.Time) {
+	r.executionMutex.Lock()
+	defer r.executionMutex.Unlock()
+
+	if !r.persistence.due(r.configuration.savePoints, now) {
+		return
+	}
+
+	slog.Info("Save point reached, saving")
+
+	if err := r.saveRDB(context.Background()); err != nil {
+		slog.Error("Error saving the DB on disk", "err", err)
+	}
+}
+
+// encodeRDB serializes the keys and function libraries into an RDB file that
+// processRDBFile can load back
+func encodeRDB(values map[string]kvstore.StoredValue, functions []byte, now time.Time) []byte {
+	rdb := []byte(fmt.Sprintf("%s%04d", REDIS_ASCII_BYTES, RDB_VERSION))
+
+	for _, aux := range [][2]string{
+		{"redis-ver", REDIS_VERSION},
+		{"redis-bits", "64"},
+		{"ctime", strconv.FormatInt(now.Unix(), 10)},
+	} {
+		rdb = append(rdb, AUX)
+		rdb = append(rdb, encodeRDBString(aux[0])...)
+		rdb = append(rdb, encodeRDBString(aux[1])...)
+	}
+
+	rdb = append(rdb, functions...)
+
+	keys := []string{}
+	expiring := 0
+
+	for key, value := range values {
+		switch stored := value.(type) {
+		case kvstore.StoredString:
+			if stored.ExpiresAt() != nil {
+				expiring++
+			}
+		case kvstore.StoredStream:
+		default:
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	if len(keys) > 0 {
+		slices.Sort(keys)
+
+		rdb = append(rdb, SELECT_DB, 0)
+		rdb = append(rdb, RESIZE_DB)
+		rdb = append(rdb, encodeRDBLength(len(keys))...)
+		rdb = append(rdb, encodeRDBLength(expiring)...)
+
+		for _, key := range keys {
+			switch stored := values[key].(type) {
+			case kvstore.StoredString:
+				if expiresAt := stored.ExpiresAt(); expiresAt != nil {
+					rdb = append(rdb, EXPIRE_TIME_MS)
+					rdb = binary.LittleEndian.AppendUint64(rdb, *expiresAt)
+				}
+
+				rdb = append(rdb, STRING_VALUE)
+				rdb = append(rdb, encodeRDBString(key)...)
+				rdb = append(rdb, encodeRDBString(stored.ToString())...)
+			case kvstore.StoredStream:
+				rdb = append(rdb, STREAM_LISTPACKS_3)
+				rdb = append(rdb, encodeRDBString(key)...)
+				rdb = append(rdb, encodeRDBStream(stored.Snapshot())...)
+			}
+		}
+	}
+
+	rdb = append(rdb, EOF)
+	return binary.LittleEndian.AppendUint64(rdb, rdbChecksum(rdb))
+}
+
+// saveRDB writes the dataset to the RDB file. The execution lock must be held.
+func (r *Redis) saveRDB(ctx context.Context) error {
+	persistencePath := path.Join(r.configuration.persistenceDir, r.configuration.persistenceFileName)
+	start := time.Now()
+	rdb := encodeRDB(r.store.Snapshot(ctx), r.functions.rdbSection(), start)
+
+	err := writeFileAtomically(r.configuration.persistenceDir, persistencePath, rdb)
+	r.persistence.saved(start, time.Since(start), err)
+	r.latency.addSampleIfNeeded(LATENCY_EVENT_SAVE, time.Since(start))
+
+	if err != nil {
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("DB saved on disk to %s", persistencePath))
+	return nil
+}
+
+// writeFileAtomically replaces the file all at once, so that it's never left half
+// written
+func writeFileAtomically(dir string, name string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, "temp-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
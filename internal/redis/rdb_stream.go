@@ -0,0 +1,339 @@
+package redis
+
+import (
+	"bufio"
+	"codecrafters/internal/kvstore"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Flags for each entry of a stream node
+const (
+	STREAM_ITEM_FLAG_DELETED    = 1 << 0
+	STREAM_ITEM_FLAG_SAMEFIELDS = 1 << 1
+)
+
+// encodeRDBStream encodes a stream the way Redis 7 does, as a listpack for each
+// node keyed by its master ID, followed by the stream's metadata. We don't have
+// consumer groups, so there are never any to save.
+func encodeRDBStream(snapshot kvstore.StreamSnapshot) []byte {
+	rdb := encodeRDBLength(len(snapshot.Nodes))
+
+	for _, node := range snapshot.Nodes {
+		masterId := binary.BigEndian.AppendUint64(nil, node.MasterId.Timestamp())
+		masterId = binary.BigEndian.AppendUint64(masterId, node.MasterId.SeqNo())
+
+		rdb = append(rdb, encodeRDBString(string(masterId))...)
+		rdb = append(rdb, encodeRDBString(string(encodeStreamNode(node)))...)
+	}
+
+	for _, n := range []uint64{
+		snapshot.Length,
+		snapshot.LastId.Timestamp(),
+		snapshot.LastId.SeqNo(),
+		snapshot.FirstId.Timestamp(),
+		snapshot.FirstId.SeqNo(),
+		snapshot.MaxDeletedId.Timestamp(),
+		snapshot.MaxDeletedId.SeqNo(),
+		snapshot.EntriesAdded,
+		0,
+	} {
+		rdb = append(rdb, encodeRDBUint64(n)...)
+	}
+
+	return rdb
+}
+
+// encodeStreamNode lays out a node as a listpack. The master entry comes first,
+// with the number of live and deleted entries and the master fields, then each
+// entry with its ID relative to the master ID. Entries end with how many
+// listpack entries they took up, so the node can be walked backwards.
+func encodeStreamNode(node kvstore.StreamSnapshotNode) []byte {
+	writer := &listpackWriter{}
+	live, deleted := 0, 0
+
+	for _, entry := range node.Entries {
+		if entry.Deleted {
+			deleted++
+		} else {
+			live++
+		}
+	}
+
+	writer.appendInt(int64(live))
+	writer.appendInt(int64(deleted))
+	writer.appendInt(int64(len(node.MasterFields)))
+
+	for _, field := range node.MasterFields {
+		writer.appendString(field)
+	}
+	writer.appendInt(0)
+
+	for _, entry := range node.Entries {
+		flags := 0
+
+		if entry.Deleted {
+			flags |= STREAM_ITEM_FLAG_DELETED
+		}
+
+		if entry.Fields == nil {
+			flags |= STREAM_ITEM_FLAG_SAMEFIELDS
+		}
+
+		writer.appendInt(int64(flags))
+		writer.appendInt(int64(entry.Id.Timestamp() - node.MasterId.Timestamp()))
+		writer.appendInt(int64(entry.Id.SeqNo() - node.MasterId.SeqNo()))
+
+		if entry.Fields == nil {
+			for _, value := range entry.Values {
+				writer.appendString(value)
+			}
+			writer.appendInt(int64(len(entry.Values) + 3))
+			continue
+		}
+
+		writer.appendInt(int64(len(entry.Fields)))
+
+		for i, field := range entry.Fields {
+			writer.appendString(field)
+			writer.appendString(entry.Values[i])
+		}
+		writer.appendInt(int64(len(entry.Fields)*2 + 4))
+	}
+
+	return writer.bytes()
+}
+
+// parseRDBStream reads a stream saved by any version of Redis that saves them as
+// listpacks, as long as it doesn't have consumer groups, which we don't support
+func parseRDBStream(reader *bufio.Reader, valueType byte) (kvstore.StoredStream, error) {
+	snapshot := kvstore.StreamSnapshot{}
+	nodeCount, err := readRDBUint64(reader)
+
+	if err != nil {
+		return kvstore.StoredStream{}, err
+	}
+
+	for range nodeCount {
+		masterId, err := readRDBString(reader)
+
+		if err != nil {
+			return kvstore.StoredStream{}, err
+		}
+
+		if len(masterId) != 16 {
+			return kvstore.StoredStream{}, errors.New("invalid stream node key")
+		}
+
+		listpack, err := readRDBString(reader)
+
+		if err != nil {
+			return kvstore.StoredStream{}, err
+		}
+
+		node, err := parseStreamNode(
+			kvstore.NewStreamId(binary.BigEndian.Uint64([]byte(masterId[:8])), binary.BigEndian.Uint64([]byte(masterId[8:]))),
+			[]byte(listpack),
+		)
+
+		if err != nil {
+			return kvstore.StoredStream{}, err
+		}
+
+		snapshot.Nodes = append(snapshot.Nodes, node)
+	}
+
+	if snapshot.Length, err = readRDBUint64(reader); err != nil {
+		return kvstore.StoredStream{}, err
+	}
+
+	if snapshot.LastId, err = readRDBStreamId(reader); err != nil {
+		return kvstore.StoredStream{}, err
+	}
+
+	if valueType == STREAM_LISTPACKS {
+		// Older versions leave out the first and max deleted IDs and the count of
+		// entries ever added, so make do with what the entries tell us
+		snapshot.EntriesAdded = snapshot.Length
+		snapshot.FirstId = firstLiveStreamId(snapshot)
+	} else {
+		if snapshot.FirstId, err = readRDBStreamId(reader); err != nil {
+			return kvstore.StoredStream{}, err
+		}
+
+		if snapshot.MaxDeletedId, err = readRDBStreamId(reader); err != nil {
+			return kvstore.StoredStream{}, err
+		}
+
+		if snapshot.EntriesAdded, err = readRDBUint64(reader); err != nil {
+			return kvstore.StoredStream{}, err
+		}
+	}
+
+	groups, err := readRDBUint64(reader)
+
+	if err != nil {
+		return kvstore.StoredStream{}, err
+	}
+
+	if groups > 0 {
+		return kvstore.StoredStream{}, errors.New("streams with consumer groups are not supported")
+	}
+
+	return kvstore.NewStoredStreamFromSnapshot(snapshot), nil
+}
+
+func readRDBStreamId(reader *bufio.Reader) (kvstore.StreamId, error) {
+	timestamp, err := readRDBUint64(reader)
+
+	if err != nil {
+		return kvstore.StreamId{}, err
+	}
+
+	seqNo, err := readRDBUint64(reader)
+	return kvstore.NewStreamId(timestamp, seqNo), err
+}
+
+func firstLiveStreamId(snapshot kvstore.StreamSnapshot) kvstore.StreamId {
+	for _, node := range snapshot.Nodes {
+		for _, entry := range node.Entries {
+			if !entry.Deleted {
+				return entry.Id
+			}
+		}
+	}
+	return kvstore.StreamId{}
+}
+
+// parseStreamNode reads back a node written by encodeStreamNode
+func parseStreamNode(masterId kvstore.StreamId, listpack []byte) (kvstore.StreamSnapshotNode, error) {
+	node := kvstore.StreamSnapshotNode{MasterId: masterId}
+	entries, err := parseListpack(listpack)
+
+	if err != nil {
+		return node, err
+	}
+
+	errCorrupt := errors.New("invalid stream node")
+	next := func() (string, error) {
+		if len(entries) == 0 {
+			return "", errCorrupt
+		}
+
+		entry := entries[0]
+		entries = entries[1:]
+		return entry, nil
+	}
+	nextInt := func() (int64, error) {
+		entry, err := next()
+
+		if err != nil {
+			return 0, err
+		}
+
+		value, err := strconv.ParseInt(entry, 10, 64)
+
+		if err != nil {
+			return 0, errCorrupt
+		}
+		return value, nil
+	}
+
+	header := make([]int64, 3)
+
+	for i := range header {
+		if header[i], err = nextInt(); err != nil {
+			return node, err
+		}
+	}
+
+	live, deleted, fieldCount := header[0], header[1], header[2]
+
+	if fieldCount < 0 || int(fieldCount) > len(entries) {
+		return node, errCorrupt
+	}
+
+	node.MasterFields = make([]string, fieldCount)
+
+	for i := range node.MasterFields {
+		node.MasterFields[i], _ = next()
+	}
+
+	if terminator, err := nextInt(); err != nil || terminator != 0 {
+		return node, errCorrupt
+	}
+
+	for len(entries) > 0 {
+		flags, err := nextInt()
+
+		if err != nil {
+			return node, err
+		}
+
+		msDiff, err := nextInt()
+
+		if err != nil {
+			return node, err
+		}
+
+		seqDiff, err := nextInt()
+
+		if err != nil {
+			return node, err
+		}
+
+		entry := kvstore.StreamSnapshotEntry{
+			Id:      kvstore.NewStreamId(masterId.Timestamp()+uint64(msDiff), masterId.SeqNo()+uint64(seqDiff)),
+			Deleted: flags&STREAM_ITEM_FLAG_DELETED != 0,
+		}
+
+		if flags&STREAM_ITEM_FLAG_SAMEFIELDS != 0 {
+			entry.Values = make([]string, len(node.MasterFields))
+
+			for i := range entry.Values {
+				if entry.Values[i], err = next(); err != nil {
+					return node, err
+				}
+			}
+		} else {
+			count, err := nextInt()
+
+			if err != nil {
+				return node, err
+			}
+
+			if count < 0 || int(count)*2 > len(entries) {
+				return node, errCorrupt
+			}
+
+			entry.Fields = make([]string, count)
+			entry.Values = make([]string, count)
+
+			for i := range entry.Fields {
+				entry.Fields[i], _ = next()
+				entry.Values[i], _ = next()
+			}
+		}
+
+		// Skip over the count used to walk backwards
+		if _, err := nextInt(); err != nil {
+			return node, err
+		}
+
+		node.Entries = append(node.Entries, entry)
+
+		if entry.Deleted {
+			deleted--
+		} else {
+			live--
+		}
+	}
+
+	if live != 0 || deleted != 0 {
+		return node, fmt.Errorf("%w: entry counts don't match", errCorrupt)
+	}
+
+	return node, nil
+}
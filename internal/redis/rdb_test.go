@@ -2,9 +2,16 @@ package redis
 
 import (
 	"bufio"
+	"bytes"
+	"codecrafters/internal/kvstore"
+	"context"
+	"fmt"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func createAndWriteTempFile(name string, body []byte) (*os.File, error) {
@@ -57,6 +64,12 @@ func Test_parseSizeEncoded(t *testing.T) {
 			want:    integerSizeEncoded{262_145},
 			wantErr: false,
 		},
+		{
+			name:     "It should parse an 8 byte integer correctly",
+			fileData: []byte{RDB_64BIT_LEN, 0, 0, 0, 1, 0, 0, 0, 2},
+			want:     integerSizeEncoded{1<<32 + 2},
+			wantErr:  false,
+		},
 		{
 			name: "It should parse a string with an 8 bit length value correctly",
 			fileData: []byte{
@@ -111,3 +124,201 @@ func Test_parseSizeEncoded(t *testing.T) {
 		})
 	}
 }
+
+func Test_parseMetadataSection(t *testing.T) {
+	tests := []struct {
+		name          string
+		fileData      []byte
+		wantSection   byte
+		wantLibraries []string
+		wantErr       bool
+	}{
+		{
+			name: "It should skip auxiliary fields",
+			fileData: append(
+				append([]byte{AUX}, append(encodeRDBString("redis-ver"), encodeRDBString("7.2.0")...)...),
+				AUX, 0x0A, 'r', 'e', 'd', 'i', 's', '-', 'b', 'i', 't', 's', 0xC0, 0x40, SELECT_DB,
+			),
+			wantSection:   SELECT_DB,
+			wantLibraries: []string{},
+		},
+		{
+			name:          "It should read function libraries",
+			fileData:      append(append([]byte{FUNCTION2}, encodeRDBString(testLibrary)...), EOF),
+			wantSection:   EOF,
+			wantLibraries: []string{testLibrary},
+		},
+		{
+			name:     "It should reject unknown opcodes",
+			fileData: []byte{0x01},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := createAndWriteTempFile("metadata", tt.fileData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+
+			section, libraries, err := parseMetadataSection(bufio.NewReader(f))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMetadataSection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if section != tt.wantSection || !reflect.DeepEqual(libraries, tt.wantLibraries) {
+				t.Errorf("parseMetadataSection() = %#x, %v, want %#x, %v", section, libraries, tt.wantSection, tt.wantLibraries)
+			}
+		})
+	}
+}
+
+func Test_encodeRDB(t *testing.T) {
+	expiresAt := uint64(1_900_000_000_000)
+	values := map[string]kvstore.StoredValue{
+		"foo":     kvstore.NewStoredString("bar", nil),
+		"expires": kvstore.NewStoredString("soon", &expiresAt),
+	}
+	functions := append([]byte{FUNCTION2}, encodeRDBString(testLibrary)...)
+
+	f, err := createAndWriteTempFile("encoded", encodeRDB(values, functions, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	reader := bufio.NewReader(f)
+
+	if err := parseHeader(reader); err != nil {
+		t.Fatalf("parseHeader() error = %v", err)
+	}
+
+	section, libraries, err := parseMetadataSection(reader)
+	if err != nil || section != SELECT_DB || !reflect.DeepEqual(libraries, []string{testLibrary}) {
+		t.Fatalf("parseMetadataSection() = %#x, %v, %v", section, libraries, err)
+	}
+
+	section, dbs, err := parseDBSection(reader)
+	if err != nil || section != EOF {
+		t.Fatalf("parseDBSection() = %#x, %v", section, err)
+	}
+
+	want := []persistedDB{{values: []keyValuePair{
+		{key: "expires", value: "soon", expiryInMs: &expiresAt},
+		{key: "foo", value: "bar"},
+	}}}
+	if !reflect.DeepEqual(dbs, want) {
+		t.Errorf("parseDBSection() = %+v, want %+v", dbs, want)
+	}
+}
+
+func Test_readRDBString(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileData []byte
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "It should read a plain string",
+			fileData: []byte{0x03, 'f', 'o', 'o'},
+			want:     "foo",
+		},
+		{
+			name: "It should decompress an LZF string",
+			// A literal "a" then a back reference repeating it nine times
+			fileData: []byte{0xC3, 0x05, 0x0A, 0x00, 'a', 0xE0, 0x00, 0x00},
+			want:     "aaaaaaaaaa",
+		},
+		{
+			name:     "It should refuse an LZF string referring to before its start",
+			fileData: []byte{0xC3, 0x05, 0x0A, 0x00, 'a', 0xE0, 0x00, 0x01},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readRDBString(bufio.NewReader(bytes.NewReader(tt.fileData)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readRDBString() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("readRDBString() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseListpack(t *testing.T) {
+	writer := &listpackWriter{}
+	want := []string{}
+
+	for _, value := range []int64{0, 127, 128, -1, 4095, -4096, 32767, -32768, 1 << 22, 1 << 30, -1 << 40} {
+		writer.appendInt(value)
+		want = append(want, strconv.FormatInt(value, 10))
+	}
+
+	for _, value := range []string{"", "foo", strings.Repeat("x", 200), strings.Repeat("y", 5000)} {
+		writer.appendString(value)
+		want = append(want, value)
+	}
+
+	got, err := parseListpack(writer.bytes())
+	if err != nil {
+		t.Fatalf("parseListpack() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseListpack() = %v, want %v", got, want)
+	}
+}
+
+func Test_encodeRDBStream(t *testing.T) {
+	ctx := context.Background()
+	store := kvstore.NewKVStore()
+
+	for i := range 150 {
+		fields := []string{"temperature", strconv.Itoa(i), "humidity", "50"}
+
+		if i%3 == 0 {
+			fields = []string{"temperature", strconv.Itoa(i)}
+		}
+
+		if _, _, err := store.SetStream(ctx, "readings", fmt.Sprintf("%d-%d", 1000+i/2, i%2), fields, kvstore.StreamAddOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := store.DeleteStreamEntries(ctx, "readings", []kvstore.StreamId{kvstore.NewStreamId(1001, 0), kvstore.NewStreamId(1060, 1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	values := store.Snapshot(ctx)
+	values["foo"] = kvstore.NewStoredString("bar", nil)
+
+	reader := bufio.NewReader(bytes.NewReader(encodeRDB(values, nil, time.Now())))
+
+	if err := parseHeader(reader); err != nil {
+		t.Fatalf("parseHeader() error = %v", err)
+	}
+
+	if section, _, err := parseMetadataSection(reader); err != nil || section != SELECT_DB {
+		t.Fatalf("parseMetadataSection() = %#x, %v", section, err)
+	}
+
+	section, dbs, err := parseDBSection(reader)
+	if err != nil || section != EOF {
+		t.Fatalf("parseDBSection() = %#x, %v", section, err)
+	}
+
+	if len(dbs) != 1 || len(dbs[0].values) != 2 || dbs[0].values[0].value != "bar" || dbs[0].values[1].stream == nil {
+		t.Fatalf("parseDBSection() = %+v, want foo and a stream", dbs)
+	}
+
+	want := values["readings"].(kvstore.StoredStream).Snapshot()
+	if got := dbs[0].values[1].stream.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDBSection() stream = %+v, want %+v", got, want)
+	}
+}
@@ -4,71 +4,255 @@ import (
 	"codecrafters/internal/kvstore"
 	"codecrafters/internal/serde"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"os"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/dchest/uniuri"
 )
 
 const (
-	PING     = "ping"
-	SET      = "set"
-	INFO     = "info"
-	ECHO     = "echo"
-	GET      = "get"
-	CONFIG   = "config"
-	KEYS     = "keys"
-	REPLCONF = "replconf"
-	PSYNC    = "psync"
-	WAIT     = "wait"
-	TYPE     = "type"
-	XADD     = "xadd"
-	XRANGE   = "xrange"
-	XREAD    = "xread"
-	INCR     = "incr"
-	MULTI    = "multi"
-	EXEC     = "exec"
-	DISCARD  = "discard"
+	PING        = "ping"
+	SET         = "set"
+	INFO        = "info"
+	ECHO        = "echo"
+	GET         = "get"
+	CONFIG      = "config"
+	KEYS        = "keys"
+	REPLCONF    = "replconf"
+	PSYNC       = "psync"
+	WAIT        = "wait"
+	TYPE        = "type"
+	DEL         = "del"
+	XADD        = "xadd"
+	XRANGE      = "xrange"
+	XREVRANGE   = "xrevrange"
+	XREAD       = "xread"
+	XLEN        = "xlen"
+	XDEL        = "xdel"
+	XTRIM       = "xtrim"
+	XSETID      = "xsetid"
+	XINFO       = "xinfo"
+	INCR        = "incr"
+	MULTI       = "multi"
+	EXEC        = "exec"
+	DISCARD     = "discard"
+	WATCH       = "watch"
+	UNWATCH     = "unwatch"
+	FLUSHALL    = "flushall"
+	FLUSHDB     = "flushdb"
+	EVAL        = "eval"
+	EVALSHA     = "evalsha"
+	EVAL_RO     = "eval_ro"
+	EVALSHA_RO  = "evalsha_ro"
+	SCRIPT      = "script"
+	FCALL       = "fcall"
+	FCALL_RO    = "fcall_ro"
+	FUNCTION    = "function"
+	AUTH        = "auth"
+	ACL         = "acl"
+	CLIENT      = "client"
+	HELLO       = "hello"
+	SHUTDOWN    = "shutdown"
+	OBJECT      = "object"
+	MEMORY      = "memory"
+	SLOWLOG     = "slowlog"
+	REPLICAOF   = "replicaof"
+	SLAVEOF     = "slaveof"
+	MONITOR     = "monitor"
+	LATENCY     = "latency"
+	SUBSCRIBE   = "subscribe"
+	UNSUBSCRIBE = "unsubscribe"
 )
 
 type Redis struct {
 	store              kvstore.KVStore
 	configuration      configurationOptions
-	listener           net.Listener
-	replicas           map[string]RedisConnection
+	listeners          []net.Listener
+	replicas           map[int64]RedisConnection
 	processedByteCount int
 	ackChan            chan ReplicaAck
+	// Held while running commands, so that a transaction can run without any other
+	// client's commands interleaving with it
+	executionMutex *sync.Mutex
+	scripting      *scriptingState
+	functions      *functionsState
+	acl            *aclState
+	clients        *clientRegistry
+	tracking       *trackingState
+	shutdown       *shutdownState
+	persistence    *persistenceState
+	stats          *serverStats
+	replication    *replicationState
+	slowlog        *slowlogState
+	latency        *latencyMonitor
+	// Identifies this run of the server, changing each time it starts
+	runId     string
+	startTime time.Time
+	// Serves Prometheus metrics over HTTP, when metrics-port is set
+	metricsListener net.Listener
 }
 
 func NewRedisWithConfig() (Redis, error) {
-	config, err := ParseConfigurationFromFlags()
+	config, err := ParseConfiguration(os.Args[1:])
 
 	redis := Redis{
-		store:         kvstore.NewKVStore(),
-		configuration: config,
-		replicas:      map[string]RedisConnection{},
-		ackChan:       make(chan ReplicaAck),
+		store:          kvstore.NewKVStore(),
+		configuration:  config,
+		replicas:       map[int64]RedisConnection{},
+		ackChan:        make(chan ReplicaAck),
+		executionMutex: &sync.Mutex{},
+		scripting:      newScriptingState(),
+		functions:      newFunctionsState(),
+		acl:            newACLState(),
+		clients:        newClientRegistry(),
+		tracking:       newTrackingState(),
+		shutdown:       newShutdownState(),
+		persistence:    newPersistenceState(),
+		stats:          newServerStats(),
+		replication:    newReplicationState(config.replBacklogSize, config.replicationConfig.replicaConfig.Role() == MASTER),
+		slowlog:        newSlowlogState(),
+		latency:        newLatencyMonitor(),
+		runId:          uniuri.NewLenChars(40, []byte("0123456789abcdef")),
+		startTime:      time.Now(),
 	}
 
+	tracking, persistence := redis.tracking, redis.persistence
+	redis.store.OnKeyModified(func(key string) {
+		tracking.keyModified(key)
+		persistence.keyModified(key)
+	})
+	redis.scripting.setBusyThreshold(time.Duration(config.busyReplyThresholdMs) * time.Millisecond)
+	applyEvictionConfig(&redis)
+	applySlowlogConfig(&redis)
+	redis.latency.setThreshold(time.Duration(config.latencyMonitorThresholdMs) * time.Millisecond)
+
 	if err != nil {
 		return redis, err
 	}
 
-	port := fmt.Sprintf(":%d", config.port)
-	fmt.Println("Listening on ", port)
-	listener, err := net.Listen("tcp", port)
+	if config.requirePass != "" {
+		redis.acl.setUser(DEFAULT_USER, []string{"resetpass", ">" + config.requirePass})
+	}
 
-	if err != nil {
-		return redis, err
+	for _, rules := range config.users {
+		if err := redis.acl.setUser(rules[0], rules[1:]); err != nil {
+			return redis, err
+		}
+	}
+
+	if config.aclFile != "" {
+		if err := redis.acl.loadFile(config.aclFile); err != nil {
+			return redis, err
+		}
 	}
 
-	redis.listener = listener
+	if err := redis.listen(); err != nil {
+		return redis, err
+	}
 
 	return redis, nil
 }
 
+// listen opens the plain TCP, TLS and Unix socket listeners for whichever are
+// enabled
+func (r *Redis) listen() error {
+	if r.configuration.port != 0 {
+		port := fmt.Sprintf(":%d", r.configuration.port)
+		fmt.Println("Listening on ", port)
+		listener, err := net.Listen("tcp", port)
+
+		if err != nil {
+			return err
+		}
+
+		r.listeners = append(r.listeners, listener)
+	}
+
+	if r.configuration.tls.port != 0 {
+		tlsConfig, err := r.configuration.tls.serverConfig()
+
+		if err != nil {
+			return err
+		}
+
+		port := fmt.Sprintf(":%d", r.configuration.tls.port)
+		fmt.Println("Listening for TLS on ", port)
+		listener, err := tls.Listen("tcp", port, tlsConfig)
+
+		if err != nil {
+			return err
+		}
+
+		r.listeners = append(r.listeners, listener)
+	}
+
+	if r.configuration.unixSocket.path != "" {
+		fmt.Println("Listening on ", r.configuration.unixSocket.path)
+		listener, err := r.configuration.unixSocket.listen()
+
+		if err != nil {
+			return err
+		}
+
+		r.listeners = append(r.listeners, listener)
+	}
+
+	if len(r.listeners) == 0 {
+		return errors.New("nothing to listen on, set port, tls-port or unixsocket")
+	}
+
+	return r.listenMetrics()
+}
+
+// serve accepts clients on every listener, returning once they've all closed
+func (r *Redis) serve() {
+	wg := sync.WaitGroup{}
+
+	for _, listener := range r.listeners {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				conn, err := listener.Accept()
+
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+				go r.handleConnection(conn)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// Close stops accepting clients, which also removes the Unix socket file. Use
+// Shutdown to stop the server altogether.
+func (r *Redis) Close() {
+	for _, listener := range r.listeners {
+		listener.Close()
+	}
+
+	if r.metricsListener != nil {
+		r.metricsListener.Close()
+	}
+}
+
 func (r Redis) Port() int {
 	return r.configuration.port
 }
@@ -79,6 +263,13 @@ func (r *Redis) Init() error {
 		return err
 	}
 
+	if err := r.writePidFile(); err != nil {
+		return err
+	}
+
+	go r.serverCron()
+	go r.serveMetricsEndpoint()
+
 	if r.configuration.replicationConfig.replicaConfig.Role() == MASTER {
 		return initMaster(r)
 	} else {
@@ -86,32 +277,211 @@ func (r *Redis) Init() error {
 	}
 }
 
-func isWriteCommand(cmd string) bool {
+func (r *Redis) executeAndMaybePropagate(ctx context.Context, cmd string, args []string, value serde.Value, connection RedisConnection) ([]serde.Value, error) {
+	start := time.Now()
+	cmd, response := r.executeCommand(ctx, cmd, args, connection)
+	spec := lookupCommand(cmd, args)
+
+	duration := time.Since(start)
+
+	if spec.name != "" {
+		r.stats.commandCalled(spec.name, duration, response)
+	}
+
+	r.feedMonitors(ctx, cmd, args, connection)
+
+	// The commands a script runs count towards the script itself
+	if !isWithinScript(ctx) {
+		r.recordSlowCommand(cmd, args, duration, connection)
+
+		if spec.hasFlag(CMD_FAST) {
+			r.latency.addSampleIfNeeded(LATENCY_EVENT_FAST_COMMAND, duration)
+		} else {
+			r.latency.addSampleIfNeeded(LATENCY_EVENT_COMMAND, duration)
+		}
+	}
+
+	if spec.hasFlag(CMD_READONLY) {
+		r.tracking.trackKeys(connection.id, commandKeys(cmd, spec, args))
+	}
+	r.invalidateTrackedKeys(connection.id)
+
+	if isWriteCommand(cmd, args) {
+		r.propagate(value)
+	}
+	return response, nil
+}
+
+// Commands run as part of EXEC may not block, instead behaving as if their
+// timeout had expired straight away
+type denyBlockingKey struct{}
+
+func withBlockingDenied(ctx context.Context) context.Context {
+	return context.WithValue(ctx, denyBlockingKey{}, true)
+}
+
+func isBlockingDenied(ctx context.Context) bool {
+	denied, ok := ctx.Value(denyBlockingKey{}).(bool)
+	return ok && denied
+}
+
+// Commands run by a script are marked, so that they aren't logged as if a client
+// had sent them
+type withinScriptKey struct{}
+
+func withinScript(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withinScriptKey{}, true)
+}
+
+func isWithinScript(ctx context.Context) bool {
+	within, ok := ctx.Value(withinScriptKey{}).(bool)
+	return ok && within
+}
+
+// whileBlocked gives up the execution lock for as long as f runs, so that other
+// clients aren't held up while a blocking command waits
+func (r Redis) whileBlocked(f func()) {
+	r.executionMutex.Unlock()
+	defer r.executionMutex.Lock()
+	r.stats.blockedClients.Add(1)
+	defer r.stats.blockedClients.Add(-1)
+	f()
+}
+
+// processCommand validates a command read from a client, and then either queues
+// it as part of a transaction or runs it
+func (r *Redis) processCommand(ctx context.Context, cmd string, args []string, value serde.Value, connection *RedisConnection) []serde.Value {
+	spec, err := validateCommand(cmd, args)
+
+	if err != nil {
+		if connection.transaction {
+			connection.transactionAborted = true
+		}
+		if spec.name != "" {
+			r.stats.commandRejected(spec.name)
+		}
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	// Clients must be allowed to run a command before anything else, even what
+	// little is left to them while a script is busy
+	if err := r.authorize(cmd, args, spec, *connection, ACL_CONTEXT_TOPLEVEL); err != nil {
+		if connection.transaction {
+			connection.transactionAborted = true
+		}
+		r.stats.commandRejected(spec.name)
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	// While a script runs past the busy threshold there's little more we'll do than
+	// kill it
+	if r.scripting.isBusy() {
+		if isAllowedWhileBusy(cmd, args) {
+			_, response := r.executeCommand(withExecutionUnlocked(ctx), cmd, args, *connection)
+			return response
+		}
+		r.stats.commandRejected(spec.name)
+		return []serde.Value{serde.NewError("BUSY Redis is busy running a script. You can only call SCRIPT KILL or FUNCTION KILL or SHUTDOWN NOSAVE.")}
+	}
+
+	if err := r.checkSubscribedContext(cmd, *connection); err != nil {
+		r.stats.commandRejected(spec.name)
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	if err := r.makeRoom(ctx, cmd, args, *connection); err != nil {
+		if connection.transaction {
+			connection.transactionAborted = true
+		}
+		r.stats.commandRejected(spec.name)
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
 	switch cmd {
-	case SET:
+	case MULTI:
+		r.feedMonitors(ctx, cmd, args, *connection)
+		return r.multi(connection)
+	case EXEC:
+		r.waitWhilePaused(ctx, cmd, args, *connection)
+		response := r.exec(ctx, connection)
+		r.feedMonitors(ctx, cmd, args, *connection)
+		return response
+	case DISCARD:
+		r.feedMonitors(ctx, cmd, args, *connection)
+		return r.discard(connection)
+	}
+
+	if connection.transaction {
+		if cmd == WATCH {
+			connection.transactionAborted = true
+			return []serde.Value{serde.NewError("ERR WATCH inside MULTI is not allowed")}
+		}
+
+		if spec.hasFlag(CMD_NO_MULTI) {
+			connection.transactionAborted = true
+			r.stats.commandRejected(spec.name)
+			return []serde.Value{serde.NewError("ERR Command not allowed inside a transaction")}
+		}
+
+		connection.bufferedCommands = append(connection.bufferedCommands, value)
+		return []serde.Value{serde.NewSimpleString("QUEUED")}
+	}
+
+	r.waitWhilePaused(ctx, cmd, args, *connection)
+
+	r.executionMutex.Lock()
+	defer r.executionMutex.Unlock()
+
+	response, _ := r.executeAndMaybePropagate(ctx, cmd, args, value, *connection)
+	return response
+}
+
+// mayWrite reports whether a command could change the dataset, and so should be
+// held back by CLIENT PAUSE WRITE. Scripts may write, as may a transaction holding
+// a command that does.
+func (r *Redis) mayWrite(cmd string, args []string, connection RedisConnection) bool {
+	switch cmd {
+	case EVAL, EVALSHA, FCALL:
 		return true
-	default:
+	case EXEC:
+		for _, command := range connection.bufferedCommands {
+			queuedCmd, queuedArgs, err := r.parseCommand(command)
+
+			if err == nil && r.mayWrite(queuedCmd, queuedArgs, connection) {
+				return true
+			}
+		}
 		return false
 	}
+
+	return isWriteCommand(cmd, args)
 }
 
-func (r *Redis) executeAndMaybePropagate(ctx context.Context, cmd string, args []string, value serde.Value, connection RedisConnection) ([]serde.Value, error) {
-	cmd, response := r.executeCommand(ctx, cmd, args, connection)
+// waitWhilePaused holds the command back while CLIENT PAUSE is in effect for it,
+// giving up should the client hang up
+func (r *Redis) waitWhilePaused(ctx context.Context, cmd string, args []string, connection RedisConnection) {
+	mayWrite := r.mayWrite(cmd, args, connection)
 
-	if isWriteCommand(cmd) {
-		for _, replica := range r.replicas {
-			replica.WithWriteMutex(func() error {
-				return replica.Send([]serde.Value{value})
-			})
-		}
-		r.processedByteCount += len(value.Marshal())
+	if !r.clients.isPaused(connection.id, mayWrite) {
+		return
 	}
-	return response, nil
+
+	waitCtx, stopWatching := connection.WatchForClose(ctx)
+	defer stopWatching()
+
+	r.clients.waitWhilePaused(waitCtx, connection.id, mayWrite)
 }
 
 func (r *Redis) handleConnection(c net.Conn) {
-	connection := NewRedisConnection(c)
+	connection := NewRedisConnection(trafficConn{c, r.stats})
+	connection.auth.authenticated = r.acl.defaultUserOpen()
+	r.clients.register(&connection, CLIENT_TYPE_NORMAL)
+	r.stats.connectionReceived()
 	defer connection.Close()
+	defer r.clients.unregister(connection.id)
+	defer r.forgetReplica(connection.id)
+	defer r.tracking.disable(connection.id)
+	defer r.store.Unwatch(connection.watch)
 	for {
 		ctx := context.Background()
 
@@ -128,61 +498,24 @@ func (r *Redis) handleConnection(c net.Conn) {
 				return err
 			}
 
-			switch cmd {
-			case MULTI:
-				{
-					if connection.transaction {
-						return connection.WithWriteMutex(func() error {
-							return connection.Send([]serde.Value{serde.NewError("ERR MULTI calls can not be nested")})
-						})
-					}
-					connection.transaction = true
-					return connection.Send(r.multi(ctx, []string{}))
-				}
-			case DISCARD:
-				{
-					if connection.transaction {
-						connection.transaction = false
-						connection.bufferedCommands = []serde.Value{}
-
-						return connection.WithWriteMutex(func() error {
-							return connection.Send([]serde.Value{serde.NewSimpleString("OK")})
-						})
-					}
-					return connection.WithWriteMutex(func() error {
-						return connection.Send([]serde.Value{serde.NewError("ERR DISCARD without MULTI")})
-					})
-				}
-			case EXEC:
-				{
-					if !connection.transaction {
-						return connection.WithWriteMutex(func() error {
-							return connection.Send([]serde.Value{serde.NewError("ERR EXEC without MULTI")})
-						})
-					}
-					response := r.exec(ctx, args, connection)
-					connection.transaction = false
-					err = connection.WithWriteMutex(func() error { return connection.Send(response) })
-					return err
-				}
-			default:
-				{
-					if connection.transaction {
-						connection.bufferedCommands = append(connection.bufferedCommands, value)
-						return connection.WithWriteMutex(func() error { return connection.Send([]serde.Value{serde.NewSimpleString("QUEUED")}) })
-					}
-					response, err := r.executeAndMaybePropagate(ctx, cmd, args, value, connection)
-					if err != nil {
-						return err
-					}
-					return connection.WithWriteMutex(func() error { return connection.Send(response) })
-				}
-			}
+			name := lookupCommand(cmd, args).name
+			r.clients.touch(connection, name)
+			response := r.processCommand(ctx, cmd, args, value, &connection)
+			r.clients.refresh(connection)
+			r.tracking.afterCommand(connection.id, name)
+			r.stats.replied(response)
+
+			err = connection.WithWriteMutex(func() error { return connection.Send(response) })
 
+			if err == nil && r.clients.shouldClose(connection.id) {
+				return io.EOF
+			}
+			return err
 		})
 
 		if err != nil {
-			if err == io.EOF {
+			// A client killed by CLIENT KILL has had its connection closed under it
+			if err == io.EOF || errors.Is(err, net.ErrClosed) {
 				return
 			} else {
 				slog.Error(fmt.Sprintf("Error reading from the client %v", err))
@@ -229,29 +562,91 @@ func (r *Redis) executeCommand(ctx context.Context, cmd string, commandArray []s
 	case GET:
 		return GET, r.get(ctx, commandArray)
 	case CONFIG:
-		return CONFIG, r.config(commandArray)
+		return CONFIG, r.config(commandArray, connection)
 	case KEYS:
 		return KEYS, r.keys(ctx, commandArray)
 	case INFO:
-		return INFO, r.info(commandArray)
+		return INFO, r.info(ctx, commandArray)
 	case REPLCONF:
 		return REPLCONF, r.replconf(commandArray, connection)
 	case PSYNC:
-		return PSYNC, r.psync(connection)
+		return PSYNC, r.psync(ctx, commandArray, connection)
+	case REPLICAOF, SLAVEOF:
+		return cmd, r.replicaOf(commandArray)
 	case WAIT:
-		return WAIT, r.wait(commandArray)
+		return WAIT, r.wait(ctx, commandArray)
 	case TYPE:
 		return TYPE, r.typeCmd(ctx, commandArray)
+	case DEL:
+		return DEL, r.del(ctx, commandArray)
 	case XADD:
 		return XADD, r.xadd(ctx, commandArray)
 	case XRANGE:
 		return XRANGE, r.xrange(ctx, commandArray)
+	case XREVRANGE:
+		return XREVRANGE, r.xrevrange(ctx, commandArray)
 	case XREAD:
-		return XREAD, r.xread(ctx, commandArray)
+		return XREAD, r.xread(ctx, commandArray, connection)
+	case XLEN:
+		return XLEN, r.xlen(ctx, commandArray)
+	case XDEL:
+		return XDEL, r.xdel(ctx, commandArray)
+	case XTRIM:
+		return XTRIM, r.xtrim(ctx, commandArray)
+	case XSETID:
+		return XSETID, r.xsetid(ctx, commandArray)
+	case XINFO:
+		return XINFO, r.xinfo(ctx, commandArray)
 	case INCR:
 		return INCR, r.incr(ctx, commandArray)
-	case MULTI:
-		return MULTI, r.multi(ctx, commandArray)
+	case WATCH:
+		return WATCH, r.watch(ctx, commandArray, connection)
+	case UNWATCH:
+		return UNWATCH, r.unwatch(connection)
+	case FLUSHALL:
+		return FLUSHALL, r.flush(commandArray)
+	case FLUSHDB:
+		return FLUSHDB, r.flush(commandArray)
+	case EVAL:
+		return EVAL, r.eval(ctx, cmd, commandArray, false, connection)
+	case EVAL_RO:
+		return EVAL_RO, r.eval(ctx, cmd, commandArray, true, connection)
+	case EVALSHA:
+		return EVALSHA, r.evalSha(ctx, cmd, commandArray, false, connection)
+	case EVALSHA_RO:
+		return EVALSHA_RO, r.evalSha(ctx, cmd, commandArray, true, connection)
+	case SCRIPT:
+		return SCRIPT, r.script(commandArray)
+	case FCALL:
+		return FCALL, r.fcall(ctx, commandArray, false, connection)
+	case FCALL_RO:
+		return FCALL_RO, r.fcall(ctx, commandArray, true, connection)
+	case FUNCTION:
+		return FUNCTION, r.function(commandArray)
+	case OBJECT:
+		return OBJECT, r.object(ctx, commandArray)
+	case MEMORY:
+		return MEMORY, r.memory(ctx, commandArray, connection)
+	case SLOWLOG:
+		return SLOWLOG, r.slowlogCommand(commandArray)
+	case LATENCY:
+		return LATENCY, r.latencyCommand(commandArray, connection)
+	case MONITOR:
+		return MONITOR, r.monitor(connection)
+	case SUBSCRIBE:
+		return SUBSCRIBE, r.subscribe(commandArray, connection)
+	case UNSUBSCRIBE:
+		return UNSUBSCRIBE, r.unsubscribe(commandArray, connection)
+	case AUTH:
+		return AUTH, r.auth(commandArray, connection)
+	case ACL:
+		return ACL, r.aclCommand(commandArray, connection)
+	case CLIENT:
+		return CLIENT, r.client(commandArray, connection)
+	case HELLO:
+		return HELLO, r.hello(commandArray, connection)
+	case SHUTDOWN:
+		return SHUTDOWN, r.shutdownCommand(ctx, commandArray)
 	default:
 		return "", []serde.Value{serde.NewError(fmt.Sprintf("invalid command %s %v", cmd, commandArray))}
 	}
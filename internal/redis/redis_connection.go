@@ -2,14 +2,16 @@ package redis
 
 import (
 	"codecrafters/internal/array"
+	"codecrafters/internal/kvstore"
 	"codecrafters/internal/serde"
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
 	"strings"
 	"sync"
-
-	"github.com/dchest/uniuri"
+	"time"
 )
 
 type RedisConnection struct {
@@ -18,10 +20,13 @@ type RedisConnection struct {
 	conn               net.Conn
 	readMutex          *sync.Mutex
 	writeMutex         *sync.Mutex
-	id                 string
+	id                 int64
 	processedByteCount int
 	transaction        bool
+	transactionAborted bool
 	bufferedCommands   []serde.Value
+	watch              *kvstore.Watch
+	auth               *connectionAuth
 }
 
 func (r RedisConnection) Ping() error {
@@ -74,7 +79,39 @@ func (r RedisConnection) ReplConf(args []string) error {
 	return err
 }
 
-func (r RedisConnection) Psync(replicationId string, offset string) error {
+func (r RedisConnection) Auth(username string, password string) error {
+	command := []string{"AUTH", password}
+
+	if username != "" {
+		command = []string{"AUTH", username, password}
+	}
+
+	err := r.Send([]serde.Value{serde.NewArray(array.Map(command, func(s string) serde.Value {
+		return serde.NewBulkString(s)
+	}))})
+
+	if err != nil {
+		return err
+	}
+
+	response, err := r.Read()
+
+	if err != nil {
+		return err
+	}
+
+	simpleString, ok := response.(serde.SimpleString)
+
+	if !ok || strings.ToLower(simpleString.Value()) != "ok" {
+		return fmt.Errorf("expected auth to respond with 'OK' got %v", response)
+	}
+
+	return nil
+}
+
+// Psync asks the master to start replicating to us, returning its answer of
+// either FULLRESYNC along with the RDB file sent with it, or CONTINUE
+func (r RedisConnection) Psync(replicationId string, offset string) (string, []byte, error) {
 	command := array.Map([]string{"PSYNC", replicationId, offset}, func(s string) serde.Value {
 		return serde.NewBulkString(s)
 	})
@@ -82,35 +119,33 @@ func (r RedisConnection) Psync(replicationId string, offset string) error {
 	err := r.Send([]serde.Value{serde.NewArray(command)})
 
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 
 	response, err := r.Read()
 
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 
 	simpleString, ok := response.(serde.SimpleString)
 
-	if !ok || !strings.HasPrefix(simpleString.Value(), "FULLRESYNC") {
-		return fmt.Errorf("expected to receive full sync on child, got %s instead", simpleString.Value())
+	if !ok {
+		return "", nil, fmt.Errorf("expected a simple string in answer to PSYNC, got %v instead", response)
 	}
 
-	return r.ReadRDB()
-}
+	reply := simpleString.Value()
 
-func (r RedisConnection) ReplConfGetAck() error {
-	return r.WithWriteMutex(func() error {
-		command := []string{"REPLCONF", "GETACK", "*"}
+	if strings.HasPrefix(reply, "CONTINUE") {
+		return reply, nil, nil
+	}
 
-		commandArr := array.Map(command, func(s string) serde.Value {
-			return serde.NewBulkString(s)
-		})
-		err := r.Send([]serde.Value{serde.NewArray(commandArr)})
+	if !strings.HasPrefix(reply, "FULLRESYNC") {
+		return "", nil, fmt.Errorf("expected to receive full sync on child, got %s instead", reply)
+	}
 
-		return err
-	})
+	rdb, err := r.ReadRDB()
+	return reply, rdb, err
 }
 
 func (r RedisConnection) Send(value []serde.Value) error {
@@ -148,10 +183,52 @@ func (r RedisConnection) CanRead() bool {
 	return r.reader.CanRead()
 }
 
-func (r RedisConnection) ReadRDB() error {
+func (r RedisConnection) ReadRDB() ([]byte, error) {
 	return r.reader.ReadRDB()
 }
 
+// WatchForClose returns a context that is cancelled should the client hang up
+// while we're blocked on its behalf. The returned stop function must be called
+// before reading from the connection again.
+func (r RedisConnection) WatchForClose(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		err := r.reader.Peek()
+
+		var netErr net.Error
+		if err != nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+			cancel()
+		}
+	}()
+
+	return ctx, func() {
+		// Knock the watcher out of its read so the reader is ours again
+		r.conn.SetReadDeadline(time.Now())
+		<-done
+		r.conn.SetReadDeadline(time.Time{})
+		cancel()
+	}
+}
+
+// Info describes the client for ACL LOG
+func (r RedisConnection) Info() string {
+	addr, laddr := "", ""
+
+	if r.conn != nil {
+		addr, laddr = clientAddrs(r.conn)
+	}
+
+	user := DEFAULT_USER
+	if r.auth != nil {
+		user = r.auth.user
+	}
+
+	return fmt.Sprintf("id=%d addr=%s laddr=%s user=%s", r.id, addr, laddr, user)
+}
+
 func (r RedisConnection) Close() {
 	r.conn.Close()
 }
@@ -166,8 +243,9 @@ func NewRedisConnection(c net.Conn) RedisConnection {
 		conn:             c,
 		readMutex:        &sync.Mutex{},
 		writeMutex:       &sync.Mutex{},
-		id:               uniuri.NewLen(40),
 		transaction:      false,
 		bufferedCommands: []serde.Value{},
+		watch:            kvstore.NewWatch(),
+		auth:             &connectionAuth{user: DEFAULT_USER},
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"strconv"
+	"strings"
 )
 
 func (r Redis) replconf(args []string, connection RedisConnection) []serde.Value {
@@ -13,8 +14,8 @@ func (r Redis) replconf(args []string, connection RedisConnection) []serde.Value
 		return []serde.Value{serde.NewError("REPLCONF needs at least one arg")}
 	}
 
-	switch args[0] {
-	case "GETACK":
+	switch strings.ToLower(args[0]) {
+	case "getack":
 		{
 			slog.Debug(fmt.Sprintf("Slave received GETACK with command %v", args))
 			if args[1] != "*" {
@@ -26,18 +27,30 @@ func (r Redis) replconf(args []string, connection RedisConnection) []serde.Value
 				serde.NewBulkString(fmt.Sprintf("%d", r.processedByteCount)),
 			})}
 		}
-	case "ACK":
+	case "ack":
 		{
 			processedBytes, err := strconv.Atoi(args[1])
 			if err != nil {
 				return []serde.Value{serde.NewError("REPLCONF ACK takes a number for offset")}
 			}
 			slog.Info(fmt.Sprintf("Master received ACK back from slave %v with %v as processedBytes. Master currently at %v bytes", connection.id, processedBytes, r.processedByteCount))
-			// TODO: probably needs a lock?
-			connection.processedByteCount = processedBytes
-			r.ackChan <- ReplicaAck{connectionId: connection.id, processedByteCount: processedBytes}
+			r.clients.replicaAcked(connection.id, processedBytes)
+			// Only a pending WAIT cares about acks, so drop them if nobody is listening
+			select {
+			case r.ackChan <- ReplicaAck{connectionId: connection.id, processedByteCount: processedBytes}:
+			default:
+			}
 			return []serde.Value{}
 		}
+	case "listening-port":
+		{
+			port, err := strconv.Atoi(args[1])
+			if err != nil {
+				return []serde.Value{serde.NewError("ERR value is not an integer or out of range")}
+			}
+			r.clients.setReplicaPort(connection.id, port)
+			return []serde.Value{serde.NewSimpleString("OK")}
+		}
 	default:
 		{
 			return []serde.Value{serde.NewSimpleString("OK")}
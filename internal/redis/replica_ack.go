@@ -1,6 +1,6 @@
 package redis
 
 type ReplicaAck struct {
-	connectionId       string
+	connectionId       int64
 	processedByteCount int
 }
@@ -0,0 +1,284 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dchest/uniuri"
+)
+
+// Bytes of the replication stream kept for replicas that reconnect, with smaller
+// sizes rounded up to the minimum as Redis does
+const (
+	DEFAULT_REPL_BACKLOG_SIZE = 1 << 20
+	REPL_BACKLOG_MIN_SIZE     = 16 << 10
+)
+
+// The second replication ID before a server has followed any other history
+const NO_REPL_ID = "0000000000000000000000000000000000000000"
+
+const (
+	DEFAULT_REPL_TIMEOUT_SECONDS             = 60
+	DEFAULT_REPL_PING_REPLICA_PERIOD_SECONDS = 10
+)
+
+func newReplId() string {
+	return uniuri.NewLenChars(40, []byte("0123456789abcdef"))
+}
+
+// replicationBacklog keeps the latest part of the replication stream, so that a
+// replica that reconnects can be sent just what it missed
+type replicationBacklog struct {
+	buffer []byte
+	// Where the next byte goes
+	index int
+	// How many bytes are held, up to the size of the buffer
+	length int
+	// The replication offset just past the last byte held
+	end int64
+}
+
+// newReplicationBacklog makes an empty backlog that carries on from offset
+func newReplicationBacklog(size int, offset int64) *replicationBacklog {
+	return &replicationBacklog{buffer: make([]byte, size), end: offset}
+}
+
+// start returns the replication offset of the first byte held
+func (b *replicationBacklog) start() int64 {
+	return b.end - int64(b.length)
+}
+
+func (b *replicationBacklog) feed(data []byte) {
+	b.end += int64(len(data))
+
+	// Only the end of anything larger than the backlog is kept
+	if len(data) >= len(b.buffer) {
+		copy(b.buffer, data[len(data)-len(b.buffer):])
+		b.index = 0
+		b.length = len(b.buffer)
+		return
+	}
+
+	n := copy(b.buffer[b.index:], data)
+	copy(b.buffer, data[n:])
+
+	b.index = (b.index + len(data)) % len(b.buffer)
+	b.length = min(b.length+len(data), len(b.buffer))
+}
+
+// since returns the stream from offset onwards, provided the backlog still holds
+// all of it
+func (b *replicationBacklog) since(offset int64) ([]byte, bool) {
+	if offset < b.start() || offset > b.end {
+		return nil, false
+	}
+
+	data := make([]byte, b.end-offset)
+	from := (b.index - len(data) + len(b.buffer)) % len(b.buffer)
+
+	n := copy(data, b.buffer[from:])
+	copy(data[n:], b.buffer)
+	return data, true
+}
+
+// resized returns a backlog of the new size holding as much of the latest stream
+// as fits
+func (b *replicationBacklog) resized(size int) *replicationBacklog {
+	held, _ := b.since(b.start())
+	resized := newReplicationBacklog(size, b.start())
+	resized.feed(held)
+	return resized
+}
+
+// replicationState tracks the history of the dataset, which replicas may carry on
+// from where they left off. It's only touched while holding the execution lock,
+// as is the offset in processedByteCount.
+type replicationState struct {
+	// Names the history the dataset follows, being the master's own on a replica
+	replId string
+	// Names the history followed before, such as the old master's once a replica is
+	// promoted, which is shared up to secondReplOffset
+	replId2          string
+	secondReplOffset int64
+	// Set once the dataset follows replId's history, so that a replica may ask for
+	// what it's missing rather than everything
+	haveHistory bool
+	backlog     *replicationBacklog
+	// The connection to our master, while we're a replica, along with how far it
+	// has got and when we last heard from it
+	masterLink *RedisConnection
+	linkState  string
+	lastIO     time.Time
+}
+
+func newReplicationState(backlogSize int64, isMaster bool) *replicationState {
+	return &replicationState{
+		replId:           newReplId(),
+		replId2:          NO_REPL_ID,
+		secondReplOffset: -1,
+		haveHistory:      isMaster,
+		backlog:          newReplicationBacklog(int(max(backlogSize, REPL_BACKLOG_MIN_SIZE)), 0),
+		linkState:        REPL_STATE_CONNECT,
+	}
+}
+
+// shiftReplId starts a new history, such as when a replica is promoted, while
+// still accepting partial syncs from those that followed the old one up to offset
+func (s *replicationState) shiftReplId(offset int64) {
+	s.replId2 = s.replId
+	s.secondReplOffset = offset
+	s.replId = newReplId()
+}
+
+// psyncArgs gives what a replica asks its master for, the history it has along
+// with the offset of the next byte it needs, or ? -1 when it has no history
+func (s *replicationState) psyncArgs(offset int) (string, string) {
+	if !s.haveHistory {
+		return "?", "-1"
+	}
+	return s.replId, strconv.Itoa(offset + 1)
+}
+
+// partialSync returns the stream a replica is missing, provided it follows our
+// history and the backlog still holds everything from its offset, as PSYNC gives
+// it counting from 1
+func (s *replicationState) partialSync(replId string, psyncOffset int64) ([]byte, bool) {
+	offset := psyncOffset - 1
+
+	switch {
+	case replId == s.replId:
+	case replId == s.replId2 && offset <= s.secondReplOffset:
+	default:
+		return nil, false
+	}
+
+	return s.backlog.since(offset)
+}
+
+// feedReplicationStream records data as having gone down the replication stream,
+// whether sent to our replicas or received from our master
+func (r *Redis) feedReplicationStream(data []byte) {
+	r.processedByteCount += len(data)
+	r.replication.backlog.feed(data)
+}
+
+// propagate sends a command to the replicas, which needs the execution lock
+func (r *Redis) propagate(value serde.Value) {
+	data := value.Marshal()
+
+	for _, replica := range r.replicas {
+		replica.WithWriteMutex(func() error {
+			return replica.Send([]serde.Value{serde.NewRawBytes(data)})
+		})
+	}
+
+	r.feedReplicationStream(data)
+}
+
+// forgetReplica stops propagating to a replica that has gone
+func (r *Redis) forgetReplica(id int64) {
+	r.executionMutex.Lock()
+	defer r.executionMutex.Unlock()
+	delete(r.replicas, id)
+}
+
+// replicationCron keeps replication links alive, running once a second. Replicas
+// acknowledge how far they've got and drop a master that has gone quiet, while
+// masters ping their replicas and drop those that stop acknowledging.
+func (r *Redis) replicationCron(seconds int) {
+	r.executionMutex.Lock()
+	defer r.executionMutex.Unlock()
+
+	timeout := time.Duration(r.configuration.replTimeoutSeconds) * time.Second
+
+	if link := r.replication.masterLink; link != nil {
+		if time.Since(r.replication.lastIO) > timeout {
+			slog.Warn("MASTER timeout: no data nor PING received, dropping the link")
+			// The replication loop notices and reconnects
+			link.Close()
+		} else {
+			link.WithWriteMutex(func() error {
+				return link.Send([]serde.Value{serde.NewArray([]serde.Value{
+					serde.NewBulkString("REPLCONF"),
+					serde.NewBulkString("ACK"),
+					serde.NewBulkString(strconv.Itoa(r.processedByteCount)),
+				})})
+			})
+		}
+	}
+
+	for _, id := range r.clients.unresponsiveReplicas(time.Now().Add(-timeout)) {
+		if replica, ok := r.replicas[id]; ok {
+			slog.Warn(fmt.Sprintf("Disconnecting timed out replica %d", id))
+			replica.Close()
+		}
+	}
+
+	if len(r.replicas) > 0 && seconds%r.configuration.replPingReplicaPeriodSeconds == 0 {
+		r.propagate(serde.NewArray([]serde.Value{serde.NewBulkString("PING")}))
+	}
+}
+
+// syncedWithMaster takes on the master's history once it has answered PSYNC with
+// either FULLRESYNC <replid> <offset> or CONTINUE [<replid>]
+func (r *Redis) syncedWithMaster(reply string) error {
+	fields := strings.Fields(reply)
+	state := r.replication
+
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		offset, err := strconv.Atoi(fields[2])
+
+		if err != nil {
+			return fmt.Errorf("invalid offset in %s", reply)
+		}
+
+		state.replId = fields[1]
+		state.replId2 = NO_REPL_ID
+		state.secondReplOffset = -1
+		state.backlog = newReplicationBacklog(len(state.backlog.buffer), int64(offset))
+		r.processedByteCount = offset
+	case len(fields) >= 1 && fields[0] == "CONTINUE":
+		// The master has a new history of its own, which carries on from ours
+		if len(fields) == 2 && fields[1] != state.replId {
+			state.replId2 = state.replId
+			state.secondReplOffset = int64(r.processedByteCount)
+			state.replId = fields[1]
+		}
+	default:
+		return fmt.Errorf("expected the master to answer PSYNC with FULLRESYNC or CONTINUE, got %s", reply)
+	}
+
+	state.haveHistory = true
+	return nil
+}
+
+// What REPLICAOF is given to stop replicating
+const REPLICAOF_NO_ONE = "no one"
+
+// replicaOf promotes a replica to a master with REPLICAOF NO ONE. Its replicas,
+// along with any others that followed the same master, may then carry on with
+// a partial sync.
+func (r *Redis) replicaOf(args []string) []serde.Value {
+	if strings.ToLower(strings.Join(args, " ")) != REPLICAOF_NO_ONE {
+		return []serde.Value{serde.NewError("ERR REPLICAOF only supports NO ONE, set replicaof in the configuration to replicate from a master")}
+	}
+
+	if r.configuration.replicationConfig.replicaConfig.Role() == MASTER {
+		return []serde.Value{serde.Ok()}
+	}
+
+	if link := r.replication.masterLink; link != nil {
+		r.clients.unregister(link.id)
+		link.Close()
+		r.replication.masterLink = nil
+	}
+
+	r.configuration.replicationConfig.replicaConfig = masterConfig{}
+	r.replication.shiftReplId(int64(r.processedByteCount))
+	return []serde.Value{serde.Ok()}
+}
@@ -0,0 +1,433 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"codecrafters/internal/kvstore"
+	"codecrafters/internal/serde"
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_replicationBacklog(t *testing.T) {
+	backlog := newReplicationBacklog(8, 100)
+
+	backlog.feed([]byte("abcde"))
+	backlog.feed([]byte("fghij"))
+
+	tests := []struct {
+		name   string
+		offset int64
+		want   string
+		wantOk bool
+	}{
+		{name: "It should give what's held from the offset", offset: 105, want: "fghij", wantOk: true},
+		{name: "It should give everything it holds", offset: 102, want: "cdefghij", wantOk: true},
+		{name: "It should give nothing for the end of the stream", offset: 110, want: "", wantOk: true},
+		{name: "It should refuse offsets that have been overwritten", offset: 101, wantOk: false},
+		{name: "It should refuse offsets past the end", offset: 111, wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := backlog.since(tt.offset)
+			if ok != tt.wantOk || string(got) != tt.want {
+				t.Errorf("since(%d) = %q, %v, want %q, %v", tt.offset, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+
+	backlog.feed([]byte("0123456789"))
+
+	if got, _ := backlog.since(backlog.start()); string(got) != "23456789" || backlog.start() != 112 {
+		t.Errorf("Expected only the end of a large write to be kept, got %q from %d", got, backlog.start())
+	}
+
+	resized := backlog.resized(4)
+
+	if got, _ := resized.since(resized.start()); string(got) != "6789" || resized.end != backlog.end {
+		t.Errorf("Expected a smaller backlog to keep the end of the stream, got %q up to %d", got, resized.end)
+	}
+
+	resized = backlog.resized(16)
+
+	if got, _ := resized.since(resized.start()); string(got) != "23456789" {
+		t.Errorf("Expected a larger backlog to keep everything, got %q", got)
+	}
+}
+
+func Test_replicationState_partialSync(t *testing.T) {
+	state := newReplicationState(REPL_BACKLOG_MIN_SIZE, true)
+	state.backlog.feed([]byte("0123456789"))
+	oldId := state.replId
+	state.shiftReplId(8)
+	state.backlog.feed([]byte("abc"))
+
+	tests := []struct {
+		name        string
+		replId      string
+		psyncOffset int64
+		want        string
+		wantOk      bool
+	}{
+		{name: "It should continue our own history", replId: state.replId, psyncOffset: 11, want: "abc", wantOk: true},
+		{name: "It should continue the old history up to where it was left", replId: oldId, psyncOffset: 9, want: "89abc", wantOk: true},
+		{name: "It should refuse the old history after it was left", replId: oldId, psyncOffset: 10, wantOk: false},
+		{name: "It should refuse another history", replId: "other", psyncOffset: 11, wantOk: false},
+		{name: "It should refuse a replica asking for everything", replId: "?", psyncOffset: -1, wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := state.partialSync(tt.replId, tt.psyncOffset)
+			if ok != tt.wantOk || string(got) != tt.want {
+				t.Errorf("partialSync(%s, %d) = %q, %v, want %q, %v", tt.replId, tt.psyncOffset, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestRedis_syncedWithMaster(t *testing.T) {
+	r := &Redis{replication: newReplicationState(REPL_BACKLOG_MIN_SIZE, false)}
+
+	if replId, offset := r.replication.psyncArgs(r.processedByteCount); replId != "?" || offset != "-1" {
+		t.Fatalf("Expected a replica without history to ask for everything, got %s %s", replId, offset)
+	}
+
+	if err := r.syncedWithMaster("FULLRESYNC 8ea3a5e1b5b1b0c3c5f2d5f8d5a8e8b8f0c1d2e3 100"); err != nil {
+		t.Fatal(err)
+	}
+
+	if replId, offset := r.replication.psyncArgs(r.processedByteCount); replId != "8ea3a5e1b5b1b0c3c5f2d5f8d5a8e8b8f0c1d2e3" || offset != "101" {
+		t.Errorf("Expected to ask for the master's history after 100 bytes, got %s %s", replId, offset)
+	}
+
+	r.feedReplicationStream([]byte("abc"))
+
+	if err := r.syncedWithMaster("CONTINUE 1111111111111111111111111111111111111111"); err != nil {
+		t.Fatal(err)
+	}
+
+	state := r.replication
+
+	if state.replId != "1111111111111111111111111111111111111111" || state.replId2 != "8ea3a5e1b5b1b0c3c5f2d5f8d5a8e8b8f0c1d2e3" || state.secondReplOffset != 103 {
+		t.Errorf("Expected the new master's history to follow on from the old one's at 103, got %s %s %d", state.replId, state.replId2, state.secondReplOffset)
+	}
+
+	if err := r.syncedWithMaster("NOPE"); err == nil {
+		t.Errorf("Expected an error for an unexpected answer")
+	}
+}
+
+func Test_nextReplBackoff(t *testing.T) {
+	backoff := REPL_RETRY_MIN_BACKOFF
+	for range 5 {
+		backoff = nextReplBackoff(backoff)
+	}
+
+	if backoff != 32*REPL_RETRY_MIN_BACKOFF {
+		t.Errorf("Expected the backoff to double each time, got %v", backoff)
+	}
+
+	if got := nextReplBackoff(REPL_RETRY_MAX_BACKOFF); got != REPL_RETRY_MAX_BACKOFF {
+		t.Errorf("Expected the backoff to stop growing at %v, got %v", REPL_RETRY_MAX_BACKOFF, got)
+	}
+}
+
+// fullResyncReply is what a master answers PSYNC with when sending everything
+func fullResyncReply(replId string, offset int, rdb []byte) []byte {
+	return []byte(fmt.Sprintf("+FULLRESYNC %s %d\r\n$%d\r\n%s", replId, offset, len(rdb), rdb))
+}
+
+// fakeMaster answers a replica's handshake, recording what it was asked, and
+// replies to PSYNC with psyncReply
+func fakeMaster(t *testing.T, psyncReply []byte) (net.Listener, *[]string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	requests := &[]string{}
+	mutex := &sync.Mutex{}
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			// Gather the arguments of each command, which is all we're sent
+			count := 0
+			fmt.Sscanf(line, "*%d", &count)
+			args := []string{}
+			for range count {
+				reader.ReadString('\n')
+				arg, _ := reader.ReadString('\n')
+				args = append(args, strings.TrimSpace(arg))
+			}
+
+			mutex.Lock()
+			*requests = append(*requests, strings.Join(args, " "))
+			mutex.Unlock()
+
+			switch strings.ToUpper(args[0]) {
+			case "PING":
+				conn.Write([]byte("+PONG\r\n"))
+			case "PSYNC":
+				conn.Write(psyncReply)
+			default:
+				conn.Write([]byte("+OK\r\n"))
+			}
+		}
+	}()
+
+	return listener, requests
+}
+
+func TestRedis_connectToMaster(t *testing.T) {
+	rdb := encodeRDB(map[string]kvstore.StoredValue{"foo": kvstore.NewStoredString("bar", nil)}, nil, time.Now())
+	listener, requests := fakeMaster(t, fullResyncReply("8ea3a5e1b5b1b0c3c5f2d5f8d5a8e8b8f0c1d2e3", 100, rdb))
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	r := &Redis{
+		store:          kvstore.NewKVStore(),
+		configuration:  configurationOptions{replicationConfig: replicationConfig{replicaConfig: slaveConfig{"127.0.0.1", port}}},
+		executionMutex: &sync.Mutex{},
+		clients:        newClientRegistry(),
+		functions:      newFunctionsState(),
+		tracking:       newTrackingState(),
+		replication:    newReplicationState(REPL_BACKLOG_MIN_SIZE, false),
+	}
+	r.store.SetKeyWithExpiresAt("stale", "value", nil)
+
+	opts := masterLinkOptions{master: slaveConfig{"127.0.0.1", port}, listeningPort: 6380, timeout: time.Second}
+	connection, err := r.connectToMaster(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	want := []string{"PING", "REPLCONF listening-port 6380", "REPLCONF capa psync2", "PSYNC ? -1"}
+	if strings.Join(*requests, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected the handshake %v, got %v", want, *requests)
+	}
+
+	if r.replication.linkState != REPL_STATE_CONNECTED || r.replication.masterLink == nil || r.processedByteCount != 100 {
+		t.Errorf("Expected the link to be up at offset 100, got %s at %d", r.replication.linkState, r.processedByteCount)
+	}
+
+	if keys := r.store.GetKeys(context.Background()); len(keys) != 1 || keys[0] != "foo" {
+		t.Errorf("Expected the master's dataset to replace ours, got %v", keys)
+	}
+
+	// A master that's gone leaves the link to be tried again
+	listener.Close()
+
+	if _, err := r.connectToMaster(opts); err == nil {
+		t.Errorf("Expected an error connecting to a master that isn't listening")
+	}
+}
+
+func TestRedis_execPropagates(t *testing.T) {
+	r := &Redis{
+		store:          kvstore.NewKVStore(),
+		configuration:  configurationOptions{replicationConfig: replicationConfig{replicaConfig: masterConfig{}}},
+		executionMutex: &sync.Mutex{},
+		acl:            newACLState(),
+		scripting:      newScriptingState(),
+		clients:        newClientRegistry(),
+		tracking:       newTrackingState(),
+		stats:          newServerStats(),
+		slowlog:        newSlowlogState(),
+		latency:        newLatencyMonitor(),
+		replication:    newReplicationState(REPL_BACKLOG_MIN_SIZE, true),
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	connection := NewRedisConnection(server)
+	connection.auth.authenticated = true
+	r.clients.register(&connection, CLIENT_TYPE_NORMAL)
+
+	run := func(commands ...[]string) {
+		for _, command := range commands {
+			value := bulkStrings(command)
+			r.processCommand(context.Background(), command[0], command[1:], value, &connection)
+		}
+	}
+
+	// A transaction that only reads has nothing to send
+	run([]string{MULTI}, []string{GET, "foo"}, []string{EXEC})
+
+	if r.replication.backlog.end != r.replication.backlog.start() {
+		t.Errorf("Expected nothing to be propagated for a read, got %d bytes", r.replication.backlog.end-r.replication.backlog.start())
+	}
+
+	run([]string{MULTI}, []string{GET, "foo"}, []string{SET, "foo", "bar"}, []string{EXEC})
+
+	want := slices.Concat(
+		serde.NewArray([]serde.Value{serde.NewBulkString("MULTI")}).Marshal(),
+		bulkStrings([]string{SET, "foo", "bar"}).Marshal(),
+		serde.NewArray([]serde.Value{serde.NewBulkString("EXEC")}).Marshal(),
+	)
+	stream, _ := r.replication.backlog.since(r.replication.backlog.start())
+
+	if !bytes.Equal(stream, want) {
+		t.Errorf("Expected the SET to be propagated as a transaction, got %q", stream)
+	}
+
+	if int64(r.processedByteCount) != r.replication.backlog.end {
+		t.Errorf("Expected the offset to be %d as the backlog is, got %d", r.replication.backlog.end, r.processedByteCount)
+	}
+}
+
+func TestRedis_connectToMasterAfterBacklogOverflow(t *testing.T) {
+	ctx := context.Background()
+	master := &Redis{
+		store:          kvstore.NewKVStore(),
+		executionMutex: &sync.Mutex{},
+		clients:        newClientRegistry(),
+		functions:      newFunctionsState(),
+		stats:          newServerStats(),
+		replicas:       map[int64]RedisConnection{},
+		replication:    newReplicationState(REPL_BACKLOG_MIN_SIZE, true),
+	}
+
+	r := &Redis{
+		store:          kvstore.NewKVStore(),
+		executionMutex: &sync.Mutex{},
+		clients:        newClientRegistry(),
+		functions:      newFunctionsState(),
+		tracking:       newTrackingState(),
+		replication:    newReplicationState(REPL_BACKLOG_MIN_SIZE, false),
+	}
+
+	// The replica was in step with the master when the link went down
+	if err := r.syncedWithMaster(fmt.Sprintf("FULLRESYNC %s 0", master.replication.replId)); err != nil {
+		t.Fatal(err)
+	}
+	r.store.SetKeyWithExpiresAt("stale", "value", nil)
+
+	// Since then the master has written more than its backlog holds
+	for i := 0; master.replication.backlog.start() == 0; i++ {
+		key := fmt.Sprintf("key:%d", i)
+		master.store.SetKeyWithExpiresAt(key, "value", nil)
+		master.propagate(bulkStrings([]string{SET, key, "value"}))
+	}
+
+	replId, offset := r.replication.psyncArgs(r.processedByteCount)
+	reply := []byte{}
+
+	for _, value := range master.psyncReply(ctx, []string{replId, offset}) {
+		reply = append(reply, value.Marshal()...)
+	}
+
+	if !bytes.HasPrefix(reply, []byte("+FULLRESYNC")) {
+		t.Fatalf("Expected the master to resync everything, got %q", reply[:min(len(reply), 64)])
+	}
+
+	listener, requests := fakeMaster(t, reply)
+	port := listener.Addr().(*net.TCPAddr).Port
+	r.configuration = configurationOptions{replicationConfig: replicationConfig{replicaConfig: slaveConfig{"127.0.0.1", port}}}
+
+	connection, err := r.connectToMaster(masterLinkOptions{master: slaveConfig{"127.0.0.1", port}, listeningPort: 6380, timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	if psync := fmt.Sprintf("PSYNC %s %s", replId, offset); !slices.Contains(*requests, psync) {
+		t.Errorf("Expected the replica to ask to carry on with %s, got %v", psync, *requests)
+	}
+
+	if r.replication.linkState != REPL_STATE_CONNECTED || r.processedByteCount != master.processedByteCount {
+		t.Errorf("Expected the link to be up at offset %d, got %s at %d", master.processedByteCount, r.replication.linkState, r.processedByteCount)
+	}
+
+	got, want := r.store.GetKeys(ctx), master.store.GetKeys(ctx)
+	slices.Sort(got)
+	slices.Sort(want)
+
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected the master's %d keys to replace ours, got %d", len(want), len(got))
+	}
+}
+
+func TestRedis_connectToMasterWithBadRDB(t *testing.T) {
+	listener, _ := fakeMaster(t, fullResyncReply("8ea3a5e1b5b1b0c3c5f2d5f8d5a8e8b8f0c1d2e3", 100, []byte("not an RDB file")))
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	r := &Redis{
+		store:          kvstore.NewKVStore(),
+		configuration:  configurationOptions{replicationConfig: replicationConfig{replicaConfig: slaveConfig{"127.0.0.1", port}}},
+		executionMutex: &sync.Mutex{},
+		clients:        newClientRegistry(),
+		functions:      newFunctionsState(),
+		tracking:       newTrackingState(),
+		replication:    newReplicationState(REPL_BACKLOG_MIN_SIZE, false),
+	}
+
+	if err := r.syncedWithMaster("FULLRESYNC 1111111111111111111111111111111111111111 50"); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := masterLinkOptions{master: slaveConfig{"127.0.0.1", port}, listeningPort: 6380, timeout: time.Second}
+	if _, err := r.connectToMaster(opts); err == nil {
+		t.Fatalf("Expected an error loading an RDB file that isn't one")
+	}
+
+	if r.replication.linkState == REPL_STATE_CONNECTED {
+		t.Errorf("Expected the link to stay down until the dataset has loaded")
+	}
+
+	// What's left of the dataset can't be continued from
+	if replId, offset := r.replication.psyncArgs(r.processedByteCount); replId != "?" || offset != "-1" {
+		t.Errorf("Expected to ask for everything next time, got %s %s", replId, offset)
+	}
+}
+
+func TestRedis_applyFromMaster(t *testing.T) {
+	r := &Redis{
+		store:       kvstore.NewKVStore(),
+		clients:     newClientRegistry(),
+		tracking:    newTrackingState(),
+		replication: newReplicationState(REPL_BACKLOG_MIN_SIZE, false),
+	}
+
+	values := []serde.Value{
+		serde.NewArray([]serde.Value{serde.NewBulkString("MULTI")}),
+		bulkStrings([]string{SET, "foo", "bar"}),
+		bulkStrings([]string{SET, "baz", "qux"}),
+		serde.NewArray([]serde.Value{serde.NewBulkString("EXEC")}),
+	}
+	length := 0
+
+	for _, value := range values {
+		length += len(value.Marshal())
+	}
+
+	r.applyFromMaster(context.Background(), values, RedisConnection{})
+
+	for _, key := range []string{"foo", "baz"} {
+		if _, ok := r.store.ReadKey(context.Background(), key); !ok {
+			t.Errorf("Expected %s to be set by the transaction", key)
+		}
+	}
+
+	if r.processedByteCount != length {
+		t.Errorf("Expected the offset to count the whole transaction, %d bytes, got %d", length, r.processedByteCount)
+	}
+}
@@ -0,0 +1,67 @@
+package redis
+
+import (
+	"codecrafters/internal/array"
+	"codecrafters/internal/serde"
+	"fmt"
+	"strings"
+)
+
+var scriptHelp = []string{
+	"SCRIPT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"EXISTS <sha1> [<sha1> ...]",
+	"    Return information about the existence of the scripts in the script cache.",
+	"FLUSH [ASYNC|SYNC]",
+	"    Flush the Lua scripts cache.",
+	"KILL",
+	"    Kill the currently executing Lua script.",
+	"LOAD <script>",
+	"    Load a script into the scripts cache without executing it.",
+	"HELP",
+	"    Print this help.",
+}
+
+func (r *Redis) script(args []string) []serde.Value {
+	switch strings.ToLower(args[0]) {
+	case "load":
+		// Make sure the script compiles before we hold on to it
+		L := newScriptState()
+		defer L.Close()
+
+		if _, err := compileScript(L, args[1]); err != nil {
+			return []serde.Value{scriptError("ERR %s", err.Error())}
+		}
+
+		return []serde.Value{serde.NewBulkString(r.scripting.load(args[1]))}
+	case "exists":
+		exists := []serde.Value{}
+		for _, sha := range args[1:] {
+			_, ok := r.scripting.lookup(sha)
+
+			if ok {
+				exists = append(exists, serde.NewInteger(1))
+			} else {
+				exists = append(exists, serde.NewInteger(0))
+			}
+		}
+		return []serde.Value{serde.NewArray(exists)}
+	case "flush":
+		if len(args) > 2 {
+			return []serde.Value{serde.NewError("ERR syntax error")}
+		}
+
+		r.scripting.flush()
+		return []serde.Value{serde.Ok()}
+	case "kill":
+		if err := r.scripting.kill(false); err != nil {
+			return []serde.Value{serde.NewError(err.Error())}
+		}
+		return []serde.Value{serde.Ok()}
+	case "help":
+		return []serde.Value{serde.NewArray(array.Map(scriptHelp, func(line string) serde.Value {
+			return serde.NewSimpleString(line)
+		}))}
+	default:
+		return []serde.Value{serde.NewError(fmt.Sprintf("ERR unknown subcommand '%s'. Try SCRIPT HELP.", args[0]))}
+	}
+}
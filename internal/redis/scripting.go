@@ -0,0 +1,470 @@
+package redis
+
+import (
+	"codecrafters/internal/array"
+	"codecrafters/internal/kvstore"
+	"codecrafters/internal/serde"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	DEFAULT_BUSY_REPLY_THRESHOLD_MS = 5000
+	SCRIPT_CHUNK_NAME               = "user_script"
+)
+
+var ErrNoScript = errors.New("NOSCRIPT No matching script. Please use EVAL.")
+
+type runningScript struct {
+	// Set when running a function through FCALL rather than a script through EVAL
+	function  string
+	command   []string
+	started   time.Time
+	kill      context.CancelFunc
+	wroteData bool
+	killed    bool
+}
+
+func (s *runningScript) killCommand() string {
+	if s.function != "" {
+		return "FUNCTION KILL"
+	}
+	return "SCRIPT KILL"
+}
+
+// scriptingState holds the script cache, along with whichever script is currently
+// running so that SCRIPT KILL can get at it from another client
+type scriptingState struct {
+	mutex   *sync.Mutex
+	cache   map[string]string
+	running *runningScript
+	// How long a script may run before other clients are answered with BUSY
+	busyThreshold time.Duration
+}
+
+func newScriptingState() *scriptingState {
+	return &scriptingState{
+		mutex:         &sync.Mutex{},
+		cache:         map[string]string{},
+		busyThreshold: DEFAULT_BUSY_REPLY_THRESHOLD_MS * time.Millisecond,
+	}
+}
+
+func scriptSha(body string) string {
+	sum := sha1.Sum([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *scriptingState) load(body string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sha := scriptSha(body)
+	s.cache[sha] = body
+	return sha
+}
+
+func (s *scriptingState) lookup(sha string) (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	body, ok := s.cache[strings.ToLower(sha)]
+	return body, ok
+}
+
+func (s *scriptingState) flush() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cache = map[string]string{}
+}
+
+func (s *scriptingState) setRunning(running *runningScript) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.running = running
+}
+
+func (s *scriptingState) setBusyThreshold(threshold time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.busyThreshold = threshold
+}
+
+// isBusy reports whether a script has been running for longer than the busy
+// threshold, at which point other clients are told to go away
+func (s *scriptingState) isBusy() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.running != nil && time.Since(s.running.started) > s.busyThreshold
+}
+
+// kill stops the running script, or function when isFunction is set, provided it
+// hasn't written anything that we'd leave half done
+func (s *scriptingState) kill(isFunction bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.running == nil || (s.running.function != "") != isFunction {
+		return errors.New("NOTBUSY No scripts in execution right now.")
+	}
+
+	if s.running.wroteData {
+		return errors.New("UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.")
+	}
+
+	s.running.killed = true
+	s.running.kill()
+	return nil
+}
+
+func (s *scriptingState) runningScript() *runningScript {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.running
+}
+
+// isAllowedWhileBusy reports whether a command may be run while a script is
+// busy, which it does without taking the execution lock
+func isAllowedWhileBusy(cmd string, args []string) bool {
+	if cmd == SHUTDOWN {
+		return slices.ContainsFunc(args, func(arg string) bool { return strings.ToLower(arg) == "nosave" })
+	}
+
+	if len(args) == 0 || (cmd != SCRIPT && cmd != FUNCTION) {
+		return false
+	}
+
+	subcommand := strings.ToLower(args[0])
+	return subcommand == "kill" || (cmd == FUNCTION && subcommand == "stats")
+}
+
+// scriptRun is the state a single script invocation needs to service redis.call
+type scriptRun struct {
+	r          *Redis
+	ctx        context.Context
+	connection RedisConnection
+	readOnly   bool
+	running    *runningScript
+}
+
+// newScriptState creates a sandboxed interpreter, leaving out the libraries that
+// would let a script reach outside of the server
+func newScriptState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+
+	libs := []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	}
+
+	for _, lib := range libs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	for _, unsafe := range []string{"dofile", "loadfile", "load", "loadstring", "module", "require"} {
+		L.SetGlobal(unsafe, lua.LNil)
+	}
+
+	return L
+}
+
+func stringsToLuaTable(L *lua.LState, values []string) *lua.LTable {
+	table := L.CreateTable(len(values), 0)
+
+	for _, v := range values {
+		table.Append(lua.LString(v))
+	}
+
+	return table
+}
+
+func luaReplyTable(L *lua.LState, field string, value string) *lua.LTable {
+	table := L.CreateTable(0, 1)
+	table.RawSetString(field, lua.LString(value))
+	return table
+}
+
+// respToLua converts a command reply following the same rules as Redis
+func respToLua(L *lua.LState, value serde.Value) lua.LValue {
+	switch v := value.(type) {
+	case serde.Integer:
+		return lua.LNumber(v.Value())
+	case serde.BulkString:
+		return lua.LString(v.Value())
+	case serde.SimpleString:
+		return luaReplyTable(L, "ok", v.Value())
+	case serde.Error:
+		return luaReplyTable(L, "err", v.Value())
+	case serde.Array:
+		table := L.CreateTable(len(v.Items), 0)
+
+		for _, item := range v.Items {
+			table.Append(respToLua(L, item))
+		}
+		return table
+	default:
+		// Null bulk strings and arrays both become false
+		return lua.LFalse
+	}
+}
+
+// luaToResp converts a value returned by a script back into a reply
+func luaToResp(value lua.LValue) serde.Value {
+	switch v := value.(type) {
+	case lua.LNumber:
+		return serde.NewInteger(int64(v))
+	case lua.LString:
+		return serde.NewBulkString(string(v))
+	case lua.LBool:
+		if v {
+			return serde.NewInteger(1)
+		}
+		return serde.NewNull()
+	case *lua.LTable:
+		if errValue, ok := v.RawGetString("err").(lua.LString); ok {
+			return serde.NewError(string(errValue))
+		}
+
+		if okValue, ok := v.RawGetString("ok").(lua.LString); ok {
+			return serde.NewSimpleString(string(okValue))
+		}
+
+		// Arrays stop at the first nil, as with the Lua length operator
+		items := []serde.Value{}
+		for i := 1; ; i++ {
+			item := v.RawGetInt(i)
+
+			if item == lua.LNil {
+				break
+			}
+			items = append(items, luaToResp(item))
+		}
+		return serde.NewArray(items)
+	default:
+		return serde.NewNull()
+	}
+}
+
+func (run *scriptRun) commandFromStack(L *lua.LState) ([]string, error) {
+	argCount := L.GetTop()
+
+	if argCount == 0 {
+		return nil, errors.New("ERR Please specify at least one argument for this redis lib call")
+	}
+
+	args := make([]string, 0, argCount)
+
+	for i := 1; i <= argCount; i++ {
+		switch v := L.Get(i).(type) {
+		case lua.LString:
+			args = append(args, string(v))
+		case lua.LNumber:
+			args = append(args, v.String())
+		default:
+			return nil, errors.New("ERR Lua redis lib command arguments must be strings or integers")
+		}
+	}
+
+	return args, nil
+}
+
+// execute runs a command on behalf of the script through the same path a client
+// command takes, so its writes are propagated to replicas individually
+func (run *scriptRun) execute(L *lua.LState) serde.Value {
+	args, err := run.commandFromStack(L)
+
+	if err != nil {
+		return serde.NewError(err.Error())
+	}
+
+	cmd := strings.ToLower(args[0])
+	spec, err := validateCommand(cmd, args[1:])
+
+	if err != nil {
+		return serde.NewError(err.Error())
+	}
+
+	if spec.hasFlag(CMD_NO_SCRIPT) {
+		return serde.NewError("ERR This Redis command is not allowed from script")
+	}
+
+	if err := run.r.authorize(cmd, args[1:], spec, run.connection, ACL_CONTEXT_LUA); err != nil {
+		return serde.NewError(err.Error())
+	}
+
+	if spec.hasFlag(CMD_DENYOOM) && run.r.store.OverMemoryLimit() {
+		return serde.NewError(kvstore.ErrOutOfMemory.Error())
+	}
+
+	if spec.hasFlag(CMD_WRITE) {
+		if run.readOnly {
+			return serde.NewError("ERR Write commands are not allowed from read-only scripts.")
+		}
+
+		run.r.scripting.mutex.Lock()
+		run.running.wroteData = true
+		run.r.scripting.mutex.Unlock()
+	}
+
+	value := serde.NewArray(array.Map(args, func(s string) serde.Value {
+		return serde.NewBulkString(s)
+	}))
+
+	response, _ := run.r.executeAndMaybePropagate(run.ctx, cmd, args[1:], value, run.connection)
+
+	if len(response) == 0 {
+		return serde.NewNull()
+	}
+	return response[0]
+}
+
+func (run *scriptRun) call(L *lua.LState) int {
+	reply := run.execute(L)
+
+	// redis.call raises errors, leaving them to abort the script
+	if errReply, ok := reply.(serde.Error); ok {
+		L.Error(luaReplyTable(L, "err", errReply.Value()), 1)
+		return 0
+	}
+
+	L.Push(respToLua(L, reply))
+	return 1
+}
+
+func (run *scriptRun) pcall(L *lua.LState) int {
+	L.Push(respToLua(L, run.execute(L)))
+	return 1
+}
+
+func (run *scriptRun) registerRedisLib(L *lua.LState) {
+	redisLib := L.NewTable()
+
+	L.SetFuncs(redisLib, map[string]lua.LGFunction{
+		"call":  run.call,
+		"pcall": run.pcall,
+		"status_reply": func(L *lua.LState) int {
+			L.Push(luaReplyTable(L, "ok", L.CheckString(1)))
+			return 1
+		},
+		"error_reply": func(L *lua.LState) int {
+			L.Push(luaReplyTable(L, "err", L.CheckString(1)))
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			L.Push(lua.LString(scriptSha(L.CheckString(1))))
+			return 1
+		},
+		"log": func(L *lua.LState) int {
+			slog.Info(fmt.Sprintf("Script log (level %d): %s", L.CheckInt(1), L.CheckString(2)))
+			return 0
+		},
+	})
+
+	for i, level := range []string{"LOG_DEBUG", "LOG_VERBOSE", "LOG_NOTICE", "LOG_WARNING"} {
+		redisLib.RawSetString(level, lua.LNumber(i))
+	}
+
+	L.SetGlobal("redis", redisLib)
+}
+
+// scriptError builds an error reply from a Lua error message, which may span
+// multiple lines where an error reply can't
+func scriptError(format string, a ...any) serde.Error {
+	message := strings.TrimSpace(fmt.Sprintf(format, a...))
+	return serde.NewError(strings.Join(strings.Fields(message), " "))
+}
+
+func scriptErrorReply(err error) serde.Value {
+	var apiErr *lua.ApiError
+
+	if !errors.As(err, &apiErr) {
+		return scriptError("ERR %s", err.Error())
+	}
+
+	// Errors raised by redis.call carry the original error reply
+	if table, ok := apiErr.Object.(*lua.LTable); ok {
+		return luaToResp(table)
+	}
+
+	return scriptError("ERR %s", apiErr.Object.String())
+}
+
+// runLua lets an interpreter call back into the server, leaving invoke to load
+// and call whatever should run with its result left on the stack. It must be
+// called with the execution lock held so the script runs atomically.
+func (r *Redis) runLua(ctx context.Context, L *lua.LState, running *runningScript, readOnly bool, connection RedisConnection, invoke func(L *lua.LState) error) []serde.Value {
+	// A library's interpreter is used again for its next call
+	defer L.SetTop(0)
+
+	scriptCtx, kill := context.WithCancel(withinScript(withBlockingDenied(ctx)))
+	defer kill()
+
+	running.started = time.Now()
+	running.kill = kill
+	r.scripting.setRunning(running)
+	defer r.scripting.setRunning(nil)
+
+	run := &scriptRun{r: r, ctx: scriptCtx, connection: connection, readOnly: readOnly, running: running}
+	run.registerRedisLib(L)
+
+	L.SetContext(scriptCtx)
+	err := invoke(L)
+
+	if running.killed {
+		return []serde.Value{serde.NewError(fmt.Sprintf("ERR Script killed by user with %s...", running.killCommand()))}
+	}
+
+	if err != nil {
+		return []serde.Value{scriptErrorReply(err)}
+	}
+
+	return []serde.Value{luaToResp(L.Get(-1))}
+}
+
+func compileScript(L *lua.LState, body string) (*lua.LFunction, error) {
+	fn, err := L.Load(strings.NewReader(body), SCRIPT_CHUNK_NAME)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error compiling script (new function): %s", err.Error())
+	}
+
+	return fn, nil
+}
+
+// runScript evaluates a script with the given KEYS and ARGV
+func (r *Redis) runScript(ctx context.Context, body string, keys []string, argv []string, readOnly bool, connection RedisConnection) []serde.Value {
+	running := &runningScript{command: append([]string{EVAL, body}, keys...)}
+	L := newScriptState()
+	defer L.Close()
+
+	return r.runLua(ctx, L, running, readOnly, connection, func(L *lua.LState) error {
+		L.SetGlobal("KEYS", stringsToLuaTable(L, keys))
+		L.SetGlobal("ARGV", stringsToLuaTable(L, argv))
+
+		fn, err := compileScript(L, body)
+
+		if err != nil {
+			return err
+		}
+
+		L.Push(fn)
+		return L.PCall(0, 1, nil)
+	})
+}
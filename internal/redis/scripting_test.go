@@ -0,0 +1,99 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_splitKeysAndArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantKeys []string
+		wantArgv []string
+		wantErr  bool
+	}{
+		{"It should split keys from args", []string{"2", "a", "b", "c"}, []string{"a", "b"}, []string{"c"}, false},
+		{"It should allow no keys", []string{"0", "a"}, []string{}, []string{"a"}, false},
+		{"It should reject more keys than args", []string{"2", "a"}, nil, nil, true},
+		{"It should reject a negative number of keys", []string{"-1"}, nil, nil, true},
+		{"It should reject a non integer number of keys", []string{"x"}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, argv, err := splitKeysAndArgs(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitKeysAndArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(keys, tt.wantKeys) || !reflect.DeepEqual(argv, tt.wantArgv) {
+				t.Errorf("splitKeysAndArgs() = %v, %v, want %v, %v", keys, argv, tt.wantKeys, tt.wantArgv)
+			}
+		})
+	}
+}
+
+func Test_runScriptReplies(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   serde.Value
+	}{
+		{"It should convert numbers to integers", "return 3.7", serde.NewInteger(3)},
+		{"It should convert strings to bulk strings", "return 'foo'", serde.NewBulkString("foo")},
+		{"It should convert true to one", "return true", serde.NewInteger(1)},
+		{"It should convert false to null", "return false", serde.NewNull()},
+		{"It should convert status tables", "return {ok='FINE'}", serde.NewSimpleString("FINE")},
+		{"It should convert error tables", "return redis.error_reply('ERR bad')", serde.NewError("ERR bad")},
+		{
+			"It should stop arrays at the first nil",
+			"return {1, {'a'}, nil, 2}",
+			serde.NewArray([]serde.Value{serde.NewInteger(1), serde.NewArray([]serde.Value{serde.NewBulkString("a")})}),
+		},
+		{"It should expose KEYS and ARGV", "return KEYS[1] .. ARGV[1]", serde.NewBulkString("kv")},
+		{"It should not expose the os library", "return os == nil", serde.NewInteger(1)},
+		{"It should report runtime errors", "error('boom')", serde.NewError("ERR user_script:1: boom")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Redis{scripting: newScriptingState()}
+			got := r.runScript(context.Background(), tt.script, []string{"k"}, []string{"v"}, false, RedisConnection{})
+			if len(got) != 1 || !reflect.DeepEqual(got[0], tt.want) {
+				t.Errorf("runScript() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedis_processCommandWhileBusy(t *testing.T) {
+	r := &Redis{scripting: newScriptingState(), acl: newACLState(), stats: newServerStats()}
+	r.acl.setUser(DEFAULT_USER, []string{"resetpass", ">secret"})
+	r.scripting.setRunning(&runningScript{command: []string{"eval", "while true do end", "0"}, started: time.Now().Add(-time.Minute)})
+
+	tests := []struct {
+		name          string
+		authenticated bool
+		cmd           string
+		args          []string
+		want          string
+	}{
+		{"It should refuse SHUTDOWN NOSAVE from a client that hasn't authenticated", false, SHUTDOWN, []string{"NOSAVE"}, ErrNoAuth.Error()},
+		{"It should refuse SCRIPT KILL from a client that hasn't authenticated", false, SCRIPT, []string{"KILL"}, ErrNoAuth.Error()},
+		{"It should tell an authenticated client that the server is busy", true, GET, []string{"foo"}, "BUSY Redis is busy running a script. You can only call SCRIPT KILL or FUNCTION KILL or SHUTDOWN NOSAVE."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connection := RedisConnection{auth: &connectionAuth{user: DEFAULT_USER, authenticated: tt.authenticated}}
+			value := serde.NewArray([]serde.Value{serde.NewBulkString(tt.cmd)})
+			got := r.processCommand(context.Background(), tt.cmd, tt.args, value, &connection)
+			if len(got) != 1 || !reflect.DeepEqual(got[0], serde.NewError(tt.want)) {
+				t.Errorf("processCommand() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
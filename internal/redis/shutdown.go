@@ -0,0 +1,233 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrShutdown = errors.New("ERR Errors trying to SHUTDOWN. Check logs.")
+
+const DEFAULT_SHUTDOWN_TIMEOUT_SECONDS = 10
+
+type shutdownOptions struct {
+	// Whether to save the RDB file, which otherwise we only do when save points
+	// are configured
+	save   bool
+	noSave bool
+	// Skip waiting for replicas to catch up
+	now bool
+	// Carry on even if saving fails
+	force bool
+}
+
+// shutdownState tracks a shutdown in progress, so that SHUTDOWN ABORT can cancel
+// it while it waits for replicas
+type shutdownState struct {
+	mutex  *sync.Mutex
+	cancel context.CancelFunc
+	// Set, and done closed, once the server has shut down, after which it can't be
+	// shut down again
+	finished bool
+	done     chan struct{}
+}
+
+func newShutdownState() *shutdownState {
+	return &shutdownState{mutex: &sync.Mutex{}, done: make(chan struct{})}
+}
+
+func (s *shutdownState) begin(cancel context.CancelFunc) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.finished {
+		return errors.New("ERR The server has already shut down")
+	}
+
+	if s.cancel != nil {
+		return errors.New("ERR Shutdown already in progress")
+	}
+
+	s.cancel = cancel
+	return nil
+}
+
+// finish marks the server as shut down, waking up anyone waiting for it
+func (s *shutdownState) finish() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.finished = true
+	close(s.done)
+}
+
+func (s *shutdownState) end() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cancel = nil
+}
+
+func (s *shutdownState) abort() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.cancel == nil {
+		return false
+	}
+
+	s.cancel()
+	return true
+}
+
+// wait blocks until the server has shut down
+func (s *shutdownState) wait() {
+	<-s.done
+}
+
+// Commands allowed while a script is busy run without the execution lock, which
+// the script is holding
+type executionUnlockedKey struct{}
+
+func withExecutionUnlocked(ctx context.Context) context.Context {
+	return context.WithValue(ctx, executionUnlockedKey{}, true)
+}
+
+func isExecutionUnlocked(ctx context.Context) bool {
+	unlocked, ok := ctx.Value(executionUnlockedKey{}).(bool)
+	return ok && unlocked
+}
+
+func (r *Redis) writePidFile() error {
+	if r.configuration.pidFile == "" {
+		return nil
+	}
+
+	return os.WriteFile(r.configuration.pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// shutdownServer stops the server, first letting replicas catch up and saving
+// the dataset. It returns an error, leaving the server running, should either be
+// aborted. The execution lock must be held unless ctx says otherwise.
+func (r *Redis) shutdownServer(ctx context.Context, opts shutdownOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := r.shutdown.begin(cancel); err != nil {
+		return err
+	}
+	defer r.shutdown.end()
+
+	slog.Info("Shutting down")
+
+	// Without the lock, which a busy script holds, we can neither wait nor save
+	locked := !isExecutionUnlocked(ctx)
+
+	timeout := time.Duration(r.configuration.shutdownTimeoutSeconds) * time.Second
+
+	if !opts.now && locked && len(r.replicas) > 0 && timeout > 0 {
+		// Hold back writes so the replicas have a chance of catching up
+		r.clients.pauseClients(CLIENT_PAUSE_WRITE, time.Now().Add(timeout))
+		caughtUp := r.waitForReplicas(ctx, len(r.replicas), timeout)
+
+		if ctx.Err() != nil {
+			r.clients.unpause()
+			slog.Warn("Shutdown aborted while waiting for replicas")
+			return ErrShutdown
+		}
+
+		if caughtUp < len(r.replicas) {
+			slog.Warn(fmt.Sprintf("%d of %d replicas are lagging behind, shutting down anyway", len(r.replicas)-caughtUp, len(r.replicas)))
+		}
+	}
+
+	// There's no append only file to flush, so the RDB file is all there is
+	save := opts.save || (!opts.noSave && len(r.configuration.savePoints) > 0)
+
+	if save && locked {
+		if err := r.saveRDB(ctx); err != nil {
+			slog.Error("Error saving the DB on disk", "err", err)
+
+			if !opts.force {
+				r.clients.unpause()
+				return ErrShutdown
+			}
+		}
+	}
+
+	r.Close()
+
+	if r.configuration.pidFile != "" {
+		os.Remove(r.configuration.pidFile)
+	}
+
+	r.clients.closeAll()
+
+	slog.Info("Redis is now ready to exit, bye bye...")
+	r.shutdown.finish()
+	return nil
+}
+
+// Shutdown stops the server as SHUTDOWN with no options would, as when the
+// process is asked to terminate
+func (r *Redis) Shutdown() error {
+	r.executionMutex.Lock()
+	defer r.executionMutex.Unlock()
+	return r.shutdownServer(context.Background(), shutdownOptions{})
+}
+
+func parseShutdownOptions(args []string) (shutdownOptions, bool, error) {
+	opts := shutdownOptions{}
+	abort := false
+
+	for _, arg := range args {
+		switch strings.ToLower(arg) {
+		case "nosave":
+			opts.noSave = true
+		case "save":
+			opts.save = true
+		case "now":
+			opts.now = true
+		case "force":
+			opts.force = true
+		case "abort":
+			abort = true
+		default:
+			return opts, false, errors.New("ERR syntax error")
+		}
+	}
+
+	if (opts.save && opts.noSave) || (abort && len(args) > 1) {
+		return opts, false, errors.New("ERR syntax error")
+	}
+
+	return opts, abort, nil
+}
+
+func (r *Redis) shutdownCommand(ctx context.Context, args []string) []serde.Value {
+	opts, abort, err := parseShutdownOptions(args)
+
+	if err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	if abort {
+		if !r.shutdown.abort() {
+			return []serde.Value{serde.NewError("ERR No shutdown in progress.")}
+		}
+		return []serde.Value{serde.Ok()}
+	}
+
+	if err := r.shutdownServer(ctx, opts); err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	// The client has been disconnected, so there's nobody to reply to
+	return []serde.Value{}
+}
@@ -0,0 +1,57 @@
+package redis
+
+import (
+	"testing"
+)
+
+func Test_parseShutdownOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		want      shutdownOptions
+		wantAbort bool
+		wantErr   bool
+	}{
+		{name: "It should save by default", args: []string{}, want: shutdownOptions{}},
+		{name: "It should accept SAVE", args: []string{"save"}, want: shutdownOptions{save: true}},
+		{name: "It should combine options", args: []string{"NOSAVE", "now", "Force"}, want: shutdownOptions{noSave: true, now: true, force: true}},
+		{name: "It should abort", args: []string{"abort"}, wantAbort: true},
+		{name: "It should reject SAVE with NOSAVE", args: []string{"save", "nosave"}, wantErr: true},
+		{name: "It should reject ABORT with other options", args: []string{"abort", "now"}, wantErr: true},
+		{name: "It should reject unknown options", args: []string{"later"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, abort, err := parseShutdownOptions(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseShutdownOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want || abort != tt.wantAbort {
+				t.Errorf("parseShutdownOptions() = %+v, %v, want %+v, %v", got, abort, tt.want, tt.wantAbort)
+			}
+		})
+	}
+}
+
+func Test_shutdownState(t *testing.T) {
+	state := newShutdownState()
+
+	if err := state.begin(func() {}); err != nil {
+		t.Fatalf("begin() error = %v", err)
+	}
+
+	if err := state.begin(func() {}); err == nil {
+		t.Errorf("Expected a second shutdown to be refused while the first is in progress")
+	}
+
+	state.finish()
+	state.end()
+	state.wait()
+
+	if err := state.begin(func() {}); err == nil {
+		t.Errorf("Expected a shutdown to be refused once the server has shut down")
+	}
+}
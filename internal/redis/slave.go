@@ -1,13 +1,17 @@
 package redis
 
 import (
+	"bufio"
+	"bytes"
 	"codecrafters/internal/serde"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net"
+	"strconv"
+	"time"
 )
 
 const (
@@ -29,8 +33,216 @@ func NewRedisClient(conn *net.TCPConn) RedisClient {
 	}
 }
 
-func handleSlaveReplicationConnection(r *Redis, connection RedisConnection) {
+// States of the link to our master, which a replica goes through in order each
+// time it connects
+const (
+	REPL_STATE_CONNECT   = "connect"
+	REPL_STATE_HANDSHAKE = "handshake"
+	REPL_STATE_TRANSFER  = "transfer"
+	REPL_STATE_CONNECTED = "connected"
+)
+
+// How long a replica waits before trying its master again, doubling with each
+// attempt that fails
+const (
+	REPL_RETRY_MIN_BACKOFF = 100 * time.Millisecond
+	REPL_RETRY_MAX_BACKOFF = 5 * time.Second
+)
+
+func nextReplBackoff(backoff time.Duration) time.Duration {
+	return min(backoff*2, REPL_RETRY_MAX_BACKOFF)
+}
+
+// masterLinkOptions is what a replica needs to connect to its master, taken from
+// the configuration while holding the execution lock
+type masterLinkOptions struct {
+	master        slaveConfig
+	user          string
+	password      string
+	listeningPort int
+	timeout       time.Duration
+}
+
+// linkOptions returns how to reach our master, or false once we're no
+// longer a replica
+func (r *Redis) linkOptions() (masterLinkOptions, bool) {
+	r.executionMutex.Lock()
+	defer r.executionMutex.Unlock()
+
+	master, ok := r.configuration.replicationConfig.replicaConfig.(slaveConfig)
+
+	if !ok {
+		return masterLinkOptions{}, false
+	}
+
+	listeningPort := r.configuration.port
+
+	if listeningPort == 0 {
+		listeningPort = r.configuration.tls.port
+	}
+
+	return masterLinkOptions{
+		master:        master,
+		user:          r.configuration.masterUser,
+		password:      r.configuration.masterAuth,
+		listeningPort: listeningPort,
+		timeout:       time.Duration(r.configuration.replTimeoutSeconds) * time.Second,
+	}, true
+}
+
+func (r *Redis) setLinkState(state string) {
+	r.executionMutex.Lock()
+	defer r.executionMutex.Unlock()
+	r.replication.linkState = state
+}
+
+// replicate follows our master for as long as we're a replica, reconnecting
+// whenever the link can't be made or drops, backing off while the master stays
+// unreachable
+func (r *Redis) replicate() {
+	backoff := REPL_RETRY_MIN_BACKOFF
+
+	for {
+		opts, ok := r.linkOptions()
+
+		if !ok {
+			return
+		}
+
+		connection, err := r.connectToMaster(opts)
+
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Unable to sync with master %s:%d, retrying in %v: %v", opts.master.host, opts.master.port, backoff, err))
+		} else {
+			slog.Info(fmt.Sprintf("Connected to master %s:%d", opts.master.host, opts.master.port))
+			backoff = REPL_RETRY_MIN_BACKOFF
+			r.streamFromMaster(connection)
+		}
+
+		r.setLinkState(REPL_STATE_CONNECT)
+
+		select {
+		case <-r.shutdown.done:
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = nextReplBackoff(backoff)
+	}
+}
+
+// connectToMaster goes through the handshake with our master and asks it to
+// replicate to us, carrying on from where we left off if it can
+func (r *Redis) connectToMaster(opts masterLinkOptions) (RedisConnection, error) {
+	conn, err := r.dialMaster(opts.master, opts.timeout)
+
+	if err != nil {
+		return RedisConnection{}, err
+	}
+
+	connection := NewRedisConnection(conn)
+
+	// Give up on a master that stops answering part way through
+	conn.SetDeadline(time.Now().Add(opts.timeout))
+	r.setLinkState(REPL_STATE_HANDSHAKE)
+
+	err = connection.WithReadMutex(func() error {
+		// Authenticate first, as otherwise the master won't answer even a PING
+		if opts.password != "" {
+			if err := connection.Auth(opts.user, opts.password); err != nil {
+				return err
+			}
+		}
+
+		if err := connection.Ping(); err != nil {
+			return err
+		}
+
+		if err := connection.ReplConf([]string{"listening-port", strconv.Itoa(opts.listeningPort)}); err != nil {
+			return err
+		}
+
+		if err := connection.ReplConf([]string{"capa", "psync2"}); err != nil {
+			return err
+		}
+
+		r.executionMutex.Lock()
+		replId, offset := r.replication.psyncArgs(r.processedByteCount)
+		r.replication.linkState = REPL_STATE_TRANSFER
+		r.executionMutex.Unlock()
+
+		reply, rdb, err := connection.Psync(replId, offset)
+
+		if err != nil {
+			return err
+		}
+
+		r.executionMutex.Lock()
+		defer r.executionMutex.Unlock()
+
+		// We may have been promoted while syncing, leaving the master's history be
+		if r.configuration.replicationConfig.replicaConfig.Role() != SLAVE {
+			return errors.New("no longer a replica")
+		}
+
+		// A full resync comes with the master's dataset, which replaces ours
+		if rdb != nil {
+			if err := r.loadFromMaster(rdb); err != nil {
+				return err
+			}
+		}
+
+		if err := r.syncedWithMaster(reply); err != nil {
+			return err
+		}
+
+		r.clients.register(&connection, CLIENT_TYPE_MASTER)
+		r.replication.masterLink = &connection
+		r.replication.linkState = REPL_STATE_CONNECTED
+		r.replication.lastIO = time.Now()
+		return nil
+	})
+
+	if err != nil {
+		connection.Close()
+		return RedisConnection{}, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return connection, nil
+}
+
+// loadFromMaster replaces the dataset and function libraries with those in the RDB
+// file our master sent for a full resync. We follow no history until it has all
+// loaded, so that should it fail we ask for everything again.
+func (r *Redis) loadFromMaster(rdb []byte) error {
+	r.replication.haveHistory = false
+	r.store.Flush()
+	r.functions.flush()
+
+	err := r.loadRDB(bufio.NewReader(bytes.NewReader(rdb)))
+
+	// Tracking clients must forget the keys we flushed, whether or not the load
+	// worked
+	r.invalidateTrackedKeys(0)
+
+	if err != nil {
+		return fmt.Errorf("failed to load the RDB file from our master: %w", err)
+	}
+	return nil
+}
+
+// streamFromMaster runs the commands our master sends until the link drops,
+// whether the master hangs up, goes quiet for too long or we're promoted
+func (r *Redis) streamFromMaster(connection RedisConnection) {
 	defer connection.Close()
+	defer r.clients.unregister(connection.id)
+	defer r.forgetMaster(connection.id)
+
+	// The commands of a transaction our master sent, from MULTI on, which are held
+	// back until EXEC arrives so that they're applied all at once
+	var transaction []serde.Value
+
 	for {
 		ctx := context.Background()
 		err := connection.WithReadMutex(func() error {
@@ -40,15 +252,24 @@ func handleSlaveReplicationConnection(r *Redis, connection RedisConnection) {
 				return err
 			}
 			slog.Debug(fmt.Sprintf("Received cmd %v in slave", value))
-			cmd, args, err := r.parseCommand(value)
+			cmd, _, err := r.parseCommand(value)
 
 			if err != nil {
 				return err
 			}
 
-			cmd, response := r.executeCommand(ctx, cmd, args, connection)
+			if cmd == MULTI || (transaction != nil && cmd != EXEC) {
+				transaction = append(transaction, value)
+				return nil
+			}
+
+			values := append(transaction, value)
+			transaction = nil
 
-			r.processedByteCount += len(value.Marshal())
+			r.executionMutex.Lock()
+			r.replication.lastIO = time.Now()
+			cmd, response := r.applyFromMaster(ctx, values, connection)
+			r.executionMutex.Unlock()
 
 			if cmd == REPLCONF {
 				connection.WithWriteMutex(func() error {
@@ -59,80 +280,72 @@ func handleSlaveReplicationConnection(r *Redis, connection RedisConnection) {
 		})
 
 		if err != nil {
-			if err == io.EOF {
-				return
+			// We close the link ourselves when promoted or the master times out
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Warn(fmt.Sprintf("Lost the link to our master: %v", err))
 			}
-			fmt.Println("Error reading from the client: ", err.Error())
 			return
 		}
 	}
 }
 
-func initSlave(r *Redis) error {
-	hostConfig, ok := r.configuration.replicationConfig.replicaConfig.(slaveConfig)
-
-	if !ok {
-		return errors.New("expected slave to have replica config")
-	}
+// applyFromMaster runs commands our master sent, returning the last one's reply.
+// MULTI and EXEC only mark out a transaction, so they count towards the offset
+// but aren't run. The execution lock must be held.
+func (r *Redis) applyFromMaster(ctx context.Context, values []serde.Value, connection RedisConnection) (string, []serde.Value) {
+	cmd, response := "", []serde.Value{}
 
-	server, err := net.ResolveTCPAddr(CONNECTION_TYPE, fmt.Sprintf("%s:%d", hostConfig.host, hostConfig.port))
+	for _, value := range values {
+		parsedCmd, args, err := r.parseCommand(value)
 
-	if err != nil {
-		return err
-	}
-
-	conn, err := net.DialTCP(CONNECTION_TYPE, nil, server)
+		if err == nil && parsedCmd != MULTI && parsedCmd != EXEC {
+			cmd, response = r.executeCommand(ctx, parsedCmd, args, connection)
+			r.invalidateTrackedKeys(connection.id)
+			r.feedMonitors(ctx, cmd, args, connection)
+		}
 
-	if err != nil {
-		return err
+		// The offset a command reports, as REPLCONF GETACK does, is from before it
+		r.feedReplicationStream(value.Marshal())
 	}
 
-	connection := NewRedisConnection(conn)
-
-	err = connection.WithReadMutex(func() error {
-		err = connection.Ping()
-
-		if err != nil {
-			return err
-		}
-
-		err = connection.ReplConf([]string{
-			"listening-port",
-			fmt.Sprintf("%d", r.configuration.port),
-		})
+	return cmd, response
+}
 
-		if err != nil {
-			return err
-		}
+// forgetMaster clears the link to our master once it has gone, unless it has
+// already been replaced
+func (r *Redis) forgetMaster(id int64) {
+	r.executionMutex.Lock()
+	defer r.executionMutex.Unlock()
 
-		err = connection.ReplConf([]string{
-			"capa",
-			"psync2",
-		})
+	if link := r.replication.masterLink; link != nil && link.id == id {
+		r.replication.masterLink = nil
+	}
+}
 
-		if err != nil {
-			return err
-		}
+// dialMaster connects to the master, over TLS when tls-replication is set
+func (r *Redis) dialMaster(hostConfig slaveConfig, timeout time.Duration) (net.Conn, error) {
+	address := net.JoinHostPort(hostConfig.host, strconv.Itoa(hostConfig.port))
+	dialer := &net.Dialer{Timeout: timeout}
 
-		err = connection.Psync("?", "-1")
+	if !r.configuration.tls.replication {
+		return dialer.Dial(CONNECTION_TYPE, address)
+	}
 
-		return err
-	})
+	tlsConfig, err := r.configuration.tls.clientConfig(hostConfig.host)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	go handleSlaveReplicationConnection(r, connection)
+	return tls.DialWithDialer(dialer, CONNECTION_TYPE, address, tlsConfig)
+}
 
-	// TODO(eatkinson): We're not a master node this is weird, but should get the tests to pass
-	for {
-		conn, err := r.listener.Accept()
-		if err != nil {
-			fmt.Println(err)
-			continue
-		}
-		go r.handleConnection(conn)
-	}
+// initSlave serves clients while following the master in the background, so
+// that a master that's down doesn't stop us from starting
+func initSlave(r *Redis) error {
+	go r.replicate()
 
+	r.serve()
+	r.shutdown.wait()
+	return nil
 }
@@ -0,0 +1,256 @@
+package redis
+
+import (
+	"codecrafters/internal/array"
+	"codecrafters/internal/serde"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entries keep no more than this many arguments, and no more than this many bytes
+// of each
+const (
+	SLOWLOG_ENTRY_MAX_ARGC   = 32
+	SLOWLOG_ENTRY_MAX_STRING = 128
+)
+
+const (
+	DEFAULT_SLOWLOG_LOG_SLOWER_THAN = 10000
+	DEFAULT_SLOWLOG_MAX_LEN         = 128
+)
+
+var slowlogHelp = []string{
+	"SLOWLOG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GET [<count>]",
+	"    Return top <count> entries from the slowlog (default: 10, -1 mean all).",
+	"    Entries are made of:",
+	"    id, timestamp, time in microseconds, arguments array, client IP and port,",
+	"    client name",
+	"LEN",
+	"    Return the length of the slowlog.",
+	"RESET",
+	"    Reset the slowlog.",
+	"HELP",
+	"    Print this help.",
+}
+
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	args     []string
+	addr     string
+	name     string
+}
+
+// slowlogState keeps the commands that took longer than slowlog-log-slower-than,
+// newest first
+type slowlogState struct {
+	mutex   *sync.Mutex
+	entries []slowlogEntry
+	nextId  int64
+	// Commands taking at least this many microseconds are logged, with a negative
+	// value logging none
+	slowerThan int64
+	maxLen     int
+}
+
+func newSlowlogState() *slowlogState {
+	return &slowlogState{
+		mutex:      &sync.Mutex{},
+		slowerThan: DEFAULT_SLOWLOG_LOG_SLOWER_THAN,
+		maxLen:     DEFAULT_SLOWLOG_MAX_LEN,
+	}
+}
+
+func (s *slowlogState) configure(slowerThan int, maxLen int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.slowerThan = int64(slowerThan)
+	s.maxLen = maxLen
+	s.entries = s.entries[:min(len(s.entries), maxLen)]
+}
+
+// slowlogArgs trims the arguments of a command down to what an entry keeps
+func slowlogArgs(args []string) []string {
+	kept := make([]string, 0, min(len(args), SLOWLOG_ENTRY_MAX_ARGC))
+
+	for i, arg := range args {
+		if i == SLOWLOG_ENTRY_MAX_ARGC-1 && len(args) > SLOWLOG_ENTRY_MAX_ARGC {
+			kept = append(kept, fmt.Sprintf("... (%d more arguments)", len(args)-i))
+			break
+		}
+
+		if len(arg) > SLOWLOG_ENTRY_MAX_STRING {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:SLOWLOG_ENTRY_MAX_STRING], len(arg)-SLOWLOG_ENTRY_MAX_STRING)
+		}
+		kept = append(kept, arg)
+	}
+	return kept
+}
+
+// slow reports whether a command that took this long should be logged
+func (s *slowlogState) slow(duration time.Duration) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.isSlow(duration)
+}
+
+func (s *slowlogState) isSlow(duration time.Duration) bool {
+	return s.slowerThan >= 0 && duration.Microseconds() >= s.slowerThan
+}
+
+// record logs the command if it was slow enough, args including the command name
+func (s *slowlogState) record(duration time.Duration, args []string, addr string, name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.isSlow(duration) {
+		return
+	}
+
+	entry := slowlogEntry{
+		id:       s.nextId,
+		time:     time.Now(),
+		duration: duration,
+		args:     slowlogArgs(args),
+		addr:     addr,
+		name:     name,
+	}
+	s.nextId++
+
+	s.entries = append([]slowlogEntry{entry}, s.entries...)
+	s.entries = s.entries[:min(len(s.entries), s.maxLen)]
+}
+
+// get returns up to count of the newest entries, or all of them for a negative
+// count
+func (s *slowlogState) get(count int) []slowlogEntry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if count < 0 || count > len(s.entries) {
+		count = len(s.entries)
+	}
+	return append([]slowlogEntry{}, s.entries[:count]...)
+}
+
+func (s *slowlogState) len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.entries)
+}
+
+func (s *slowlogState) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries = nil
+}
+
+// redactedArgs hides the secrets given to a command, such as passwords, before
+// it's logged. args includes the command name.
+func redactedArgs(args []string) []string {
+	redacted := append([]string{}, args...)
+	redact := func(from int) {
+		for i := from; i < len(redacted); i++ {
+			redacted[i] = "(redacted)"
+		}
+	}
+
+	cmd := strings.ToLower(args[0])
+	subcommand := ""
+
+	if len(args) > 1 {
+		subcommand = strings.ToLower(args[1])
+	}
+
+	switch {
+	case cmd == AUTH:
+		redact(1)
+	case cmd == HELLO:
+		for i := 2; i < len(args); i++ {
+			if strings.ToLower(args[i]) == "auth" {
+				redact(i + 1)
+				break
+			}
+		}
+	case cmd == ACL && subcommand == "setuser":
+		redact(3)
+	case cmd == CONFIG && subcommand == "set":
+		for i := 2; i+1 < len(args); i += 2 {
+			switch strings.ToLower(args[i]) {
+			case "requirepass", "masterauth", "masteruser":
+				redacted[i+1] = "(redacted)"
+			}
+		}
+	}
+	return redacted
+}
+
+// recordSlowCommand logs a command run directly by a client, leaving out those
+// run by scripts whose time counts towards the script's own
+func (r *Redis) recordSlowCommand(cmd string, args []string, duration time.Duration, connection RedisConnection) {
+	if !r.slowlog.slow(duration) {
+		return
+	}
+
+	addr := ""
+
+	if connection.conn != nil {
+		addr, _ = clientAddrs(connection.conn)
+	}
+
+	r.slowlog.record(duration, redactedArgs(append([]string{cmd}, args...)), addr, r.clients.name(connection.id))
+}
+
+func (r *Redis) slowlogCommand(args []string) []serde.Value {
+	switch strings.ToLower(args[0]) {
+	case "get":
+		count := 10
+
+		if len(args) > 2 {
+			return []serde.Value{serde.NewError("ERR wrong number of arguments for 'slowlog|get' command")}
+		}
+
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+
+			if err != nil {
+				return []serde.Value{serde.NewError("ERR value is not an integer or out of range")}
+			}
+
+			if n < -1 {
+				return []serde.Value{serde.NewError("ERR count should be greater than or equal to -1")}
+			}
+			count = n
+		}
+
+		return []serde.Value{serde.NewArray(array.Map(r.slowlog.get(count), func(entry slowlogEntry) serde.Value {
+			return serde.NewArray([]serde.Value{
+				serde.NewInteger(entry.id),
+				serde.NewInteger(entry.time.Unix()),
+				serde.NewInteger(entry.duration.Microseconds()),
+				serde.NewArray(array.Map(entry.args, func(arg string) serde.Value {
+					return serde.NewBulkString(arg)
+				})),
+				serde.NewBulkString(entry.addr),
+				serde.NewBulkString(entry.name),
+			})
+		}))}
+	case "len":
+		return []serde.Value{serde.NewInteger(int64(r.slowlog.len()))}
+	case "reset":
+		r.slowlog.reset()
+		return []serde.Value{serde.Ok()}
+	case "help":
+		return []serde.Value{serde.NewArray(array.Map(slowlogHelp, func(line string) serde.Value {
+			return serde.NewSimpleString(line)
+		}))}
+	default:
+		return []serde.Value{serde.NewError(fmt.Sprintf("ERR unknown subcommand '%s'. Try SLOWLOG HELP.", args[0]))}
+	}
+}
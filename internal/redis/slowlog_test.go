@@ -0,0 +1,87 @@
+package redis
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_slowlogArgs(t *testing.T) {
+	many := make([]string, 40)
+
+	for i := range many {
+		many[i] = fmt.Sprint(i)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "It should keep short commands as they are", args: []string{"set", "foo", "bar"}, want: []string{"set", "foo", "bar"}},
+		{name: "It should trim long arguments", args: []string{"set", "foo", strings.Repeat("x", 130)}, want: []string{"set", "foo", strings.Repeat("x", 128) + "... (2 more bytes)"}},
+		{name: "It should trim many arguments", args: many, want: append(append([]string{}, many[:31]...), "... (9 more arguments)")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := slowlogArgs(tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("slowlogArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_redactedArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "It should redact AUTH", args: []string{"auth", "user", "pass"}, want: []string{"auth", "(redacted)", "(redacted)"}},
+		{name: "It should redact HELLO AUTH", args: []string{"hello", "3", "AUTH", "user", "pass"}, want: []string{"hello", "3", "AUTH", "(redacted)", "(redacted)"}},
+		{name: "It should redact ACL SETUSER rules", args: []string{"acl", "setuser", "alice", ">secret", "on"}, want: []string{"acl", "setuser", "alice", "(redacted)", "(redacted)"}},
+		{name: "It should redact passwords given to CONFIG SET", args: []string{"config", "set", "maxmemory", "1mb", "requirepass", "secret"}, want: []string{"config", "set", "maxmemory", "1mb", "requirepass", "(redacted)"}},
+		{name: "It should leave other commands alone", args: []string{"set", "auth", "pass"}, want: []string{"set", "auth", "pass"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactedArgs(tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("redactedArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_slowlogState(t *testing.T) {
+	slowlog := newSlowlogState()
+	slowlog.configure(1000, 2)
+
+	slowlog.record(999*time.Microsecond, []string{"fast"}, "", "")
+	slowlog.record(time.Millisecond, []string{"first"}, "", "")
+	slowlog.record(time.Second, []string{"second"}, "", "")
+	slowlog.record(time.Second, []string{"third"}, "", "")
+
+	entries := slowlog.get(-1)
+	got := []string{}
+
+	for _, entry := range entries {
+		got = append(got, fmt.Sprintf("%d %s", entry.id, entry.args[0]))
+	}
+
+	if want := []string{"2 third", "1 second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the newest entries within slowlog-max-len %v, got %v", want, got)
+	}
+
+	if got := slowlog.get(1); len(got) != 1 || got[0].id != 2 {
+		t.Errorf("Expected only the newest entry, got %v", got)
+	}
+
+	slowlog.configure(-1, 2)
+	slowlog.record(time.Minute, []string{"ignored"}, "", "")
+
+	if got := slowlog.len(); got != 2 {
+		t.Errorf("Expected nothing to be logged with the slow log disabled, got %d entries", got)
+	}
+}
@@ -0,0 +1,347 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"math/bits"
+	"net"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Each power of two is split into this many buckets, which keeps latency
+// percentiles to within about 6% of the real value
+const LATENCY_SUB_BUCKETS = 16
+
+// latencyHistogram counts how long calls took in microseconds
+type latencyHistogram struct {
+	counts [(64 + 1) * LATENCY_SUB_BUCKETS]int64
+	total  int64
+}
+
+func latencyBucket(usec int64) int {
+	if usec < LATENCY_SUB_BUCKETS {
+		return int(usec)
+	}
+
+	shift := bits.Len64(uint64(usec)) - bits.Len64(LATENCY_SUB_BUCKETS)
+	return shift*LATENCY_SUB_BUCKETS + int(usec>>shift)
+}
+
+// latencyBucketValue returns the highest latency counted in the bucket
+func latencyBucketValue(bucket int) int64 {
+	if bucket < LATENCY_SUB_BUCKETS {
+		return int64(bucket)
+	}
+
+	shift := bucket/LATENCY_SUB_BUCKETS - 1
+	sub := int64(bucket%LATENCY_SUB_BUCKETS + LATENCY_SUB_BUCKETS)
+	return (sub+1)<<shift - 1
+}
+
+func (h *latencyHistogram) record(usec int64) {
+	h.counts[latencyBucket(max(usec, 1))]++
+	h.total++
+}
+
+// percentile returns the latency that the given percentage of calls came in
+// under
+func (h *latencyHistogram) percentile(percent float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+
+	target := int64(float64(h.total)*percent/100 + 0.5)
+	seen := int64(0)
+
+	for bucket, count := range h.counts {
+		seen += count
+
+		if count > 0 && seen >= max(target, 1) {
+			return latencyBucketValue(bucket)
+		}
+	}
+	return 0
+}
+
+// cumulativePowersOfTwo counts the calls that took at most each power of two
+// microseconds, as pairs of the power and the count. Powers are only given where
+// the count goes up.
+func (h *latencyHistogram) cumulativePowersOfTwo() [][2]int64 {
+	buckets := [][2]int64{}
+	seen := int64(0)
+
+	for bucket, count := range h.counts {
+		if count == 0 {
+			continue
+		}
+		seen += count
+
+		// Buckets fall within a power of two, so the whole of one counts towards the
+		// power its highest latency rounds up to
+		power := int64(1) << bits.Len64(uint64(latencyBucketValue(bucket)-1))
+
+		if last := len(buckets) - 1; last >= 0 && buckets[last][0] == power {
+			buckets[last][1] = seen
+			continue
+		}
+		buckets = append(buckets, [2]int64{power, seen})
+	}
+	return buckets
+}
+
+// countUpTo counts the calls that took at most usec microseconds, as the le bound
+// of a Prometheus bucket is inclusive. Past 32µs each bucket spans several
+// latencies starting from a power of two, so a call taking exactly that power is
+// only counted once usec reaches the end of its bucket.
+func (h *latencyHistogram) countUpTo(usec int64) int64 {
+	count := int64(0)
+
+	for bucket, n := range h.counts {
+		if latencyBucketValue(bucket) > usec {
+			break
+		}
+		count += n
+	}
+	return count
+}
+
+type commandStats struct {
+	calls int64
+	usec  int64
+	// Calls refused before running, such as for a wrong number of arguments
+	rejectedCalls int64
+	// Calls that ran but replied with an error
+	failedCalls int64
+	latency     *latencyHistogram
+}
+
+// How many samples instantaneous metrics are averaged over
+const STATS_METRIC_SAMPLES = 16
+
+// instantaneousMetric works out how quickly a counter has been going up lately
+type instantaneousMetric struct {
+	lastValue int64
+	lastTime  time.Time
+	samples   [STATS_METRIC_SAMPLES]float64
+	index     int
+}
+
+func (m *instantaneousMetric) track(value int64, now time.Time) {
+	if !m.lastTime.IsZero() && now.After(m.lastTime) {
+		m.samples[m.index] = float64(value-m.lastValue) / now.Sub(m.lastTime).Seconds()
+		m.index = (m.index + 1) % STATS_METRIC_SAMPLES
+	}
+
+	m.lastValue = value
+	m.lastTime = now
+}
+
+// perSecond averages the recent samples
+func (m *instantaneousMetric) perSecond() float64 {
+	sum := 0.0
+
+	for _, sample := range m.samples {
+		sum += sample
+	}
+	return sum / STATS_METRIC_SAMPLES
+}
+
+// serverStats counts what the server has been up to since it started, or since
+// CONFIG RESETSTAT
+type serverStats struct {
+	mutex               *sync.Mutex
+	connectionsReceived int64
+	commandsProcessed   int64
+	errorReplies        int64
+	commands            map[string]*commandStats
+	// Error replies by their prefix, such as ERR or WRONGTYPE
+	errors map[string]int64
+	// Replicas that were sent the whole dataset, and those that asked for just what
+	// they missed and either were or weren't sent it
+	fullSyncs          int64
+	partialSyncs       int64
+	failedPartialSyncs int64
+	// Bytes read from and written to clients, counted as they go over the wire
+	netInput  *atomic.Int64
+	netOutput *atomic.Int64
+	// Clients waiting on a blocking command, and the most memory ever used, which
+	// aren't reset
+	blockedClients *atomic.Int64
+	peakMemory     *atomic.Uint64
+	// Memory in use as the server started, before any data was loaded
+	startupMemory   uint64
+	opsPerSecond    instantaneousMetric
+	inputPerSecond  instantaneousMetric
+	outputPerSecond instantaneousMetric
+}
+
+func newServerStats() *serverStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return &serverStats{
+		startupMemory:  mem.HeapAlloc,
+		mutex:          &sync.Mutex{},
+		commands:       map[string]*commandStats{},
+		errors:         map[string]int64{},
+		netInput:       &atomic.Int64{},
+		netOutput:      &atomic.Int64{},
+		blockedClients: &atomic.Int64{},
+		peakMemory:     &atomic.Uint64{},
+	}
+}
+
+func (s *serverStats) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.connectionsReceived = 0
+	s.commandsProcessed = 0
+	s.errorReplies = 0
+	s.commands = map[string]*commandStats{}
+	s.errors = map[string]int64{}
+	s.fullSyncs = 0
+	s.partialSyncs = 0
+	s.failedPartialSyncs = 0
+	s.netInput.Store(0)
+	s.netOutput.Store(0)
+	s.opsPerSecond = instantaneousMetric{}
+	s.inputPerSecond = instantaneousMetric{}
+	s.outputPerSecond = instantaneousMetric{}
+}
+
+func (s *serverStats) connectionReceived() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.connectionsReceived++
+}
+
+func (s *serverStats) fullSync() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.fullSyncs++
+}
+
+func (s *serverStats) partialSync(ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if ok {
+		s.partialSyncs++
+	} else {
+		s.failedPartialSyncs++
+	}
+}
+
+func (s *serverStats) command(name string) *commandStats {
+	stats, ok := s.commands[name]
+
+	if !ok {
+		stats = &commandStats{latency: &latencyHistogram{}}
+		s.commands[name] = stats
+	}
+	return stats
+}
+
+// commandCalled records a command that ran, given its full name such as
+// client|list
+func (s *serverStats) commandCalled(name string, duration time.Duration, response []serde.Value) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats := s.command(name)
+	stats.calls++
+	stats.usec += duration.Microseconds()
+	stats.latency.record(duration.Microseconds())
+
+	if isErrorReply(response) {
+		stats.failedCalls++
+	}
+}
+
+func (s *serverStats) commandRejected(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.command(name).rejectedCalls++
+}
+
+// replied records the reply sent to a client for a command
+func (s *serverStats) replied(response []serde.Value) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.commandsProcessed++
+
+	if !isErrorReply(response) {
+		return
+	}
+
+	s.errorReplies++
+	prefix, _, _ := strings.Cut(response[0].(serde.Error).Value(), " ")
+	s.errors[prefix]++
+}
+
+// sample updates the instantaneous metrics, which needs calling regularly
+func (s *serverStats) sample(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.opsPerSecond.track(s.commandsProcessed, now)
+	s.inputPerSecond.track(s.netInput.Load(), now)
+	s.outputPerSecond.track(s.netOutput.Load(), now)
+}
+
+// memoryUsed returns how much memory is in use, keeping track of the peak
+func (s *serverStats) memoryUsed() runtime.MemStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	for peak := s.peakMemory.Load(); mem.HeapAlloc > peak; peak = s.peakMemory.Load() {
+		if s.peakMemory.CompareAndSwap(peak, mem.HeapAlloc) {
+			break
+		}
+	}
+	return mem
+}
+
+// sortedCommandNames returns the names of the commands with stats, in order
+func (s *serverStats) sortedCommandNames() []string {
+	names := make([]string, 0, len(s.commands))
+
+	for name := range s.commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func isErrorReply(response []serde.Value) bool {
+	if len(response) == 0 {
+		return false
+	}
+
+	_, ok := response[0].(serde.Error)
+	return ok
+}
+
+// trafficConn counts the bytes going over a client's connection
+type trafficConn struct {
+	net.Conn
+	stats *serverStats
+}
+
+func (c trafficConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.stats.netInput.Add(int64(n))
+	return n, err
+}
+
+func (c trafficConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.stats.netOutput.Add(int64(n))
+	return n, err
+}
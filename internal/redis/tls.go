@@ -0,0 +1,140 @@
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	TLS_AUTH_CLIENTS_YES      = "yes"
+	TLS_AUTH_CLIENTS_NO       = "no"
+	TLS_AUTH_CLIENTS_OPTIONAL = "optional"
+)
+
+type tlsOptions struct {
+	port       int
+	certFile   string
+	keyFile    string
+	caCertFile string
+	// Whether clients must present a certificate signed by the CA, one of yes, no
+	// or optional
+	authClients string
+	// Whether a replica connects to its master over TLS
+	replication bool
+}
+
+func (t tlsOptions) enabled() bool {
+	return t.port != 0 || t.replication
+}
+
+func (t tlsOptions) validate() error {
+	if !t.enabled() {
+		return nil
+	}
+
+	if t.certFile == "" || t.keyFile == "" {
+		return errors.New("TLS requires both tls-cert-file and tls-key-file")
+	}
+
+	switch t.authClients {
+	case TLS_AUTH_CLIENTS_YES, TLS_AUTH_CLIENTS_OPTIONAL:
+		if t.caCertFile == "" {
+			return errors.New("TLS client authentication requires tls-ca-cert-file")
+		}
+	case TLS_AUTH_CLIENTS_NO:
+	default:
+		return fmt.Errorf("invalid tls-auth-clients value %s, expected yes, no or optional", t.authClients)
+	}
+
+	return nil
+}
+
+func loadCertPool(caCertFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caCertFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caCertFile)
+	}
+
+	return pool, nil
+}
+
+// serverConfig builds the configuration for accepting TLS clients
+func (t tlsOptions) serverConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		ClientAuth:   tls.NoClientCert,
+	}
+
+	if t.authClients == TLS_AUTH_CLIENTS_NO {
+		return config, nil
+	}
+
+	config.ClientCAs, err = loadCertPool(t.caCertFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if t.authClients == TLS_AUTH_CLIENTS_OPTIONAL {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	} else {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// clientConfig builds the configuration a replica uses to connect to its master,
+// presenting our own certificate in case the master wants one
+func (t tlsOptions) clientConfig(host string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		ServerName:   host,
+	}
+
+	if t.caCertFile != "" {
+		config.RootCAs, err = loadCertPool(t.caCertFile)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+func parseYesNo(name string, value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid %s value %s, expected yes or no", name, value)
+	}
+}
@@ -0,0 +1,172 @@
+package redis
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificates creates a self-signed CA along with a certificate it
+// signed for localhost, returning the paths of the CA, certificate and key
+func writeTestCertificates(t *testing.T) (string, string, string) {
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []struct {
+		name      string
+		blockType string
+		bytes     []byte
+	}{
+		{"ca.crt", "CERTIFICATE", caDer},
+		{"redis.crt", "CERTIFICATE", der},
+		{"redis.key", "EC PRIVATE KEY", keyDer},
+	}
+
+	for _, f := range files {
+		encoded := pem.EncodeToMemory(&pem.Block{Type: f.blockType, Bytes: f.bytes})
+		if err := os.WriteFile(filepath.Join(dir, f.name), encoded, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return filepath.Join(dir, "ca.crt"), filepath.Join(dir, "redis.crt"), filepath.Join(dir, "redis.key")
+}
+
+func Test_tlsHandshake(t *testing.T) {
+	caFile, certFile, keyFile := writeTestCertificates(t)
+
+	tests := []struct {
+		name          string
+		authClients   string
+		presentCert   bool
+		wantHandshake bool
+	}{
+		{"It should accept a client with a certificate", TLS_AUTH_CLIENTS_YES, true, true},
+		{"It should reject a client without a certificate", TLS_AUTH_CLIENTS_YES, false, false},
+		{"It should accept a client without a certificate when optional", TLS_AUTH_CLIENTS_OPTIONAL, false, true},
+		{"It should accept a client without a certificate when not required", TLS_AUTH_CLIENTS_NO, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tlsOptions{port: 1, certFile: certFile, keyFile: keyFile, caCertFile: caFile, authClients: tt.authClients}
+			if err := opts.validate(); err != nil {
+				t.Fatalf("validate() error = %v", err)
+			}
+
+			serverConfig, err := opts.serverConfig()
+			if err != nil {
+				t.Fatalf("serverConfig() error = %v", err)
+			}
+
+			listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+
+			serverErr := make(chan error, 1)
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					serverErr <- err
+					return
+				}
+				defer conn.Close()
+				serverErr <- conn.(*tls.Conn).Handshake()
+			}()
+
+			clientConfig, err := opts.clientConfig("localhost")
+			if err != nil {
+				t.Fatalf("clientConfig() error = %v", err)
+			}
+			if !tt.presentCert {
+				clientConfig.Certificates = nil
+			}
+
+			conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+			if err == nil {
+				defer conn.Close()
+			}
+
+			if err := <-serverErr; (err == nil) != tt.wantHandshake {
+				t.Errorf("server handshake error = %v, wantHandshake %v", err, tt.wantHandshake)
+			}
+		})
+	}
+}
+
+func Test_tlsOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    tlsOptions
+		wantErr bool
+	}{
+		{"It should allow TLS to be disabled", tlsOptions{authClients: TLS_AUTH_CLIENTS_YES}, false},
+		{"It should require a certificate", tlsOptions{port: 6380, keyFile: "key", authClients: TLS_AUTH_CLIENTS_NO}, true},
+		{"It should require a CA to verify clients", tlsOptions{port: 6380, certFile: "crt", keyFile: "key", authClients: TLS_AUTH_CLIENTS_YES}, true},
+		{"It should not require a CA when clients aren't verified", tlsOptions{port: 6380, certFile: "crt", keyFile: "key", authClients: TLS_AUTH_CLIENTS_NO}, false},
+		{"It should reject unknown client auth values", tlsOptions{port: 6380, certFile: "crt", keyFile: "key", authClients: "maybe"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
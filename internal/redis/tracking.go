@@ -0,0 +1,355 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/armon/go-radix"
+)
+
+// Channel RESP2 clients receive redirected invalidation messages on
+const TRACKING_INVALIDATE_CHANNEL = "__redis__:invalidate"
+
+const (
+	TRACKING_CACHING_YES = "yes"
+	TRACKING_CACHING_NO  = "no"
+)
+
+type trackingOptions struct {
+	// The client to send invalidation messages to instead, or 0 for the client
+	// itself
+	redirect int64
+	// Whether the client is told about every key under its prefixes rather than
+	// just those it has read
+	bcast    bool
+	prefixes []string
+	optIn    bool
+	optOut   bool
+	// Whether the client is spared invalidations for keys it modified itself
+	noLoop bool
+}
+
+type trackingClient struct {
+	options trackingOptions
+	// Set by CLIENT CACHING for the next command only
+	caching string
+}
+
+// trackingInvalidation is a message owed to a tracking client
+type trackingInvalidation struct {
+	redirect int64
+	keys     []string
+}
+
+// trackingState remembers which clients cache which keys, so that they can be
+// told to drop them once the keys are modified
+type trackingState struct {
+	mutex   *sync.Mutex
+	clients map[int64]*trackingClient
+	// Clients that have read each key, in the default mode. A key is forgotten once
+	// its clients have been told it was modified.
+	keys map[string]map[int64]struct{}
+	// Clients broadcasting each prefix
+	prefixes *radix.Tree
+	// Keys modified by the command being run, not yet invalidated
+	pending []string
+}
+
+func newTrackingState() *trackingState {
+	return &trackingState{
+		mutex:    &sync.Mutex{},
+		clients:  map[int64]*trackingClient{},
+		keys:     map[string]map[int64]struct{}{},
+		prefixes: radix.New(),
+	}
+}
+
+// overlappingPrefix finds a pair of prefixes where one starts with the other
+func overlappingPrefix(existing []string, added []string) (string, string, bool) {
+	for i, prefix := range added {
+		others := append(slices.Clone(existing), added[:i]...)
+
+		for _, other := range others {
+			if strings.HasPrefix(prefix, other) || strings.HasPrefix(other, prefix) {
+				return prefix, other, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// enable turns tracking on for the client, or adds prefixes should it already be
+// broadcasting
+func (t *trackingState) enable(id int64, options trackingOptions) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(options.prefixes) > 0 && !options.bcast {
+		return errors.New("ERR PREFIX option requires BCAST mode to be enabled")
+	}
+
+	if options.optIn && options.optOut {
+		return errors.New("ERR You can't use both OPTIN and OPTOUT")
+	}
+
+	if (options.optIn || options.optOut) && options.bcast {
+		return errors.New("ERR OPTIN and OPTOUT are not compatible with BCAST")
+	}
+
+	existing, tracking := t.clients[id]
+	existingPrefixes := []string{}
+
+	if tracking {
+		if existing.options.bcast != options.bcast {
+			return errors.New("ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode.")
+		}
+
+		if existing.options.optIn != options.optIn || existing.options.optOut != options.optOut {
+			return errors.New("ERR You can't switch OPTIN/OPTOUT mode before disabling tracking for this client, and then re-enabling it with a different mode.")
+		}
+
+		existingPrefixes = existing.options.prefixes
+	}
+
+	// Broadcasting without a prefix covers every key
+	prefixes := slices.Clone(options.prefixes)
+	if options.bcast && len(prefixes) == 0 && len(existingPrefixes) == 0 {
+		prefixes = []string{""}
+	}
+
+	if prefix, other, ok := overlappingPrefix(existingPrefixes, prefixes); ok {
+		return fmt.Errorf("ERR Prefix '%s' overlaps with an existing prefix '%s'. Prefixes for a single client must not overlap.", prefix, other)
+	}
+
+	for _, prefix := range prefixes {
+		clients, ok := t.prefixes.Get(prefix)
+
+		if !ok {
+			clients = map[int64]struct{}{}
+			t.prefixes.Insert(prefix, clients)
+		}
+
+		clients.(map[int64]struct{})[id] = struct{}{}
+	}
+
+	options.prefixes = append(slices.Clone(existingPrefixes), prefixes...)
+	t.clients[id] = &trackingClient{options: options}
+	return nil
+}
+
+// disable turns tracking off for the client. Any keys it read are left in the
+// table, and skipped over when next modified.
+func (t *trackingState) disable(id int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	client, ok := t.clients[id]
+
+	if !ok {
+		return
+	}
+
+	for _, prefix := range client.options.prefixes {
+		if clients, ok := t.prefixes.Get(prefix); ok {
+			delete(clients.(map[int64]struct{}), id)
+
+			if len(clients.(map[int64]struct{})) == 0 {
+				t.prefixes.Delete(prefix)
+			}
+		}
+	}
+
+	delete(t.clients, id)
+}
+
+// stats returns how many clients are tracking keys, along with how many keys and
+// prefixes are being tracked
+func (t *trackingState) stats() (int, int, int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.clients), len(t.keys), t.prefixes.Len()
+}
+
+// options returns how the client is tracking keys, if it is at all
+func (t *trackingState) options(id int64) (trackingOptions, string, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	client, ok := t.clients[id]
+
+	if !ok {
+		return trackingOptions{}, "", false
+	}
+	return client.options, client.caching, true
+}
+
+func (t *trackingState) setCaching(id int64, caching string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	client, ok := t.clients[id]
+
+	if !ok || !(client.options.optIn || client.options.optOut) {
+		return errors.New("ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+	}
+
+	if caching == TRACKING_CACHING_YES && !client.options.optIn {
+		return errors.New("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+	}
+
+	if caching == TRACKING_CACHING_NO && !client.options.optOut {
+		return errors.New("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+	}
+
+	client.caching = caching
+	return nil
+}
+
+// afterCommand forgets CLIENT CACHING once the command it applied to has run
+func (t *trackingState) afterCommand(id int64, cmd string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if client, ok := t.clients[id]; ok && cmd != "client|caching" {
+		client.caching = ""
+	}
+}
+
+// trackKeys remembers that the client read the keys, if it's tracking them
+func (t *trackingState) trackKeys(id int64, keys []string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	client, ok := t.clients[id]
+
+	if !ok || client.options.bcast {
+		return
+	}
+
+	if client.options.optIn && client.caching != TRACKING_CACHING_YES {
+		return
+	}
+
+	if client.options.optOut && client.caching == TRACKING_CACHING_NO {
+		return
+	}
+
+	for _, key := range keys {
+		if t.keys[key] == nil {
+			t.keys[key] = map[int64]struct{}{}
+		}
+		t.keys[key][id] = struct{}{}
+	}
+}
+
+// keyModified queues the key to be invalidated once the current command has run
+func (t *trackingState) keyModified(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.clients) == 0 {
+		return
+	}
+
+	t.pending = append(t.pending, key)
+}
+
+// takeInvalidations works out which clients must be told about the keys modified
+// since last called, writer being the client that modified them
+func (t *trackingState) takeInvalidations(writer int64) map[int64]*trackingInvalidation {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	invalidations := map[int64]*trackingInvalidation{}
+	seen := map[string]bool{}
+
+	invalidate := func(id int64, key string) {
+		client, ok := t.clients[id]
+
+		if !ok || (client.options.noLoop && id == writer) {
+			return
+		}
+
+		if invalidations[id] == nil {
+			invalidations[id] = &trackingInvalidation{redirect: client.options.redirect}
+		}
+		invalidations[id].keys = append(invalidations[id].keys, key)
+	}
+
+	for _, key := range t.pending {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		for id := range t.keys[key] {
+			if client, ok := t.clients[id]; ok && !client.options.bcast {
+				invalidate(id, key)
+			}
+		}
+		delete(t.keys, key)
+
+		t.prefixes.WalkPath(key, func(_ string, clients interface{}) bool {
+			for id := range clients.(map[int64]struct{}) {
+				invalidate(id, key)
+			}
+			return false
+		})
+	}
+
+	t.pending = nil
+	return invalidations
+}
+
+// invalidateTrackedKeys tells tracking clients about the keys modified by the
+// command that has just run
+func (r *Redis) invalidateTrackedKeys(writer int64) {
+	for id, invalidation := range r.tracking.takeInvalidations(writer) {
+		r.sendInvalidation(id, invalidation)
+	}
+}
+
+func (r *Redis) sendInvalidation(id int64, invalidation *trackingInvalidation) {
+	target := id
+	if invalidation.redirect != 0 {
+		target = invalidation.redirect
+	}
+
+	connection, protocol, ok := r.clients.lookup(target)
+
+	var message serde.Value
+
+	switch {
+	case !ok:
+		// Let the client know that whoever it redirected to has gone, if it can hear us
+		connection, protocol, ok = r.clients.lookup(id)
+
+		if !ok || protocol < 3 {
+			return
+		}
+
+		message = serde.NewPush([]serde.Value{serde.NewBulkString("tracking-redir-broken"), serde.NewInteger(invalidation.redirect)})
+	case protocol >= 3:
+		message = serde.NewPush([]serde.Value{serde.NewBulkString("invalidate"), bulkStrings(invalidation.keys)})
+	case invalidation.redirect != 0 && r.clients.isSubscribed(target, TRACKING_INVALIDATE_CHANNEL):
+		// RESP2 clients hear of invalidations as messages on a channel they've
+		// subscribed to
+		message = serde.NewArray([]serde.Value{
+			serde.NewBulkString("message"),
+			serde.NewBulkString(TRACKING_INVALIDATE_CHANNEL),
+			bulkStrings(invalidation.keys),
+		})
+	default:
+		// A RESP2 client can't be sent anything it didn't ask for, which includes a
+		// redirect target that hasn't subscribed
+		return
+	}
+
+	connection.WithWriteMutex(func() error {
+		return connection.Send([]serde.Value{message})
+	})
+}
@@ -0,0 +1,156 @@
+package redis
+
+import (
+	"net"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_trackingStateEnable(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing *trackingOptions
+		options  trackingOptions
+		wantErr  bool
+	}{
+		{"It should enable the default mode", nil, trackingOptions{}, false},
+		{"It should reject prefixes without BCAST", nil, trackingOptions{prefixes: []string{"a"}}, true},
+		{"It should reject OPTIN with OPTOUT", nil, trackingOptions{optIn: true, optOut: true}, true},
+		{"It should reject OPTIN with BCAST", nil, trackingOptions{optIn: true, bcast: true}, true},
+		{"It should reject overlapping prefixes", nil, trackingOptions{bcast: true, prefixes: []string{"user:", "user:1"}}, true},
+		{"It should add prefixes while broadcasting", &trackingOptions{bcast: true, prefixes: []string{"a"}}, trackingOptions{bcast: true, prefixes: []string{"b"}}, false},
+		{"It should reject a prefix overlapping one already added", &trackingOptions{bcast: true, prefixes: []string{"ab"}}, trackingOptions{bcast: true, prefixes: []string{"a"}}, true},
+		{"It should reject switching to BCAST", &trackingOptions{}, trackingOptions{bcast: true}, true},
+		{"It should reject switching to OPTIN", &trackingOptions{optOut: true}, trackingOptions{optIn: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracking := newTrackingState()
+
+			if tt.existing != nil {
+				if err := tracking.enable(1, *tt.existing); err != nil {
+					t.Fatalf("enable() error = %v", err)
+				}
+			}
+
+			if err := tracking.enable(1, tt.options); (err != nil) != tt.wantErr {
+				t.Errorf("enable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_trackingStateTakeInvalidations(t *testing.T) {
+	tests := []struct {
+		name    string
+		options trackingOptions
+		caching string
+		reads   []string
+		writes  []string
+		writer  int64
+		want    []string
+	}{
+		{"It should invalidate keys the client read", trackingOptions{}, "", []string{"a", "b"}, []string{"a", "c"}, 2, []string{"a"}},
+		{"It should invalidate a key once however often it's written", trackingOptions{}, "", []string{"a"}, []string{"a", "a"}, 2, []string{"a"}},
+		{"It should skip the client's own writes with NOLOOP", trackingOptions{noLoop: true}, "", []string{"a"}, []string{"a"}, 1, nil},
+		{"It should include the client's own writes without NOLOOP", trackingOptions{}, "", []string{"a"}, []string{"a"}, 1, []string{"a"}},
+		{"It should only track reads after CACHING YES with OPTIN", trackingOptions{optIn: true}, "", []string{"a"}, []string{"a"}, 2, nil},
+		{"It should track reads after CACHING YES with OPTIN", trackingOptions{optIn: true}, TRACKING_CACHING_YES, []string{"a"}, []string{"a"}, 2, []string{"a"}},
+		{"It should skip reads after CACHING NO with OPTOUT", trackingOptions{optOut: true}, TRACKING_CACHING_NO, []string{"a"}, []string{"a"}, 2, nil},
+		{"It should broadcast keys under a prefix", trackingOptions{bcast: true, prefixes: []string{"user:"}}, "", nil, []string{"user:1", "other"}, 2, []string{"user:1"}},
+		{"It should broadcast every key without a prefix", trackingOptions{bcast: true}, "", nil, []string{"a", "b"}, 2, []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracking := newTrackingState()
+
+			if err := tracking.enable(1, tt.options); err != nil {
+				t.Fatalf("enable() error = %v", err)
+			}
+
+			if tt.caching != "" {
+				if err := tracking.setCaching(1, tt.caching); err != nil {
+					t.Fatalf("setCaching() error = %v", err)
+				}
+			}
+
+			tracking.trackKeys(1, tt.reads)
+
+			for _, key := range tt.writes {
+				tracking.keyModified(key)
+			}
+
+			var got []string
+			if invalidation, ok := tracking.takeInvalidations(tt.writer)[1]; ok {
+				got = invalidation.keys
+				slices.Sort(got)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("takeInvalidations() = %v, want %v", got, tt.want)
+			}
+
+			// Keys in the default mode are only invalidated once
+			if again := tracking.takeInvalidations(tt.writer); len(again) != 0 {
+				t.Errorf("takeInvalidations() again = %v, want none", again)
+			}
+		})
+	}
+}
+
+func TestRedis_sendInvalidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocol  int
+		redirect  bool
+		subscribe bool
+		want      string
+	}{
+		{name: "It should push invalidations to RESP3 clients", protocol: 3, want: ">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n"},
+		{name: "It should send nothing to RESP2 clients", protocol: 2, want: ""},
+		{name: "It should push invalidations to a RESP3 redirect target", protocol: 3, redirect: true, want: ">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n"},
+		{name: "It should send a message to a RESP2 redirect target that subscribed", protocol: 2, redirect: true, subscribe: true, want: "*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*1\r\n$3\r\nfoo\r\n"},
+		{name: "It should send nothing to a RESP2 redirect target that didn't subscribe", protocol: 2, redirect: true, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Redis{clients: newClientRegistry()}
+
+			tracker := NewRedisConnection(nil)
+			r.clients.register(&tracker, CLIENT_TYPE_NORMAL)
+
+			server, client := net.Pipe()
+			defer client.Close()
+			target := NewRedisConnection(server)
+			r.clients.register(&target, CLIENT_TYPE_NORMAL)
+			r.clients.setProtocol(target.id, tt.protocol)
+
+			invalidation := &trackingInvalidation{keys: []string{"foo"}}
+			if tt.redirect {
+				invalidation.redirect = target.id
+			} else {
+				tracker = target
+			}
+
+			if tt.subscribe {
+				r.subscribe([]string{TRACKING_INVALIDATE_CHANNEL}, target)
+			}
+
+			received := make(chan string)
+			go func() {
+				buffer := make([]byte, 4096)
+				client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+				n, _ := client.Read(buffer)
+				received <- string(buffer[:n])
+			}()
+
+			r.sendInvalidation(tracker.id, invalidation)
+
+			if got := <-received; got != tt.want {
+				t.Errorf("sendInvalidation() sent %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
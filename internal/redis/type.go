@@ -17,7 +17,7 @@ func (r *Redis) typeCmd(ctx context.Context, args []string) []serde.Value {
 
 	key := args[0]
 
-	stored, found := r.store.GetKey(ctx, key)
+	stored, found := r.store.ReadKey(ctx, key)
 
 	if !found {
 		return []serde.Value{serde.NewSimpleString(NONE)}
@@ -0,0 +1,49 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+)
+
+type unixSocketOptions struct {
+	path string
+	// Permissions applied to the socket file, or 0 to leave them to the umask
+	perm fs.FileMode
+}
+
+func parseUnixSocketPerm(value string) (fs.FileMode, error) {
+	perm, err := strconv.ParseUint(value, 8, 32)
+
+	if err != nil || perm > 0777 {
+		return 0, fmt.Errorf("invalid unixsocketperm %s, expected octal permissions such as 700", value)
+	}
+
+	return fs.FileMode(perm), nil
+}
+
+// listen listens on the configured socket path, replacing any socket file
+// left behind by a server that didn't shut down cleanly
+func (u unixSocketOptions) listen() (net.Listener, error) {
+	if err := os.Remove(u.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", u.path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if u.perm != 0 {
+		if err := os.Chmod(u.path, u.perm); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	return listener, nil
+}
@@ -0,0 +1,61 @@
+package redis
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_parseUnixSocketPerm(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    fs.FileMode
+		wantErr bool
+	}{
+		{"It should parse octal permissions", "770", 0770, false},
+		{"It should allow permissions to be left unset", "0", 0, false},
+		{"It should reject non-octal digits", "789", 0, true},
+		{"It should reject bits beyond the permissions", "7777", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseUnixSocketPerm(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseUnixSocketPerm() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseUnixSocketPerm() = %o, want %o", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_unixSocketListen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.sock")
+
+	// A stale socket file shouldn't stop the server from starting
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := unixSocketOptions{path: path, perm: 0700}.listen()
+	if err != nil {
+		t.Fatalf("listen() error = %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("socket permissions = %o, want %o", info.Mode().Perm(), 0700)
+	}
+
+	listener.Close()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file should be removed on close, stat error = %v", err)
+	}
+}
@@ -2,12 +2,12 @@ package redis
 
 import (
 	"codecrafters/internal/serde"
-	"log/slog"
+	"context"
 	"strconv"
 	"time"
 )
 
-func (r *Redis) wait(args []string) []serde.Value {
+func (r *Redis) wait(ctx context.Context, args []string) []serde.Value {
 	if len(args) != 2 {
 		return []serde.Value{serde.NewError("WAIT requires two arguments: <numreplicas> <timeout>")}
 	}
@@ -24,39 +24,61 @@ func (r *Redis) wait(args []string) []serde.Value {
 		return []serde.Value{serde.NewError("Number of milliseconds for WAIT must be an integer")}
 	}
 
+	return []serde.Value{serde.NewInteger(int64(r.waitForReplicas(ctx, replicasNeeded, time.Duration(timeoutMs)*time.Millisecond)))}
+}
+
+// waitForReplicas asks the replicas how far they've got, returning how many have
+// processed everything sent to them once enough have, the timeout expires or ctx
+// is done
+func (r *Redis) waitForReplicas(ctx context.Context, replicasNeeded int, timeout time.Duration) int {
 	bytesNeeded := r.processedByteCount
 
-	caughtUp := map[string]RedisConnection{}
+	caughtUp := map[int64]bool{}
 	for _, replica := range r.replicas {
 		// If we already know they're up to date, don't waste time
 		if replica.processedByteCount >= bytesNeeded {
-			caughtUp[replica.id] = replica
-			continue
+			caughtUp[replica.id] = true
 		}
+	}
 
-		go func() {
-			err := replica.ReplConfGetAck()
-			if err != nil {
-				slog.Error("Error getting replication ack from replica:", err)
-			}
-		}()
+	// Within a transaction we can only report on what we already know
+	if isBlockingDenied(ctx) {
+		return len(caughtUp)
+	}
+
+	// The request goes down the replication stream like any other command, so that
+	// the replicas' offsets keep matching ours
+	if len(caughtUp) < len(r.replicas) {
+		r.propagate(serde.NewArray([]serde.Value{
+			serde.NewBulkString("REPLCONF"),
+			serde.NewBulkString("GETACK"),
+			serde.NewBulkString("*"),
+		}))
 	}
 
-ReplicaWaitLoop:
-	for len(caughtUp) < replicasNeeded {
-		select {
-		case ack := <-r.ackChan:
-			{
-				if ack.processedByteCount >= bytesNeeded {
-					caughtUp[ack.connectionId] = r.replicas[ack.connectionId]
+	timer := time.After(timeout)
+
+	r.whileBlocked(func() {
+	ReplicaWaitLoop:
+		for len(caughtUp) < replicasNeeded {
+			select {
+			case ack := <-r.ackChan:
+				{
+					if ack.processedByteCount >= bytesNeeded {
+						caughtUp[ack.connectionId] = true
+					}
+				}
+			case <-timer:
+				{
+					break ReplicaWaitLoop
+				}
+			case <-ctx.Done():
+				{
+					break ReplicaWaitLoop
 				}
-			}
-		case <-time.After(time.Duration(timeoutMs) * time.Millisecond):
-			{
-				break ReplicaWaitLoop
 			}
 		}
-	}
+	})
 
-	return []serde.Value{serde.NewInteger(int64(len(caughtUp)))}
+	return len(caughtUp)
 }
@@ -0,0 +1,20 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"context"
+)
+
+func (r Redis) watch(ctx context.Context, args []string, connection RedisConnection) []serde.Value {
+	if len(args) < 1 {
+		return []serde.Value{serde.NewError("ERR wrong number of arguments for 'watch' command")}
+	}
+
+	r.store.WatchKeys(ctx, connection.watch, args)
+	return []serde.Value{serde.Ok()}
+}
+
+func (r Redis) unwatch(connection RedisConnection) []serde.Value {
+	r.store.Unwatch(connection.watch)
+	return []serde.Value{serde.Ok()}
+}
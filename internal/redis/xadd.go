@@ -1,26 +1,68 @@
 package redis
 
 import (
+	"codecrafters/internal/kvstore"
 	"codecrafters/internal/serde"
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 )
 
-func parseXaddArgs(args []string) (map[string]string, error) {
+type xaddArgs struct {
+	opts   kvstore.StreamAddOptions
+	id     string
+	fields []string
+}
+
+// parseXaddFields validates the field value pairs of an entry, which are kept in
+// the order given, duplicate fields and all
+func parseXaddFields(args []string) ([]string, error) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return []string{}, fmt.Errorf("args to XADD must be key value pairs %v", args)
+	}
+
+	return args, nil
+}
+
+func parseXaddArgs(args []string) (xaddArgs, error) {
+	parsedArgs := xaddArgs{}
+
+	i := 0
 
-	parsedArgs := map[string]string{}
+OptionsLoop:
+	for i < len(args) {
+		switch strings.ToLower(args[i]) {
+		case "nomkstream":
+			parsedArgs.opts.NoMkStream = true
+			i++
+		case kvstore.TRIM_MAXLEN, kvstore.TRIM_MINID:
+			trim, consumed, err := parseStreamTrimArgs(args[i:])
 
-	if len(args)%2 != 0 {
-		return parsedArgs, fmt.Errorf("args to XADD must be key value pairs %v", args)
+			if err != nil {
+				return parsedArgs, err
+			}
+
+			parsedArgs.opts.Trim = &trim
+			i += consumed
+		default:
+			break OptionsLoop
+		}
+	}
+
+	if i >= len(args) {
+		return parsedArgs, errors.New("ERR wrong number of arguments for 'xadd' command")
 	}
 
-	for i := 0; i < len(args); i = i + 2 {
-		key := args[i]
-		value := args[i+1]
+	parsedArgs.id = args[i]
+
+	fields, err := parseXaddFields(args[i+1:])
 
-		parsedArgs[key] = value
+	if err != nil {
+		return parsedArgs, err
 	}
 
+	parsedArgs.fields = fields
 	return parsedArgs, nil
 }
 
@@ -30,15 +72,18 @@ func (r Redis) xadd(ctx context.Context, args []string) []serde.Value {
 	}
 
 	key := args[0]
-	id := args[1]
 
-	parsedArgs, err := parseXaddArgs(args[2:])
+	parsedArgs, err := parseXaddArgs(args[1:])
 
 	if err != nil {
 		return []serde.Value{serde.NewError(err.Error())}
 	}
 
-	insertedId, _, err := r.store.SetStream(ctx, key, id, parsedArgs)
+	insertedId, _, err := r.store.SetStream(ctx, key, parsedArgs.id, parsedArgs.fields, parsedArgs.opts)
+
+	if errors.Is(err, kvstore.ErrNoSuchKey) {
+		return []serde.Value{serde.NewNull()}
+	}
 
 	if err != nil {
 		return []serde.Value{serde.NewError(err.Error())}
@@ -0,0 +1,34 @@
+package redis
+
+import (
+	"codecrafters/internal/kvstore"
+	"codecrafters/internal/serde"
+	"context"
+)
+
+func (r Redis) xdel(ctx context.Context, args []string) []serde.Value {
+	if len(args) < 2 {
+		return []serde.Value{serde.NewError("ERR wrong number of arguments for 'xdel' command")}
+	}
+
+	key := args[0]
+	ids := []kvstore.StreamId{}
+
+	// Validate every ID up front so a bad one doesn't leave us half done
+	for _, arg := range args[1:] {
+		id, err := kvstore.ParseStreamId(arg)
+
+		if err != nil {
+			return []serde.Value{serde.NewError(err.Error())}
+		}
+		ids = append(ids, id)
+	}
+
+	deleted, err := r.store.DeleteStreamEntries(ctx, key, ids)
+
+	if err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	return []serde.Value{serde.NewInteger(int64(deleted))}
+}
@@ -0,0 +1,147 @@
+package redis
+
+import (
+	"codecrafters/internal/kvstore"
+	"codecrafters/internal/serde"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Number of entries XINFO STREAM FULL reports when no COUNT is given
+const DEFAULT_XINFO_FULL_COUNT = 10
+
+func streamEntryValue(entry *kvstore.StreamQueryResult) serde.Value {
+	if entry == nil {
+		return serde.NewNull()
+	}
+	return processXRangeOutput([]kvstore.StreamQueryResult{*entry}).Items[0]
+}
+
+func (r Redis) xinfoStream(ctx context.Context, args []string) []serde.Value {
+	if len(args) < 1 {
+		return []serde.Value{serde.NewError("ERR wrong number of arguments for 'xinfo|stream' command")}
+	}
+
+	key := args[0]
+	full := false
+	count := DEFAULT_XINFO_FULL_COUNT
+
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "full":
+			full = true
+		case "count":
+			if !full || i+1 == len(args) {
+				return []serde.Value{serde.NewError("ERR syntax error")}
+			}
+
+			parsed, err := strconv.Atoi(args[i+1])
+
+			if err != nil || parsed < 0 {
+				return []serde.Value{serde.NewError("ERR value is not an integer or out of range")}
+			}
+			count = parsed
+			i++
+		default:
+			return []serde.Value{serde.NewError("ERR syntax error")}
+		}
+	}
+
+	info, err := r.store.StreamInfo(ctx, key, full, count)
+
+	if err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	reply := []serde.Value{
+		serde.NewBulkString("length"), serde.NewInteger(int64(info.Length)),
+		serde.NewBulkString("radix-tree-keys"), serde.NewInteger(int64(info.RadixTreeKeys)),
+		serde.NewBulkString("radix-tree-nodes"), serde.NewInteger(int64(info.RadixTreeNodes)),
+		serde.NewBulkString("last-generated-id"), serde.NewBulkString(info.LastGeneratedId.ToString()),
+		serde.NewBulkString("max-deleted-entry-id"), serde.NewBulkString(info.MaxDeletedEntryId.ToString()),
+		serde.NewBulkString("entries-added"), serde.NewInteger(int64(info.EntriesAdded)),
+		serde.NewBulkString("recorded-first-entry-id"), serde.NewBulkString(info.RecordedFirstEntryId.ToString()),
+	}
+
+	// Consumer groups aren't supported, so there are never any to report on
+	if full {
+		reply = append(reply,
+			serde.NewBulkString("entries"), processXRangeOutput(info.Entries),
+			serde.NewBulkString("groups"), serde.NewArray([]serde.Value{}),
+		)
+	} else {
+		reply = append(reply,
+			serde.NewBulkString("groups"), serde.NewInteger(0),
+			serde.NewBulkString("first-entry"), streamEntryValue(info.FirstEntry),
+			serde.NewBulkString("last-entry"), streamEntryValue(info.LastEntry),
+		)
+	}
+
+	return []serde.Value{serde.NewArray(reply)}
+}
+
+func (r Redis) xinfoGroups(ctx context.Context, args []string) []serde.Value {
+	if len(args) != 1 {
+		return []serde.Value{serde.NewError("ERR wrong number of arguments for 'xinfo|groups' command")}
+	}
+
+	_, err := r.store.StreamInfo(ctx, args[0], false, 0)
+
+	if err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	return []serde.Value{serde.NewArray([]serde.Value{})}
+}
+
+func (r Redis) xinfoConsumers(ctx context.Context, args []string) []serde.Value {
+	if len(args) != 2 {
+		return []serde.Value{serde.NewError("ERR wrong number of arguments for 'xinfo|consumers' command")}
+	}
+
+	key, group := args[0], args[1]
+
+	_, err := r.store.StreamInfo(ctx, key, false, 0)
+
+	if err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	return []serde.Value{serde.NewError(fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", group, key))}
+}
+
+func (r Redis) xinfo(ctx context.Context, args []string) []serde.Value {
+	if len(args) < 1 {
+		return []serde.Value{serde.NewError("ERR wrong number of arguments for 'xinfo' command")}
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "stream":
+		return r.xinfoStream(ctx, args[1:])
+	case "groups":
+		return r.xinfoGroups(ctx, args[1:])
+	case "consumers":
+		return r.xinfoConsumers(ctx, args[1:])
+	case "help":
+		help := []string{
+			"XINFO <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"CONSUMERS <key> <groupname>",
+			"    Show consumers of <groupname>.",
+			"GROUPS <key>",
+			"    Show the stream consumer groups.",
+			"STREAM <key> [FULL [COUNT <count>]",
+			"    Show information about the stream.",
+			"HELP",
+			"    Print this help.",
+		}
+		helpArr := []serde.Value{}
+		for _, line := range help {
+			helpArr = append(helpArr, serde.NewSimpleString(line))
+		}
+		return []serde.Value{serde.NewArray(helpArr)}
+	default:
+		return []serde.Value{serde.NewError(fmt.Sprintf("ERR unknown subcommand '%s'. Try XINFO HELP.", args[0]))}
+	}
+}
@@ -0,0 +1,76 @@
+package redis
+
+import (
+	"codecrafters/internal/kvstore"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRedis_xinfo(t *testing.T) {
+	ctx := context.Background()
+	r := Redis{store: kvstore.NewKVStore()}
+
+	for _, id := range []string{"1-1", "2-1", "3-1"} {
+		r.xadd(ctx, []string{"stream", id, "field", "value-" + id})
+	}
+	r.store.SetKeyWithExpiresAt("string", "value", nil)
+
+	tests := []struct {
+		name       string
+		args       []string
+		want       []string
+		wantAbsent []string
+	}{
+		{
+			name:       "It should summarise the stream",
+			args:       []string{"STREAM", "stream"},
+			want:       []string{"$6\r\nlength\r\n:3\r\n", "$17\r\nlast-generated-id\r\n$3\r\n3-1\r\n", "$6\r\ngroups\r\n:0\r\n", "$11\r\nfirst-entry\r\n*2\r\n$3\r\n1-1\r\n", "$10\r\nlast-entry\r\n*2\r\n$3\r\n3-1\r\n"},
+			wantAbsent: []string{"$7\r\nentries\r\n"},
+		},
+		{
+			name:       "It should list the entries in full",
+			args:       []string{"STREAM", "stream", "FULL"},
+			want:       []string{"$7\r\nentries\r\n*3\r\n", "value-1-1", "value-3-1", "$6\r\ngroups\r\n*0\r\n"},
+			wantAbsent: []string{"$11\r\nfirst-entry\r\n"},
+		},
+		{
+			name:       "It should list as many entries as COUNT",
+			args:       []string{"STREAM", "stream", "FULL", "COUNT", "2"},
+			want:       []string{"$7\r\nentries\r\n*2\r\n", "value-1-1", "value-2-1"},
+			wantAbsent: []string{"value-3-1"},
+		},
+		{name: "It should only take COUNT with FULL", args: []string{"STREAM", "stream", "COUNT", "2"}, want: []string{"-ERR syntax error"}},
+		{name: "It should refuse a negative COUNT", args: []string{"STREAM", "stream", "FULL", "COUNT", "-1"}, want: []string{"-ERR value is not an integer or out of range"}},
+		{name: "It should refuse a stream that doesn't exist", args: []string{"STREAM", "missing"}, want: []string{"-ERR no such key"}},
+		{name: "It should refuse a key of another type", args: []string{"STREAM", "string"}, want: []string{"-WRONGTYPE"}},
+		{name: "It should list no groups", args: []string{"GROUPS", "stream"}, want: []string{"*0\r\n"}},
+		{name: "It should refuse the groups of a stream that doesn't exist", args: []string{"GROUPS", "missing"}, want: []string{"-ERR no such key"}},
+		{name: "It should refuse the groups of a key of another type", args: []string{"GROUPS", "string"}, want: []string{"-WRONGTYPE"}},
+		{name: "It should check the arguments for groups", args: []string{"GROUPS"}, want: []string{"-ERR wrong number of arguments for 'xinfo|groups' command"}},
+		{name: "It should refuse consumers of an unknown group", args: []string{"CONSUMERS", "stream", "group"}, want: []string{"-NOGROUP No such consumer group 'group' for key name 'stream'"}},
+		{name: "It should refuse consumers of a stream that doesn't exist", args: []string{"CONSUMERS", "missing", "group"}, want: []string{"-ERR no such key"}},
+		{name: "It should check the arguments for consumers", args: []string{"CONSUMERS", "stream"}, want: []string{"-ERR wrong number of arguments for 'xinfo|consumers' command"}},
+		{name: "It should refuse an unknown subcommand", args: []string{"NOPE"}, want: []string{"-ERR unknown subcommand 'NOPE'. Try XINFO HELP."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			for _, value := range r.xinfo(ctx, tt.args) {
+				got += string(value.Marshal())
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("xinfo(%v) = %q, want it to contain %q", tt.args, got, want)
+				}
+			}
+
+			for _, absent := range tt.wantAbsent {
+				if strings.Contains(got, absent) {
+					t.Errorf("xinfo(%v) = %q, want it not to contain %q", tt.args, got, absent)
+				}
+			}
+		})
+	}
+}
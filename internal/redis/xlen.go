@@ -0,0 +1,20 @@
+package redis
+
+import (
+	"codecrafters/internal/serde"
+	"context"
+)
+
+func (r Redis) xlen(ctx context.Context, args []string) []serde.Value {
+	if len(args) != 1 {
+		return []serde.Value{serde.NewError("ERR wrong number of arguments for 'xlen' command")}
+	}
+
+	length, err := r.store.StreamLength(ctx, args[0])
+
+	if err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	return []serde.Value{serde.NewInteger(int64(length))}
+}
@@ -4,6 +4,10 @@ import (
 	"codecrafters/internal/array"
 	"codecrafters/internal/kvstore"
 	"codecrafters/internal/serde"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 
 	"context"
 )
@@ -23,16 +27,74 @@ func processXRangeOutput(queryResult []kvstore.StreamQueryResult) serde.Array {
 	return serde.NewArray(outputArr)
 }
 
-func (r Redis) xrange(ctx context.Context, args []string) []serde.Value {
+func parseXRangeArgs(cmd string, args []string, reverse bool) (kvstore.StreamRange, error) {
+	query := kvstore.StreamRange{Reverse: reverse}
+
+	if len(args) != 2 && len(args) != 4 {
+		return query, fmt.Errorf("ERR wrong number of arguments for '%s' command", cmd)
+	}
+
+	// XREVRANGE takes its interval from the end backwards
+	startArg, endArg := args[0], args[1]
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+
+	start, err := kvstore.ParseRangeStart(startArg)
+
+	if err != nil {
+		return query, err
+	}
+
+	end, err := kvstore.ParseRangeEnd(endArg)
+
+	if err != nil {
+		return query, err
+	}
+
+	query.Start = start
+	query.End = end
+
+	if len(args) == 4 {
+		if strings.ToLower(args[2]) != "count" {
+			return query, errors.New("ERR syntax error")
+		}
+
+		count, err := strconv.Atoi(args[3])
+
+		if err != nil {
+			return query, errors.New("ERR value is not an integer or out of range")
+		}
+
+		// A COUNT of zero or less asks for nothing, which we flag with -1 as 0
+		// means unbounded to the store
+		if count <= 0 {
+			count = -1
+		}
+		query.Count = count
+	}
+
+	return query, nil
+}
+
+func (r Redis) queryStreamRange(ctx context.Context, cmd string, args []string, reverse bool) []serde.Value {
 	if len(args) < 3 {
-		return []serde.Value{serde.NewError("XRANGE expects at least three arguments")}
+		return []serde.Value{serde.NewError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd))}
 	}
 
 	key := args[0]
-	startId := args[1]
-	endId := args[2]
 
-	queryResult, err := r.store.QueryStream(ctx, key, startId, endId)
+	query, err := parseXRangeArgs(cmd, args[1:], reverse)
+
+	if err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	if query.Count < 0 {
+		return []serde.Value{serde.NewArray([]serde.Value{})}
+	}
+
+	queryResult, err := r.store.QueryStream(ctx, key, query)
 
 	if err != nil {
 		return []serde.Value{serde.NewError(err.Error())}
@@ -40,3 +102,11 @@ func (r Redis) xrange(ctx context.Context, args []string) []serde.Value {
 
 	return []serde.Value{processXRangeOutput(queryResult)}
 }
+
+func (r Redis) xrange(ctx context.Context, args []string) []serde.Value {
+	return r.queryStreamRange(ctx, XRANGE, args, false)
+}
+
+func (r Redis) xrevrange(ctx context.Context, args []string) []serde.Value {
+	return r.queryStreamRange(ctx, XREVRANGE, args, true)
+}
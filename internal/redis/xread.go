@@ -4,88 +4,152 @@ import (
 	"codecrafters/internal/kvstore"
 	"codecrafters/internal/serde"
 	"context"
+	"errors"
 	"strconv"
+	"strings"
 	"time"
 )
 
-type xreadArgs struct {
+type xreadStream struct {
 	key string
 	id  string
 }
 
-func parseXReadArgs(args []string) []xreadArgs {
-	res := []xreadArgs{}
-	if len(args)%2 != 0 {
-		panic("Expected xread args to have key, id pairs")
-	}
-	for i := 0; i < len(args)/2; i = i + 1 {
-		key := args[i]
-		id := args[len(args)-1-i]
-
-		res = append(res, xreadArgs{key, id})
-	}
-	return res
+type xreadArgs struct {
+	count   int
+	blockMs *int
+	streams []xreadStream
 }
 
-func xreadBlocking(ctx context.Context, r Redis, args []string) []serde.Value {
-	blockMs, err := strconv.Atoi(args[0])
+func parseXReadArgs(args []string) (xreadArgs, error) {
+	parsedArgs := xreadArgs{}
 
-	if err != nil {
-		return []serde.Value{serde.NewError("Expected blocking ms to be integer for xread")}
+	i := 0
+	for ; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+
+		if option == "streams" {
+			i++
+			break
+		}
+
+		if i+1 == len(args) {
+			return parsedArgs, errors.New("ERR syntax error")
+		}
+
+		switch option {
+		case "count":
+			count, err := strconv.Atoi(args[i+1])
+
+			if err != nil {
+				return parsedArgs, errors.New("ERR value is not an integer or out of range")
+			}
+
+			if count > 0 {
+				parsedArgs.count = count
+			}
+		case "block":
+			blockMs, err := strconv.Atoi(args[i+1])
+
+			if err != nil {
+				return parsedArgs, errors.New("ERR timeout is not an integer or out of range")
+			}
+
+			if blockMs < 0 {
+				return parsedArgs, errors.New("ERR timeout is negative")
+			}
+			parsedArgs.blockMs = &blockMs
+		default:
+			return parsedArgs, errors.New("ERR syntax error")
+		}
+		i++
 	}
 
-	parsedArgs := parseXReadArgs(args[2:])
-	resultChans := make([]chan kvstore.BlockingQueryResult, len(parsedArgs))
+	streamArgs := args[i:]
 
-	for i, stream := range parsedArgs {
-		resultChans[i] = make(chan kvstore.BlockingQueryResult)
-		go r.store.ReadStreamBlocking(ctx, stream.key, stream.id, resultChans[i])
+	if len(streamArgs) == 0 || len(streamArgs)%2 != 0 {
+		return parsedArgs, errors.New("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
 	}
 
-	var timeoutChan <-chan time.Time
+	// Keys come first followed by their IDs in the same order
+	streamCount := len(streamArgs) / 2
+	for i := 0; i < streamCount; i++ {
+		parsedArgs.streams = append(parsedArgs.streams, xreadStream{streamArgs[i], streamArgs[streamCount+i]})
+	}
+
+	return parsedArgs, nil
+}
 
-	if blockMs != 0 {
-		timeoutChan = time.After(time.Duration(blockMs) * time.Millisecond)
+func processXReadOutput(results []kvstore.StreamReadResult) []serde.Value {
+	// As in Redis, nothing to read, whether straight away or by the time a blocking
+	// read times out, is a null array
+	if len(results) == 0 {
+		return []serde.Value{serde.NewNullArray()}
 	}
 
-	for _, resultChan := range resultChans {
-		select {
-		case <-ctx.Done():
-			continue
-		case result := <-resultChan:
-			streamArr := serde.NewArray([]serde.Value{serde.NewBulkString(result.Key), processXRangeOutput(result.Values)})
-			return []serde.Value{serde.NewArray([]serde.Value{streamArr})}
-		case <-timeoutChan:
-			return []serde.Value{serde.NewNull()}
-		}
+	outputArr := []serde.Value{}
+
+	for _, result := range results {
+		streamArr := serde.NewArray([]serde.Value{serde.NewBulkString(result.Key), processXRangeOutput(result.Values)})
+		outputArr = append(outputArr, streamArr)
 	}
-	return []serde.Value{serde.NewNull()}
+
+	return []serde.Value{serde.NewArray(outputArr)}
 }
 
-func (r Redis) xread(ctx context.Context, args []string) []serde.Value {
+func (r Redis) xread(ctx context.Context, args []string, connection RedisConnection) []serde.Value {
 	if len(args) < 3 {
 		return []serde.Value{serde.NewError("XREAD expects at least three arguments")}
 	}
 
-	if args[0] == "block" {
-		return xreadBlocking(ctx, r, args[1:])
+	parsedArgs, err := parseXReadArgs(args)
+
+	if err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
 	}
 
-	parsedArgs := parseXReadArgs(args[1:])
+	// Special IDs are resolved once up front, so "$" means whatever was last at the
+	// time XREAD was called rather than when we next look
+	reads := []kvstore.StreamRead{}
 
-	outputArr := []serde.Value{}
+	for _, stream := range parsedArgs.streams {
+		after, err := r.store.StreamReadStart(ctx, stream.key, stream.id)
+
+		if err != nil {
+			return []serde.Value{serde.NewError(err.Error())}
+		}
 
-	for _, stream := range parsedArgs {
-		queryResult, err := r.store.ReadStream(ctx, stream.key, stream.id)
+		reads = append(reads, kvstore.StreamRead{Key: stream.key, After: after})
+	}
+
+	if parsedArgs.blockMs == nil || isBlockingDenied(ctx) {
+		results, err := r.store.ReadStreams(ctx, reads, parsedArgs.count)
 
 		if err != nil {
-			continue
+			return []serde.Value{serde.NewError(err.Error())}
 		}
 
-		streamRes := processXRangeOutput(queryResult)
-		streamArr := serde.NewArray([]serde.Value{serde.NewBulkString(stream.key), streamRes})
-		outputArr = append(outputArr, streamArr)
+		return processXReadOutput(results)
 	}
 
-	return []serde.Value{serde.NewArray(outputArr)}
+	blockCtx, stopWatching := connection.WatchForClose(ctx)
+	defer stopWatching()
+
+	if *parsedArgs.blockMs != 0 {
+		var cancel context.CancelFunc
+		blockCtx, cancel = context.WithTimeout(blockCtx, time.Duration(*parsedArgs.blockMs)*time.Millisecond)
+		defer cancel()
+	}
+
+	var results []kvstore.StreamReadResult
+
+	r.whileBlocked(func() {
+		results, err = r.store.ReadStreamsBlocking(blockCtx, reads, parsedArgs.count)
+	})
+
+	if err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	return processXReadOutput(results)
 }
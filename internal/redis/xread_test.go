@@ -0,0 +1,38 @@
+package redis
+
+import (
+	"codecrafters/internal/kvstore"
+	"context"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestRedis_xreadNothingToRead(t *testing.T) {
+	r := Redis{store: kvstore.NewKVStore(), executionMutex: &sync.Mutex{}, stats: newServerStats()}
+	r.xadd(context.Background(), []string{"stream", "1-1", "field", "value"})
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "It should return a null array when there's nothing new", args: []string{"STREAMS", "stream", "$"}},
+		{name: "It should return a null array when a blocking read times out", args: []string{"BLOCK", "10", "STREAMS", "stream", "$"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Blocking reads give up the execution lock while they wait
+			r.executionMutex.Lock()
+			defer r.executionMutex.Unlock()
+
+			server, client := net.Pipe()
+			defer client.Close()
+
+			response := r.xread(context.Background(), tt.args, NewRedisConnection(server))
+
+			if len(response) != 1 || string(response[0].Marshal()) != "*-1\r\n" {
+				t.Errorf("xread(%v) = %v, want a null array", tt.args, response)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package redis
+
+import (
+	"codecrafters/internal/kvstore"
+	"codecrafters/internal/serde"
+	"context"
+	"strconv"
+	"strings"
+)
+
+func (r Redis) xsetid(ctx context.Context, args []string) []serde.Value {
+	if len(args) < 2 {
+		return []serde.Value{serde.NewError("ERR wrong number of arguments for 'xsetid' command")}
+	}
+
+	key := args[0]
+
+	id, err := kvstore.ParseStreamId(args[1])
+
+	if err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	var entriesAdded *uint64 = nil
+	var maxDeletedId *kvstore.StreamId = nil
+
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return []serde.Value{serde.NewError("ERR syntax error")}
+		}
+
+		switch strings.ToLower(args[i]) {
+		case "entriesadded":
+			added, err := strconv.ParseInt(args[i+1], 10, 64)
+
+			if err != nil || added < 0 {
+				return []serde.Value{serde.NewError("ERR entries_added must be positive")}
+			}
+			entriesAdded = new(uint64)
+			*entriesAdded = uint64(added)
+		case "maxdeletedid":
+			maxDeleted, err := kvstore.ParseStreamId(args[i+1])
+
+			if err != nil {
+				return []serde.Value{serde.NewError(err.Error())}
+			}
+			maxDeletedId = &maxDeleted
+		default:
+			return []serde.Value{serde.NewError("ERR syntax error")}
+		}
+	}
+
+	err = r.store.SetStreamId(ctx, key, id, entriesAdded, maxDeletedId)
+
+	if err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	return []serde.Value{serde.Ok()}
+}
@@ -0,0 +1,109 @@
+package redis
+
+import (
+	"codecrafters/internal/kvstore"
+	"codecrafters/internal/serde"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Matches Redis' default LIMIT for approximate trims of 100 * stream-node-max-entries
+const DEFAULT_TRIM_LIMIT = 100 * kvstore.STREAM_NODE_MAX_ENTRIES
+
+// parseStreamTrimArgs parses a MAXLEN|MINID [=|~] threshold [LIMIT count] clause
+// starting at args[0], returning the options along with the number of args consumed
+func parseStreamTrimArgs(args []string) (kvstore.StreamTrimOptions, int, error) {
+	opts := kvstore.StreamTrimOptions{}
+
+	if len(args) < 2 {
+		return opts, 0, errors.New("ERR syntax error")
+	}
+
+	opts.Strategy = strings.ToLower(args[0])
+	i := 1
+
+	switch args[i] {
+	case "~":
+		opts.Approximate = true
+		i++
+	case "=":
+		i++
+	}
+
+	if i >= len(args) {
+		return opts, 0, errors.New("ERR syntax error")
+	}
+
+	switch opts.Strategy {
+	case kvstore.TRIM_MAXLEN:
+		maxLen, err := strconv.ParseInt(args[i], 10, 64)
+
+		if err != nil {
+			return opts, 0, errors.New("ERR value is not an integer or out of range")
+		}
+
+		if maxLen < 0 {
+			return opts, 0, errors.New("ERR The MAXLEN argument must be >= 0.")
+		}
+		opts.MaxLen = uint64(maxLen)
+	case kvstore.TRIM_MINID:
+		minId, err := kvstore.ParseStreamId(args[i])
+
+		if err != nil {
+			return opts, 0, err
+		}
+		opts.MinId = minId
+	default:
+		return opts, 0, errors.New("ERR syntax error")
+	}
+	i++
+
+	if opts.Approximate {
+		opts.Limit = DEFAULT_TRIM_LIMIT
+	}
+
+	if i+1 < len(args) && strings.ToLower(args[i]) == "limit" {
+		limit, err := strconv.ParseInt(args[i+1], 10, 64)
+
+		if err != nil || limit < 0 {
+			return opts, 0, errors.New("ERR The LIMIT argument must be >= 0.")
+		}
+
+		if !opts.Approximate {
+			return opts, 0, errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+
+		opts.Limit = uint64(limit)
+		i += 2
+	}
+
+	return opts, i, nil
+}
+
+func (r Redis) xtrim(ctx context.Context, args []string) []serde.Value {
+	if len(args) < 3 {
+		return []serde.Value{serde.NewError("ERR wrong number of arguments for 'xtrim' command")}
+	}
+
+	key := args[0]
+
+	opts, consumed, err := parseStreamTrimArgs(args[1:])
+
+	if err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	if consumed != len(args)-1 {
+		return []serde.Value{serde.NewError("ERR syntax error")}
+	}
+
+	deleted, err := r.store.TrimStream(ctx, key, opts)
+
+	if err != nil {
+		return []serde.Value{serde.NewError(err.Error())}
+	}
+
+	return []serde.Value{serde.NewInteger(int64(deleted))}
+}
@@ -12,6 +12,10 @@ func (s Error) Marshal() []byte {
 	return bytes
 }
 
+func (s Error) Value() string {
+	return s.value
+}
+
 func NewError(value string) Error {
 	return Error{value}
 }
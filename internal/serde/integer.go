@@ -16,6 +16,10 @@ func (i Integer) Marshal() []byte {
 	return bytes
 }
 
+func (i Integer) Value() int64 {
+	return i.value
+}
+
 func NewInteger(value int64) Integer {
 	return Integer{value}
 }
@@ -9,3 +9,13 @@ func (null Null) Marshal() []byte {
 func NewNull() Null {
 	return Null{}
 }
+
+type NullArray struct{}
+
+func (null NullArray) Marshal() []byte {
+	return []byte("*-1\r\n")
+}
+
+func NewNullArray() NullArray {
+	return NullArray{}
+}
@@ -0,0 +1,48 @@
+package serde
+
+import "strconv"
+
+// Push is a RESP3 out-of-band message, such as a client tracking invalidation,
+// that the client didn't ask for
+type Push struct {
+	Items []Value
+}
+
+func (p Push) Marshal() []byte {
+	var bytes []byte
+
+	bytes = append(bytes, PUSH)
+	bytes = append(bytes, []byte(strconv.Itoa(len(p.Items)))...)
+	bytes = append(bytes, []byte(CRLF)...)
+
+	for _, v := range p.Items {
+		bytes = append(bytes, v.Marshal()...)
+	}
+	return bytes
+}
+
+func NewPush(items []Value) Push {
+	return Push{Items: items}
+}
+
+// Map is a RESP3 map, holding its keys and values alternately
+type Map struct {
+	Items []Value
+}
+
+func (m Map) Marshal() []byte {
+	var bytes []byte
+
+	bytes = append(bytes, MAP)
+	bytes = append(bytes, []byte(strconv.Itoa(len(m.Items)/2))...)
+	bytes = append(bytes, []byte(CRLF)...)
+
+	for _, v := range m.Items {
+		bytes = append(bytes, v.Marshal()...)
+	}
+	return bytes
+}
+
+func NewMap(items []Value) Map {
+	return Map{Items: items}
+}
@@ -0,0 +1,53 @@
+package serde
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPush_Marshal(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []Value
+		want  []byte
+	}{
+		{
+			"Should marshal an invalidation message correctly",
+			[]Value{NewBulkString("invalidate"), NewArray([]Value{NewBulkString("foo")})},
+			[]byte(">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewPush(tt.items).Marshal(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Push.Marshal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMap_Marshal(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []Value
+		want  []byte
+	}{
+		{
+			"Should marshal an empty map correctly",
+			[]Value{},
+			[]byte("%0\r\n"),
+		},
+		{
+			"Should count each key and value pair once",
+			[]Value{NewBulkString("proto"), NewInteger(3)},
+			[]byte("%1\r\n$5\r\nproto\r\n:3\r\n"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewMap(tt.items).Marshal(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Map.Marshal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
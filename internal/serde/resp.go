@@ -15,6 +15,8 @@ const (
 	INTEGER = ':'
 	ARRAY   = '*'
 	BULK    = '$'
+	MAP     = '%'
+	PUSH    = '>'
 
 	CRLF = "\r\n"
 )
@@ -131,10 +133,27 @@ func (r *Reader) readSimpleString() (Value, error) {
 
 }
 
+// Peek blocks until there is data to read, without consuming any of it
+func (r *Reader) Peek() error {
+	_, err := r.reader.Peek(1)
+	return err
+}
+
 func (r *Reader) CanRead() bool {
 	return r.reader.Buffered() > 0
 }
 
+// Buffered returns how many bytes have been read from the connection but not yet
+// parsed
+func (r *Reader) Buffered() int {
+	return r.reader.Buffered()
+}
+
+// Size returns the size of the read buffer
+func (r *Reader) Size() int {
+	return r.reader.Size()
+}
+
 func (r *Reader) Read() (Value, error) {
 	_type, err := r.reader.ReadByte()
 
@@ -161,29 +180,30 @@ func (r *Reader) Read() (Value, error) {
 	}
 }
 
-// TODO: Should probably actually return a value here that we can parse etc
-func (r *Reader) ReadRDB() error {
+// ReadRDB reads the RDB payload a master sends in answer to PSYNC, which is like
+// a bulk string without the trailing CRLF
+func (r *Reader) ReadRDB() ([]byte, error) {
 	length, n, err := r.readLine()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if n < 2 || length[0] != BULK {
-		return errors.New("expect RBD payload to be prefixed by $<length>\\r\\n")
+		return nil, errors.New("expect RBD payload to be prefixed by $<length>\\r\\n")
 	}
 
 	bytesToReadCount, err := strconv.Atoi(string((length[1:])))
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if bytesToReadCount < 0 {
-		return errors.New("expect RBD to have positive byte count for transfer")
+		return nil, errors.New("expect RBD to have positive byte count for transfer")
 	}
 
 	rdbContent := make([]byte, bytesToReadCount)
 
 	_, err = io.ReadFull(r.reader, rdbContent)
 
-	return err
+	return rdbContent, err
 }